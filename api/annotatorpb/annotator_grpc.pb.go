@@ -0,0 +1,109 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.2.0
+// - protoc             (unknown)
+// source: annotator.proto
+
+package annotatorpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// AnnotatorClient is the client API for Annotator service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type AnnotatorClient interface {
+	// Annotate annotates all the IPs in the request, using the annotator
+	// selected by the request date.
+	Annotate(ctx context.Context, in *AnnotateRequest, opts ...grpc.CallOption) (*AnnotateResponse, error)
+}
+
+type annotatorClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewAnnotatorClient(cc grpc.ClientConnInterface) AnnotatorClient {
+	return &annotatorClient{cc}
+}
+
+func (c *annotatorClient) Annotate(ctx context.Context, in *AnnotateRequest, opts ...grpc.CallOption) (*AnnotateResponse, error) {
+	out := new(AnnotateResponse)
+	err := c.cc.Invoke(ctx, "/annotator.Annotator/Annotate", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// AnnotatorServer is the server API for Annotator service.
+// All implementations must embed UnimplementedAnnotatorServer
+// for forward compatibility
+type AnnotatorServer interface {
+	// Annotate annotates all the IPs in the request, using the annotator
+	// selected by the request date.
+	Annotate(context.Context, *AnnotateRequest) (*AnnotateResponse, error)
+	mustEmbedUnimplementedAnnotatorServer()
+}
+
+// UnimplementedAnnotatorServer must be embedded to have forward compatible implementations.
+type UnimplementedAnnotatorServer struct {
+}
+
+func (UnimplementedAnnotatorServer) Annotate(context.Context, *AnnotateRequest) (*AnnotateResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Annotate not implemented")
+}
+func (UnimplementedAnnotatorServer) mustEmbedUnimplementedAnnotatorServer() {}
+
+// UnsafeAnnotatorServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to AnnotatorServer will
+// result in compilation errors.
+type UnsafeAnnotatorServer interface {
+	mustEmbedUnimplementedAnnotatorServer()
+}
+
+func RegisterAnnotatorServer(s grpc.ServiceRegistrar, srv AnnotatorServer) {
+	s.RegisterService(&Annotator_ServiceDesc, srv)
+}
+
+func _Annotator_Annotate_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AnnotateRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AnnotatorServer).Annotate(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/annotator.Annotator/Annotate",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AnnotatorServer).Annotate(ctx, req.(*AnnotateRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Annotator_ServiceDesc is the grpc.ServiceDesc for Annotator service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Annotator_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "annotator.Annotator",
+	HandlerType: (*AnnotatorServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Annotate",
+			Handler:    _Annotator_Annotate_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "annotator.proto",
+}
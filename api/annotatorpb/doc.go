@@ -0,0 +1,5 @@
+// Package annotatorpb contains the protocol buffer messages and the gRPC
+// service for the annotation service API, generated from annotator.proto.
+package annotatorpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative annotator.proto
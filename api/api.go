@@ -5,6 +5,7 @@ package api
 import (
 	"encoding/json"
 	"errors"
+	"net"
 	"os"
 	"regexp"
 	"time"
@@ -172,6 +173,55 @@ type Annotator interface {
 
 	// The date associated with the dataset.
 	AnnotatorDate() time.Time
+
+	// The datasets used for the annotations.
+	Provenance() []Provenance
+}
+
+// PrefixAnnotator is implemented by Annotators that keep the routing prefix
+// hierarchy of their datasets.
+type PrefixAnnotator interface {
+	// CoveringPrefixes returns all the announced prefixes containing the IP,
+	// from the least to the most specific, each with its origin AS set.
+	// Annotators without prefixes for the IP return an error of kind
+	// ErrNotApplicable.
+	CoveringPrefixes(ip string) ([]ASData, error)
+
+	// OriginPrefixes returns all the announced prefixes originated by the AS,
+	// in address order, with their complete origin AS sets.  Annotators that
+	// find none return an error of kind ErrNotFound.
+	OriginPrefixes(asn uint32) ([]ASData, error)
+}
+
+// RangeAnnotations are the annotations of a range of IP addresses.
+type RangeAnnotations struct {
+	First, Last net.IP // The first and last addresses of the range
+	Annotations
+}
+
+// RangeAnnotator is implemented by Annotators that can annotate all the
+// addresses of a prefix at once.
+type RangeAnnotator interface {
+	// AnnotateRange splits the prefix into the ranges that have different
+	// data in the datasets, and returns their annotations, in address order.
+	// The ranges are clipped to the prefix, and addresses not found in the
+	// datasets are omitted.
+	AnnotateRange(prefix *net.IPNet) ([]RangeAnnotations, error)
+}
+
+// Dataset types reported in Provenance.
+const (
+	LegacyType      = "geolite-legacy" // GeoLiteCity .dat files
+	GeoLite2Type    = "geolite2"       // GeoLite2-City CSV zips or MMDB files
+	RouteViewsType  = "routeviews"     // RouteViews pfx2as files
+	GeoLite2ASNType = "geolite2-asn"   // GeoLite2-ASN CSV zips
+)
+
+// Provenance identifies a dataset used for annotations.
+type Provenance struct {
+	Type string    // The dataset type, e.g. GeoLite2Type
+	Name string    // The name of the object the dataset was loaded from
+	Date time.Time // The date of the dataset snapshot
 }
 
 var dateRE = regexp.MustCompile(`[0-9]{8}T`)
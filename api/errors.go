@@ -0,0 +1,134 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+/*************************************************************************
+*                            Annotation Errors                           *
+*************************************************************************/
+
+// Error kinds.  Annotators return errors that wrap one of these, so callers
+// can classify them with errors.Is, whatever package they come from.
+var (
+	// ErrNotFound means the IP is not covered by the dataset.
+	ErrNotFound = errors.New("IP not found in dataset")
+	// ErrNotLoaded means the dataset is not (or not properly) loaded.
+	ErrNotLoaded = errors.New("Dataset not loaded")
+	// ErrInvalidIP means the IP string is empty or cannot be parsed.
+	ErrInvalidIP = errors.New("Invalid IP")
+	// ErrAlreadyPopulated means the annotation field was already filled in.
+	ErrAlreadyPopulated = errors.New("Annotation already populated")
+	// ErrNotApplicable means the dataset does not handle this kind of IP,
+	// e.g. an IPv4 only dataset was asked about an IPv6 address.
+	ErrNotApplicable = errors.New("Dataset does not apply to IP")
+	// ErrCorrupt means a dataset file is malformed, so loading it again will
+	// fail the same way.
+	ErrCorrupt = errors.New("Corrupt dataset")
+)
+
+// kindError is an error of one of the kinds above, which may also wrap
+// another error.
+type kindError struct {
+	msg  string
+	kind error
+	err  error
+}
+
+func (e *kindError) Error() string        { return e.msg }
+func (e *kindError) Is(target error) bool { return target == e.kind }
+func (e *kindError) Unwrap() error        { return e.err }
+
+// NewError returns an error with the message msg, for which errors.Is(err, kind)
+// is true.  It is used to define package errors of one of the kinds above.
+func NewError(msg string, kind error) error {
+	return &kindError{msg: msg, kind: kind}
+}
+
+// WrapError returns an error with the message of err, that wraps err and is
+// also of the given kind.
+func WrapError(err error, kind error) error {
+	return &kindError{msg: err.Error(), kind: kind, err: err}
+}
+
+// CompositeError is returned by an Annotator that combines several annotators
+// when any of them fails.  The annotations of the other annotators are still
+// populated, so the result may be used anyway.
+type CompositeError struct {
+	Errors []error // The errors of the annotators that failed
+	Total  int     // The number of annotators, including those that succeeded
+}
+
+func (e *CompositeError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i := range e.Errors {
+		msgs[i] = e.Errors[i].Error()
+	}
+	return fmt.Sprintf("%d of %d annotators failed: %s", len(e.Errors), e.Total, strings.Join(msgs, "; "))
+}
+
+// Is returns true if any of the errors matches target.
+func (e *CompositeError) Is(target error) bool {
+	for i := range e.Errors {
+		if errors.Is(e.Errors[i], target) {
+			return true
+		}
+	}
+	return false
+}
+
+// partial returns true if some of the annotators succeeded.
+func (e *CompositeError) partial() bool {
+	if len(e.Errors) < e.Total {
+		return true
+	}
+	for i := range e.Errors {
+		if ce, ok := e.Errors[i].(*CompositeError); ok && ce.partial() {
+			return true
+		}
+	}
+	return false
+}
+
+// Annotation status values, as reported per IP in v2 responses.
+const (
+	StatusOK        = "ok"         // All annotators succeeded
+	StatusPartial   = "partial"    // Some annotators succeeded
+	StatusNotFound  = "not-found"  // The IP is in none of the datasets
+	StatusInvalidIP = "invalid-ip" // The IP could not be parsed
+	StatusNotLoaded = "not-loaded" // A dataset could not be loaded; retrying may help
+	StatusError     = "error"      // Any other error
+)
+
+// Status returns the annotation status for the error returned by
+// Annotator.Annotate.  When all annotators fail for different reasons, the
+// most actionable one is reported: invalid-ip, then not-loaded, then error,
+// and not-found only when all of them failed to find the IP.
+func Status(err error) string {
+	if err == nil {
+		return StatusOK
+	}
+	if ce, ok := err.(*CompositeError); ok && ce.partial() {
+		return StatusPartial
+	}
+	switch {
+	case errors.Is(err, ErrInvalidIP):
+		return StatusInvalidIP
+	case errors.Is(err, ErrNotLoaded):
+		return StatusNotLoaded
+	}
+	if ce, ok := err.(*CompositeError); ok {
+		for i := range ce.Errors {
+			if Status(ce.Errors[i]) != StatusNotFound {
+				return StatusError
+			}
+		}
+		return StatusNotFound
+	}
+	if errors.Is(err, ErrNotFound) {
+		return StatusNotFound
+	}
+	return StatusError
+}
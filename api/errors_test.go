@@ -0,0 +1,51 @@
+package api_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/m-lab/annotation-service/api"
+)
+
+func TestNewError(t *testing.T) {
+	err := api.NewError("No record", api.ErrNotFound)
+	if err.Error() != "No record" {
+		t.Error("Error() =", err.Error())
+	}
+	if !errors.Is(err, api.ErrNotFound) || errors.Is(err, api.ErrNotLoaded) {
+		t.Error("wrong kind", err)
+	}
+
+	cause := errors.New("cannot read file")
+	err = api.WrapError(cause, api.ErrNotLoaded)
+	if err.Error() != "cannot read file" {
+		t.Error("Error() =", err.Error())
+	}
+	if !errors.Is(err, api.ErrNotLoaded) || !errors.Is(err, cause) {
+		t.Error("wrong kind", err)
+	}
+}
+
+func TestStatus(t *testing.T) {
+	notFound := api.NewError("not found", api.ErrNotFound)
+	invalid := api.NewError("invalid", api.ErrInvalidIP)
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{nil, api.StatusOK},
+		{notFound, api.StatusNotFound},
+		{invalid, api.StatusInvalidIP},
+		{api.WrapError(errors.New("no such object"), api.ErrNotLoaded), api.StatusNotLoaded},
+		{errors.New("other"), api.StatusError},
+		{&api.CompositeError{Errors: []error{notFound}, Total: 2}, api.StatusPartial},
+		{&api.CompositeError{Errors: []error{notFound, notFound}, Total: 2}, api.StatusNotFound},
+		{&api.CompositeError{Errors: []error{notFound, invalid}, Total: 2}, api.StatusInvalidIP},
+		{&api.CompositeError{Errors: []error{notFound, errors.New("other")}, Total: 2}, api.StatusError},
+	}
+	for _, tt := range tests {
+		if got := api.Status(tt.err); got != tt.want {
+			t.Errorf("Status(%v) = %s, want %s", tt.err, got, tt.want)
+		}
+	}
+}
@@ -40,6 +40,10 @@ type Request struct {
 	RequestInfo string    // Arbitrary info about the requester, to be used, e.g., for stats.
 	Date        time.Time // The date to be used to annotate the addresses.
 	IPs         []string  // The IP addresses to be annotated
+	// How the geolocation and AS datasets are selected for the Date:
+	// "last-before" (the default), "nearest" or "first-after".
+	GeoPolicy string `json:",omitempty"`
+	ASNPolicy string `json:",omitempty"`
 }
 
 // NewRequest returns a partially initialized requests.  Caller should fill in IPs.
@@ -49,9 +53,125 @@ func NewRequest(date time.Time, ips []string) Request {
 
 // Response describes data returned in V2 responses (json encoded).
 type Response struct {
-	// TODO should we include additional metadata about the annotator sources?  Perhaps map of filenames?
 	AnnotatorDate time.Time                   // The publication date(s) of the dataset used for the annotation
 	Annotations   map[string]*api.Annotations // Map from human readable IP address to GeoData
+	Provenance    []api.Provenance            `json:",omitempty"` // The datasets used for the annotations
+	Missing       []string                    `json:",omitempty"` // The sources without datasets for the date, if any
+	Stale         []string                    `json:",omitempty"` // The sources whose datasets are older than their validity window
+	Extrapolated  []string                    `json:",omitempty"` // The sources whose datasets are later than the date
+	Status        map[string]string           `json:",omitempty"` // Map from IP address to api.Status, e.g. "ok" or "not-found"
+}
+
+// MultiDateRequestTag is the string associated with MultiDateRequests.
+const MultiDateRequestTag = "Annotate v2.0 multi-date"
+
+// MultiDateRequest is like Request, but has a date for each IP address.
+type MultiDateRequest struct {
+	RequestType string         // This should contain "Annotate v2.0 multi-date"
+	RequestInfo string         // Arbitrary info about the requester, to be used, e.g., for stats.
+	IPs         []StreamRecord // The IP addresses to be annotated, and their dates
+	// How the datasets are selected for the dates, as in Request.
+	GeoPolicy string `json:",omitempty"`
+	ASNPolicy string `json:",omitempty"`
+}
+
+// NewMultiDateRequest returns a MultiDateRequest for the records.
+func NewMultiDateRequest(records []StreamRecord) MultiDateRequest {
+	return MultiDateRequest{RequestType: MultiDateRequestTag, IPs: records}
+}
+
+// MultiDateResponse describes data returned for MultiDateRequests (json encoded).
+type MultiDateResponse struct {
+	// The results for each of the request IPs, in the same order.  Each one
+	// has the date of the annotator applied to the IP.
+	Results []StreamResult
+}
+
+// StreamRecord is one line of the newline delimited JSON body of a
+// /stream_annotate request.
+type StreamRecord struct {
+	IP   string    // The IP address to be annotated
+	Date time.Time // The date to be used to annotate the address.
+}
+
+// StreamResult is one line of the newline delimited JSON /stream_annotate
+// response.  There is one StreamResult for each StreamRecord, in the same order.
+type StreamResult struct {
+	IP            string
+	AnnotatorDate time.Time        // The publication date of the dataset used for the annotation
+	Annotation    *api.Annotations `json:",omitempty"`
+	Missing       []string         `json:",omitempty"` // The sources without datasets for the date, if any
+	Stale         []string         `json:",omitempty"` // The sources whose datasets are older than their validity window
+	Extrapolated  []string         `json:",omitempty"` // The sources whose datasets are later than the date
+	Status        string           `json:",omitempty"` // The api.Status of the annotation
+	Error         string           `json:",omitempty"` // Why the IP could not be annotated
+}
+
+// PrefixesResponse is the JSON response to /covering_prefixes requests.
+type PrefixesResponse struct {
+	AnnotatorDate time.Time    // The publication date of the dataset used
+	IP            string       // The requested IP address
+	Prefixes      []api.ASData // The covering prefixes, from the least to the most specific
+}
+
+// RangeResponse is the JSON response to /annotate_range requests.
+type RangeResponse struct {
+	AnnotatorDate time.Time              // The publication date(s) of the dataset used for the annotation
+	CIDR          string                 // The requested prefix
+	Ranges        []api.RangeAnnotations // The ranges of the prefix with different annotations, in address order
+	Provenance    []api.Provenance       `json:",omitempty"` // The datasets used for the annotations
+	Missing       []string               `json:",omitempty"` // The sources without datasets for the date, if any
+	Stale         []string               `json:",omitempty"` // The sources whose datasets are older than their validity window
+	Extrapolated  []string               `json:",omitempty"` // The sources whose datasets are later than the date
+}
+
+// DatasetInfo describes a dataset used by an annotator of the directory.
+type DatasetInfo struct {
+	api.Provenance        // The type, object name and date of the dataset
+	Loaded         bool   // False for lazy datasets that are not currently loaded
+	Nodes          int    `json:",omitempty"` // The number of ranges or prefixes, if known
+	Size           int64  `json:",omitempty"` // The approximate memory used, in bytes, if known
+	Error          string `json:",omitempty"` // The error of the last load, if it failed
+}
+
+// AnnotatorInfo describes a (composite) annotator of the directory, and the
+// dates it is used for.
+type AnnotatorInfo struct {
+	Date     time.Time     // The annotator is used for dates after this one...
+	Until    *time.Time    `json:",omitempty"` // ...up to this one, or all later dates if nil
+	Datasets []DatasetInfo // The datasets wrapped by the annotator, in order
+}
+
+// DatasetLoad is a dataset load that is in progress, or that failed.
+type DatasetLoad struct {
+	api.Provenance           // The type and object name of the dataset
+	Since          time.Time // When the load started, or the last attempt failed
+	Error          string    `json:",omitempty"` // The load error, for failed loads
+	Attempts       int       `json:",omitempty"` // The number of failed attempts
+	Quarantined    bool      `json:",omitempty"` // True if the dataset is corrupt, and is not loaded again
+}
+
+// DatasetsResponse is the JSON response to /v2/datasets requests.
+type DatasetsResponse struct {
+	Annotators []AnnotatorInfo // The annotators of the directory, in date order
+	Pending    []DatasetLoad   // The datasets being loaded
+	Failed     []DatasetLoad   // The datasets that failed to load
+}
+
+// DirectoryInfo summarizes a directory of annotators.
+type DirectoryInfo struct {
+	Annotators int       // The number of annotators
+	Latest     time.Time // The date of the latest annotator
+	Since      time.Time // When the directory was swapped in
+}
+
+// DirectoryStatus is the JSON response to /admin/directory requests.
+type DirectoryStatus struct {
+	Current    *DirectoryInfo `json:",omitempty"`
+	Previous   *DirectoryInfo `json:",omitempty"` // The directory kept for rollback
+	Held       bool           `json:",omitempty"` // Whether the updates are held after a rollback
+	Rejected   string         `json:",omitempty"` // Why the last update was rejected, since the last swap
+	RejectedAt *time.Time     `json:",omitempty"`
 }
 
 // Annotator defines the GetAnnotations method used for annotating.
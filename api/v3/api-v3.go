@@ -0,0 +1,77 @@
+// Package api describes the v3 JSON API, which serves routing and dataset
+// queries that are not tied to annotating IP addresses.
+package api
+
+import (
+	"net"
+	"time"
+
+	"github.com/m-lab/annotation-service/api"
+)
+
+/*************************************************************************
+*                       Request/Response Structs                         *
+*************************************************************************/
+
+// OriginPrefix is a prefix originated by an AS.
+type OriginPrefix struct {
+	CIDR    string       // The announced prefix
+	Systems []api.System // The origin AS sets, most common first, as in api.ASData
+	MOAS    bool         `json:",omitempty"` // True if the prefix has multiple origin ASes
+}
+
+// ASNPrefixesResponse is the JSON response to /v3/asn/{asn}/prefixes requests.
+type ASNPrefixesResponse struct {
+	ASN           uint32
+	AnnotatorDate time.Time        // The date of the annotator selected for the request date
+	Provenance    []api.Provenance // The RouteViews datasets searched, with their dates
+	Prefixes      []OriginPrefix   // In address order
+}
+
+// Kinds of changes reported in RangeChange.Kinds.
+const (
+	GeoAdded       = "geo-added"   // The range was not geolocated before
+	GeoRemoved     = "geo-removed" // The range is no longer geolocated
+	CountryChanged = "country"     // The range moved to another country
+	CityChanged    = "city"        // The range moved to another city in the same country
+	ASNAdded       = "asn-added"   // The range was not routed before
+	ASNRemoved     = "asn-removed" // The range is no longer routed
+	ASNChanged     = "asn"         // The range is originated by another AS
+)
+
+// Fields reported in DiffResponse.Skipped.
+const (
+	GeoField = "geo"     // The Geo annotations
+	ASNField = "network" // The Network (AS) annotations
+)
+
+// RangeChange is a range of addresses whose annotations differ between two
+// annotators.
+type RangeChange struct {
+	First, Last net.IP   // The first and last addresses of the range
+	Kinds       []string // The kinds of changes, e.g. "country" or "asn-added"
+	Before      api.Annotations
+	After       api.Annotations
+}
+
+// ChangeCount counts the changed ranges of a country or AS.
+type ChangeCount struct {
+	Gained int // Ranges annotated with it after, but not before
+	Lost   int // Ranges annotated with it before, but not after
+}
+
+// DiffResponse is the JSON response to /admin/diff requests, and the output
+// of the annodiff command.
+type DiffResponse struct {
+	Before, After    time.Time        // The dates of the annotators compared
+	BeforeProvenance []api.Provenance // The datasets of the Before annotator
+	AfterProvenance  []api.Provenance // The datasets of the After annotator
+	Kinds            map[string]int   // The number of changed ranges of each kind
+	Countries        map[string]ChangeCount
+	ASNs             map[uint32]ChangeCount
+	Changes          []RangeChange // The changed ranges, in address order, IPv4 first
+	Truncated        bool          `json:",omitempty"` // True if some changes were left out of Changes
+	// The fields left out of the comparison, GeoField or ASNField, as the
+	// datasets of one of the annotators don't support range queries.
+	Skipped []string `json:",omitempty"`
+}
@@ -3,6 +3,7 @@ package asn
 import (
 	"errors"
 	"log"
+	"net"
 	"regexp"
 	"strconv"
 	"strings"
@@ -21,28 +22,41 @@ var (
 
 	// ErrorIllegalIPNodeType raised when the ASNDataset contains IPNode which is not an ASNIPNode
 	ErrorIllegalIPNodeType = errors.New("Illegal IPNode type found")
-	annotateLogger         = logx.NewLogEvery(nil, time.Second)
+	// ErrNilDataset is returned when Annotate is called on a nil ASNDataset.
+	ErrNilDataset = api.NewError("Nil ASN dataset", api.ErrNotLoaded)
+	// ErrAlreadyPopulated is returned when the Network annotation is already populated.
+	ErrAlreadyPopulated = api.NewError("Network annotation already populated", api.ErrAlreadyPopulated)
+	// ErrWrongIPFamily is returned when an IPv4 address is requested from an
+	// IPv6 dataset, or vice versa.
+	ErrWrongIPFamily = api.NewError("IP family does not match dataset", api.ErrNotApplicable)
+	// ErrNoPrefixes is returned by CoveringPrefixes for datasets that do not
+	// keep the prefix hierarchy, like GeoLite2-ASN.
+	ErrNoPrefixes = api.NewError("Dataset has no prefix hierarchy", api.ErrNotApplicable)
+	// ErrNoOriginPrefixes is returned by OriginPrefixes for AS numbers that
+	// originate no prefix in the dataset.
+	ErrNoOriginPrefixes = api.NewError("No prefixes originated by AS", api.ErrNotFound)
+	annotateLogger      = logx.NewLogEvery(nil, time.Second)
 )
 
 // Annotate expects an IP string and an api.GeoData pointer to find the ASN
 // and populate the data into the GeoData.ASN struct
 func (asn *ASNDataset) Annotate(ip string, ann *api.GeoData) error {
 	if asn == nil {
-		return errors.New("ErrNilASNDataset") // TODO
+		return ErrNilDataset
 	}
 	if ann.Network != nil {
-		return errors.New("ErrAlreadyPopulated") // TODO
-	}
-
-	ipNodeGetter := func(idx int) iputils.IPNode {
-		return &asn.IPList[idx]
+		return ErrAlreadyPopulated
 	}
 
 	parsed, err := iputils.ParseIPWithMetrics(ip)
 	if err != nil {
 		return err
 	}
-	node, err := iputils.SearchBinary(parsed, len(asn.IPList), ipNodeGetter)
+	if isIPv4 := parsed.To4() != nil; (isIPv4 && !asn.ipv4) || (!isIPv4 && !asn.ipv6) {
+		// e.g. a RouteViews IPv4 dataset, for an IPv6 address.
+		return ErrWrongIPFamily
+	}
+	row, err := asn.search(parsed)
 	if err != nil {
 		// ErrNodeNotFound is super spammy - 10% of requests, so suppress those.
 		if err != iputils.ErrNodeNotFound {
@@ -53,17 +67,18 @@ func (asn *ASNDataset) Annotate(ip string, ann *api.GeoData) error {
 		return err
 	}
 
-	asnNode, ok := node.(*ASNIPNode)
-	if !ok {
-		return ErrorIllegalIPNodeType
-	}
-
-	result := api.ASData{}
+	low, high := asn.bounds(row)
+	result := asn.asData(asn.asns.Get(row), low, high)
+	ann.Network = &result
+	return nil
+}
 
+// parseSystems returns the Systems of an ASNString from a pfx2as file.
+func parseSystems(asnString string) []api.System {
 	// split the set on underscores (multi-origin ASNs)
 	// TODO - this should be done in the ASN loader, not here.
-	systems := strings.Split(asnNode.ASNString, "_")
-	result.Systems = make([]api.System, 0, len(systems))
+	systems := strings.Split(asnString, "_")
+	result := make([]api.System, 0, len(systems))
 	for _, asn := range systems {
 		// split the set elements on comas (ASN set)
 		asnList := strings.Split(asn, ",")
@@ -77,9 +92,35 @@ func (asn *ASNDataset) Annotate(ip string, ann *api.GeoData) error {
 			}
 		}
 		newSystem := api.System{ASNs: intList}
-		result.Systems = append(result.Systems, newSystem)
+		result = append(result, newSystem)
 	}
-	result.CIDR = iputils.CIDRRange(asnNode.IPAddressLow, asnNode.IPAddressHigh)
+	return result
+}
+
+// AnnotateRange returns the AS data of each range or prefix overlapping the
+// prefix, clipped to it.  The CIDR of each one is the one Annotate would
+// return, not the clipped range.  See api.RangeAnnotator.
+func (asn *ASNDataset) AnnotateRange(prefix *net.IPNet) ([]api.RangeAnnotations, error) {
+	if asn == nil {
+		return nil, ErrNilDataset
+	}
+	ranges := asn.searchRange(iputils.PrefixBounds(prefix))
+	result := make([]api.RangeAnnotations, len(ranges))
+	for i := range ranges {
+		low, high := asn.bounds(ranges[i].Row)
+		data := asn.asData(asn.asns.Get(ranges[i].Row), low, high)
+		result[i].Network = &data
+		result[i].First, result[i].Last = ranges[i].First, ranges[i].Last
+	}
+	return result, nil
+}
+
+// asData returns the ASData for the range or prefix [low, high], with the
+// ASNString from the pfx2as file.
+func (asn *ASNDataset) asData(asnString string, low, high net.IP) api.ASData {
+	result := api.ASData{}
+	result.Systems = parseSystems(asnString)
+	result.CIDR = iputils.CIDRRange(low, high)
 	if len(result.Systems) > 0 &&
 		len(result.Systems[0].ASNs) > 0 {
 		result.ASNumber = result.Systems[0].ASNs[0]
@@ -87,11 +128,62 @@ func (asn *ASNDataset) Annotate(ip string, ann *api.GeoData) error {
 	} else {
 		result.Missing = true
 	}
-	ann.Network = &result
-	return nil
+	return result
+}
+
+// CoveringPrefixes returns all the pfx2as prefixes containing the IP, from the
+// least to the most specific, each with its origin AS set.  It returns
+// ErrNoPrefixes if the dataset does not keep the prefix hierarchy.
+func (asn *ASNDataset) CoveringPrefixes(ip string) ([]api.ASData, error) {
+	if asn == nil {
+		return nil, ErrNilDataset
+	}
+	if asn.prefixes == nil {
+		return nil, ErrNoPrefixes
+	}
+	parsed, err := iputils.ParseIPWithMetrics(ip)
+	if err != nil {
+		return nil, err
+	}
+	rows := asn.prefixes.Covering(parsed)
+	if len(rows) == 0 {
+		return nil, iputils.ErrNodeNotFound
+	}
+	result := make([]api.ASData, len(rows))
+	for i, row := range rows {
+		low, high := asn.prefixes.Bounds(row)
+		result[i] = asn.asData(asn.prefixASNs.Get(row), low, high)
+	}
+	return result, nil
+}
+
+// OriginPrefixes returns all the pfx2as prefixes originated by the AS, alone
+// or as part of a multi-origin or AS set origin, in address order.
+func (asn *ASNDataset) OriginPrefixes(number uint32) ([]api.ASData, error) {
+	if asn == nil {
+		return nil, ErrNilDataset
+	}
+	if asn.prefixes == nil {
+		return nil, ErrNoPrefixes
+	}
+	rows := asn.origins[number]
+	if len(rows) == 0 {
+		return nil, ErrNoOriginPrefixes
+	}
+	result := make([]api.ASData, len(rows))
+	for i, row := range rows {
+		low, high := asn.prefixes.Bounds(int(row))
+		result[i] = asn.asData(asn.prefixASNs.Get(int(row)), low, high)
+	}
+	return result, nil
 }
 
 // AnnotatorDate The date associated with the dataset.
 func (asn *ASNDataset) AnnotatorDate() time.Time {
 	return asn.Start
 }
+
+// Provenance returns the type, name and date of the dataset.
+func (asn *ASNDataset) Provenance() []api.Provenance {
+	return []api.Provenance{{Type: asn.Type, Name: asn.Name, Date: asn.Start}}
+}
@@ -1,13 +1,15 @@
 package asn
 
 import (
+	"compress/gzip"
 	"context"
 	"encoding/csv"
 	"errors"
 	"io"
 	"io/ioutil"
 	"log"
-	"os"
+	"net"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -15,8 +17,11 @@ import (
 
 	"cloud.google.com/go/storage"
 	"github.com/m-lab/annotation-service/api"
+	"github.com/m-lab/annotation-service/iptrie"
 	"github.com/m-lab/annotation-service/iputils"
 	"github.com/m-lab/annotation-service/loader"
+	"github.com/m-lab/annotation-service/rangetable"
+	"github.com/m-lab/annotation-service/snapshot"
 	"github.com/m-lab/go/rtx"
 	"github.com/m-lab/uuid-annotator/ipinfo"
 )
@@ -26,6 +31,11 @@ var (
 
 	errExtractDateFromFilename = errors.New("cannot extract date from input filename")
 
+	// LookupEngine selects the lookup structure built when datasets are loaded
+	// from the raw files.  Snapshots hold range lists, so they are not used
+	// with iputils.PrefixTrie.
+	LookupEngine = iputils.RangeList
+
 	// ASNamesFile names the ASN source data.
 	ASNamesFile = "data/asnames.ipinfo.csv"
 
@@ -40,9 +50,188 @@ var (
 
 // ASNDataset holds the database in the memory
 type ASNDataset struct {
-	IPList  []ASNIPNode
 	ASNames ipinfo.ASNames
 	Start   time.Time // Date from which to start using this dataset
+	Type    string    // The dataset type, api.RouteViewsType or api.GeoLite2ASNType
+	Name    string    // The object the dataset was loaded from
+
+	ranges rangetable.Table   // The IP ranges
+	trie   *iptrie.Trie       // The prefixes, used instead of ranges when not nil
+	asns   rangetable.Strings // The ASNString of each range or prefix
+	snap   *snapshot.Mapping  // The snapshot holding the tables, if they were loaded from one
+
+	// The original pfx2as prefixes, including those hidden by more specific
+	// ones in the ranges, or nil if the hierarchy is not kept.  With the
+	// trie engine, they are the same as trie and asns.
+	prefixes   *iptrie.Trie
+	prefixASNs rangetable.Strings // The ASNString of each prefix
+	origins    map[uint32][]int32 // The prefix rows of each origin AS, in address order
+
+	// Whether the dataset has IPv4 and IPv6 ranges, so that lookups in a
+	// missing family are not reported as not found.
+	ipv4, ipv6 bool
+}
+
+// NewASNDataset creates a dataset from a sorted node list.
+func NewASNDataset(nodes []ASNIPNode) *ASNDataset {
+	b := rangetable.Builder{}
+	for i := range nodes {
+		b.Add(nodes[i].IPAddressLow, nodes[i].IPAddressHigh)
+	}
+	ranges, order := b.Build()
+	asns := make([]string, len(order))
+	for row, i := range order {
+		asns[row] = nodes[i].ASNString
+	}
+	ds := &ASNDataset{ranges: ranges, asns: rangetable.NewStrings(asns)}
+	ds.ipv4, ds.ipv6 = ranges.Families()
+	return ds
+}
+
+// NewASNTrieDataset creates a dataset from a node list holding the original
+// prefixes, which may be nested and in any order.  The prefixes are stored in
+// a trie, and searches return the most specific one, with its exact CIDR.
+func NewASNTrieDataset(nodes []ASNIPNode) (*ASNDataset, error) {
+	b := iptrie.Builder{}
+	asns := make([]string, len(nodes))
+	for i := range nodes {
+		if err := b.Add(nodes[i].IPAddressLow, nodes[i].IPAddressHigh); err != nil {
+			return nil, err
+		}
+		asns[i] = nodes[i].ASNString
+	}
+	ds := &ASNDataset{trie: b.Build(), asns: rangetable.NewStrings(asns)}
+	ds.ipv4, ds.ipv6 = ds.trie.Families()
+	return ds, nil
+}
+
+// newDataset creates a dataset from the nodes read by buildNodes.
+func newDataset(nodes []ASNIPNode) (*ASNDataset, error) {
+	if LookupEngine == iputils.PrefixTrie {
+		return NewASNTrieDataset(nodes)
+	}
+	return NewASNDataset(nodes), nil
+}
+
+// newRouteViewsDataset creates a dataset from the nodes read by parser, and
+// keeps the original prefixes as well, for CoveringPrefixes.
+func newRouteViewsDataset(parser *asnNodeParser) (*ASNDataset, error) {
+	ds, err := newDataset(parser.list)
+	if err != nil {
+		return nil, err
+	}
+	if ds.trie != nil {
+		ds.prefixes, ds.prefixASNs = ds.trie, ds.asns
+	} else {
+		hierarchy, err := NewASNTrieDataset(parser.prefixes)
+		if err != nil {
+			return nil, err
+		}
+		ds.prefixes, ds.prefixASNs = hierarchy.trie, hierarchy.asns
+	}
+	ds.buildOrigins()
+	return ds, nil
+}
+
+// buildOrigins builds the index from origin AS numbers to the prefixes they
+// originate, for OriginPrefixes.  Every AS of multi-origin prefixes and AS
+// sets is indexed.
+func (asn *ASNDataset) buildOrigins() {
+	asn.origins = map[uint32][]int32{}
+	parsed := map[string][]uint32{} // The distinct AS numbers of each ASNString
+	for row := 0; row < asn.prefixASNs.Len(); row++ {
+		s := asn.prefixASNs.Get(row)
+		numbers, ok := parsed[s]
+		if !ok {
+			seen := map[uint32]bool{}
+			for _, system := range parseSystems(s) {
+				for _, number := range system.ASNs {
+					if !seen[number] {
+						seen[number] = true
+						numbers = append(numbers, number)
+					}
+				}
+			}
+			parsed[s] = numbers
+		}
+		for _, number := range numbers {
+			asn.origins[number] = append(asn.origins[number], int32(row))
+		}
+	}
+	for _, rows := range asn.origins {
+		sort.Slice(rows, func(i, j int) bool { return asn.prefixes.Less(int(rows[i]), int(rows[j])) })
+	}
+}
+
+// buildNodes reads the nodes with the parser, as a range list, or as a prefix
+// list for iputils.PrefixTrie.
+func buildNodes(reader io.Reader, parser iputils.IPNodeParser) error {
+	if LookupEngine == iputils.PrefixTrie {
+		return iputils.BuildPrefixList(reader, parser)
+	}
+	return iputils.BuildIPNodeList(reader, parser)
+}
+
+// search returns the row of the range or prefix containing ip.
+func (asn *ASNDataset) search(ip net.IP) (int, error) {
+	if asn.trie != nil {
+		return asn.trie.Search(ip)
+	}
+	return asn.ranges.Search(ip)
+}
+
+// bounds returns the first and last addresses of the range or prefix in the row.
+func (asn *ASNDataset) bounds(row int) (net.IP, net.IP) {
+	if asn.trie != nil {
+		return asn.trie.Bounds(row)
+	}
+	return asn.ranges.Bounds(row)
+}
+
+// searchRange returns the ranges or prefixes overlapping [first, last],
+// clipped to it.
+func (asn *ASNDataset) searchRange(first, last net.IP) []iputils.RowRange {
+	if asn.trie != nil {
+		return asn.trie.SearchRange(first, last)
+	}
+	return asn.ranges.SearchRange(first, last)
+}
+
+// NodeCount returns the number of ranges, or prefixes, used for annotations.
+func (asn *ASNDataset) NodeCount() int {
+	if asn.trie != nil {
+		return asn.trie.Len()
+	}
+	return asn.ranges.Len()
+}
+
+// Size returns the approximate memory used by the dataset, in bytes.  The AS
+// names are not included, as they are usually shared with other datasets.
+func (asn *ASNDataset) Size() int64 {
+	var size int64
+	if asn.trie != nil {
+		size = asn.trie.Size() + asn.asns.Size()
+	} else {
+		size = asn.ranges.Size() + asn.asns.Size()
+		if asn.prefixes != nil {
+			size += asn.prefixes.Size() + asn.prefixASNs.Size()
+		}
+	}
+	for _, rows := range asn.origins {
+		size += 32 + 4*int64(len(rows))
+	}
+	return size
+}
+
+// IPList returns a copy of the node list.  It allocates the whole list, so it
+// is meant for tests and tools, not for lookups.
+func (asn *ASNDataset) IPList() []ASNIPNode {
+	nodes := make([]ASNIPNode, asn.asns.Len())
+	for row := range nodes {
+		nodes[row].IPAddressLow, nodes[row].IPAddressHigh = asn.bounds(row)
+		nodes[row].ASNString = asn.asns.Get(row)
+	}
+	return nodes
 }
 
 //-----------------------------------------------------------------
@@ -72,7 +261,8 @@ func (n *ASNIPNode) DataEquals(other iputils.IPNode) bool {
 
 // asnNodeParser the parser object
 type asnNodeParser struct {
-	list []ASNIPNode
+	list     []ASNIPNode
+	prefixes []ASNIPNode // Every record, as read, before the list is flattened
 }
 
 func createAsnNodeParser() *asnNodeParser {
@@ -105,6 +295,7 @@ func (p *asnNodeParser) PopulateRecordData(record []string, node iputils.IPNode)
 		return ErrorIllegalIPNodeType
 	}
 	asnNode.ASNString = record[2]
+	p.prefixes = append(p.prefixes, *asnNode)
 	return nil
 }
 
@@ -130,24 +321,34 @@ func (p *asnNodeParser) LastNode() iputils.IPNode {
 // DATASET LOADER IMPLEMENTATION
 //-----------------------------------------------------------------
 
-// LoadASNDataset loads a dataset from a GCS object.
-func LoadASNDataset(file *storage.ObjectAttrs) (api.Annotator, error) {
-	dataFileName := loader.GetGzBase(file.Name)
-	err := loader.UncompressGzFile(context.Background(), file.Bucket, file.Name, dataFileName)
+// LoadASNDataset loads a dataset from an object in the dataset source.
+// If there is a snapshot of the dataset next to it, the snapshot is used instead.
+func LoadASNDataset(src loader.Source, file *storage.ObjectAttrs) (api.Annotator, error) {
+	dataset, err := loadSnapshot(src, file.Name)
 	if err != nil {
-		return nil, err
+		dataset, err = LoadRouteViews(src, file.Name)
+		if err != nil {
+			return nil, err
+		}
 	}
-	defer os.Remove(dataFileName)
-	nodes, err := loadData(dataFileName, file.Name)
+
+	time, err := ExtractTimeFromASNFileName(loader.GetGzBase(file.Name))
 	if err != nil {
 		return nil, err
 	}
+	dataset.Start = *time
+	dataset.Type = api.RouteViewsType
+	dataset.Name = file.Name
 
-	time, err := ExtractTimeFromASNFileName(dataFileName)
-	if err != nil {
-		return nil, err
+	// RouteViews data has no AS names, so use the ipinfo names.
+	if len(dataset.ASNames) == 0 {
+		dataset.ASNames = loadASNames()
 	}
+	return dataset, nil
+}
 
+// loadASNames returns the AS names from ASNamesFile, loading them on first use.
+func loadASNames() ipinfo.ASNames {
 	once.Do(func() {
 		// Load the ipinfo CSV containing the ASN -> ASName mapping.
 		content, err := ioutil.ReadFile(ASNamesFile)
@@ -155,31 +356,45 @@ func LoadASNDataset(file *storage.ObjectAttrs) (api.Annotator, error) {
 		asnames, err = ipinfo.Parse(content)
 		rtx.Must(err, "Cannot parse asnames file")
 	})
-
-	return &ASNDataset{IPList: nodes, Start: *time, ASNames: asnames}, nil
+	return asnames
 }
 
-// LoadASNDatasetFromReader produces a new ASN api.Annotator.
-func LoadASNDatasetFromReader(file io.Reader) (api.Annotator, error) {
+// LoadRouteViews loads a RouteViews pfx2as file from the dataset source, ignoring
+// any snapshot.  Gzipped files are decompressed while reading.  The returned
+// dataset has no start date or AS names set.
+func LoadRouteViews(src loader.Source, filename string) (*ASNDataset, error) {
+	log.Println("Loading dataset from", filename)
+	rdr, err := src.NewReader(context.Background(), filename)
+	if err != nil {
+		return nil, err
+	}
+	defer rdr.Close()
+
+	var data io.Reader = rdr
+	if strings.HasSuffix(filename, ".gz") {
+		gzr, err := gzip.NewReader(rdr)
+		if err != nil {
+			return nil, err
+		}
+		defer gzr.Close()
+		data = gzr
+	}
 	parser := createAsnNodeParser()
-	err := iputils.BuildIPNodeList(file, parser)
+	err = buildNodes(data, parser)
 	if err != nil {
 		return nil, err
 	}
-	return &ASNDataset{IPList: parser.list}, nil
+	return newRouteViewsDataset(parser)
 }
 
-// loadData loads the data into an ASNIPNode list
-// FIXME eliminate, it's a copy-paste of LoadIPListGLite2 from geo-g2.go
-func loadData(fileName, datasetName string) ([]ASNIPNode, error) {
-	file, err := os.Open(fileName)
+// LoadASNDatasetFromReader produces a new ASN api.Annotator.
+func LoadASNDatasetFromReader(file io.Reader) (api.Annotator, error) {
+	parser := createAsnNodeParser()
+	err := buildNodes(file, parser)
 	if err != nil {
 		return nil, err
 	}
-	defer file.Close()
-
-	parser := createAsnNodeParser()
-	return parser.list, iputils.BuildIPNodeList(file, parser)
+	return newRouteViewsDataset(parser)
 }
 
 // ExtractTimeFromASNFileName extract the start time of the dataset validity
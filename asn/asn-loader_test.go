@@ -2,10 +2,16 @@ package asn
 
 import (
 	"bytes"
+	"fmt"
 	"io/ioutil"
+	"net"
+	"strings"
 	"testing"
 
+	"github.com/go-test/deep"
+
 	"github.com/m-lab/annotation-service/api"
+	"github.com/m-lab/annotation-service/iputils"
 	"github.com/m-lab/go/rtx"
 )
 
@@ -55,3 +61,170 @@ func TestLoadASNDatasetFromReader(t *testing.T) {
 		})
 	}
 }
+
+func TestLoadASNDatasetFromReaderTrie(t *testing.T) {
+	// A /16 with a more specific /24 inside it, announced by another AS.
+	pfx2as := "1.0.0.0\t16\t100\n" +
+		"1.0.1.0\t24\t200\n" +
+		"2001:db8::\t32\t300\n"
+	tests := []struct {
+		ip       string
+		asn      uint32
+		trieCIDR string
+		listCIDR string // From the flattened ranges, which may not be prefixes
+	}{
+		{ip: "1.0.0.1", asn: 100, trieCIDR: "1.0.0.0/16", listCIDR: "1.0.0.0/24"},
+		{ip: "1.0.1.1", asn: 200, trieCIDR: "1.0.1.0/24", listCIDR: "1.0.1.0/24"},
+		{ip: "1.0.2.1", asn: 100, trieCIDR: "1.0.0.0/16", listCIDR: "1.0.2.0/17"}, // 1.0.2.0-1.0.255.255
+		{ip: "2001:db8::1", asn: 300, trieCIDR: "2001:db8::/32", listCIDR: "2001:db8::/32"},
+	}
+	defer func() { LookupEngine = iputils.RangeList }()
+	for _, engine := range []iputils.Engine{iputils.RangeList, iputils.PrefixTrie} {
+		LookupEngine = engine
+		ann, err := LoadASNDatasetFromReader(bytes.NewBufferString(pfx2as))
+		if err != nil {
+			t.Fatal(engine, err)
+		}
+		for _, tt := range tests {
+			data := &api.Annotations{}
+			if err := ann.Annotate(tt.ip, data); err != nil {
+				t.Error(engine, tt.ip, err)
+				continue
+			}
+			want := tt.listCIDR
+			if engine == iputils.PrefixTrie {
+				want = tt.trieCIDR
+			}
+			if data.Network.ASNumber != tt.asn || data.Network.CIDR != want {
+				t.Errorf("%s: Annotate(%s) = AS%d %s, want AS%d %s", engine, tt.ip,
+					data.Network.ASNumber, data.Network.CIDR, tt.asn, want)
+			}
+		}
+		if err := ann.(*ASNDataset).WriteSnapshot(ioutil.Discard); (err == ErrTrieSnapshot) != (engine == iputils.PrefixTrie) {
+			t.Error(engine, "WriteSnapshot() error =", err)
+		}
+	}
+}
+
+func TestCoveringPrefixes(t *testing.T) {
+	pfx2as := "1.0.0.0\t8\t100\n" +
+		"1.0.4.0\t22\t200_300\n" +
+		"1.0.0.0\t16\t400\n" +
+		"1.0.5.0\t24\t500\n"
+	tests := []struct {
+		ip      string
+		want    []string // CIDR and AS number of each prefix
+		wantErr error
+	}{
+		{ip: "1.0.5.1", want: []string{"1.0.0.0/8 100", "1.0.0.0/16 400", "1.0.4.0/22 200", "1.0.5.0/24 500"}},
+		{ip: "1.0.6.1", want: []string{"1.0.0.0/8 100", "1.0.0.0/16 400", "1.0.4.0/22 200"}},
+		{ip: "1.2.0.1", want: []string{"1.0.0.0/8 100"}},
+		{ip: "2.0.0.1", wantErr: iputils.ErrNodeNotFound},
+		{ip: "not an IP", wantErr: iputils.ErrInvalidIP},
+	}
+	defer func() { LookupEngine = iputils.RangeList }()
+	for _, engine := range []iputils.Engine{iputils.RangeList, iputils.PrefixTrie} {
+		LookupEngine = engine
+		ann, err := LoadASNDatasetFromReader(bytes.NewBufferString(pfx2as))
+		if err != nil {
+			t.Fatal(engine, err)
+		}
+		for _, tt := range tests {
+			prefixes, err := ann.(*ASNDataset).CoveringPrefixes(tt.ip)
+			if err != tt.wantErr {
+				t.Errorf("%s: CoveringPrefixes(%s) error = %v, want %v", engine, tt.ip, err, tt.wantErr)
+				continue
+			}
+			got := []string{}
+			for _, p := range prefixes {
+				got = append(got, fmt.Sprintf("%s %d", p.CIDR, p.ASNumber))
+			}
+			if diff := deep.Equal(got, tt.want); err == nil && diff != nil {
+				t.Errorf("%s: CoveringPrefixes(%s) %v", engine, tt.ip, diff)
+			}
+		}
+	}
+
+	// Datasets created from flattened nodes have no hierarchy.
+	if _, err := NewASNDataset(nil).CoveringPrefixes("1.0.0.1"); err != ErrNoPrefixes {
+		t.Error("CoveringPrefixes() error =", err)
+	}
+}
+
+func TestOriginPrefixes(t *testing.T) {
+	pfx2as := "1.0.4.0\t22\t200_300\n" +
+		"1.0.0.0\t16\t200\n" +
+		"1.0.5.0\t24\t300,400\n" +
+		"2001:db8::\t32\t200\n" +
+		"1.0.0.0\t8\t100\n"
+	tests := []struct {
+		asn     uint32
+		want    []string
+		wantErr error
+	}{
+		{asn: 200, want: []string{"1.0.0.0/16 200", "1.0.4.0/22 200_300", "2001:db8::/32 200"}},
+		{asn: 300, want: []string{"1.0.4.0/22 200_300", "1.0.5.0/24 300,400"}},
+		{asn: 400, want: []string{"1.0.5.0/24 300,400"}},
+		{asn: 500, wantErr: ErrNoOriginPrefixes},
+	}
+	defer func() { LookupEngine = iputils.RangeList }()
+	for _, engine := range []iputils.Engine{iputils.RangeList, iputils.PrefixTrie} {
+		LookupEngine = engine
+		ann, err := LoadASNDatasetFromReader(bytes.NewBufferString(pfx2as))
+		if err != nil {
+			t.Fatal(engine, err)
+		}
+		for _, tt := range tests {
+			prefixes, err := ann.(*ASNDataset).OriginPrefixes(tt.asn)
+			if err != tt.wantErr {
+				t.Errorf("%s: OriginPrefixes(%d) error = %v, want %v", engine, tt.asn, err, tt.wantErr)
+				continue
+			}
+			got := []string{}
+			for _, p := range prefixes {
+				systems := []string{}
+				for _, s := range p.Systems {
+					asns := []string{}
+					for _, asn := range s.ASNs {
+						asns = append(asns, fmt.Sprint(asn))
+					}
+					systems = append(systems, strings.Join(asns, ","))
+				}
+				got = append(got, p.CIDR+" "+strings.Join(systems, "_"))
+			}
+			if diff := deep.Equal(got, tt.want); err == nil && diff != nil {
+				t.Errorf("%s: OriginPrefixes(%d) %v", engine, tt.asn, diff)
+			}
+		}
+	}
+}
+
+func TestAnnotateRange(t *testing.T) {
+	pfx2as := "1.0.0.0\t16\t100\n" +
+		"1.0.1.0\t24\t200\n" +
+		"1.0.3.0\t24\t300\n"
+	_, prefix, _ := net.ParseCIDR("1.0.0.128/23")
+	tests := map[iputils.Engine][]string{
+		iputils.RangeList:  {"1.0.0.0-1.0.0.255 1.0.0.0/24", "1.0.1.0-1.0.1.255 1.0.1.0/24"},
+		iputils.PrefixTrie: {"1.0.0.0-1.0.0.255 1.0.0.0/16", "1.0.1.0-1.0.1.255 1.0.1.0/24"},
+	}
+	defer func() { LookupEngine = iputils.RangeList }()
+	for engine, want := range tests {
+		LookupEngine = engine
+		ann, err := LoadASNDatasetFromReader(bytes.NewBufferString(pfx2as))
+		if err != nil {
+			t.Fatal(engine, err)
+		}
+		ranges, err := ann.(*ASNDataset).AnnotateRange(prefix)
+		if err != nil {
+			t.Fatal(engine, err)
+		}
+		got := []string{}
+		for _, r := range ranges {
+			got = append(got, fmt.Sprintf("%s-%s %s", r.First, r.Last, r.Network.CIDR))
+		}
+		if diff := deep.Equal(got, want); diff != nil {
+			t.Error(engine, diff)
+		}
+	}
+}
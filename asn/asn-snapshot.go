@@ -0,0 +1,112 @@
+package asn
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+
+	"github.com/m-lab/annotation-service/iptrie"
+	"github.com/m-lab/annotation-service/iputils"
+	"github.com/m-lab/annotation-service/loader"
+	"github.com/m-lab/annotation-service/rangetable"
+	"github.com/m-lab/annotation-service/snapshot"
+	"github.com/m-lab/uuid-annotator/ipinfo"
+)
+
+// ErrTrieSnapshot is returned when writing a snapshot of a dataset that uses
+// a trie.  Snapshots can only hold range lists.
+var ErrTrieSnapshot = errors.New("Snapshots cannot hold prefix tries")
+
+// WriteSnapshot writes the range table, the prefix hierarchy if it is kept,
+// and the AS names to w in the snapshot format.  The start date is not included.  RouteViews datasets
+// should be written without AS names, as those come from ASNamesFile.
+func (asn *ASNDataset) WriteSnapshot(w io.Writer) error {
+	if asn.trie != nil {
+		return ErrTrieSnapshot
+	}
+	e := snapshot.NewEncoder(w, snapshot.KindASN)
+	asn.ranges.Encode(e)
+	asn.asns.Encode(e)
+	if asn.prefixes != nil {
+		e.Uvarint(1)
+		asn.prefixes.Encode(e)
+		asn.prefixASNs.Encode(e)
+	} else {
+		e.Uvarint(0)
+	}
+	e.Uvarint(uint64(len(asn.ASNames)))
+	for number, name := range asn.ASNames {
+		e.Uvarint(uint64(number))
+		e.Text(name)
+	}
+	return e.Close()
+}
+
+// ReadSnapshot reads an ASNDataset written by WriteSnapshot.  The returned
+// dataset has no start date set.
+func ReadSnapshot(r io.Reader) (*ASNDataset, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return decodeSnapshot(data)
+}
+
+// decodeSnapshot decodes an ASNDataset snapshot.  The range table uses data
+// in place when possible, but the prefix trie is rebuilt on the heap.
+func decodeSnapshot(data []byte) (*ASNDataset, error) {
+	d, err := snapshot.NewDecoder(data, snapshot.KindASN)
+	if err != nil {
+		return nil, err
+	}
+	ds := &ASNDataset{}
+	ds.ranges = rangetable.DecodeTable(d)
+	ds.ipv4, ds.ipv6 = ds.ranges.Families()
+	ds.asns = rangetable.DecodeStrings(d)
+	if ds.asns.Len() != ds.ranges.Len() {
+		d.Fail(snapshot.ErrCorrupt)
+	}
+	if d.Uvarint() != 0 {
+		ds.prefixes = iptrie.DecodeTrie(d)
+		ds.prefixASNs = rangetable.DecodeStrings(d)
+		if ds.prefixASNs.Len() != ds.prefixes.Len() {
+			d.Fail(snapshot.ErrCorrupt)
+		}
+	}
+	if count := d.Count(); count > 0 {
+		ds.ASNames = make(ipinfo.ASNames, count)
+		for i := 0; i < count; i++ {
+			number := uint32(d.Uvarint())
+			ds.ASNames[number] = d.Text()
+		}
+	}
+	if err := d.Close(); err != nil {
+		return nil, err
+	}
+	if ds.prefixes != nil {
+		ds.buildOrigins()
+	}
+	return ds, nil
+}
+
+// loadSnapshot loads the snapshot for filename from the dataset source, if
+// there is one.  The snapshot is memory mapped when possible.
+func loadSnapshot(src loader.Source, filename string) (*ASNDataset, error) {
+	if LookupEngine == iputils.PrefixTrie {
+		return nil, ErrTrieSnapshot
+	}
+	m, err := snapshot.Load(context.Background(), src, filename)
+	if err != nil {
+		return nil, err
+	}
+	ds, err := decodeSnapshot(m.Bytes())
+	if err != nil {
+		log.Println("Error reading snapshot for", filename, err)
+		return nil, err
+	}
+	ds.snap = m
+	log.Println("Loaded dataset snapshot for", filename)
+	return ds, nil
+}
@@ -0,0 +1,63 @@
+package asn_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/go-test/deep"
+
+	"github.com/m-lab/annotation-service/asn"
+)
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	reader, err := zip.OpenReader("testdata/GeoLite2-ASN-CSV.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	g2asn, err := asn.GeoLite2ASNFromZip(&reader.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rv, err := asn.LoadASNDatasetFromReader(strings.NewReader("1.0.0.0\t16\t100\n1.0.0.0\t24\t13335\n1.0.4.0\t22\t56203_38803\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, want := range map[string]*asn.ASNDataset{"geolite2-asn": g2asn, "routeviews": rv.(*asn.ASNDataset)} {
+		t.Run(name, func(t *testing.T) {
+			buf := bytes.Buffer{}
+			if err := want.WriteSnapshot(&buf); err != nil {
+				t.Fatal(err)
+			}
+			got, err := asn.ReadSnapshot(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := deep.Equal(got.IPList(), want.IPList()); diff != nil {
+				t.Error(diff)
+			}
+			if diff := deep.Equal(got.ASNames, want.ASNames); diff != nil {
+				t.Error(diff)
+			}
+			gotPrefixes, gotErr := got.CoveringPrefixes("1.0.4.1")
+			wantPrefixes, wantErr := want.CoveringPrefixes("1.0.4.1")
+			if gotErr != wantErr {
+				t.Errorf("CoveringPrefixes() error = %v, want %v", gotErr, wantErr)
+			}
+			if diff := deep.Equal(gotPrefixes, wantPrefixes); diff != nil {
+				t.Error(diff)
+			}
+			gotPrefixes, gotErr = got.OriginPrefixes(38803)
+			wantPrefixes, wantErr = want.OriginPrefixes(38803)
+			if gotErr != wantErr {
+				t.Errorf("OriginPrefixes() error = %v, want %v", gotErr, wantErr)
+			}
+			if diff := deep.Equal(gotPrefixes, wantPrefixes); diff != nil {
+				t.Error(diff)
+			}
+		})
+	}
+}
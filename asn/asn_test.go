@@ -120,7 +120,7 @@ func TestAnnotateV4(t *testing.T) {
 
 	// test already populated error
 	err = ann.Annotate("43.228.11", &geoData)
-	assert.EqualError(t, err, "ErrAlreadyPopulated")
+	assert.Equal(t, asn.ErrAlreadyPopulated, err)
 
 	// test bad IP error
 	geoData.Network = nil
@@ -180,7 +180,7 @@ func TestAnnotateV6(t *testing.T) {
 
 	// test already populated error
 	err = ann.Annotate("2001:2b8:i3", &geoData)
-	assert.EqualError(t, err, "ErrAlreadyPopulated")
+	assert.Equal(t, asn.ErrAlreadyPopulated, err)
 
 	// test bad IP error
 	geoData.Network = nil
@@ -0,0 +1,144 @@
+package asn
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"io"
+	"log"
+	"sort"
+	"strconv"
+
+	"cloud.google.com/go/storage"
+	"github.com/m-lab/annotation-service/api"
+	"github.com/m-lab/annotation-service/iputils"
+	"github.com/m-lab/annotation-service/loader"
+	"github.com/m-lab/uuid-annotator/ipinfo"
+)
+
+var (
+	geoLite2ASNColumnCount       = 3                              // network,autonomous_system_number,autonomous_system_organization
+	geoLite2ASNBlocksFilenameIP4 = "GeoLite2-ASN-Blocks-IPv4.csv" // Filename of ipv4 blocks file
+	geoLite2ASNBlocksFilenameIP6 = "GeoLite2-ASN-Blocks-IPv6.csv" // Filename of ipv6 blocks file
+
+	// ErrEmptyGeoLite2ASN is returned when a GeoLite2-ASN blocks file has no header.
+	ErrEmptyGeoLite2ASN = api.NewError("Empty GeoLite2-ASN input data", api.ErrCorrupt)
+)
+
+//-----------------------------------------------------------------
+// CUSTOM GEOLITE2-ASN PARSER IMPLEMENTATION
+//-----------------------------------------------------------------
+
+// geoLite2ASNParser parses the GeoLite2-ASN blocks files into ASNIPNodes, and
+// collects the AS names found in the same snapshot.
+type geoLite2ASNParser struct {
+	asnNodeParser
+	names ipinfo.ASNames
+}
+
+func createGeoLite2ASNParser(names ipinfo.ASNames) *geoLite2ASNParser {
+	return &geoLite2ASNParser{
+		asnNodeParser: asnNodeParser{list: []ASNIPNode{}},
+		names:         names,
+	}
+}
+
+// PreconfigureReader for details see the iputils.IPNodeParser interface!
+func (p *geoLite2ASNParser) PreconfigureReader(reader *csv.Reader) error {
+	reader.FieldsPerRecord = geoLite2ASNColumnCount
+	// Skip the header line.
+	_, err := reader.Read()
+	if err == io.EOF {
+		return ErrEmptyGeoLite2ASN
+	}
+	return err
+}
+
+// ExtractIP for details see the iputils.IPNodeParser interface!
+func (p *geoLite2ASNParser) ExtractIP(record []string) string {
+	return record[0]
+}
+
+// PopulateRecordData for details see the iputils.IPNodeParser interface!
+func (p *geoLite2ASNParser) PopulateRecordData(record []string, node iputils.IPNode) error {
+	asnNode, ok := node.(*ASNIPNode)
+	if !ok {
+		return ErrorIllegalIPNodeType
+	}
+	number, err := strconv.ParseUint(record[1], 10, 32)
+	if err != nil {
+		return err
+	}
+	asnNode.ASNString = record[1]
+	p.names[uint32(number)] = record[2]
+	return nil
+}
+
+//-----------------------------------------------------------------
+// GEOLITE2-ASN DATASET LOADER IMPLEMENTATION
+//-----------------------------------------------------------------
+
+// LoadGeoLite2ASN loads a GeoLite2-ASN CSV zip from an object in the dataset source.
+// The AS names are taken from the same snapshot, so they are correct for the
+// dataset date.  If there is a snapshot of the dataset next to it, the snapshot
+// is used instead.
+func LoadGeoLite2ASN(src loader.Source, file *storage.ObjectAttrs) (api.Annotator, error) {
+	dataset, err := loadSnapshot(src, file.Name)
+	if err != nil {
+		dataset, err = LoadGeoLite2ASNZip(src, file.Name)
+		if err != nil {
+			return nil, err
+		}
+	}
+	date, err := api.ExtractDateFromFilename(file.Name)
+	if err != nil {
+		return nil, err
+	}
+	dataset.Start = date
+	dataset.Type = api.GeoLite2ASNType
+	dataset.Name = file.Name
+	return dataset, nil
+}
+
+// LoadGeoLite2ASNZip loads a GeoLite2-ASN CSV zip from the dataset source,
+// ignoring any snapshot.  The returned dataset has no start date set.
+func LoadGeoLite2ASNZip(src loader.Source, filename string) (*ASNDataset, error) {
+	log.Println("Loading dataset from", filename)
+	zip, err := loader.CreateZipReader(context.Background(), src, filename)
+	if err != nil {
+		return nil, err
+	}
+	return GeoLite2ASNFromZip(zip)
+}
+
+// GeoLite2ASNFromZip builds an ASNDataset from the IPv4 and IPv6 blocks files
+// in a GeoLite2-ASN CSV zip.  The returned dataset has no start date set.
+func GeoLite2ASNFromZip(zip *zip.Reader) (*ASNDataset, error) {
+	names := ipinfo.ASNames{}
+	nodes := []ASNIPNode{}
+	for _, fn := range []string{geoLite2ASNBlocksFilenameIP4, geoLite2ASNBlocksFilenameIP6} {
+		blocks, err := loader.FindFile(fn, zip)
+		if err != nil {
+			return nil, err
+		}
+		parser := createGeoLite2ASNParser(names)
+		err = buildNodes(blocks, parser)
+		blocks.Close()
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, parser.list...)
+	}
+	// Both lists use 16 byte addresses, so IPv4 (::ffff:0:0/96) nodes must be
+	// interleaved with the IPv6 nodes to keep the list sorted.
+	sort.Slice(nodes, func(i, j int) bool {
+		return bytes.Compare(nodes[i].IPAddressLow, nodes[j].IPAddressLow) < 0
+	})
+	ds, err := newDataset(nodes)
+	if err != nil {
+		return nil, err
+	}
+	ds.ASNames = names
+	return ds, nil
+}
@@ -0,0 +1,66 @@
+package asn_test
+
+import (
+	"archive/zip"
+	"testing"
+
+	"github.com/go-test/deep"
+
+	"github.com/m-lab/annotation-service/api"
+	"github.com/m-lab/annotation-service/asn"
+	"github.com/m-lab/annotation-service/iputils"
+)
+
+func TestGeoLite2ASNFromZip(t *testing.T) {
+	reader, err := zip.OpenReader("testdata/GeoLite2-ASN-CSV.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	ds, err := asn.GeoLite2ASNFromZip(&reader.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		ip      string
+		want    *api.ASData
+		wantErr error
+	}{
+		{
+			ip: "1.0.0.1",
+			want: &api.ASData{
+				CIDR: "1.0.0.0/24", ASNumber: 13335, ASName: "CLOUDFLARENET",
+				Systems: []api.System{{ASNs: []uint32{13335}}},
+			},
+		},
+		{
+			ip: "1.0.130.4",
+			want: &api.ASData{
+				CIDR: "1.0.128.0/17", ASNumber: 23969, ASName: "TOT Public Company Limited",
+				Systems: []api.System{{ASNs: []uint32{23969}}},
+			},
+		},
+		{
+			ip: "2001:4860:4860::8888",
+			want: &api.ASData{
+				CIDR: "2001:4860::/32", ASNumber: 15169, ASName: "GOOGLE",
+				Systems: []api.System{{ASNs: []uint32{15169}}},
+			},
+		},
+		{ip: "1.0.2.1", wantErr: iputils.ErrNodeNotFound},
+		{ip: "2002::1", wantErr: iputils.ErrNodeNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			ann := api.GeoData{}
+			err := ds.Annotate(tt.ip, &ann)
+			if err != tt.wantErr {
+				t.Fatalf("Annotate() error = %v, want %v", err, tt.wantErr)
+			}
+			if diff := deep.Equal(ann.Network, tt.want); diff != nil {
+				t.Error(diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+// annodiff compares the annotations of the datasets selected for two dates,
+// and writes the ranges whose country, city or AS changed, with a summary by
+// country and AS, as a v3.DiffResponse in JSON.  Only the datasets used for
+// the two dates are loaded.
+//
+// Usage:
+//
+//	annodiff -datasets gs://downloader-mlab-oti -before 2019-03-01 -after 2019-04-01
+//	annodiff -datasets file:///data -before 2019-03-01 -max_changes 0
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+	"time"
+
+	"github.com/m-lab/annotation-service/api"
+	"github.com/m-lab/annotation-service/diff"
+	"github.com/m-lab/annotation-service/directory"
+	"github.com/m-lab/annotation-service/geoloader"
+	"github.com/m-lab/annotation-service/loader"
+	"github.com/m-lab/annotation-service/manager"
+	"github.com/m-lab/go/rtx"
+)
+
+var (
+	datasetSource = flag.String("datasets", "gs://"+api.MaxmindBucketName, "URL of the dataset tree. gs:// and file:// schemes accepted.")
+	beforeDate    = flag.String("before", "", "Date of the datasets to compare from, e.g. 2019-03-01.")
+	afterDate     = flag.String("after", "", "Date of the datasets to compare to. Defaults to the latest datasets.")
+	maxChanges    = flag.Int("max_changes", -1, "Maximum number of changed ranges to list. Negative lists all of them, 0 only the summary.")
+)
+
+// parseDate parses a date flag, or returns the current time if it is empty.
+func parseDate(value string) (time.Time, error) {
+	if value == "" {
+		return time.Now(), nil
+	}
+	return time.Parse("2006-01-02", value)
+}
+
+func init() {
+	// Always prepend the filename and line number.
+	log.SetFlags(log.LstdFlags | log.Lshortfile)
+}
+
+func main() {
+	flag.Parse()
+
+	if *beforeDate == "" {
+		log.Fatal("Missing -before date")
+	}
+	before, err := parseDate(*beforeDate)
+	rtx.Must(err, "Invalid -before date %q", *beforeDate)
+	after, err := parseDate(*afterDate)
+	rtx.Must(err, "Invalid -after date %q", *afterDate)
+
+	src, err := loader.NewSource(*datasetSource)
+	rtx.Must(err, "Invalid dataset source URL %q", *datasetSource)
+	geoloader.SetSource(src)
+	// Only the datasets of the two annotators need to be loaded.
+	geoloader.SetLazyCache(directory.NewCache(0))
+	manager.MustUpdateDirectory()
+
+	beforeAnn, err := manager.GetAnnotator(before)
+	rtx.Must(err, "No annotator for %s", before.Format("2006-01-02"))
+	afterAnn, err := manager.GetAnnotator(after)
+	rtx.Must(err, "No annotator for %s", after.Format("2006-01-02"))
+
+	resp, err := diff.Annotators(beforeAnn, afterAnn, *maxChanges)
+	rtx.Must(err, "Could not compare the annotators of %s and %s", beforeAnn.AnnotatorDate().Format("2006-01-02"), afterAnn.AnnotatorDate().Format("2006-01-02"))
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	rtx.Must(enc.Encode(resp), "Could not write the differences")
+}
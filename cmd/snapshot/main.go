@@ -0,0 +1,159 @@
+// snapshot generates dataset snapshots from the raw GeoLite2 and RouteViews
+// datasets.  Each snapshot is written to the output directory, with the same
+// relative name as the raw dataset plus the snapshot suffix, so the output
+// directory can be copied (or synced) over the dataset tree.
+//
+// Usage:
+//
+//	snapshot -datasets gs://downloader-mlab-oti -output /tmp/snaps \
+//	    Maxmind/2018/03/08/20180308T000000Z-GeoLite2-City-CSV.zip
+//	snapshot -datasets file:///data -output /data -prefix RouteViewIPv4/2019/
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"google.golang.org/api/iterator"
+
+	"github.com/m-lab/annotation-service/api"
+	"github.com/m-lab/annotation-service/asn"
+	"github.com/m-lab/annotation-service/geolite2v2"
+	"github.com/m-lab/annotation-service/loader"
+	"github.com/m-lab/annotation-service/snapshot"
+	"github.com/m-lab/go/rtx"
+)
+
+var (
+	datasetSource = flag.String("datasets", "gs://"+api.MaxmindBucketName, "URL of the dataset tree. gs:// and file:// schemes accepted.")
+	outputDir     = flag.String("output", ".", "Directory where the snapshots are written.")
+	prefix        = flag.String("prefix", "", "When no datasets are named on the command line, snapshot all supported datasets with this prefix.")
+	overwrite     = flag.Bool("overwrite", false, "Regenerate snapshots that already exist in the output directory.")
+
+	errUnsupported = errors.New("Unsupported dataset type")
+)
+
+// snapshotWriter is implemented by the datasets that support snapshots.
+type snapshotWriter interface {
+	WriteSnapshot(w io.Writer) error
+}
+
+// rawLoader returns the function that loads the raw dataset with the given
+// name, or nil if the dataset type does not support snapshots.
+func rawLoader(name string) func(loader.Source, string) (snapshotWriter, error) {
+	switch {
+	case strings.HasSuffix(name, snapshot.Suffix):
+		return nil
+	case strings.Contains(name, "GeoLite2-City-CSV.zip"):
+		return func(src loader.Source, name string) (snapshotWriter, error) {
+			return geolite2v2.LoadG2Zip(src, name)
+		}
+	case strings.Contains(name, "GeoLite2-ASN-CSV.zip"):
+		return func(src loader.Source, name string) (snapshotWriter, error) {
+			return asn.LoadGeoLite2ASNZip(src, name)
+		}
+	case strings.Contains(name, ".pfx2as"):
+		return func(src loader.Source, name string) (snapshotWriter, error) {
+			return asn.LoadRouteViews(src, name)
+		}
+	}
+	return nil
+}
+
+// writeSnapshot loads the named raw dataset from src, and writes its snapshot
+// under dir.  The snapshot is written to a temporary file first, so that a
+// partial snapshot is never left behind.
+func writeSnapshot(src loader.Source, name string, dir string) error {
+	load := rawLoader(name)
+	if load == nil {
+		return errUnsupported
+	}
+	ds, err := load(src, name)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(dir, filepath.FromSlash(snapshot.Name(name)))
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(path + ".tmp")
+	if err != nil {
+		return err
+	}
+	err = ds.WriteSnapshot(f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// exists returns true if the snapshot for name is already in dir.
+func exists(name string, dir string) bool {
+	_, err := os.Stat(filepath.Join(dir, filepath.FromSlash(snapshot.Name(name))))
+	return err == nil
+}
+
+// listDatasets returns the names of all supported datasets in src with the prefix.
+func listDatasets(src loader.Source, prefix string) ([]string, error) {
+	it, err := src.Objects(context.Background(), prefix)
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for obj, err := it.Next(); err != iterator.Done; obj, err = it.Next() {
+		if err != nil {
+			return nil, err
+		}
+		if rawLoader(obj.Name) != nil {
+			names = append(names, obj.Name)
+		}
+	}
+	return names, nil
+}
+
+func init() {
+	// Always prepend the filename and line number.
+	log.SetFlags(log.LstdFlags | log.Lshortfile)
+}
+
+func main() {
+	flag.Parse()
+
+	src, err := loader.NewSource(*datasetSource)
+	rtx.Must(err, "Invalid dataset source URL %q", *datasetSource)
+
+	names := flag.Args()
+	if len(names) == 0 {
+		names, err = listDatasets(src, *prefix)
+		rtx.Must(err, "Could not list datasets in %s", src)
+	}
+
+	failed := 0
+	for _, name := range names {
+		if !*overwrite && exists(name, *outputDir) {
+			log.Println("Skipping", name, "snapshot already exists")
+			continue
+		}
+		err := writeSnapshot(src, name, *outputDir)
+		if err != nil {
+			log.Println("Failed to snapshot", name, err)
+			failed++
+			continue
+		}
+		log.Println("Wrote snapshot for", name)
+	}
+	if failed > 0 {
+		log.Fatal(failed, " of ", len(names), " snapshots failed")
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/m-lab/annotation-service/asn"
+	"github.com/m-lab/annotation-service/loader"
+	"github.com/m-lab/annotation-service/snapshot"
+)
+
+func TestRawLoader(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"Maxmind/2018/03/08/20180308T000000Z-GeoLite2-City-CSV.zip", true},
+		{"Maxmind/2020/01/07/20200107T000000Z-GeoLite2-ASN-CSV.zip", true},
+		{"RouteViewIPv4/2019/01/routeviews-rv2-20190101-1200.pfx2as.gz", true},
+		{"RouteViewIPv4/2019/01/routeviews-rv2-20190101-1200.pfx2as.gz.snap", false},
+		{"Maxmind/2014/03/07/20140307T160000Z-GeoLiteCity.dat.gz", false},
+	}
+	for _, tt := range tests {
+		if got := rawLoader(tt.name) != nil; got != tt.want {
+			t.Errorf("rawLoader(%s) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestWriteSnapshot(t *testing.T) {
+	raw, err := ioutil.ReadFile("../../asn/testdata/GeoLite2-ASN-CSV.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	name := "Maxmind/2020/01/07/20200107T000000Z-GeoLite2-ASN-CSV.zip"
+	path := filepath.Join(dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, raw, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	src := loader.NewDirSource(dir)
+	names, err := listDatasets(src, "Maxmind/")
+	if err != nil || len(names) != 1 || names[0] != name {
+		t.Fatal("listDatasets() =", names, err)
+	}
+	if err := writeSnapshot(src, name, dir); err != nil {
+		t.Fatal(err)
+	}
+	if !exists(name, dir) {
+		t.Fatal("snapshot not written")
+	}
+	rdr, err := src.NewReader(context.Background(), snapshot.Name(name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rdr.Close()
+	ds, err := asn.ReadSnapshot(rdr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ds.IPList()) != 6 || ds.ASNames[15169] != "GOOGLE" {
+		t.Errorf("ReadSnapshot() = %+v", ds)
+	}
+	// The snapshot itself must not be listed as a dataset.
+	names, err = listDatasets(src, "Maxmind/")
+	if err != nil || len(names) != 1 {
+		t.Error("listDatasets() =", names, err)
+	}
+	if err := writeSnapshot(src, "Maxmind/2014/03/07/20140307T160000Z-GeoLiteCity.dat.gz", dir); err != errUnsupported {
+		t.Error("writeSnapshot() error =", err)
+	}
+}
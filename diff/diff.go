@@ -0,0 +1,314 @@
+// Package diff compares the annotations of two annotators, e.g. those the
+// directory selects for two dates, to show what changed between two releases
+// of the datasets.
+package diff
+
+import (
+	"bytes"
+	"errors"
+	"net"
+
+	"github.com/m-lab/annotation-service/api"
+	v3 "github.com/m-lab/annotation-service/api/v3"
+	"github.com/m-lab/annotation-service/directory"
+	"github.com/m-lab/annotation-service/iputils"
+)
+
+var (
+	// ErrNoRanges is returned by Annotators if an annotator does not support
+	// range queries, or if none of its fields can be compared.
+	ErrNoRanges = api.NewError("Annotator does not support range queries", api.ErrNotApplicable)
+
+	// The fields of api.Annotations filled by each type of dataset.
+	fields = map[string]string{
+		api.LegacyType:      v3.GeoField,
+		api.GeoLite2Type:    v3.GeoField,
+		api.RouteViewsType:  v3.ASNField,
+		api.GeoLite2ASNType: v3.ASNField,
+	}
+
+	// The prefix queried to find whether a dataset supports range queries.
+	probe = &net.IPNet{IP: net.IPv4zero.To4(), Mask: net.CIDRMask(32, 32)}
+
+	// The prefixes compared, covering all the addresses.
+	allPrefixes = []*net.IPNet{
+		{IP: net.IPv4zero.To4(), Mask: net.CIDRMask(0, 32)},
+		{IP: net.IPv6zero, Mask: net.CIDRMask(0, 128)},
+	}
+)
+
+// Annotators walks the annotated ranges of before and after in lockstep, and
+// returns the ranges whose country, city or AS changed, or that are annotated
+// by only one of them.  Other changes, e.g. of the coordinates within a city,
+// are ignored.  At most maxChanges ranges are returned, or all of them if it
+// is negative, but the summary counts cover all the changes.
+//
+// A field is left out of the comparison on both sides, and listed in
+// Skipped, if the datasets that fill it for either annotator don't support
+// range queries, e.g. legacy or MMDB geolocation.
+func Annotators(before, after api.Annotator, maxChanges int) (*v3.DiffResponse, error) {
+	br, ok := before.(api.RangeAnnotator)
+	if !ok {
+		return nil, ErrNoRanges
+	}
+	ar, ok := after.(api.RangeAnnotator)
+	if !ok {
+		return nil, ErrNoRanges
+	}
+	resp := &v3.DiffResponse{
+		Before:           before.AnnotatorDate(),
+		After:            after.AnnotatorDate(),
+		BeforeProvenance: before.Provenance(),
+		AfterProvenance:  after.Provenance(),
+		Kinds:            map[string]int{},
+		Countries:        map[string]v3.ChangeCount{},
+		ASNs:             map[uint32]v3.ChangeCount{},
+		Changes:          []v3.RangeChange{},
+	}
+	found, skipped := map[string]bool{}, map[string]bool{}
+	for _, ann := range []api.Annotator{before, after} {
+		if err := unsupported(ann, found, skipped); err != nil {
+			return nil, err
+		}
+	}
+	compared := len(found) == 0 // Annotators without provenance are compared
+	for _, field := range []string{v3.GeoField, v3.ASNField} {
+		if skipped[field] {
+			resp.Skipped = append(resp.Skipped, field)
+		} else if found[field] {
+			compared = true
+		}
+	}
+	if !compared {
+		return nil, ErrNoRanges
+	}
+	for _, prefix := range allPrefixes {
+		b, err := ranges(br, prefix)
+		if err != nil {
+			return nil, err
+		}
+		a, err := ranges(ar, prefix)
+		if err != nil {
+			return nil, err
+		}
+		walk(b, a, func(first, last net.IP, b, a api.Annotations) {
+			if skipped[v3.GeoField] {
+				b.Geo, a.Geo = nil, nil
+			}
+			if skipped[v3.ASNField] {
+				b.Network, a.Network = nil, nil
+			}
+			add(resp, first, last, b, a, maxChanges)
+		})
+	}
+	return resp, nil
+}
+
+// unsupported adds to found the fields of ann's annotations that it has
+// datasets for, and to skipped those whose datasets don't support range
+// queries.  A field that ann has no datasets for is not skipped, so that it
+// is compared with the other annotator's datasets, if any.
+func unsupported(ann api.Annotator, found, skipped map[string]bool) error {
+	has := map[string]bool{}
+	supported := map[string]bool{}
+	for _, ds := range directory.Datasets(ann) {
+		var field string
+		for _, p := range ds.Provenance() {
+			if f, ok := fields[p.Type]; ok {
+				field = f
+			}
+		}
+		if field == "" {
+			continue
+		}
+		has[field] = true
+		found[field] = true
+		ra, ok := ds.(api.RangeAnnotator)
+		if !ok {
+			continue
+		}
+		_, err := ra.AnnotateRange(probe)
+		if errors.Is(err, api.ErrNotApplicable) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		supported[field] = true
+	}
+	for field := range has {
+		if !supported[field] {
+			skipped[field] = true
+		}
+	}
+	return nil
+}
+
+// ranges returns the annotated ranges of the prefix.  The IPv4 addresses in
+// ::ffff:0:0/96 are left out of the IPv6 ranges, as they are compared with
+// the IPv4 ones.
+func ranges(ra api.RangeAnnotator, prefix *net.IPNet) ([]api.RangeAnnotations, error) {
+	result, err := ra.AnnotateRange(prefix)
+	if errors.Is(err, api.ErrNotApplicable) {
+		// None of the datasets supports range queries, so the annotator
+		// only has datasets for the fields that are skipped.
+		return nil, nil
+	}
+	if err != nil || prefix.IP.To4() != nil {
+		return result, err
+	}
+	v6 := result[:0]
+	for i := range result {
+		if result[i].First.To4() == nil {
+			v6 = append(v6, result[i])
+		}
+	}
+	return v6, nil
+}
+
+// walk splits the address space at the bounds of the ranges of both lists,
+// and calls f for each piece covered by either of them, in address order,
+// with the annotations of the ranges covering it.  Both lists must be sorted
+// and non-overlapping.
+func walk(before, after []api.RangeAnnotations, f func(first, last net.IP, b, a api.Annotations)) {
+	i, j := 0, 0
+	var next net.IP // The first address not yet visited, or nil at the start
+	for i < len(before) || j < len(after) {
+		// The start of each list's next range, excluding what was visited.
+		bs, as := start(before, i, next), start(after, j, next)
+		first := bs
+		if first == nil || (as != nil && bytes.Compare(as, first) < 0) {
+			first = as
+		}
+		// The piece ends where the range covering it ends, or before the
+		// other list's next range starts.
+		var last net.IP
+		var b, a api.Annotations
+		for _, r := range []struct {
+			list  []api.RangeAnnotations
+			k     int
+			start net.IP
+			ann   *api.Annotations
+		}{{before, i, bs, &b}, {after, j, as, &a}} {
+			var end net.IP
+			switch {
+			case r.start == nil:
+				continue
+			case r.start.Equal(first):
+				*r.ann = r.list[r.k].Annotations
+				end = r.list[r.k].Last.To16()
+			default:
+				end = iputils.MinusOne(r.start)
+			}
+			if last == nil || bytes.Compare(end, last) < 0 {
+				last = end
+			}
+		}
+		f(first, last, b, a)
+		if i < len(before) && last.Equal(before[i].Last) {
+			i++
+		}
+		if j < len(after) && last.Equal(after[j].Last) {
+			j++
+		}
+		if next = iputils.PlusOne(last); next == nil {
+			return
+		}
+	}
+}
+
+// start returns the first address of list[k] that is not before next, or nil
+// if k is past the end of the list.
+func start(list []api.RangeAnnotations, k int, next net.IP) net.IP {
+	if k >= len(list) {
+		return nil
+	}
+	first := list[k].First.To16()
+	if next != nil && bytes.Compare(first, next) < 0 {
+		return next
+	}
+	return first
+}
+
+// add records the changes between b and a for the range [first, last].
+func add(resp *v3.DiffResponse, first, last net.IP, b, a api.Annotations, maxChanges int) {
+	kinds := changes(b, a)
+	if len(kinds) == 0 {
+		return
+	}
+	if maxChanges < 0 || len(resp.Changes) < maxChanges {
+		resp.Changes = append(resp.Changes, v3.RangeChange{First: first, Last: last, Kinds: kinds, Before: b, After: a})
+	} else {
+		resp.Truncated = true
+	}
+	for _, kind := range kinds {
+		resp.Kinds[kind]++
+	}
+	if bc, ac := country(b.Geo), country(a.Geo); bc != ac {
+		if bc != "" {
+			count := resp.Countries[bc]
+			count.Lost++
+			resp.Countries[bc] = count
+		}
+		if ac != "" {
+			count := resp.Countries[ac]
+			count.Gained++
+			resp.Countries[ac] = count
+		}
+	}
+	if bn, an := asNumber(b.Network), asNumber(a.Network); bn != an {
+		if bn != 0 {
+			count := resp.ASNs[bn]
+			count.Lost++
+			resp.ASNs[bn] = count
+		}
+		if an != 0 {
+			count := resp.ASNs[an]
+			count.Gained++
+			resp.ASNs[an] = count
+		}
+	}
+}
+
+// changes returns the kinds of changes between b and a.
+func changes(b, a api.Annotations) []string {
+	var kinds []string
+	bg, ag := b.Geo != nil && !b.Geo.Missing, a.Geo != nil && !a.Geo.Missing
+	switch {
+	case !bg && ag:
+		kinds = append(kinds, v3.GeoAdded)
+	case bg && !ag:
+		kinds = append(kinds, v3.GeoRemoved)
+	case bg && b.Geo.CountryCode != a.Geo.CountryCode:
+		kinds = append(kinds, v3.CountryChanged)
+	case bg && b.Geo.City != a.Geo.City:
+		kinds = append(kinds, v3.CityChanged)
+	}
+	bn, an := asNumber(b.Network), asNumber(a.Network)
+	switch {
+	case bn == 0 && an != 0:
+		kinds = append(kinds, v3.ASNAdded)
+	case bn != 0 && an == 0:
+		kinds = append(kinds, v3.ASNRemoved)
+	case bn != an:
+		kinds = append(kinds, v3.ASNChanged)
+	}
+	return kinds
+}
+
+// country returns the country code of geo, or "" if it is missing.
+func country(geo *api.GeolocationIP) string {
+	if geo == nil || geo.Missing {
+		return ""
+	}
+	return geo.CountryCode
+}
+
+// asNumber returns the first origin AS of network, or 0 if it is missing.
+func asNumber(network *api.ASData) uint32 {
+	asn, err := network.BestASN()
+	if err != nil || network.Missing {
+		return 0
+	}
+	return uint32(asn)
+}
@@ -0,0 +1,175 @@
+package diff_test
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/m-lab/annotation-service/api"
+	v3 "github.com/m-lab/annotation-service/api/v3"
+	"github.com/m-lab/annotation-service/diff"
+	"github.com/m-lab/annotation-service/directory"
+)
+
+// fakeRanges is a fake annotator with fixed ranges.  Like the RouteViews
+// datasets, IPv6 queries also return the IPv4 ranges, as mapped addresses.
+type fakeRanges struct {
+	date   time.Time
+	prov   []api.Provenance
+	ranges []api.RangeAnnotations
+}
+
+func (f *fakeRanges) Annotate(ip string, ann *api.Annotations) error { return nil }
+func (f *fakeRanges) AnnotatorDate() time.Time                       { return f.date }
+func (f *fakeRanges) Provenance() []api.Provenance                   { return f.prov }
+
+func (f *fakeRanges) AnnotateRange(prefix *net.IPNet) ([]api.RangeAnnotations, error) {
+	var result []api.RangeAnnotations
+	for _, r := range f.ranges {
+		if prefix.IP.To4() == nil || r.First.To4() != nil {
+			result = append(result, api.RangeAnnotations{First: r.First.To16(), Last: r.Last.To16(), Annotations: r.Annotations})
+		}
+	}
+	return result, nil
+}
+
+// geo returns a range located in the country and city.
+func geo(first, last, country, city string) api.RangeAnnotations {
+	return api.RangeAnnotations{
+		First:       net.ParseIP(first),
+		Last:        net.ParseIP(last),
+		Annotations: api.Annotations{Geo: &api.GeolocationIP{CountryCode: country, City: city}},
+	}
+}
+
+// network returns a range originated by the AS, with the geo annotation of g.
+func network(g api.RangeAnnotations, asn uint32) api.RangeAnnotations {
+	g.Network = &api.ASData{ASNumber: asn, Systems: []api.System{{ASNs: []uint32{asn}}}}
+	return g
+}
+
+func TestAnnotators(t *testing.T) {
+	before := &fakeRanges{date: time.Date(2019, 3, 1, 0, 0, 0, 0, time.UTC), ranges: []api.RangeAnnotations{
+		network(geo("1.0.0.0", "1.0.0.255", "US", "Boston"), 100),
+		geo("1.0.1.0", "1.0.1.255", "US", "Boston"),
+		geo("2.0.0.0", "2.0.0.255", "US", "Denver"),
+		geo("2001:db8::", "2001:db8::ffff", "DE", "Berlin"),
+	}}
+	after := &fakeRanges{date: time.Date(2019, 4, 1, 0, 0, 0, 0, time.UTC), ranges: []api.RangeAnnotations{
+		network(geo("1.0.0.0", "1.0.0.127", "US", "Boston"), 200),
+		network(geo("1.0.0.128", "1.0.0.255", "CA", "Toronto"), 100),
+		geo("1.0.1.0", "1.0.1.127", "US", "Boston"),
+		geo("1.0.1.128", "1.0.1.255", "US", "Chicago"),
+		geo("3.0.0.0", "3.0.0.255", "FR", "Paris"),
+		geo("2001:db8::", "2001:db8::ff", "DE", "Berlin"),
+		network(geo("2001:db8::100", "2001:db8::ffff", "DE", "Berlin"), 300),
+	}}
+
+	resp, err := diff.Annotators(before, after, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"1.0.0.0-1.0.0.127 asn",
+		"1.0.0.128-1.0.0.255 country",
+		"1.0.1.128-1.0.1.255 city",
+		"2.0.0.0-2.0.0.255 geo-removed",
+		"3.0.0.0-3.0.0.255 geo-added",
+		"2001:db8::100-2001:db8::ffff asn-added",
+	}
+	if len(resp.Changes) != len(want) {
+		t.Fatalf("Annotators() returned %d changes, want %d: %+v", len(resp.Changes), len(want), resp.Changes)
+	}
+	for i, c := range resp.Changes {
+		if got := c.First.String() + "-" + c.Last.String() + " " + strings.Join(c.Kinds, ","); got != want[i] {
+			t.Errorf("Changes[%d] = %s, want %s", i, got, want[i])
+		}
+	}
+	if !resp.Before.Equal(before.date) || !resp.After.Equal(after.date) || resp.Truncated {
+		t.Errorf("Annotators() = %+v", resp)
+	}
+	if resp.Kinds["asn"] != 1 || resp.Kinds["asn-added"] != 1 || resp.Kinds["city"] != 1 {
+		t.Error("Kinds =", resp.Kinds)
+	}
+	if c := resp.Countries["US"]; c.Lost != 2 || c.Gained != 0 {
+		t.Error("Countries[US] =", c)
+	}
+	if c := resp.Countries["CA"]; c.Lost != 0 || c.Gained != 1 {
+		t.Error("Countries[CA] =", c)
+	}
+	if c := resp.ASNs[100]; c.Lost != 1 || c.Gained != 0 {
+		t.Error("ASNs[100] =", c)
+	}
+	if c := resp.ASNs[300]; c.Lost != 0 || c.Gained != 1 {
+		t.Error("ASNs[300] =", c)
+	}
+
+	// The summary still counts the changes that are left out.
+	resp, err = diff.Annotators(before, after, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Changes) != 2 || !resp.Truncated || resp.Kinds["geo-added"] != 1 {
+		t.Errorf("Annotators() = %+v", resp)
+	}
+
+	// The same datasets have no changes.
+	resp, err = diff.Annotators(before, before, -1)
+	if err != nil || len(resp.Changes) != 0 || len(resp.Kinds) != 0 {
+		t.Errorf("Annotators() = %+v, %v", resp, err)
+	}
+}
+
+func TestAnnotatorsNoRanges(t *testing.T) {
+	ranges := &fakeRanges{}
+	other := struct{ api.Annotator }{ranges}
+	if _, err := diff.Annotators(ranges, other, -1); err != diff.ErrNoRanges {
+		t.Error("Annotators() error =", err)
+	}
+}
+
+func TestAnnotatorsSkipped(t *testing.T) {
+	date := time.Date(2019, 3, 1, 0, 0, 0, 0, time.UTC)
+	// Like a legacy or MMDB dataset, without range queries.
+	legacy := struct{ api.Annotator }{&fakeRanges{prov: []api.Provenance{{Type: api.LegacyType}}}}
+	geolite2 := &fakeRanges{prov: []api.Provenance{{Type: api.GeoLite2Type}}, ranges: []api.RangeAnnotations{
+		geo("1.0.0.0", "1.0.0.255", "US", "Boston"),
+	}}
+	before := directory.NewCompositeAnnotator([]api.Annotator{legacy, &fakeRanges{
+		date: date,
+		prov: []api.Provenance{{Type: api.RouteViewsType}},
+		ranges: []api.RangeAnnotations{
+			network(api.RangeAnnotations{First: net.ParseIP("1.0.0.0"), Last: net.ParseIP("1.0.0.255")}, 100),
+		},
+	}})
+	after := directory.NewCompositeAnnotator([]api.Annotator{geolite2, &fakeRanges{
+		date: date,
+		prov: []api.Provenance{{Type: api.RouteViewsType}},
+		ranges: []api.RangeAnnotations{
+			network(api.RangeAnnotations{First: net.ParseIP("1.0.0.0"), Last: net.ParseIP("1.0.0.127")}, 200),
+		},
+	}})
+
+	// The geolocation is left out on both sides, rather than reported as added.
+	resp, err := diff.Annotators(before, after, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Skipped) != 1 || resp.Skipped[0] != v3.GeoField {
+		t.Error("Skipped =", resp.Skipped)
+	}
+	if len(resp.Changes) != 2 || resp.Kinds[v3.ASNChanged] != 1 || resp.Kinds[v3.ASNRemoved] != 1 || len(resp.Kinds) != 2 {
+		t.Fatalf("Annotators() = %+v", resp)
+	}
+	if c := resp.Changes[0]; c.Before.Geo != nil || c.After.Geo != nil {
+		t.Errorf("Changes[0] = %+v", c)
+	}
+
+	// Nothing is left to compare.
+	before = directory.NewCompositeAnnotator([]api.Annotator{legacy})
+	after = directory.NewCompositeAnnotator([]api.Annotator{geolite2})
+	if _, err := diff.Annotators(before, after, -1); err != diff.ErrNoRanges {
+		t.Error("Annotators() error =", err)
+	}
+}
@@ -0,0 +1,66 @@
+package directory
+
+import (
+	"time"
+
+	"github.com/m-lab/annotation-service/api"
+)
+
+// Window is the validity window of the datasets of a source: a dataset is
+// valid for the dates from its own date up to ValidFor later.
+type Window struct {
+	Source   string        // The name of the source, for Coverage
+	ValidFor time.Duration // 0 means that the datasets never become stale
+}
+
+// Coverage tells which datasets of an annotator are not valid for a date.
+type Coverage struct {
+	// Stale are the sources whose datasets are older than their window.
+	Stale []string
+	// Extrapolated are the sources whose datasets are later than the date,
+	// e.g. for dates before all the datasets.
+	Extrapolated []string
+}
+
+// Covered returns true if all the datasets are valid for the date.
+func (c Coverage) Covered() bool {
+	return len(c.Stale) == 0 && len(c.Extrapolated) == 0
+}
+
+// SetWindows sets the validity windows of the datasets of the directory,
+// keyed by their Provenance names.  It must be called before the directory
+// is used.
+func (d *Directory) SetWindows(windows map[string]Window) {
+	d.windows = windows
+}
+
+// Coverage checks the datasets wrapped by ann against the date.  Datasets
+// without a window are named by their Provenance type, and never stale.
+func (d *Directory) Coverage(ann api.Annotator, date time.Time) Coverage {
+	result := Coverage{}
+	for _, ds := range Datasets(ann) {
+		for _, p := range ds.Provenance() {
+			w, ok := d.windows[p.Name]
+			if !ok {
+				w = Window{Source: p.Type}
+			}
+			switch {
+			case date.Before(p.Date):
+				result.Extrapolated = appendOnce(result.Extrapolated, w.Source)
+			case w.ValidFor > 0 && date.After(p.Date.Add(w.ValidFor)):
+				result.Stale = appendOnce(result.Stale, w.Source)
+			}
+		}
+	}
+	return result
+}
+
+// appendOnce appends s to list, unless it is already there.
+func appendOnce(list []string, s string) []string {
+	for i := range list {
+		if list[i] == s {
+			return list
+		}
+	}
+	return append(list, s)
+}
@@ -0,0 +1,57 @@
+package directory_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/m-lab/annotation-service/api"
+	"github.com/m-lab/annotation-service/directory"
+)
+
+func TestCoverage(t *testing.T) {
+	geo := newFake("20200101")
+	asn := newFake("20200110")
+	dir := directory.Build(directory.MergeSources(
+		directory.Source{Name: "geo", Annotators: []api.Annotator{geo}},
+		directory.Source{Name: "asn", Annotators: []api.Annotator{asn}}))
+	// The fakes all have the same Provenance name, so they share a window.
+	dir.SetWindows(map[string]directory.Window{"": {Source: "fakes", ValidFor: 14 * 24 * time.Hour}})
+
+	day := func(s string) time.Time {
+		d, _ := time.Parse("20060102", s)
+		return d
+	}
+	tests := []struct {
+		date         string
+		stale        []string
+		extrapolated []string
+	}{
+		{"20200112", nil, nil},
+		{"20200120", []string{"fakes"}, nil},
+		{"20191201", nil, []string{"fakes"}},
+	}
+	for _, tt := range tests {
+		ann, err := dir.GetAnnotator(day(tt.date))
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := dir.Coverage(ann, day(tt.date))
+		if !reflect.DeepEqual(c.Stale, tt.stale) || !reflect.DeepEqual(c.Extrapolated, tt.extrapolated) {
+			t.Errorf("Coverage(%s) = %+v", tt.date, c)
+		}
+		if c.Covered() != (tt.stale == nil && tt.extrapolated == nil) {
+			t.Errorf("Covered(%s) = %v", tt.date, c.Covered())
+		}
+	}
+
+	// Without windows, the datasets are named by their type, and never stale.
+	dir.SetWindows(nil)
+	ann, _ := dir.GetAnnotator(day("20300101"))
+	if c := dir.Coverage(ann, day("20300101")); !c.Covered() {
+		t.Error("Coverage() =", c)
+	}
+	if c := dir.Coverage(ann, day("20200105")); !reflect.DeepEqual(c.Extrapolated, []string{"fake"}) {
+		t.Error("Coverage() =", c)
+	}
+}
@@ -21,12 +21,15 @@ package directory
 // Then, we merge the Geo annotation list with the ASN annotator list (when available).
 
 import (
+	"bytes"
 	"errors"
 	"log"
+	"net"
 	"sort"
 	"time"
 
 	"github.com/m-lab/annotation-service/api"
+	"github.com/m-lab/annotation-service/iputils"
 )
 
 var (
@@ -34,6 +37,14 @@ var (
 
 	// ErrEmptyDirectory is returned by GetAnnotator if a Directory has no entries.
 	ErrEmptyDirectory = errors.New("Directory is empty")
+
+	// ErrNoPrefixes is returned by CoveringPrefixes if none of the datasets
+	// keeps the routing prefixes.
+	ErrNoPrefixes = api.NewError("No dataset with routing prefixes", api.ErrNotApplicable)
+
+	// ErrNoRanges is returned by AnnotateRange if none of the datasets
+	// supports range queries.
+	ErrNoRanges = api.NewError("No dataset supports range queries", api.ErrNotApplicable)
 )
 
 // CompositeAnnotator wraps several annotators, and calls to Annotate() are forwarded to all of them.
@@ -42,21 +53,218 @@ type CompositeAnnotator struct {
 	// It is precomputed, and returned by AnnotatorDate()
 	date       time.Time
 	annotators []api.Annotator
+	// missing are the names of the sources with no annotator for the dates
+	// of this CA.  See MergeSources.
+	missing []string
 }
 
 // Annotate calls each of the wrapped annotators to annotate the ann object.
 // See Annotator.Annotate().
-// Error handling is currently under development.
+// If any of them fails, it returns an *api.CompositeError with their errors, and
+// ann still holds the annotations of the others.  Annotators that don't apply
+// to the IP, or whose field was already populated by an earlier annotator, are
+// not counted, nor are those that did not find the IP when a later annotator
+// populated their fields, e.g. a fallback ASN dataset.
 func (ca CompositeAnnotator) Annotate(ip string, ann *api.GeoData) error {
+	var failed []int
+	var errs []error
+	total := 0
 	for i := range ca.annotators {
 		err := ca.annotators[i].Annotate(ip, ann)
+		if errors.Is(err, api.ErrNotApplicable) || errors.Is(err, api.ErrAlreadyPopulated) {
+			// Another annotator does the job.
+			continue
+		}
+		total++
 		if err != nil {
-			// TODO - don't want to return error if there is another annotator that can do the job.
+			failed = append(failed, i)
+			errs = append(errs, err)
 		}
 	}
+	for k := len(errs) - 1; k >= 0; k-- {
+		if api.Status(errs[k]) == api.StatusNotFound && populated(ca.annotators[failed[k]], ann) {
+			errs = append(errs[:k], errs[k+1:]...)
+			total--
+		}
+	}
+	if len(errs) > 0 {
+		return &api.CompositeError{Errors: errs, Total: total}
+	}
 	return nil
 }
 
+// populated returns true if all the fields of ann that the datasets of the
+// annotator provide are populated.
+func populated(annotator api.Annotator, ann *api.GeoData) bool {
+	prov := annotator.Provenance()
+	for i := range prov {
+		switch prov[i].Type {
+		case api.LegacyType, api.GeoLite2Type:
+			if ann.Geo == nil {
+				return false
+			}
+		case api.RouteViewsType, api.GeoLite2ASNType:
+			if ann.Network == nil {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return len(prov) > 0
+}
+
+// CoveringPrefixes returns the covering prefixes from the first wrapped
+// annotator that has prefixes for the IP.  See api.PrefixAnnotator.
+func (ca CompositeAnnotator) CoveringPrefixes(ip string) ([]api.ASData, error) {
+	var firstErr error
+	for i := range ca.annotators {
+		pa, ok := ca.annotators[i].(api.PrefixAnnotator)
+		if !ok {
+			continue
+		}
+		prefixes, err := pa.CoveringPrefixes(ip)
+		if err == nil {
+			return prefixes, nil
+		}
+		// The IP may still be found by another annotator, e.g. the
+		// RouteViews IPv6 dataset after the IPv4 one.
+		if firstErr == nil && !errors.Is(err, api.ErrNotApplicable) {
+			firstErr = err
+		}
+	}
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return nil, ErrNoPrefixes
+}
+
+// OriginPrefixes returns the prefixes originated by the AS in all the wrapped
+// annotators, e.g. both the RouteViews IPv4 and IPv6 datasets.  See
+// api.PrefixAnnotator.
+func (ca CompositeAnnotator) OriginPrefixes(asn uint32) ([]api.ASData, error) {
+	var result []api.ASData
+	var firstErr error
+	for i := range ca.annotators {
+		pa, ok := ca.annotators[i].(api.PrefixAnnotator)
+		if !ok {
+			continue
+		}
+		prefixes, err := pa.OriginPrefixes(asn)
+		if err != nil {
+			if firstErr == nil && !errors.Is(err, api.ErrNotApplicable) {
+				firstErr = err
+			}
+			continue
+		}
+		result = append(result, prefixes...)
+	}
+	if len(result) > 0 {
+		return result, nil
+	}
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return nil, ErrNoPrefixes
+}
+
+// AnnotateRange returns the annotations of the wrapped annotators for the
+// prefix, split at the bounds of all their ranges.  As with Annotate, the
+// first annotator that covers a range provides each of its fields.  See
+// api.RangeAnnotator.
+func (ca CompositeAnnotator) AnnotateRange(prefix *net.IPNet) ([]api.RangeAnnotations, error) {
+	var result []api.RangeAnnotations
+	found := false
+	for i := range ca.annotators {
+		ra, ok := ca.annotators[i].(api.RangeAnnotator)
+		if !ok {
+			continue
+		}
+		ranges, err := ra.AnnotateRange(prefix)
+		if errors.Is(err, api.ErrNotApplicable) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		found = true
+		result = overlay(result, ranges)
+	}
+	if !found {
+		return nil, ErrNoRanges
+	}
+	return result, nil
+}
+
+// overlay merges two sorted lists of non-overlapping ranges.  The result is
+// split at the bounds of the ranges of both lists, and the fields set in a
+// take precedence over those in b.
+func overlay(a, b []api.RangeAnnotations) []api.RangeAnnotations {
+	if len(a) == 0 {
+		return b
+	}
+	if len(b) == 0 {
+		return a
+	}
+	// The first address of each piece.
+	starts := make([]net.IP, 0, 2*(len(a)+len(b)))
+	for _, list := range [][]api.RangeAnnotations{a, b} {
+		for i := range list {
+			starts = append(starts, list[i].First.To16())
+			if next := iputils.PlusOne(list[i].Last); next != nil {
+				starts = append(starts, next)
+			}
+		}
+	}
+	sort.Slice(starts, func(i, j int) bool { return bytes.Compare(starts[i], starts[j]) < 0 })
+
+	var result []api.RangeAnnotations
+	i, j := 0, 0
+	for k, start := range starts {
+		if k+1 < len(starts) && start.Equal(starts[k+1]) {
+			continue
+		}
+		for i < len(a) && bytes.Compare(a[i].Last.To16(), start) < 0 {
+			i++
+		}
+		for j < len(b) && bytes.Compare(b[j].Last.To16(), start) < 0 {
+			j++
+		}
+		piece := api.RangeAnnotations{First: start}
+		if i < len(a) && bytes.Compare(a[i].First.To16(), start) <= 0 {
+			piece.Annotations = a[i].Annotations
+			piece.Last = a[i].Last.To16()
+		}
+		if j < len(b) && bytes.Compare(b[j].First.To16(), start) <= 0 {
+			if piece.Geo == nil {
+				piece.Geo = b[j].Geo
+			}
+			if piece.Network == nil {
+				piece.Network = b[j].Network
+			}
+			piece.Last = b[j].Last.To16()
+		}
+		if piece.Last == nil {
+			// Neither list covers the piece.
+			continue
+		}
+		// The piece ends before the next start, if there is one.  Otherwise
+		// it ends at the last address, like the ranges covering it.
+		if k+1 < len(starts) {
+			piece.Last = iputils.MinusOne(starts[k+1])
+		}
+		// Pieces with the same annotations as the previous one, e.g. where a
+		// range of b ends inside a range of a, are merged back.
+		if n := len(result); n > 0 && result[n-1].Geo == piece.Geo && result[n-1].Network == piece.Network &&
+			iputils.PlusOne(result[n-1].Last).Equal(start) {
+			result[n-1].Last = piece.Last
+			continue
+		}
+		result = append(result, piece)
+	}
+	return result
+}
+
 // PrintAll prints all dates inside this CompositeAnnotator
 func (ca CompositeAnnotator) PrintAll() {
 	log.Println("Date of this CA: ", ca.date.Format("20060102"))
@@ -73,6 +281,37 @@ func (ca CompositeAnnotator) AnnotatorDate() time.Time {
 	return ca.date
 }
 
+// Provenance returns the datasets of all the wrapped annotators, in order.
+func (ca CompositeAnnotator) Provenance() []api.Provenance {
+	result := []api.Provenance{}
+	for i := range ca.annotators {
+		result = append(result, ca.annotators[i].Provenance()...)
+	}
+	return result
+}
+
+// Missing returns the names of the sources missing from this CA, and from
+// the CAs it wraps, in order.
+func (ca CompositeAnnotator) Missing() []string {
+	result := append([]string(nil), ca.missing...)
+	for i := range ca.annotators {
+		if inner, ok := ca.annotators[i].(CompositeAnnotator); ok {
+			result = append(result, inner.Missing()...)
+		}
+	}
+	return result
+}
+
+// Missing returns the names of the sources missing from ann, if it is a
+// (nested) CompositeAnnotator, or nil.
+func Missing(ann api.Annotator) []string {
+	ca, ok := ann.(CompositeAnnotator)
+	if !ok {
+		return nil
+	}
+	return ca.Missing()
+}
+
 func computerEarliestDate(annotators []api.Annotator) time.Time {
 	t := time.Now()
 	for i := range annotators {
@@ -112,22 +351,49 @@ func NewCompositeAnnotator(annotators []api.Annotator) api.Annotator {
 // TODO not crazy about this name.
 type Directory struct {
 	annotators []api.Annotator
+	windows    map[string]Window // See SetWindows
+	sources    []Source          // See BuildSources
 }
 
 var lastLogTime = time.Now()
 
-// GetAnnotator returns an appropriate api.Annotator for a given date.
+// GetAnnotator returns an appropriate api.Annotator for a given date.  Any
+// Lazy datasets it uses are loaded before it is returned.
 func (d *Directory) GetAnnotator(date time.Time) (api.Annotator, error) {
-	if len(d.annotators) < 1 {
-		return nil, ErrEmptyDirectory
+	ann, err := d.Find(date, nil)
+	if err != nil {
+		return nil, err
 	}
+	load(ann)
+	return ann, nil
+}
 
-	ann := d.lastEarlierThan(date)
-	if time.Since(lastLogTime) > 5*time.Minute {
-		log.Printf("Using (%s) for %s\n", ann.AnnotatorDate().Format("20060102"), date.Format("20060102"))
-		lastLogTime = time.Now()
+// Load loads the Lazy datasets used by ann, which may be a (nested)
+// CompositeAnnotator.  Datasets that are already loaded are just marked as
+// recently used.
+func Load(ann api.Annotator) {
+	load(ann)
+}
+
+// Annotators returns the annotators of the directory, in date order.  Each
+// one is used for the dates after its AnnotatorDate, up to the date of the
+// next one.
+func (d *Directory) Annotators() []api.Annotator {
+	return append([]api.Annotator(nil), d.annotators...)
+}
+
+// Datasets returns the annotators wrapped by ann, which may be a (nested)
+// CompositeAnnotator, in order.  Other annotators are returned as is.
+func Datasets(ann api.Annotator) []api.Annotator {
+	ca, ok := ann.(CompositeAnnotator)
+	if !ok {
+		return []api.Annotator{ann}
 	}
-	return ann, nil
+	var result []api.Annotator
+	for i := range ca.annotators {
+		result = append(result, Datasets(ca.annotators[i])...)
+	}
+	return result
 }
 
 // Build builds a Directory object from a list of Annotators.
@@ -167,7 +433,15 @@ func advance(lists [][]api.Annotator) ([][]api.Annotator, bool) {
 // MergeAnnotators merges multiple lists of annotators, and returns a list of CompositeAnnotators.
 // Result will include a separate CompositeAnnotator for each unique date in any list, and each
 // CA will include annotator of different types, that was the earlist available one after the CA date.
+// Empty lists are skipped.  See MergeSources to keep track of them.
 func MergeAnnotators(lists ...[]api.Annotator) []api.Annotator {
+	nonEmpty := make([][]api.Annotator, 0, len(lists))
+	for _, list := range lists {
+		if len(list) > 0 {
+			nonEmpty = append(nonEmpty, list)
+		}
+	}
+	lists = nonEmpty
 	listCount := len(lists)
 	if listCount == 0 {
 		return nil
@@ -184,9 +458,6 @@ func MergeAnnotators(lists ...[]api.Annotator) []api.Annotator {
 		// Create and add group with first annotator from each list
 		group := make([]api.Annotator, len(lists))
 		for l, list := range lists {
-			if len(list) == 0 {
-				return nil
-			}
 			group[l] = list[0]
 		}
 		groups = append(groups, group)
@@ -201,6 +472,57 @@ func MergeAnnotators(lists ...[]api.Annotator) []api.Annotator {
 	return result
 }
 
+// Source is a named list of annotators, in date order, to be merged by
+// MergeSources.
+type Source struct {
+	Name       string
+	Annotators []api.Annotator
+}
+
+// MergeSources merges the annotators of the sources into CompositeAnnotators,
+// one for each date of any annotator.  Each CA wraps the latest annotator of
+// every source that has one at its date, in source order, and lists the other
+// sources as missing.  So a source that is empty, or starts later than the
+// others, does not prevent the others from being used.  Unlike
+// MergeAnnotators, a source never covers the dates before its first
+// annotator.  It returns nil if all the sources are empty.
+func MergeSources(sources ...Source) []api.Annotator {
+	var dates []time.Time
+	seen := map[time.Time]bool{}
+	for _, s := range sources {
+		for _, ann := range s.Annotators {
+			if d := ann.AnnotatorDate(); !seen[d] {
+				seen[d] = true
+				dates = append(dates, d)
+			}
+		}
+	}
+	if len(dates) == 0 {
+		return nil
+	}
+	sort.Slice(dates, func(i, j int) bool { return dates[i].Before(dates[j]) })
+
+	result := make([]api.Annotator, 0, len(dates))
+	// next[s] is the index of the first annotator of source s after the
+	// current date.
+	next := make([]int, len(sources))
+	for _, date := range dates {
+		ca := CompositeAnnotator{date: date}
+		for s, src := range sources {
+			for next[s] < len(src.Annotators) && !src.Annotators[next[s]].AnnotatorDate().After(date) {
+				next[s]++
+			}
+			if next[s] == 0 {
+				ca.missing = append(ca.missing, src.Name)
+				continue
+			}
+			ca.annotators = append(ca.annotators, src.Annotators[next[s]-1])
+		}
+		result = append(result, ca)
+	}
+	return result
+}
+
 // TODO move all of this to geoloader.
 func lessFunc(s []api.Annotator) func(i, j int) bool {
 	return func(i, j int) bool {
@@ -3,11 +3,15 @@ package directory_test
 import (
 	"errors"
 	"log"
+	"net"
+	"reflect"
 	"testing"
 	"time"
 
 	"github.com/m-lab/annotation-service/api"
+	"github.com/m-lab/annotation-service/asn"
 	"github.com/m-lab/annotation-service/directory"
+	"github.com/m-lab/annotation-service/iputils"
 )
 
 func init() {
@@ -30,6 +34,10 @@ func (f *fakeAnn) AnnotatorDate() time.Time {
 	return f.startDate
 }
 
+func (f *fakeAnn) Provenance() []api.Provenance {
+	return []api.Provenance{{Type: "fake", Date: f.startDate}}
+}
+
 func (f *fakeAnn) String() string {
 	return "fake:" + f.AnnotatorDate().Format("20060102")
 
@@ -99,32 +107,364 @@ func TestCompositeAnnotator_String(t *testing.T) {
 	}
 }
 
+func TestCompositeAnnotator_Provenance(t *testing.T) {
+	inner := directory.NewCompositeAnnotator([]api.Annotator{newFake("20110304"), newFake("20120506")})
+	ca := directory.NewCompositeAnnotator([]api.Annotator{newFake("20100203"), inner})
+	got := ca.Provenance()
+	want := []string{"20100203", "20110304", "20120506"}
+	if len(got) != len(want) {
+		t.Fatal("Provenance() =", got)
+	}
+	for i := range want {
+		if got[i].Date.Format("20060102") != want[i] {
+			t.Error("Provenance() =", got)
+		}
+	}
+}
+
+func TestDatasets(t *testing.T) {
+	inner := directory.NewCompositeAnnotator([]api.Annotator{newFake("20110304"), newFake("20120506")})
+	ca := directory.NewCompositeAnnotator([]api.Annotator{newFake("20100203"), inner})
+	got := directory.Datasets(ca)
+	want := []string{"20100203", "20110304", "20120506"}
+	if len(got) != len(want) {
+		t.Fatal("Datasets() =", got)
+	}
+	for i := range want {
+		if got[i].AnnotatorDate().Format("20060102") != want[i] {
+			t.Error("Datasets() =", got)
+		}
+	}
+	if got := directory.Datasets(newFake("20100203")); len(got) != 1 {
+		t.Error("Datasets() =", got)
+	}
+}
+
+// fakeErr returns a fake annotator that fails with err.
+func fakeErr(date string, err error) *fakeAnn {
+	f := newFake(date)
+	f.err = err
+	return f
+}
+
 func TestCompositeAnnotator_Annotate(t *testing.T) {
-	errAnn := newFake("20110304")
-	errAnn.err = errors.New("fake error")
+	errFake := errors.New("fake error")
+	notFound := api.NewError("fake not found", api.ErrNotFound)
+	notLoaded := api.NewError("fake not loaded", api.ErrNotLoaded)
 	tests := []struct {
 		name       string
-		date       time.Time
 		annotators []api.Annotator
-		ip         string
-		ann        *api.GeoData
+		wantStatus string
+		wantErrs   int
 	}{
 		{
 			name:       "success",
-			annotators: []api.Annotator{newFake("20100203"), errAnn},
+			annotators: []api.Annotator{newFake("20100203"), newFake("20110304")},
+			wantStatus: api.StatusOK,
+		},
+		{
+			name:       "partial",
+			annotators: []api.Annotator{newFake("20100203"), fakeErr("20110304", errFake)},
+			wantStatus: api.StatusPartial,
+			wantErrs:   1,
+		},
+		{
+			name: "skipped",
+			annotators: []api.Annotator{
+				newFake("20100203"),
+				fakeErr("20110304", api.NewError("fake populated", api.ErrAlreadyPopulated)),
+				fakeErr("20120506", api.NewError("fake IPv6", api.ErrNotApplicable)),
+			},
+			wantStatus: api.StatusOK,
+		},
+		{
+			name:       "not-found",
+			annotators: []api.Annotator{fakeErr("20100203", notFound), fakeErr("20110304", notFound)},
+			wantStatus: api.StatusNotFound,
+			wantErrs:   2,
+		},
+		{
+			name:       "not-loaded",
+			annotators: []api.Annotator{fakeErr("20100203", notFound), fakeErr("20110304", notLoaded)},
+			wantStatus: api.StatusNotLoaded,
+			wantErrs:   2,
+		},
+		{
+			name:       "error",
+			annotators: []api.Annotator{fakeErr("20100203", notFound), fakeErr("20110304", errFake)},
+			wantStatus: api.StatusError,
+			wantErrs:   2,
+		},
+		{
+			name: "nested-partial",
+			annotators: []api.Annotator{
+				fakeErr("20100203", notFound),
+				directory.NewCompositeAnnotator([]api.Annotator{newFake("20110304"), fakeErr("20120506", notFound)}),
+			},
+			wantStatus: api.StatusPartial,
+			wantErrs:   2,
+		},
+		{
+			name: "nested-not-found",
+			annotators: []api.Annotator{
+				fakeErr("20100203", notFound),
+				directory.NewCompositeAnnotator([]api.Annotator{fakeErr("20110304", notFound)}),
+			},
+			wantStatus: api.StatusNotFound,
+			wantErrs:   2,
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			ca := directory.NewCompositeAnnotator(tt.annotators)
 			g := &api.GeoData{}
-			if err := ca.Annotate(tt.ip, g); err != nil {
-				t.Errorf("CompositeAnnotator.Annotate() error = %v, want nil", err)
+			err := ca.Annotate("1.2.3.4", g)
+			if got := api.Status(err); got != tt.wantStatus {
+				t.Errorf("Status(%v) = %s, want %s", err, got, tt.wantStatus)
+			}
+			if tt.wantErrs == 0 {
+				if err != nil {
+					t.Errorf("CompositeAnnotator.Annotate() error = %v, want nil", err)
+				}
+				return
+			}
+			ce, ok := err.(*api.CompositeError)
+			if !ok {
+				t.Fatalf("CompositeAnnotator.Annotate() error = %v, want CompositeError", err)
+			}
+			if len(ce.Errors) != tt.wantErrs {
+				t.Errorf("CompositeAnnotator.Annotate() error = %v, want %d errors", err, tt.wantErrs)
+			}
+			for _, child := range ce.Errors {
+				if !errors.Is(err, child) {
+					t.Errorf("errors.Is(%v, %v) = false", err, child)
+				}
 			}
 		})
 	}
 }
 
+// asnDataset returns an ASN dataset of the type, with one range.
+func asnDataset(kind, low, high, asnString string) *asn.ASNDataset {
+	ds := asn.NewASNDataset([]asn.ASNIPNode{{
+		BaseIPNode: iputils.BaseIPNode{IPAddressLow: net.ParseIP(low), IPAddressHigh: net.ParseIP(high)},
+		ASNString:  asnString,
+	}})
+	ds.Type = kind
+	return ds
+}
+
+func TestCompositeAnnotator_AnnotateASN(t *testing.T) {
+	v4 := asnDataset(api.RouteViewsType, "1.0.0.0", "1.0.0.255", "10")
+	v6 := asnDataset(api.RouteViewsType, "2001::", "2001::ffff", "20")
+	routeViews := directory.NewCompositeAnnotator([]api.Annotator{v4, v6})
+	fallback := directory.NewCompositeAnnotator([]api.Annotator{
+		routeViews, asnDataset(api.GeoLite2ASNType, "2.0.0.0", "2.0.0.255", "30")})
+
+	tests := []struct {
+		name       string
+		ann        api.Annotator
+		ip         string
+		wantStatus string
+		wantASN    uint32
+	}{
+		{"v4", routeViews, "1.0.0.1", api.StatusOK, 10},
+		{"v6", routeViews, "2001::1", api.StatusOK, 20},
+		{"v6-not-found", routeViews, "2002::1", api.StatusNotFound, 0},
+		{"primary", fallback, "1.0.0.1", api.StatusOK, 10},
+		{"fallback", fallback, "2.0.0.1", api.StatusOK, 30},
+		{"not-found", fallback, "3.0.0.1", api.StatusNotFound, 0},
+	}
+	for _, tt := range tests {
+		g := &api.GeoData{}
+		err := tt.ann.Annotate(tt.ip, g)
+		if got := api.Status(err); got != tt.wantStatus {
+			t.Errorf("%s: Status(%v) = %s, want %s", tt.name, err, got, tt.wantStatus)
+		}
+		if tt.wantStatus == api.StatusOK && err != nil {
+			t.Errorf("%s: Annotate() error = %v", tt.name, err)
+		}
+		if tt.wantASN != 0 && (g.Network == nil || g.Network.ASNumber != tt.wantASN) {
+			t.Errorf("%s: Network = %+v, want AS%d", tt.name, g.Network, tt.wantASN)
+		}
+	}
+
+	// The fallback does not hide other failures.
+	geo := fakeErr("20100203", api.NewError("fake not found", api.ErrNotFound))
+	ca := directory.NewCompositeAnnotator([]api.Annotator{geo, fallback})
+	if err := ca.Annotate("2.0.0.1", &api.GeoData{}); api.Status(err) != api.StatusPartial {
+		t.Errorf("Status(%v) = %s, want %s", err, api.Status(err), api.StatusPartial)
+	}
+}
+
+// fakePrefixes is a fake annotator that keeps routing prefixes.
+type fakePrefixes struct {
+	fakeAnn
+	prefixes []api.ASData
+}
+
+func (f *fakePrefixes) CoveringPrefixes(ip string) ([]api.ASData, error) {
+	return f.prefixes, f.err
+}
+
+func (f *fakePrefixes) OriginPrefixes(asn uint32) ([]api.ASData, error) {
+	return f.prefixes, f.err
+}
+
+func TestCompositeAnnotator_CoveringPrefixes(t *testing.T) {
+	notFound := api.NewError("fake not found", api.ErrNotFound)
+	prefixes := []api.ASData{{CIDR: "1.0.0.0/8", ASNumber: 100}, {CIDR: "1.2.0.0/16", ASNumber: 200}}
+	found := &fakePrefixes{prefixes: prefixes}
+	tests := []struct {
+		name       string
+		annotators []api.Annotator
+		want       []api.ASData
+		wantErr    error
+	}{
+		{
+			name:       "no-prefixes",
+			annotators: []api.Annotator{newFake("20100203")},
+			wantErr:    directory.ErrNoPrefixes,
+		},
+		{
+			name:       "second",
+			annotators: []api.Annotator{newFake("20100203"), &fakePrefixes{fakeAnn: fakeAnn{err: notFound}}, found},
+			want:       prefixes,
+		},
+		{
+			name: "not-found",
+			annotators: []api.Annotator{
+				&fakePrefixes{fakeAnn: fakeAnn{err: api.NewError("fake IPv6", api.ErrNotApplicable)}},
+				&fakePrefixes{fakeAnn: fakeAnn{err: notFound}},
+			},
+			wantErr: notFound,
+		},
+		{
+			name:       "nested",
+			annotators: []api.Annotator{newFake("20100203"), directory.NewCompositeAnnotator([]api.Annotator{found})},
+			want:       prefixes,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ca := directory.NewCompositeAnnotator(tt.annotators).(api.PrefixAnnotator)
+			got, err := ca.CoveringPrefixes("1.2.3.4")
+			if err != tt.wantErr {
+				t.Errorf("CoveringPrefixes() error = %v, want %v", err, tt.wantErr)
+			}
+			if len(got) != len(tt.want) {
+				t.Errorf("CoveringPrefixes() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompositeAnnotator_OriginPrefixes(t *testing.T) {
+	notFound := api.NewError("fake not found", api.ErrNotFound)
+	v4 := &fakePrefixes{prefixes: []api.ASData{{CIDR: "1.0.0.0/8"}}}
+	v6 := &fakePrefixes{prefixes: []api.ASData{{CIDR: "2001:db8::/32"}}}
+	ca := directory.NewCompositeAnnotator([]api.Annotator{
+		newFake("20100203"),
+		directory.NewCompositeAnnotator([]api.Annotator{v4, v6}),
+	}).(api.PrefixAnnotator)
+	got, err := ca.OriginPrefixes(100)
+	if err != nil || len(got) != 2 {
+		t.Error("OriginPrefixes() =", got, err)
+	}
+
+	ca = directory.NewCompositeAnnotator([]api.Annotator{v4, &fakePrefixes{fakeAnn: fakeAnn{err: notFound}}}).(api.PrefixAnnotator)
+	if got, err := ca.OriginPrefixes(100); err != nil || len(got) != 1 {
+		t.Error("OriginPrefixes() =", got, err)
+	}
+	ca = directory.NewCompositeAnnotator([]api.Annotator{newFake("20100203"), &fakePrefixes{fakeAnn: fakeAnn{err: notFound}}}).(api.PrefixAnnotator)
+	if _, err := ca.OriginPrefixes(100); err != notFound {
+		t.Error("OriginPrefixes() error =", err)
+	}
+	ca = directory.NewCompositeAnnotator([]api.Annotator{newFake("20100203")}).(api.PrefixAnnotator)
+	if _, err := ca.OriginPrefixes(100); err != directory.ErrNoPrefixes {
+		t.Error("OriginPrefixes() error =", err)
+	}
+}
+
+// fakeRanges is a fake annotator that supports range queries.
+type fakeRanges struct {
+	fakeAnn
+	ranges []api.RangeAnnotations
+}
+
+func (f *fakeRanges) AnnotateRange(prefix *net.IPNet) ([]api.RangeAnnotations, error) {
+	return f.ranges, f.err
+}
+
+// rangeOf returns a RangeAnnotations with the geo or network annotation name.
+func rangeOf(first, last string, geo, network string) api.RangeAnnotations {
+	r := api.RangeAnnotations{First: net.ParseIP(first), Last: net.ParseIP(last)}
+	if geo != "" {
+		r.Geo = &api.GeolocationIP{City: geo}
+	}
+	if network != "" {
+		r.Network = &api.ASData{CIDR: network}
+	}
+	return r
+}
+
+func TestCompositeAnnotator_AnnotateRange(t *testing.T) {
+	geo := &fakeRanges{ranges: []api.RangeAnnotations{
+		rangeOf("1.0.0.0", "1.0.0.127", "A", ""),
+		rangeOf("1.0.0.128", "1.0.0.255", "B", ""),
+	}}
+	asn := &fakeRanges{ranges: []api.RangeAnnotations{
+		rangeOf("1.0.0.64", "1.0.0.191", "", "X"),
+		rangeOf("1.0.1.0", "1.0.1.255", "", "Y"),
+	}}
+	// A second network dataset, used where the first has no data.
+	asn2 := &fakeRanges{ranges: []api.RangeAnnotations{
+		rangeOf("1.0.0.0", "1.0.1.127", "", "Z"),
+	}}
+	ca := directory.NewCompositeAnnotator([]api.Annotator{
+		newFake("20100203"), // Doesn't support range queries.
+		geo,
+		directory.NewCompositeAnnotator([]api.Annotator{asn, asn2}),
+	}).(api.RangeAnnotator)
+	_, prefix, _ := net.ParseCIDR("1.0.0.0/23")
+	got, err := ca.AnnotateRange(prefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"1.0.0.0-1.0.0.63 A Z",
+		"1.0.0.64-1.0.0.127 A X",
+		"1.0.0.128-1.0.0.191 B X",
+		"1.0.0.192-1.0.0.255 B Z",
+		"1.0.1.0-1.0.1.255 - Y",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("AnnotateRange() returned %d ranges, want %d: %v", len(got), len(want), got)
+	}
+	for i := range got {
+		city, cidr := "-", "-"
+		if got[i].Geo != nil {
+			city = got[i].Geo.City
+		}
+		if got[i].Network != nil {
+			cidr = got[i].Network.CIDR
+		}
+		if s := got[i].First.String() + "-" + got[i].Last.String() + " " + city + " " + cidr; s != want[i] {
+			t.Errorf("AnnotateRange()[%d] = %s, want %s", i, s, want[i])
+		}
+	}
+
+	ca = directory.NewCompositeAnnotator([]api.Annotator{newFake("20100203")}).(api.RangeAnnotator)
+	if _, err := ca.AnnotateRange(prefix); err != directory.ErrNoRanges {
+		t.Error("AnnotateRange() error =", err)
+	}
+	errFake := errors.New("fake error")
+	ca = directory.NewCompositeAnnotator([]api.Annotator{geo, &fakeRanges{fakeAnn: fakeAnn{err: errFake}}}).(api.RangeAnnotator)
+	if _, err := ca.AnnotateRange(prefix); err != errFake {
+		t.Error("AnnotateRange() error =", err)
+	}
+}
+
 func TestMergeAnnotators(t *testing.T) {
 	type args struct {
 	}
@@ -165,3 +505,69 @@ func TestMergeAnnotators(t *testing.T) {
 		})
 	}
 }
+
+func TestMergeSources(t *testing.T) {
+	tests := []struct {
+		name    string
+		sources []directory.Source
+		want    string
+		dates   []string
+		missing [][]string
+	}{
+		{
+			name: "empty-v6",
+			sources: []directory.Source{
+				{Name: "v4", Annotators: []api.Annotator{newFake("20100101"), newFake("20110101")}},
+				{Name: "v6"}},
+			want:    "([20100101])([20110101])",
+			dates:   []string{"20100101", "20110101"},
+			missing: [][]string{{"v6"}, {"v6"}},
+		},
+		{
+			name: "late",
+			sources: []directory.Source{
+				{Name: "geo", Annotators: []api.Annotator{newFake("20100203"), newFake("20110405")}},
+				{Name: "asn", Annotators: []api.Annotator{newFake("20100301")}}},
+			want:    "([20100203])([20100203][20100301])([20110405][20100301])",
+			dates:   []string{"20100203", "20100301", "20110405"},
+			missing: [][]string{{"asn"}, nil, nil},
+		},
+		{
+			name:    "none",
+			sources: []directory.Source{{Name: "geo"}, {Name: "asn"}},
+		},
+	}
+	for _, tt := range tests {
+		got := directory.MergeSources(tt.sources...)
+		if len(got) != len(tt.dates) {
+			t.Errorf("%s: MergeSources() = %v", tt.name, got)
+			continue
+		}
+		if len(got) == 0 {
+			continue
+		}
+		if s := directory.NewCompositeAnnotator(got).(directory.CompositeAnnotator).String(); s != tt.want {
+			t.Errorf("%s: MergeSources() = %s, want %s", tt.name, s, tt.want)
+		}
+		for i := range got {
+			if d := got[i].AnnotatorDate().Format("20060102"); d != tt.dates[i] {
+				t.Errorf("%s: date[%d] = %s, want %s", tt.name, i, d, tt.dates[i])
+			}
+			if m := directory.Missing(got[i]); !reflect.DeepEqual(m, tt.missing[i]) {
+				t.Errorf("%s: Missing(%d) = %v, want %v", tt.name, i, m, tt.missing[i])
+			}
+		}
+	}
+
+	// Nested composites report their missing sources too.
+	inner := directory.MergeSources(directory.Source{Name: "v4", Annotators: []api.Annotator{newFake("20100101")}},
+		directory.Source{Name: "v6"})
+	outer := directory.MergeSources(directory.Source{Name: "geo", Annotators: inner}, directory.Source{Name: "asn"})
+	if m := directory.Missing(outer[0]); !reflect.DeepEqual(m, []string{"asn", "v6"}) {
+		t.Error("Missing() =", m)
+	}
+	// Empty lists are skipped by MergeAnnotators.
+	if got := directory.MergeAnnotators([]api.Annotator{newFake("20100101")}, nil); len(got) != 1 {
+		t.Error("MergeAnnotators() =", got)
+	}
+}
@@ -0,0 +1,311 @@
+package directory
+
+import (
+	"container/list"
+	"errors"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/m-lab/annotation-service/api"
+	"github.com/m-lab/annotation-service/metrics"
+)
+
+var (
+	// ErrNilLoader is returned by Lazy handles created without a load function.
+	ErrNilLoader = errors.New("Lazy handle has no loader")
+)
+
+// Sizer is implemented by datasets that can report their approximate memory
+// use, in bytes.  Datasets that don't implement it are accounted with the
+// size estimate given to NewLazy.
+type Sizer interface {
+	Size() int64
+}
+
+// NodeCounter is implemented by datasets that can report their number of
+// nodes, i.e. the ranges or prefixes they search.
+type NodeCounter interface {
+	NodeCount() int
+}
+
+// Cache holds the datasets loaded through Lazy handles.  When the total size
+// of the loaded datasets exceeds the budget, the least recently used ones are
+// evicted, and loaded again on their next use.
+type Cache struct {
+	mu     sync.Mutex
+	budget int64      // Maximum total size of the loaded datasets, or 0 for no limit
+	used   int64      // Total size of the loaded datasets
+	lru    *list.List // The loaded handles, most recently used first
+}
+
+// NewCache creates a Cache with a memory budget in bytes.  A budget of 0 means
+// datasets are never evicted.
+func NewCache(budget int64) *Cache {
+	return &Cache{budget: budget, lru: list.New()}
+}
+
+// Used returns the total size of the loaded datasets.
+func (c *Cache) Used() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.used
+}
+
+// Lazy is an api.Annotator handle for a dataset that is loaded on first use.
+// Concurrent users share a single load.  The dataset may be evicted from the
+// Cache, and is then loaded again when it is next used.
+type Lazy struct {
+	prov  api.Provenance
+	size  int64 // The size estimate, until the dataset is loaded
+	load  func() (api.Annotator, error)
+	cache *Cache
+
+	// These are protected by cache.mu.
+	elem    *list.Element // The position in the LRU list, while loaded
+	loaded  int64         // The size accounted for the loaded dataset
+	pending *pendingLoad  // The load in progress, if any
+	lastErr error         // The error of the last load, if it failed
+	since   time.Time     // When the load in progress started, or the last load ended
+
+	annLock sync.RWMutex  // Protects ann, which is also only written with cache.mu held.
+	ann     api.Annotator // The loaded dataset, or nil
+}
+
+// pendingLoad is a load in progress, shared by all the callers that need it.
+type pendingLoad struct {
+	done chan struct{}
+	ann  api.Annotator
+	err  error
+}
+
+// NewLazy creates a handle for a dataset in the cache.  The provenance Date
+// must be the AnnotatorDate of the dataset, and size an estimate of its memory
+// use.  The dataset is not loaded until it is used.
+func (c *Cache) NewLazy(prov api.Provenance, size int64, load func() (api.Annotator, error)) *Lazy {
+	return &Lazy{prov: prov, size: size, load: load, cache: c}
+}
+
+// Load returns the dataset, loading it if needed, and marks it as the most
+// recently used.
+func (h *Lazy) Load() (api.Annotator, error) {
+	if h.load == nil {
+		return nil, ErrNilLoader
+	}
+	c := h.cache
+	c.mu.Lock()
+	if h.elem != nil {
+		c.lru.MoveToFront(h.elem)
+		ann := h.ann
+		c.mu.Unlock()
+		return ann, nil
+	}
+	if p := h.pending; p != nil {
+		c.mu.Unlock()
+		<-p.done
+		return p.ann, p.err
+	}
+	p := &pendingLoad{done: make(chan struct{})}
+	h.pending = p
+	h.since = time.Now()
+	c.mu.Unlock()
+
+	metrics.PendingLoads.Inc()
+	p.ann, p.err = h.load()
+	metrics.PendingLoads.Dec()
+	if p.err != nil {
+		log.Println("Failed loading", h.prov.Name, p.err)
+	}
+
+	c.mu.Lock()
+	h.pending = nil
+	h.lastErr = p.err
+	h.since = time.Now()
+	if p.err == nil {
+		c.add(h, p.ann)
+	}
+	c.mu.Unlock()
+	close(p.done)
+	return p.ann, p.err
+}
+
+// add records the loaded dataset for h, and evicts other datasets if the
+// cache is over budget.  c.mu must be held.
+func (c *Cache) add(h *Lazy, ann api.Annotator) {
+	h.loaded = h.size
+	if s, ok := ann.(Sizer); ok {
+		h.loaded = s.Size()
+	}
+	h.annLock.Lock()
+	h.ann = ann
+	h.annLock.Unlock()
+	h.elem = c.lru.PushFront(h)
+	c.used += h.loaded
+	metrics.LoadCount.Inc()
+	metrics.DatasetCount.Inc()
+
+	// The new dataset is kept even if it exceeds the budget on its own.
+	for c.budget > 0 && c.used > c.budget && c.lru.Back() != h.elem {
+		c.evict(c.lru.Back().Value.(*Lazy))
+	}
+}
+
+// evict drops the dataset loaded for h.  Annotations already in progress keep
+// using it until they complete.  c.mu must be held.
+func (c *Cache) evict(h *Lazy) {
+	log.Println("Evicting", h.prov.Name)
+	c.lru.Remove(h.elem)
+	h.elem = nil
+	c.used -= h.loaded
+	h.annLock.Lock()
+	h.ann = nil
+	h.annLock.Unlock()
+	metrics.EvictionCount.Inc()
+	metrics.DatasetCount.Dec()
+}
+
+// annotator returns the dataset, loading it if it is not loaded.  Load
+// errors are of kind api.ErrNotLoaded.
+func (h *Lazy) annotator() (api.Annotator, error) {
+	h.annLock.RLock()
+	ann := h.ann
+	h.annLock.RUnlock()
+	if ann == nil {
+		var err error
+		ann, err = h.Load()
+		if err != nil {
+			return nil, api.WrapError(err, api.ErrNotLoaded)
+		}
+	}
+	return ann, nil
+}
+
+// Annotate annotates with the dataset, loading it if it is not loaded.
+func (h *Lazy) Annotate(ip string, data *api.GeoData) error {
+	ann, err := h.annotator()
+	if err != nil {
+		return err
+	}
+	return ann.Annotate(ip, data)
+}
+
+// CoveringPrefixes returns the covering prefixes from the dataset, loading it
+// if it is not loaded.  See api.PrefixAnnotator.  Only RouteViews datasets
+// keep the prefixes, so other datasets are not loaded.
+func (h *Lazy) CoveringPrefixes(ip string) ([]api.ASData, error) {
+	if h.prov.Type != api.RouteViewsType {
+		return nil, ErrNoPrefixes
+	}
+	ann, err := h.annotator()
+	if err != nil {
+		return nil, err
+	}
+	pa, ok := ann.(api.PrefixAnnotator)
+	if !ok {
+		return nil, ErrNoPrefixes
+	}
+	return pa.CoveringPrefixes(ip)
+}
+
+// OriginPrefixes returns the prefixes originated by the AS in the dataset,
+// loading it if it is not loaded.  See api.PrefixAnnotator.
+func (h *Lazy) OriginPrefixes(asn uint32) ([]api.ASData, error) {
+	if h.prov.Type != api.RouteViewsType {
+		return nil, ErrNoPrefixes
+	}
+	ann, err := h.annotator()
+	if err != nil {
+		return nil, err
+	}
+	pa, ok := ann.(api.PrefixAnnotator)
+	if !ok {
+		return nil, ErrNoPrefixes
+	}
+	return pa.OriginPrefixes(asn)
+}
+
+// AnnotateRange annotates the prefix with the dataset, loading it if it is not
+// loaded.  See api.RangeAnnotator.  Legacy datasets don't support range
+// queries, so they are not loaded.
+func (h *Lazy) AnnotateRange(prefix *net.IPNet) ([]api.RangeAnnotations, error) {
+	if h.prov.Type == api.LegacyType {
+		return nil, ErrNoRanges
+	}
+	ann, err := h.annotator()
+	if err != nil {
+		return nil, err
+	}
+	ra, ok := ann.(api.RangeAnnotator)
+	if !ok {
+		return nil, ErrNoRanges
+	}
+	return ra.AnnotateRange(prefix)
+}
+
+// AnnotatorDate returns the date of the dataset, which is known without loading it.
+func (h *Lazy) AnnotatorDate() time.Time {
+	return h.prov.Date
+}
+
+// Provenance returns the provenance of the dataset, which is known without
+// loading it.
+func (h *Lazy) Provenance() []api.Provenance {
+	return []api.Provenance{h.prov}
+}
+
+// LazyState is the load state of a Lazy handle.
+type LazyState struct {
+	Annotator api.Annotator // The loaded dataset, or nil if it is not loaded
+	Pending   bool          // True while the dataset is being loaded
+	Err       error         // The error of the last load, if it failed
+	Since     time.Time     // When the load in progress started, or the last one ended
+}
+
+// State returns the load state of the handle.  Unlike the other methods, it
+// never loads the dataset.
+func (h *Lazy) State() LazyState {
+	h.cache.mu.Lock()
+	defer h.cache.mu.Unlock()
+	return LazyState{Annotator: h.ann, Pending: h.pending != nil, Err: h.lastErr, Since: h.since}
+}
+
+// isLoaded returns true if the dataset is currently loaded.
+func (h *Lazy) isLoaded() bool {
+	h.annLock.RLock()
+	defer h.annLock.RUnlock()
+	return h.ann != nil
+}
+
+// load loads all the Lazy handles used by ann.  Handles that are not loaded
+// yet are loaded concurrently.  Load errors are logged by the handles, and
+// reported again by their Annotate calls.
+func load(ann api.Annotator) {
+	wg := sync.WaitGroup{}
+	forEachLazy(ann, func(h *Lazy) {
+		if h.isLoaded() {
+			// Just mark it as recently used.
+			h.Load()
+			return
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			h.Load()
+		}()
+	})
+	wg.Wait()
+}
+
+// forEachLazy calls f for each Lazy handle in ann, which may be a (nested)
+// CompositeAnnotator.
+func forEachLazy(ann api.Annotator, f func(*Lazy)) {
+	switch a := ann.(type) {
+	case *Lazy:
+		f(a)
+	case CompositeAnnotator:
+		for i := range a.annotators {
+			forEachLazy(a.annotators[i], f)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package directory_test
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/m-lab/annotation-service/api"
+	"github.com/m-lab/annotation-service/directory"
+)
+
+// sizedFake is a fake dataset that reports its size.
+type sizedFake struct {
+	fakeAnn
+	size int64
+}
+
+func (f *sizedFake) Size() int64 {
+	return f.size
+}
+
+// lazyFake returns a Lazy handle for a fake dataset of the given size, and a
+// counter of its loads.
+func lazyFake(c *directory.Cache, date string, size int64) (*directory.Lazy, *int32) {
+	loads := new(int32)
+	d, _ := time.Parse("20060102", date)
+	h := c.NewLazy(api.Provenance{Type: "fake", Name: date, Date: d}, 1, func() (api.Annotator, error) {
+		atomic.AddInt32(loads, 1)
+		// Give concurrent callers a chance to pile up.
+		time.Sleep(10 * time.Millisecond)
+		return &sizedFake{fakeAnn: fakeAnn{startDate: d}, size: size}, nil
+	})
+	return h, loads
+}
+
+func TestLazyLoadsOnce(t *testing.T) {
+	c := directory.NewCache(0)
+	h, loads := lazyFake(c, "20180308", 100)
+	if !h.AnnotatorDate().Equal(time.Date(2018, 3, 8, 0, 0, 0, 0, time.UTC)) {
+		t.Error("AnnotatorDate() =", h.AnnotatorDate())
+	}
+	if *loads != 0 {
+		t.Fatal("dataset loaded before use")
+	}
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := h.Annotate("1.2.3.4", &api.GeoData{}); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if *loads != 1 {
+		t.Error("loads =", *loads)
+	}
+	if c.Used() != 100 {
+		t.Error("Used() =", c.Used())
+	}
+}
+
+func TestLazyEviction(t *testing.T) {
+	c := directory.NewCache(250)
+	a, aLoads := lazyFake(c, "20180101", 100)
+	b, bLoads := lazyFake(c, "20180201", 100)
+	x, xLoads := lazyFake(c, "20180301", 100)
+	dir := directory.Build([]api.Annotator{a, b, x})
+
+	for _, date := range []string{"20180102", "20180202", "20180105", "20180302"} {
+		d, _ := time.Parse("20060102", date)
+		if _, err := dir.GetAnnotator(d); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Loading x exceeded the budget, and b was the least recently used.
+	if *aLoads != 1 || *bLoads != 1 || *xLoads != 1 {
+		t.Error("loads =", *aLoads, *bLoads, *xLoads)
+	}
+	if c.Used() != 200 {
+		t.Error("Used() =", c.Used())
+	}
+	// Using b again reloads it, and evicts a.
+	if err := b.Annotate("1.2.3.4", &api.GeoData{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if *aLoads != 2 || *bLoads != 2 || *xLoads != 1 {
+		t.Error("loads =", *aLoads, *bLoads, *xLoads)
+	}
+}
+
+func TestLazyLoadsComposite(t *testing.T) {
+	c := directory.NewCache(0)
+	g, gLoads := lazyFake(c, "20180101", 1)
+	n, nLoads := lazyFake(c, "20180101", 1)
+	dir := directory.Build([]api.Annotator{directory.NewCompositeAnnotator([]api.Annotator{g, n})})
+	if _, err := dir.GetAnnotator(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if *gLoads != 1 || *nLoads != 1 {
+		t.Error("loads =", *gLoads, *nLoads)
+	}
+}
+
+func TestLazyLoadError(t *testing.T) {
+	c := directory.NewCache(0)
+	errLoad := errors.New("load failed")
+	calls := 0
+	h := c.NewLazy(api.Provenance{Name: "broken", Date: time.Now()}, 1, func() (api.Annotator, error) {
+		calls++
+		return nil, errLoad
+	})
+	if err := h.Annotate("1.2.3.4", &api.GeoData{}); !errors.Is(err, errLoad) || !errors.Is(err, api.ErrNotLoaded) {
+		t.Error("Annotate() error =", err)
+	}
+	// Failed loads are retried on the next use.
+	if _, err := h.Load(); err != errLoad || calls != 2 {
+		t.Error("Load() error =", err, "calls =", calls)
+	}
+	if c.Used() != 0 {
+		t.Error("Used() =", c.Used())
+	}
+	if s := h.State(); s.Annotator != nil || s.Pending || s.Err != errLoad || s.Since.IsZero() {
+		t.Errorf("State() = %+v", s)
+	}
+}
+
+func TestLazyState(t *testing.T) {
+	c := directory.NewCache(0)
+	h, loads := lazyFake(c, "20180308", 100)
+	if s := h.State(); s.Annotator != nil || s.Pending || s.Err != nil || *loads != 0 {
+		t.Errorf("State() = %+v", s)
+	}
+	done := make(chan struct{})
+	go func() {
+		h.Load()
+		close(done)
+	}()
+	// The fake load takes 10ms.
+	time.Sleep(2 * time.Millisecond)
+	if s := h.State(); !s.Pending {
+		t.Errorf("State() = %+v during the load", s)
+	}
+	<-done
+	if s := h.State(); s.Annotator == nil || s.Pending || s.Err != nil {
+		t.Errorf("State() = %+v", s)
+	}
+}
+
+func TestLazyCoveringPrefixes(t *testing.T) {
+	c := directory.NewCache(0)
+	h, loads := lazyFake(c, "20180308", 100)
+	if _, err := h.CoveringPrefixes("1.2.3.4"); err != directory.ErrNoPrefixes || *loads != 0 {
+		t.Error("CoveringPrefixes() error =", err, "loads =", *loads)
+	}
+
+	prefixes := []api.ASData{{CIDR: "1.0.0.0/8", ASNumber: 100}}
+	h = c.NewLazy(api.Provenance{Type: api.RouteViewsType, Date: time.Now()}, 1, func() (api.Annotator, error) {
+		return &fakePrefixes{prefixes: prefixes}, nil
+	})
+	if got, err := h.CoveringPrefixes("1.2.3.4"); err != nil || len(got) != 1 {
+		t.Error("CoveringPrefixes() =", got, err)
+	}
+	if got, err := h.OriginPrefixes(100); err != nil || len(got) != 1 {
+		t.Error("OriginPrefixes() =", got, err)
+	}
+	h = c.NewLazy(api.Provenance{Type: api.RouteViewsType, Date: time.Now()}, 1, func() (api.Annotator, error) {
+		return newFake("20180308"), nil
+	})
+	if _, err := h.CoveringPrefixes("1.2.3.4"); err != directory.ErrNoPrefixes {
+		t.Error("CoveringPrefixes() error =", err)
+	}
+}
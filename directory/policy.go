@@ -0,0 +1,141 @@
+package directory
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/m-lab/annotation-service/api"
+)
+
+// Policy is how the dataset of a source is selected for a date.
+type Policy string
+
+// Selection policies.
+const (
+	// LastBefore selects the last dataset before the date, or none for
+	// earlier dates.  This is the default.
+	LastBefore Policy = "last-before"
+	// Nearest selects the dataset closest to the date, before or after it.
+	// On a tie, the one before is selected.
+	Nearest Policy = "nearest"
+	// FirstAfter selects the first dataset on or after the date, or the last
+	// one for later dates.
+	FirstAfter Policy = "first-after"
+)
+
+var (
+	// ErrBadPolicy is returned by ParsePolicy for unknown policies.
+	ErrBadPolicy = errors.New("Invalid selection policy")
+)
+
+// ParsePolicy returns the Policy named s, or LastBefore if s is empty.
+func ParsePolicy(s string) (Policy, error) {
+	switch p := Policy(s); p {
+	case "":
+		return LastBefore, nil
+	case LastBefore, Nearest, FirstAfter:
+		return p, nil
+	default:
+		return "", fmt.Errorf("%w: %q, want %q, %q or %q", ErrBadPolicy, s, LastBefore, Nearest, FirstAfter)
+	}
+}
+
+// Selection holds the policies of the sources of a directory, keyed by source
+// name.  Sources without a policy use LastBefore.
+type Selection map[string]Policy
+
+// pick returns the index of the annotator of the sorted, non empty list
+// selected by the policy for the date, or -1 if there is none.
+func (p Policy) pick(list []api.Annotator, date time.Time) int {
+	// The first annotator on or after the date.
+	i := sort.Search(len(list), searchFunc(list, date))
+	switch {
+	case i == 0 && (p == "" || p == LastBefore) && list[0].AnnotatorDate().After(date):
+		return -1
+	case i == 0:
+		return 0
+	case i == len(list):
+		return i - 1
+	case p == FirstAfter:
+		return i
+	case p == Nearest && list[i].AnnotatorDate().Sub(date) < date.Sub(list[i-1].AnnotatorDate()):
+		return i
+	default:
+		return i - 1
+	}
+}
+
+// BuildSources builds a Directory from the sources merged by MergeSources.
+// The sources are kept, so that Select can use a different policy for each.
+func BuildSources(sources ...Source) *Directory {
+	sorted := make([]Source, len(sources))
+	for i, s := range sources {
+		sorted[i] = Source{Name: s.Name, Annotators: SortSlice(append([]api.Annotator(nil), s.Annotators...))}
+	}
+	dir := Build(MergeSources(sorted...))
+	dir.sources = sorted
+	return dir
+}
+
+// Select is like GetAnnotator, but selects the dataset of each source with
+// its policy in sel.  See Find.
+func (d *Directory) Select(date time.Time, sel Selection) (api.Annotator, error) {
+	ann, err := d.Find(date, sel)
+	if err != nil {
+		return nil, err
+	}
+	load(ann)
+	return ann, nil
+}
+
+// Find returns the annotator for the date, with the dataset of each source
+// selected by its policy in sel, but does not load its Lazy datasets, which
+// may take a while.  See Load.  When all the policies are LastBefore, the
+// annotator is one of the directory.  Otherwise, it is a CompositeAnnotator
+// of the selected datasets, dated by the latest of them.  As in the annotators
+// of the directory, sources without a dataset selected are Missing, and dates
+// before all the datasets are treated as the date of the first ones.
+// Directories built without sources always use LastBefore.
+func (d *Directory) Find(date time.Time, sel Selection) (api.Annotator, error) {
+	if len(d.annotators) < 1 {
+		return nil, ErrEmptyDirectory
+	}
+	custom := false
+	for _, s := range d.sources {
+		if p := sel[s.Name]; p != "" && p != LastBefore {
+			custom = true
+		}
+	}
+	if !custom {
+		ann := d.lastEarlierThan(date)
+		if time.Since(lastLogTime) > 5*time.Minute {
+			log.Printf("Using (%s) for %s\n", ann.AnnotatorDate().Format("20060102"), date.Format("20060102"))
+			lastLogTime = time.Now()
+		}
+		return ann, nil
+	}
+
+	if first := d.annotators[0].AnnotatorDate(); date.Before(first) {
+		date = first
+	}
+	ca := CompositeAnnotator{}
+	for _, s := range d.sources {
+		i := -1
+		if len(s.Annotators) > 0 {
+			i = sel[s.Name].pick(s.Annotators, date)
+		}
+		if i < 0 {
+			ca.missing = append(ca.missing, s.Name)
+			continue
+		}
+		ann := s.Annotators[i]
+		ca.annotators = append(ca.annotators, ann)
+		if ann.AnnotatorDate().After(ca.date) {
+			ca.date = ann.AnnotatorDate()
+		}
+	}
+	return ca, nil
+}
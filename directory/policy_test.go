@@ -0,0 +1,96 @@
+package directory_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/m-lab/annotation-service/api"
+	"github.com/m-lab/annotation-service/directory"
+)
+
+func TestParsePolicy(t *testing.T) {
+	for s, want := range map[string]directory.Policy{
+		"":            directory.LastBefore,
+		"last-before": directory.LastBefore,
+		"nearest":     directory.Nearest,
+		"first-after": directory.FirstAfter,
+	} {
+		if p, err := directory.ParsePolicy(s); err != nil || p != want {
+			t.Errorf("ParsePolicy(%q) = %q, %v", s, p, err)
+		}
+	}
+	if _, err := directory.ParsePolicy("latest"); !errors.Is(err, directory.ErrBadPolicy) {
+		t.Error("ParsePolicy(latest) error =", err)
+	}
+}
+
+func TestSelect(t *testing.T) {
+	dir := directory.BuildSources(
+		directory.Source{Name: "geo", Annotators: []api.Annotator{newFake("20200101"), newFake("20200201"), newFake("20200301")}},
+		directory.Source{Name: "asn", Annotators: []api.Annotator{newFake("20200110"), newFake("20200210")}},
+		directory.Source{Name: "empty"})
+	date := newFake("20200120").AnnotatorDate()
+
+	tests := []struct {
+		name string
+		sel  directory.Selection
+		want string
+		date string
+	}{
+		{"default", nil, "[20200101][20200110]", "20200110"},
+		{"last-before", directory.Selection{"geo": directory.LastBefore}, "[20200101][20200110]", "20200110"},
+		{"geo-nearest", directory.Selection{"geo": directory.Nearest}, "[20200201][20200110]", "20200201"},
+		{"asn-nearest", directory.Selection{"asn": directory.Nearest}, "[20200101][20200110]", "20200110"},
+		{"asn-first-after", directory.Selection{"asn": directory.FirstAfter}, "[20200101][20200210]", "20200210"},
+		{"both", directory.Selection{"geo": directory.FirstAfter, "asn": directory.Nearest}, "[20200201][20200110]", "20200201"},
+	}
+	for _, tt := range tests {
+		ann, err := dir.Select(date, tt.sel)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ca := ann.(directory.CompositeAnnotator)
+		if got := ca.String(); got != tt.want {
+			t.Errorf("%s: Select() = %s, want %s", tt.name, got, tt.want)
+		}
+		if got := ca.AnnotatorDate().Format("20060102"); got != tt.date {
+			t.Errorf("%s: AnnotatorDate() = %s, want %s", tt.name, got, tt.date)
+		}
+		if m := ca.Missing(); len(m) != 1 || m[0] != "empty" {
+			t.Errorf("%s: Missing() = %v", tt.name, m)
+		}
+	}
+
+	// Before the first ASN dataset, LastBefore leaves it missing, as the
+	// default annotator does, whatever the policy of the other sources.
+	beforeASN := newFake("20200105").AnnotatorDate()
+	for _, sel := range []directory.Selection{nil, {"geo": directory.Nearest}, {"geo": directory.FirstAfter, "asn": directory.LastBefore}} {
+		ann, err := dir.Select(beforeASN, sel)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m := directory.Missing(ann); len(m) != 2 || m[0] != "asn" || m[1] != "empty" {
+			t.Errorf("Select(%v) Missing() = %v", sel, m)
+		}
+	}
+	ann, _ := dir.Select(beforeASN, directory.Selection{"asn": directory.Nearest})
+	if got := ann.(directory.CompositeAnnotator).String(); got != "[20200101][20200110]" {
+		t.Error("Select(nearest asn) =", got)
+	}
+
+	// Dates beyond the datasets use the first or last ones.
+	early := newFake("20190101").AnnotatorDate()
+	ann, _ = dir.Select(early, directory.Selection{"geo": directory.Nearest, "asn": directory.FirstAfter})
+	if got := ann.(directory.CompositeAnnotator).String(); got != "[20200101][20200110]" {
+		t.Error("Select(early) =", got)
+	}
+	ann, _ = dir.Select(early, directory.Selection{"geo": directory.Nearest})
+	if got := ann.(directory.CompositeAnnotator).String(); got != "[20200101]" {
+		t.Error("Select(early, asn last-before) =", got)
+	}
+	late := newFake("20210101").AnnotatorDate()
+	ann, _ = dir.Select(late, directory.Selection{"geo": directory.FirstAfter})
+	if got := ann.(directory.CompositeAnnotator).String(); got != "[20200301][20200210]" {
+		t.Error("Select(late) =", got)
+	}
+}
@@ -8,7 +8,9 @@ import (
 	"strconv"
 	"strings"
 
+	"github.com/m-lab/annotation-service/api"
 	"github.com/m-lab/annotation-service/iputils"
+	"github.com/m-lab/annotation-service/loader"
 )
 
 var (
@@ -61,7 +63,7 @@ func (p *geoNodeParser) PreconfigureReader(reader *csv.Reader) error {
 	_, err := reader.Read()
 	if err == io.EOF {
 		log.Println("Empty input data")
-		return errors.New("Empty input data")
+		return ErrEmptyFile
 	}
 	return nil
 }
@@ -129,7 +131,7 @@ func (p *geoNodeParser) LastNode() iputils.IPNode {
 func checkNumColumns(record []string, size int) error {
 	if len(record) != size {
 		log.Println("Incorrect number of columns in IP list", size, " got: ", len(record), record)
-		return errors.New("Corrupted Data: wrong number of columns")
+		return loader.ErrBadRecord
 	}
 	return nil
 }
@@ -145,12 +147,12 @@ func checkNumColumns(record []string, size int) error {
 func lookupGeoID(gnid string, idMap map[int]int) (int, error) {
 	geonameID, err := strconv.Atoi(gnid)
 	if err != nil {
-		return 0, errors.New("Corrupted Data: geonameID should be a number")
+		return 0, api.NewError("Corrupted Data: geonameID should be a number", api.ErrCorrupt)
 	}
 	loadIndex, ok := idMap[geonameID]
 	if !ok {
 		log.Println("geonameID not found ", geonameID)
-		return 0, errors.New("Corrupted Data: geonameId not found")
+		return 0, api.NewError("Corrupted Data: geonameId not found", api.ErrCorrupt)
 	}
 	return loadIndex, nil
 }
@@ -161,7 +163,7 @@ func stringToFloat(str, field string) (float64, error) {
 		if len(str) > 0 {
 			log.Println(field, " was not a number")
 			output := strings.Join([]string{"Corrupted Data: ", field, " should be an int"}, "")
-			return 0, errors.New(output)
+			return 0, api.NewError(output, api.ErrCorrupt)
 		}
 	}
 	return flt, nil
@@ -176,3 +178,12 @@ func LoadIPListG2(reader io.Reader, idMap map[int]int) ([]GeoIPNode, error) {
 	err := iputils.BuildIPNodeList(reader, parser)
 	return parser.list, err
 }
+
+// LoadPrefixListG2 creates a list of GeoIPNodes from a GeoLite2 reader, with
+// one node per block, holding the bounds of its prefix.  Nested blocks are not
+// flattened.
+func LoadPrefixListG2(reader io.Reader, idMap map[int]int) ([]GeoIPNode, error) {
+	parser := newGeoNodeParser(idMap)
+	err := iputils.BuildPrefixList(reader, parser)
+	return parser.list, err
+}
@@ -2,13 +2,13 @@ package geolite2v2
 
 import (
 	"encoding/csv"
-	"errors"
 	"io"
 	"log"
 	"regexp"
 	"strconv"
 	"strings"
 
+	"github.com/m-lab/annotation-service/api"
 	"github.com/m-lab/annotation-service/loader"
 )
 
@@ -20,9 +20,9 @@ var (
 
 // Loader errors
 var (
-	ErrEmptyFile      = errors.New("Empty input data")
-	ErrBadGeonameID   = errors.New("Corrupted Data: GeonameID should be a number")
-	ErrBadCountryName = errors.New("Corrupted Data: country name should be letters")
+	ErrEmptyFile      = api.NewError("Empty input data", api.ErrCorrupt)
+	ErrBadGeonameID   = api.NewError("Corrupted Data: GeonameID should be a number", api.ErrCorrupt)
+	ErrBadCountryName = api.NewError("Corrupted Data: country name should be letters", api.ErrCorrupt)
 )
 
 // LocationNode defines Location databases
@@ -155,7 +155,7 @@ func checkCaps(str, field string) (string, error) {
 	}
 	log.Println(field, "should be all capitals and no punctuation: ", str)
 	output := strings.Join([]string{"Corrupted Data: ", field, " should be all caps and no punctuation"}, "")
-	return "", errors.New(output)
+	return "", api.NewError(output, api.ErrCorrupt)
 }
 
 // LoadLocationsG2 creates the Location list for GLite2 databases
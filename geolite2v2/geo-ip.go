@@ -5,12 +5,17 @@ import (
 	"context"
 	"errors"
 	"log"
+	"net"
 	"time"
+	"unsafe"
 
 	"cloud.google.com/go/storage"
 	"github.com/m-lab/annotation-service/api"
+	"github.com/m-lab/annotation-service/iptrie"
 	"github.com/m-lab/annotation-service/iputils"
 	"github.com/m-lab/annotation-service/loader"
+	"github.com/m-lab/annotation-service/rangetable"
+	"github.com/m-lab/annotation-service/snapshot"
 )
 
 const (
@@ -18,10 +23,20 @@ const (
 )
 
 var (
+	// LookupEngine selects the lookup structure built when datasets are loaded
+	// from CSV zips.  Snapshots hold range lists, so they are not used with
+	// iputils.PrefixTrie.
+	LookupEngine = iputils.RangeList
+
 	gLite2Prefix              = "GeoLite2-City"
 	geoLite2BlocksFilenameIP4 = "GeoLite2-City-Blocks-IPv4.csv"  // Filename of ipv4 blocks file
 	geoLite2BlocksFilenameIP6 = "GeoLite2-City-Blocks-IPv6.csv"  // Filename of ipv6 blocks file
 	geoLite2LocationsFilename = "GeoLite2-City-Locations-en.csv" // Filename of locations file
+
+	// ErrNilGeoData is returned when Annotate is called with a nil GeoData.
+	ErrNilGeoData = errors.New("Nil GeoData")
+	// ErrAlreadyPopulated is returned when the Geo annotation is already populated.
+	ErrAlreadyPopulated = api.NewError("Geo annotation already populated", api.ErrAlreadyPopulated)
 )
 
 // The GeoDataset struct bundles all the data needed to search and
@@ -29,27 +44,199 @@ var (
 // It implements the api.Annotator interface.
 type GeoDataset struct {
 	Start         time.Time      // Date from which to start using this dataset
-	IP4Nodes      []GeoIPNode    // The IPNode list containing IP4Nodes
-	IP6Nodes      []GeoIPNode    // The IPNode list containing IP6Nodes
+	Name          string         // The object the dataset was loaded from
 	LocationNodes []LocationNode // The location nodes corresponding to the IPNodes
+
+	ip4  geoTable          // The IPv4 blocks
+	ip6  geoTable          // The IPv6 blocks
+	snap *snapshot.Mapping // The snapshot holding the tables, if they were loaded from one
 }
 
-// LoadG2 loads a dataset from a GCS object.
-func LoadG2(file *storage.ObjectAttrs) (api.Annotator, error) {
-	return LoadG2Dataset(file.Name, file.Bucket)
+// geoTable holds a GeoIPNode list as fixed width columns, indexed by the
+// rows of the range table, or of the trie if there is one.
+type geoTable struct {
+	ranges    rangetable.Table
+	trie      *iptrie.Trie // The prefixes, used instead of ranges when not nil
+	location  []int32
+	postal    rangetable.Strings
+	latitude  []float64
+	longitude []float64
 }
 
-// LoadG2Dataset loads the dataset from the specified filename and bucket
-func LoadG2Dataset(filename string, bucketname string) (*GeoDataset, error) {
-	zip, err := loader.CreateZipReader(context.Background(), bucketname, filename)
-	log.Println("Loading dataset from", filename)
+func newGeoTable(nodes []GeoIPNode) geoTable {
+	b := rangetable.Builder{}
+	for i := range nodes {
+		b.Add(nodes[i].IPAddressLow, nodes[i].IPAddressHigh)
+	}
+	ranges, order := b.Build()
+	t := geoTable{ranges: ranges}
+	t.setColumns(nodes, order)
+	return t
+}
+
+// newGeoTrieTable is like newGeoTable, but stores the prefixes of the nodes,
+// which may be nested and in any order, in a trie.
+func newGeoTrieTable(nodes []GeoIPNode) (geoTable, error) {
+	b := iptrie.Builder{}
+	order := make([]int, len(nodes))
+	for i := range nodes {
+		if err := b.Add(nodes[i].IPAddressLow, nodes[i].IPAddressHigh); err != nil {
+			return geoTable{}, err
+		}
+		order[i] = i
+	}
+	t := geoTable{trie: b.Build()}
+	t.setColumns(nodes, order)
+	return t, nil
+}
+
+// setColumns fills the columns with the data of the nodes, where order holds
+// the index of the node for each row.
+func (t *geoTable) setColumns(nodes []GeoIPNode, order []int) {
+	t.location = make([]int32, len(order))
+	t.latitude = make([]float64, len(order))
+	t.longitude = make([]float64, len(order))
+	postal := make([]string, len(order))
+	for row, i := range order {
+		t.location[row] = int32(nodes[i].LocationIndex)
+		postal[row] = nodes[i].PostalCode
+		t.latitude[row] = nodes[i].Latitude
+		t.longitude[row] = nodes[i].Longitude
+	}
+	t.postal = rangetable.NewStrings(postal)
+}
+
+// search returns the row of the block containing ip.
+func (t *geoTable) search(ip net.IP) (int, error) {
+	if t.trie != nil {
+		return t.trie.Search(ip)
+	}
+	return t.ranges.Search(ip)
+}
+
+// bounds returns the first and last addresses of the block in the row.
+func (t *geoTable) bounds(row int) (net.IP, net.IP) {
+	if t.trie != nil {
+		return t.trie.Bounds(row)
+	}
+	return t.ranges.Bounds(row)
+}
+
+// searchRange returns the blocks overlapping [first, last], clipped to it.
+func (t *geoTable) searchRange(first, last net.IP) []iputils.RowRange {
+	if t.trie != nil {
+		return t.trie.SearchRange(first, last)
+	}
+	return t.ranges.SearchRange(first, last)
+}
+
+// len returns the number of blocks in the table.
+func (t *geoTable) len() int {
+	if t.trie != nil {
+		return t.trie.Len()
+	}
+	return t.ranges.Len()
+}
+
+// node returns the data of the row, without the IP bounds.
+func (t *geoTable) node(row int) GeoIPNode {
+	return GeoIPNode{
+		LocationIndex: int(t.location[row]),
+		PostalCode:    t.postal.Get(row),
+		Latitude:      t.latitude[row],
+		Longitude:     t.longitude[row],
+	}
+}
+
+// nodes returns a copy of the table as a node list.
+func (t *geoTable) nodes() []GeoIPNode {
+	nodes := make([]GeoIPNode, t.len())
+	for row := range nodes {
+		nodes[row] = t.node(row)
+		nodes[row].IPAddressLow, nodes[row].IPAddressHigh = t.bounds(row)
+	}
+	return nodes
+}
+
+// size returns the approximate memory used by the table, in bytes.
+func (t *geoTable) size() int64 {
+	size := t.ranges.Size()
+	if t.trie != nil {
+		size = t.trie.Size()
+	}
+	return size + t.postal.Size() + int64(4*len(t.location)+8*len(t.latitude)+8*len(t.longitude))
+}
+
+// NewGeoDataset creates a dataset from the IPv4 and IPv6 node lists, which
+// must be sorted, and the location list they refer to.
+func NewGeoDataset(ip4Nodes, ip6Nodes []GeoIPNode, locationNodes []LocationNode) *GeoDataset {
+	return &GeoDataset{
+		LocationNodes: locationNodes,
+		ip4:           newGeoTable(ip4Nodes),
+		ip6:           newGeoTable(ip6Nodes),
+	}
+}
+
+// NewGeoTrieDataset is like NewGeoDataset, but the node lists hold the
+// original prefixes of the blocks, which may be nested and in any order, and
+// are stored in tries.  Searches return the most specific block.
+func NewGeoTrieDataset(ip4Nodes, ip6Nodes []GeoIPNode, locationNodes []LocationNode) (*GeoDataset, error) {
+	ip4, err := newGeoTrieTable(ip4Nodes)
 	if err != nil {
 		return nil, err
 	}
-	dataset, err := DatasetFromZip(zip)
+	ip6, err := newGeoTrieTable(ip6Nodes)
 	if err != nil {
 		return nil, err
 	}
+	return &GeoDataset{LocationNodes: locationNodes, ip4: ip4, ip6: ip6}, nil
+}
+
+// Size returns the approximate memory used by the dataset, in bytes.
+func (ds *GeoDataset) Size() int64 {
+	size := ds.ip4.size() + ds.ip6.size()
+	for i := range ds.LocationNodes {
+		loc := &ds.LocationNodes[i]
+		size += int64(unsafe.Sizeof(*loc)) + int64(len(loc.ContinentCode)+len(loc.CountryCode)+len(loc.CountryName)+
+			len(loc.RegionCode)+len(loc.RegionName)+len(loc.Subdivision1ISOCode)+len(loc.Subdivision1Name)+
+			len(loc.Subdivision2ISOCode)+len(loc.Subdivision2Name)+len(loc.CityName))
+	}
+	return size
+}
+
+// NodeCount returns the number of IPv4 and IPv6 blocks in the dataset.
+func (ds *GeoDataset) NodeCount() int {
+	return ds.ip4.len() + ds.ip6.len()
+}
+
+// IP4Nodes returns a copy of the IPv4 node list.  It allocates the whole
+// list, so it is meant for tests and tools, not for lookups.
+func (ds *GeoDataset) IP4Nodes() []GeoIPNode {
+	return ds.ip4.nodes()
+}
+
+// IP6Nodes returns a copy of the IPv6 node list.  It allocates the whole
+// list, so it is meant for tests and tools, not for lookups.
+func (ds *GeoDataset) IP6Nodes() []GeoIPNode {
+	return ds.ip6.nodes()
+}
+
+// LoadG2 loads a dataset from an object in the dataset source.
+func LoadG2(src loader.Source, file *storage.ObjectAttrs) (api.Annotator, error) {
+	return LoadG2Dataset(src, file.Name)
+}
+
+// LoadG2Dataset loads the dataset from the specified filename in the dataset source.
+// If there is a snapshot of the dataset next to it, the snapshot is used instead.
+func LoadG2Dataset(src loader.Source, filename string) (*GeoDataset, error) {
+	dataset, err := loadSnapshot(src, filename)
+	if err != nil {
+		dataset, err = LoadG2Zip(src, filename)
+		if err != nil {
+			return nil, err
+		}
+	}
+	dataset.Name = filename
 	date, err := api.ExtractDateFromFilename(filename)
 	if err != nil {
 		log.Println("Error extracting date:", filename)
@@ -59,6 +246,17 @@ func LoadG2Dataset(filename string, bucketname string) (*GeoDataset, error) {
 	return dataset, nil
 }
 
+// LoadG2Zip loads the dataset from the zip file in the dataset source, ignoring
+// any snapshot.  The returned dataset has no start date set.
+func LoadG2Zip(src loader.Source, filename string) (*GeoDataset, error) {
+	zip, err := loader.CreateZipReader(context.Background(), src, filename)
+	log.Println("Loading dataset from", filename)
+	if err != nil {
+		return nil, err
+	}
+	return DatasetFromZip(zip)
+}
+
 // DatasetFromZip composes the location, IPv4, and IPv6 lists within a zipfile
 // containing those elements.  The returned dataset has no start date set.
 func DatasetFromZip(zip *zip.Reader) (*GeoDataset, error) {
@@ -73,25 +271,32 @@ func DatasetFromZip(zip *zip.Reader) (*GeoDataset, error) {
 		return nil, err
 	}
 
-	blocks4, err := loader.FindFile(geoLite2BlocksFilenameIP4, zip)
+	ipNodes4, err := loadBlocks(zip, geoLite2BlocksFilenameIP4, geoidMap)
 	if err != nil {
 		return nil, err
 	}
-	ipNodes4, err := LoadIPListG2(blocks4, geoidMap)
-	blocks4.Close()
+	ipNodes6, err := loadBlocks(zip, geoLite2BlocksFilenameIP6, geoidMap)
 	if err != nil {
 		return nil, err
 	}
-	blocks6, err := loader.FindFile(geoLite2BlocksFilenameIP6, zip)
-	if err != nil {
-		return nil, err
+	if LookupEngine == iputils.PrefixTrie {
+		return NewGeoTrieDataset(ipNodes4, ipNodes6, locationNode)
 	}
-	ipNodes6, err := LoadIPListG2(blocks6, geoidMap)
-	blocks6.Close()
+	return NewGeoDataset(ipNodes4, ipNodes6, locationNode), nil
+}
+
+// loadBlocks loads a blocks file from the zip, as a range list, or as a
+// prefix list for iputils.PrefixTrie.
+func loadBlocks(zip *zip.Reader, filename string, geoidMap map[int]int) ([]GeoIPNode, error) {
+	blocks, err := loader.FindFile(filename, zip)
 	if err != nil {
 		return nil, err
 	}
-	return &GeoDataset{IP4Nodes: ipNodes4, IP6Nodes: ipNodes6, LocationNodes: locationNode}, nil
+	defer blocks.Close()
+	if LookupEngine == iputils.PrefixTrie {
+		return LoadPrefixListG2(blocks, geoidMap)
+	}
+	return LoadIPListG2(blocks, geoidMap)
 }
 
 // ConvertIPNodeToGeoData takes a parser.IPNode, plus a list of
@@ -124,25 +329,30 @@ func populateLocationData(ipNode iputils.IPNode, locationNodes []LocationNode, d
 	}
 }
 
-// SearchBinary does a binary search for a list element.
-func (ds *GeoDataset) SearchBinary(ipLookup string) (p iputils.IPNode, e error) {
+// search finds the table and row of the block containing ipLookup.
+func (ds *GeoDataset) search(ipLookup string) (*geoTable, int, error) {
 	parsed, err := iputils.ParseIPWithMetrics(ipLookup)
 	if err != nil {
-		return p, err
+		return nil, 0, err
 	}
 
-	ipNodes := ds.IP6Nodes
+	t := &ds.ip6
 	if parsed.To4() != nil {
-		ipNodes = ds.IP4Nodes
+		t = &ds.ip4
 	}
+	row, err := t.search(parsed)
+	return t, row, err
+}
 
-	node, err := iputils.SearchBinary(parsed,
-		len(ipNodes),
-		func(idx int) iputils.IPNode {
-			return &ipNodes[idx]
-		})
-
-	return node, err
+// SearchBinary does a binary search for the block containing ipLookup.
+func (ds *GeoDataset) SearchBinary(ipLookup string) (p iputils.IPNode, e error) {
+	t, row, err := ds.search(ipLookup)
+	if err != nil {
+		return p, err
+	}
+	node := t.node(row)
+	node.IPAddressLow, node.IPAddressHigh = t.bounds(row)
+	return &node, nil
 }
 
 var lastLogTime = time.Time{}
@@ -150,13 +360,13 @@ var lastLogTime = time.Time{}
 // Annotate annotates the api.GeoData with the location informations
 func (ds *GeoDataset) Annotate(ip string, data *api.GeoData) error {
 	if data == nil {
-		return errors.New("ErrNilGeoData") // TODO
+		return ErrNilGeoData
 	}
 	if data.Geo != nil {
-		return errors.New("ErrAlreadyPopulated") // TODO
+		return ErrAlreadyPopulated
 	}
 
-	node, err := ds.SearchBinary(ip)
+	t, row, err := ds.search(ip)
 
 	if err != nil {
 		// ErrNodeNotFound is super spammy - 10% of requests, so suppress those.
@@ -171,12 +381,38 @@ func (ds *GeoDataset) Annotate(ip string, data *api.GeoData) error {
 		return err
 	}
 
-	populateLocationData(node, ds.LocationNodes, data)
+	// The bounds are not needed, so don't allocate them.
+	node := t.node(row)
+	populateLocationData(&node, ds.LocationNodes, data)
 	return nil
 }
 
+// AnnotateRange returns the location of each block overlapping the prefix,
+// clipped to it.  See api.RangeAnnotator.
+func (ds *GeoDataset) AnnotateRange(prefix *net.IPNet) ([]api.RangeAnnotations, error) {
+	first, last := iputils.PrefixBounds(prefix)
+	// As in search, addresses in ::ffff:0:0/96 are in the IPv4 table.
+	t := &ds.ip6
+	if first.To4() != nil && last.To4() != nil {
+		t = &ds.ip4
+	}
+	ranges := t.searchRange(first, last)
+	result := make([]api.RangeAnnotations, len(ranges))
+	for i := range ranges {
+		node := t.node(ranges[i].Row)
+		populateLocationData(&node, ds.LocationNodes, &result[i].Annotations)
+		result[i].First, result[i].Last = ranges[i].First, ranges[i].Last
+	}
+	return result, nil
+}
+
 // AnnotatorDate returns the date that the dataset was published.
 // TODO implement actual dataset time!!
 func (ds *GeoDataset) AnnotatorDate() time.Time {
 	return ds.Start
 }
+
+// Provenance returns the name and date of the dataset.
+func (ds *GeoDataset) Provenance() []api.Provenance {
+	return []api.Provenance{{Type: api.GeoLite2Type, Name: ds.Name, Date: ds.Start}}
+}
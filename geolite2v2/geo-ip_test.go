@@ -3,9 +3,11 @@ package geolite2v2_test
 // TODO - migrate these tests to geolite2v2 before removing geolite2 package
 
 import (
+	"archive/zip"
 	"bytes"
 	"encoding/binary"
 	"errors"
+	"io/ioutil"
 	"log"
 	"math/rand"
 	"net"
@@ -99,7 +101,7 @@ func searchList(list []geolite2v2.GeoIPNode, ipLookUp string) (iputils.IPNode, e
 func randomValidIPv6(ann api.Annotator) (int, net.IP) {
 	switch v := ann.(type) {
 	case *geolite2v2.GeoDataset:
-		gl2ipv6 := v.IP6Nodes
+		gl2ipv6 := v.IP6Nodes()
 		i := rand.Intn(len(gl2ipv6))
 		ipMiddle := findMiddle(gl2ipv6[i].IPAddressLow, gl2ipv6[i].IPAddressHigh)
 		return i, ipMiddle
@@ -111,7 +113,7 @@ func randomValidIPv6(ann api.Annotator) (int, net.IP) {
 func randomValidIPv4(ann api.Annotator) (int, net.IP) {
 	switch v := ann.(type) {
 	case *geolite2v2.GeoDataset:
-		gl2ipv4 := v.IP4Nodes
+		gl2ipv4 := v.IP4Nodes()
 		i := rand.Intn(len(gl2ipv4))
 		ipMiddle := findMiddle(gl2ipv4[i].IPAddressLow, gl2ipv4[i].IPAddressHigh)
 		return i, ipMiddle
@@ -134,7 +136,7 @@ func TestGeoLite2SearchBinaryV6(t *testing.T) {
 
 	v6errMatch := 0
 	v6ipMatch := 0
-	gl2ipv6 := annotator.IP6Nodes
+	gl2ipv6 := annotator.IP6Nodes()
 	for i := 0; i < 10000; i++ {
 		idx, v6 := randomValidIPv6(annotator)
 		ipBin, errBin := annotator.SearchBinary(v6.String())
@@ -173,7 +175,7 @@ func TestGeoLite2SearchBinaryV4(t *testing.T) {
 	}
 
 	// Test IPv4
-	gl2ipv4 := annotator.IP4Nodes
+	gl2ipv4 := annotator.IP4Nodes()
 	v4errMatch := 0
 	v4ipMatch := 0
 	for i := 0; i < 10000; i++ {
@@ -241,13 +243,115 @@ func BenchmarkGeoLite2ipv4(b *testing.B) {
 		return
 	}
 
-	b.ResetTimer()
+	benchmarkSearch(b, annotator)
+}
 
-	gl2ipv4 := annotator.IP4Nodes
+// BenchmarkGeoLite2ipv4Trie is BenchmarkGeoLite2ipv4 with the dataset loaded
+// into prefix tries, to compare the lookup engines.
+func BenchmarkGeoLite2ipv4Trie(b *testing.B) {
+	geolite2v2.LookupEngine = iputils.PrefixTrie
+	defer func() { geolite2v2.LookupEngine = iputils.RangeList }()
+	geoloader.UpdateGeoliteDatePattern("2017/09/07")
+	g2loader := geoloader.Geolite2Loader(geolite2v2.LoadG2)
+	if err := g2loader.UpdateCache(); err != nil || len(g2loader.Fetch()) == 0 {
+		log.Println("Skipping benchmark, the dataset is not available:", err)
+		return
+	}
+	benchmarkSearch(b, g2loader.Fetch()[0].(*geolite2v2.GeoDataset))
+}
+
+// benchmarkSearch searches the dataset for addresses in its IPv4 blocks.
+func benchmarkSearch(b *testing.B, ds *geolite2v2.GeoDataset) {
+	gl2ipv4 := ds.IP4Nodes()
+	b.ResetTimer()
 	for n := 0; n < b.N; n++ {
 		i := rand.Intn(len(gl2ipv4))
 		ipMiddle := findMiddle(gl2ipv4[i].IPAddressLow, gl2ipv4[i].IPAddressHigh)
-		_, _ = annotator.SearchBinary(ipMiddle.String())
+		_, _ = ds.SearchBinary(ipMiddle.String())
+	}
+}
+
+func TestDatasetFromZipTrie(t *testing.T) {
+	reader, err := zip.OpenReader("testdata/GeoLite2City.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	ranges, err := geolite2v2.DatasetFromZip(&reader.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	geolite2v2.LookupEngine = iputils.PrefixTrie
+	defer func() { geolite2v2.LookupEngine = iputils.RangeList }()
+	trie, err := geolite2v2.DatasetFromZip(&reader.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Both engines must annotate every address of every flattened range the
+	// same way.
+	nodes := append(ranges.IP4Nodes(), ranges.IP6Nodes()...)
+	if len(nodes) == 0 {
+		t.Fatal("no nodes")
+	}
+	for _, n := range nodes {
+		for _, ip := range []net.IP{n.IPAddressLow, n.IPAddressHigh} {
+			want, got := api.GeoData{}, api.GeoData{}
+			wantErr := ranges.Annotate(ip.String(), &want)
+			gotErr := trie.Annotate(ip.String(), &got)
+			if gotErr != wantErr {
+				t.Errorf("Annotate(%s) error = %v, want %v", ip, gotErr, wantErr)
+			}
+			if diff := deep.Equal(got, want); diff != nil {
+				t.Error(ip, diff)
+			}
+		}
+	}
+	if err := trie.WriteSnapshot(ioutil.Discard); err != geolite2v2.ErrTrieSnapshot {
+		t.Error("WriteSnapshot() error =", err)
+	}
+}
+
+func TestAnnotateRange(t *testing.T) {
+	reader, err := zip.OpenReader("testdata/GeoLite2City.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	defer func() { geolite2v2.LookupEngine = iputils.RangeList }()
+	for _, engine := range []iputils.Engine{iputils.RangeList, iputils.PrefixTrie} {
+		geolite2v2.LookupEngine = engine
+		ds, err := geolite2v2.DatasetFromZip(&reader.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		nodes := append(ds.IP4Nodes(), ds.IP6Nodes()...)
+		for i := 0; i < len(nodes); i += len(nodes)/10 + 1 {
+			bits := 128
+			if nodes[i].IPAddressLow.To4() != nil {
+				bits = 32
+			}
+			prefix := &net.IPNet{IP: nodes[i].IPAddressLow, Mask: net.CIDRMask(bits*3/4, bits)}
+			ranges, err := ds.AnnotateRange(prefix)
+			if err != nil || len(ranges) == 0 {
+				t.Fatal(engine, prefix, ranges, err)
+			}
+			// Each range is in the prefix, and annotated like its addresses.
+			for _, r := range ranges {
+				for _, ip := range []net.IP{r.First, r.Last} {
+					if !prefix.Contains(ip) {
+						t.Errorf("%s: %s is not in %s", engine, ip, prefix)
+					}
+					want := api.GeoData{}
+					if err := ds.Annotate(ip.String(), &want); err != nil {
+						t.Error(engine, ip, err)
+					}
+					if diff := deep.Equal(r.Geo, want.Geo); diff != nil {
+						t.Error(engine, ip, diff)
+					}
+				}
+			}
+		}
 	}
 }
 
@@ -0,0 +1,168 @@
+package geolite2v2
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io/ioutil"
+	"log"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/oschwald/maxminddb-golang"
+
+	"github.com/m-lab/annotation-service/api"
+	"github.com/m-lab/annotation-service/iputils"
+	"github.com/m-lab/annotation-service/loader"
+)
+
+// mmdbRecord holds the subset of the GeoLite2-City MMDB record that is used to
+// populate api.GeolocationIP.
+type mmdbRecord struct {
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+	Continent struct {
+		Code string `maxminddb:"code"`
+	} `maxminddb:"continent"`
+	Country struct {
+		IsoCode string            `maxminddb:"iso_code"`
+		Names   map[string]string `maxminddb:"names"`
+	} `maxminddb:"country"`
+	Location struct {
+		AccuracyRadius uint16  `maxminddb:"accuracy_radius"`
+		Latitude       float64 `maxminddb:"latitude"`
+		Longitude      float64 `maxminddb:"longitude"`
+		MetroCode      uint    `maxminddb:"metro_code"`
+	} `maxminddb:"location"`
+	Postal struct {
+		Code string `maxminddb:"code"`
+	} `maxminddb:"postal"`
+	Subdivisions []struct {
+		IsoCode string            `maxminddb:"iso_code"`
+		Names   map[string]string `maxminddb:"names"`
+	} `maxminddb:"subdivisions"`
+}
+
+// MMDBDataset wraps a GeoLite2-City database in the MaxMind MMDB format.
+// It implements the api.Annotator interface.
+type MMDBDataset struct {
+	Start  time.Time // Date from which to start using this dataset
+	Name   string    // The object the dataset was loaded from
+	reader *maxminddb.Reader
+	size   int64 // The size of the MMDB data
+}
+
+// LoadMMDB loads an MMDB dataset from an object in the dataset source.
+// Objects with a .gz suffix are decompressed in memory.
+func LoadMMDB(src loader.Source, file *storage.ObjectAttrs) (api.Annotator, error) {
+	log.Println("Loading dataset from", file.Name)
+	rdr, err := src.NewReader(context.Background(), file.Name)
+	if err != nil {
+		return nil, err
+	}
+	defer rdr.Close()
+	data, err := ioutil.ReadAll(rdr)
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasSuffix(file.Name, ".gz") {
+		gzr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		data, err = ioutil.ReadAll(gzr)
+		if err != nil {
+			return nil, err
+		}
+	}
+	dataset, err := DatasetFromMMDB(data)
+	if err != nil {
+		return nil, err
+	}
+	dataset.Name = file.Name
+	date, err := api.ExtractDateFromFilename(file.Name)
+	if err != nil {
+		log.Println("Error extracting date:", file.Name)
+	} else {
+		dataset.Start = date
+	}
+	return dataset, nil
+}
+
+// DatasetFromMMDB creates an MMDBDataset from the content of an MMDB file.
+// The returned dataset has no start date set.
+func DatasetFromMMDB(data []byte) (*MMDBDataset, error) {
+	reader, err := maxminddb.FromBytes(data)
+	if err != nil {
+		return nil, err
+	}
+	return &MMDBDataset{reader: reader, size: int64(len(data))}, nil
+}
+
+// Annotate annotates the api.GeoData with the location informations
+func (ds *MMDBDataset) Annotate(ip string, data *api.GeoData) error {
+	if data == nil {
+		return ErrNilGeoData
+	}
+	if data.Geo != nil {
+		return ErrAlreadyPopulated
+	}
+	parsed, err := iputils.ParseIPWithMetrics(ip)
+	if err != nil {
+		return err
+	}
+
+	record := mmdbRecord{}
+	_, ok, err := ds.reader.LookupNetwork(parsed, &record)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return iputils.ErrNodeNotFound
+	}
+
+	data.Geo = &api.GeolocationIP{
+		ContinentCode:    record.Continent.Code,
+		CountryCode:      record.Country.IsoCode,
+		CountryName:      record.Country.Names["en"],
+		MetroCode:        int64(record.Location.MetroCode),
+		City:             record.City.Names["en"],
+		PostalCode:       record.Postal.Code,
+		Latitude:         record.Location.Latitude,
+		Longitude:        record.Location.Longitude,
+		AccuracyRadiusKm: int64(record.Location.AccuracyRadius),
+	}
+	if len(record.Subdivisions) > 0 {
+		// TODO: remove Region once the parser has been updated.
+		data.Geo.Region = record.Subdivisions[0].IsoCode
+		data.Geo.Subdivision1ISOCode = record.Subdivisions[0].IsoCode
+		data.Geo.Subdivision1Name = record.Subdivisions[0].Names["en"]
+	}
+	if len(record.Subdivisions) > 1 {
+		data.Geo.Subdivision2ISOCode = record.Subdivisions[1].IsoCode
+		data.Geo.Subdivision2Name = record.Subdivisions[1].Names["en"]
+	}
+	return nil
+}
+
+// AnnotatorDate returns the date that the dataset was published.
+func (ds *MMDBDataset) AnnotatorDate() time.Time {
+	return ds.Start
+}
+
+// Provenance returns the name and date of the dataset.
+func (ds *MMDBDataset) Provenance() []api.Provenance {
+	return []api.Provenance{{Type: api.GeoLite2Type, Name: ds.Name, Date: ds.Start}}
+}
+
+// Size returns the size of the MMDB data, which is held in memory.
+func (ds *MMDBDataset) Size() int64 {
+	return ds.size
+}
+
+// NodeCount returns the number of nodes in the MMDB search tree.
+func (ds *MMDBDataset) NodeCount() int {
+	return int(ds.reader.Metadata.NodeCount)
+}
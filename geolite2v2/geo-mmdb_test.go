@@ -0,0 +1,115 @@
+package geolite2v2_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/go-test/deep"
+
+	"github.com/m-lab/annotation-service/api"
+	"github.com/m-lab/annotation-service/geolite2v2"
+	"github.com/m-lab/annotation-service/iputils"
+	"github.com/m-lab/annotation-service/loader"
+)
+
+func TestMMDBDataset_Annotate(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/GeoLite2-City.mmdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ds, err := geolite2v2.DatasetFromMMDB(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		ip      string
+		want    *api.GeolocationIP
+		wantErr error
+	}{
+		{
+			ip: "1.9.130.1",
+			want: &api.GeolocationIP{
+				ContinentCode: "AS", CountryCode: "MY", CountryName: "Malaysia",
+				Region: "14", Subdivision1ISOCode: "14", Subdivision1Name: "Kuala Lumpur",
+				City: "Kuala Lumpur", PostalCode: "50586", Latitude: 3.1667, Longitude: 101.7,
+				AccuracyRadiusKm: 50,
+			},
+		},
+		{
+			ip: "8.8.8.8",
+			want: &api.GeolocationIP{
+				ContinentCode: "NA", CountryCode: "US", CountryName: "United States",
+				Latitude: 37.751, Longitude: -97.822, AccuracyRadiusKm: 1000,
+			},
+		},
+		{
+			ip: "2001:5a0:4300::1",
+			want: &api.GeolocationIP{
+				ContinentCode: "NA", CountryCode: "US", CountryName: "United States",
+				Region: "NY", Subdivision1ISOCode: "NY", Subdivision1Name: "New York",
+				Subdivision2ISOCode: "NYC", Subdivision2Name: "New York City",
+				MetroCode: 501, City: "New York", PostalCode: "10001",
+				Latitude: 40.7143, Longitude: -74.006, AccuracyRadiusKm: 10,
+			},
+		},
+		{ip: "9.9.9.9", wantErr: iputils.ErrNodeNotFound},
+		{ip: "garbage", wantErr: iputils.ErrInvalidIP},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			ann := api.GeoData{}
+			err := ds.Annotate(tt.ip, &ann)
+			if err != tt.wantErr {
+				t.Fatalf("Annotate() error = %v, want %v", err, tt.wantErr)
+			}
+			if diff := deep.Equal(ann.Geo, tt.want); diff != nil {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+func TestLoadMMDB(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/GeoLite2-City.mmdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "mmdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	buf := bytes.Buffer{}
+	gz := gzip.NewWriter(&buf)
+	gz.Write(data)
+	gz.Close()
+	name := "Maxmind/2020/01/07/20200107T000000Z-GeoLite2-City.mmdb.gz"
+	path := filepath.Join(dir, filepath.FromSlash(name))
+	os.MkdirAll(filepath.Dir(path), 0755)
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ann, err := geolite2v2.LoadMMDB(loader.NewDirSource(dir), &storage.ObjectAttrs{Name: name})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ann.AnnotatorDate().Equal(time.Date(2020, 1, 7, 0, 0, 0, 0, time.UTC)) {
+		t.Error("Wrong date", ann.AnnotatorDate())
+	}
+	if p := ann.Provenance(); len(p) != 1 || p[0].Type != api.GeoLite2Type || p[0].Name != name {
+		t.Error("Wrong provenance", p)
+	}
+	geo := api.GeoData{}
+	if err := ann.Annotate("1.22.64.1", &geo); err != nil || geo.Geo.City != "Faridabad" {
+		t.Errorf("Annotate() = %+v, %v", geo.Geo, err)
+	}
+}
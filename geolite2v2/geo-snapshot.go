@@ -0,0 +1,130 @@
+package geolite2v2
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+
+	"github.com/m-lab/annotation-service/iputils"
+	"github.com/m-lab/annotation-service/loader"
+	"github.com/m-lab/annotation-service/rangetable"
+	"github.com/m-lab/annotation-service/snapshot"
+)
+
+// ErrTrieSnapshot is returned when writing a snapshot of a dataset that uses
+// tries.  Snapshots can only hold range lists.
+var ErrTrieSnapshot = errors.New("Snapshots cannot hold prefix tries")
+
+// WriteSnapshot writes the location table and the IPv4 and IPv6 block tables
+// to w in the snapshot format.  The start date is not included.
+func (ds *GeoDataset) WriteSnapshot(w io.Writer) error {
+	if ds.ip4.trie != nil || ds.ip6.trie != nil {
+		return ErrTrieSnapshot
+	}
+	e := snapshot.NewEncoder(w, snapshot.KindGeoLite2)
+	e.Uvarint(uint64(len(ds.LocationNodes)))
+	for i := range ds.LocationNodes {
+		loc := &ds.LocationNodes[i]
+		e.Varint(int64(loc.GeonameID))
+		e.Text(loc.ContinentCode)
+		e.Text(loc.CountryCode)
+		e.Text(loc.CountryName)
+		e.Text(loc.RegionCode)
+		e.Text(loc.RegionName)
+		e.Text(loc.Subdivision1ISOCode)
+		e.Text(loc.Subdivision1Name)
+		e.Text(loc.Subdivision2ISOCode)
+		e.Text(loc.Subdivision2Name)
+		e.Varint(loc.MetroCode)
+		e.Text(loc.CityName)
+		e.Varint(loc.AccuracyRadiusKm)
+	}
+	for _, t := range []*geoTable{&ds.ip4, &ds.ip6} {
+		t.ranges.Encode(e)
+		e.Int32s(t.location)
+		t.postal.Encode(e)
+		e.Float64s(t.latitude)
+		e.Float64s(t.longitude)
+	}
+	return e.Close()
+}
+
+// ReadSnapshot reads a GeoDataset written by WriteSnapshot.  The returned
+// dataset has no start date set.
+func ReadSnapshot(r io.Reader) (*GeoDataset, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return decodeSnapshot(data)
+}
+
+// decodeSnapshot decodes a GeoDataset snapshot.  The block tables use data
+// in place when possible.
+func decodeSnapshot(data []byte) (*GeoDataset, error) {
+	d, err := snapshot.NewDecoder(data, snapshot.KindGeoLite2)
+	if err != nil {
+		return nil, err
+	}
+	ds := &GeoDataset{}
+	ds.LocationNodes = make([]LocationNode, d.Count())
+	for i := range ds.LocationNodes {
+		loc := &ds.LocationNodes[i]
+		loc.GeonameID = int(d.Varint())
+		loc.ContinentCode = d.Text()
+		loc.CountryCode = d.Text()
+		loc.CountryName = d.Text()
+		loc.RegionCode = d.Text()
+		loc.RegionName = d.Text()
+		loc.Subdivision1ISOCode = d.Text()
+		loc.Subdivision1Name = d.Text()
+		loc.Subdivision2ISOCode = d.Text()
+		loc.Subdivision2Name = d.Text()
+		loc.MetroCode = d.Varint()
+		loc.CityName = d.Text()
+		loc.AccuracyRadiusKm = d.Varint()
+	}
+	for _, t := range []*geoTable{&ds.ip4, &ds.ip6} {
+		t.ranges = rangetable.DecodeTable(d)
+		t.location = d.Int32s()
+		t.postal = rangetable.DecodeStrings(d)
+		t.latitude = d.Float64s()
+		t.longitude = d.Float64s()
+		n := t.ranges.Len()
+		if len(t.location) != n || t.postal.Len() != n || len(t.latitude) != n || len(t.longitude) != n {
+			d.Fail(snapshot.ErrCorrupt)
+		}
+		for _, loc := range t.location {
+			if int(loc) >= len(ds.LocationNodes) {
+				d.Fail(snapshot.ErrCorrupt)
+				break
+			}
+		}
+	}
+	if err := d.Close(); err != nil {
+		return nil, err
+	}
+	return ds, nil
+}
+
+// loadSnapshot loads the snapshot for filename from the dataset source, if
+// there is one.  The snapshot is memory mapped when possible.
+func loadSnapshot(src loader.Source, filename string) (*GeoDataset, error) {
+	if LookupEngine == iputils.PrefixTrie {
+		return nil, ErrTrieSnapshot
+	}
+	m, err := snapshot.Load(context.Background(), src, filename)
+	if err != nil {
+		return nil, err
+	}
+	ds, err := decodeSnapshot(m.Bytes())
+	if err != nil {
+		log.Println("Error reading snapshot for", filename, err)
+		return nil, err
+	}
+	ds.snap = m
+	log.Println("Loaded dataset snapshot for", filename)
+	return ds, nil
+}
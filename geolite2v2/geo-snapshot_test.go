@@ -0,0 +1,89 @@
+package geolite2v2_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-test/deep"
+
+	"github.com/m-lab/annotation-service/api"
+	"github.com/m-lab/annotation-service/geolite2v2"
+	"github.com/m-lab/annotation-service/loader"
+	"github.com/m-lab/annotation-service/snapshot"
+)
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	reader, err := zip.OpenReader("testdata/GeoLite2City.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	want, err := geolite2v2.DatasetFromZip(&reader.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := bytes.Buffer{}
+	if err := want.WriteSnapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	got, err := geolite2v2.ReadSnapshot(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := deep.Equal(got.LocationNodes, want.LocationNodes); diff != nil {
+		t.Error(diff)
+	}
+	if diff := deep.Equal(got.IP4Nodes(), want.IP4Nodes()); diff != nil {
+		t.Error(diff)
+	}
+	if diff := deep.Equal(got.IP6Nodes(), want.IP6Nodes()); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestLoadG2DatasetPrefersSnapshot(t *testing.T) {
+	reader, err := zip.OpenReader("testdata/GeoLite2City.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	ds, err := geolite2v2.DatasetFromZip(&reader.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the snapshot exists, so loading must not touch the raw zip.
+	dir := t.TempDir()
+	name := "Maxmind/2018/03/08/20180308T000000Z-GeoLite2-City-CSV.zip"
+	path := filepath.Join(dir, filepath.FromSlash(snapshot.Name(name)))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ds.WriteSnapshot(f); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	got, err := geolite2v2.LoadG2Dataset(loader.NewDirSource(dir), name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Start.Format("20060102") != "20180308" {
+		t.Error("wrong start date", got.Start)
+	}
+	data := api.GeoData{}
+	if err := got.Annotate("1.0.0.1", &data); err != nil {
+		t.Fatal(err)
+	}
+	if data.Geo.PostalCode != "3095" {
+		t.Errorf("Annotate() = %+v", data.Geo)
+	}
+}
@@ -43,43 +43,30 @@ func UpdateASNDatePattern(ym string) {
 	log.Printf("Date filter is set to %s", ym)
 }
 
-// asnFilterFrom returns nil if a file object's name matches the regular expression, and has a date field <= fileTime.
-func asnFilterFrom(file *storage.ObjectAttrs, r *regexp.Regexp, from time.Time) error {
-	baseFilename := loader.GetGzBase(file.Name)
-	fileTime, err := asn.ExtractTimeFromASNFileName(baseFilename)
+// routeViewDate returns the date of a RouteView dataset, from its filename.
+func routeViewDate(name string) (time.Time, error) {
+	t, err := asn.ExtractTimeFromASNFileName(loader.GetGzBase(name))
 	if err != nil {
-		return err
+		return time.Time{}, err
 	}
-
-	if from.After(*fileTime) {
-		return errNeededLoadingDate
-	}
-
-	if !r.MatchString(file.Name) {
-		return errNoMatch
-	}
-
-	return nil
+	return *t, nil
 }
 
 // ASNv4Loader should be used to load ASNv4 RouteView files
 func ASNv4Loader(
-	loader func(*storage.ObjectAttrs) (api.Annotator, error)) api.CachingLoader {
-	return newCachingLoader(
-		func(file *storage.ObjectAttrs) error {
-			return asnFilterFrom(file, asnRegexV4, asnV4StartTime)
-		},
-		loader,
-		routeViewPrefix)
+	loader func(loader.Source, *storage.ObjectAttrs) (api.Annotator, error)) api.CachingLoader {
+	return mustSourceLoader(SourceConfig{Name: "routeviews-v4", Type: RouteViewsSource, Family: IPv4}, loader)
 }
 
 // ASNv6Loader should be used to load ASNv6 RouteView files
 func ASNv6Loader(
-	loader func(*storage.ObjectAttrs) (api.Annotator, error)) api.CachingLoader {
-	return newCachingLoader(
-		func(file *storage.ObjectAttrs) error {
-			return asnFilterFrom(file, asnRegexV6, asnV6StartTime)
-		},
-		loader,
-		routeViewPrefix)
+	loader func(loader.Source, *storage.ObjectAttrs) (api.Annotator, error)) api.CachingLoader {
+	return mustSourceLoader(SourceConfig{Name: "routeviews-v6", Type: RouteViewsSource, Family: IPv6}, loader)
+}
+
+// GeoLite2ASNLoader should be used to load GeoLite2-ASN CSV files.  Each file
+// contains both IPv4 and IPv6 prefixes.
+func GeoLite2ASNLoader(
+	loader func(loader.Source, *storage.ObjectAttrs) (api.Annotator, error)) api.CachingLoader {
+	return mustSourceLoader(SourceConfig{Name: "geolite2-asn", Type: GeoLite2ASNSource}, loader)
 }
@@ -0,0 +1,208 @@
+package geoloader
+
+import (
+	"errors"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/m-lab/annotation-service/api"
+	"github.com/m-lab/annotation-service/metrics"
+)
+
+var (
+	// MinRetryDelay is the time before a dataset that failed to load is tried
+	// again.  The delay doubles after each failed attempt, up to MaxRetryDelay.
+	MinRetryDelay = 10 * time.Minute
+	// MaxRetryDelay is the longest delay between two attempts to load a dataset.
+	MaxRetryDelay = 7 * 24 * time.Hour
+)
+
+// LoadStatus describes a dataset load that is in progress, or that failed.
+type LoadStatus struct {
+	api.Provenance           // The dataset, as the loaded annotator would report it
+	Since          time.Time // When the load started, or the last attempt failed
+	Err            error     // The load error, or nil while the load is in progress
+	Source         string    // The loader of the dataset, e.g. "geolite2"
+	Attempts       int       // The number of failed attempts
+	NextAttempt    time.Time // When the dataset may be loaded again
+	Quarantined    bool      // True if the dataset is corrupt, and is not loaded again
+}
+
+// nextAttempt returns when the failed dataset may be loaded again.  It is
+// computed from the current retry delays, so that they may be changed while
+// datasets are failing.
+func (s *LoadStatus) nextAttempt() time.Time {
+	return s.Since.Add(retryDelay(s.Attempts))
+}
+
+// failureRegistry records the datasets of a cachingLoader that failed to
+// load, so they are tried again with exponential backoff, and corrupt ones
+// are never tried again.  Datasets are removed when they are loaded.
+type failureRegistry struct {
+	source string // The metrics label of the loader, e.g. "geolite2"
+
+	lock     sync.Mutex
+	failures map[Filename]*LoadStatus
+}
+
+var (
+	// registriesLock must be held when accessing registries or pendingLoads.
+	registriesLock sync.Mutex
+	// registries holds the failure registries of all the loaders.
+	registries []*failureRegistry
+	// pendingLoads holds the loads in progress.
+	pendingLoads = map[Filename]LoadStatus{}
+)
+
+// newFailureRegistry creates a registry for the loader with the source label,
+// and adds it to the registries reported by Loads.
+func newFailureRegistry(source string) *failureRegistry {
+	r := &failureRegistry{source: source, failures: map[Filename]*LoadStatus{}}
+	registriesLock.Lock()
+	defer registriesLock.Unlock()
+	registries = append(registries, r)
+	return r
+}
+
+// Loads returns the dataset loads in progress, and the datasets that failed
+// to load, including the quarantined ones, sorted by name.
+func Loads() (pending []LoadStatus, failed []LoadStatus) {
+	registriesLock.Lock()
+	for _, s := range pendingLoads {
+		pending = append(pending, s)
+	}
+	all := append([]*failureRegistry(nil), registries...)
+	registriesLock.Unlock()
+	for _, r := range all {
+		failed = append(failed, r.statuses()...)
+	}
+	sort.Slice(pending, func(i, j int) bool { return pending[i].Name < pending[j].Name })
+	sort.Slice(failed, func(i, j int) bool { return failed[i].Name < failed[j].Name })
+	return pending, failed
+}
+
+// retryDelay returns the delay before the next attempt, after the given
+// number of failed attempts.
+func retryDelay(attempts int) time.Duration {
+	delay := MinRetryDelay
+	for i := 1; i < attempts && delay < MaxRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > MaxRetryDelay {
+		delay = MaxRetryDelay
+	}
+	return delay
+}
+
+// load loads the dataset prov with f, unless it is quarantined or its last
+// failure is too recent, in which case the error of its last attempt is
+// returned.  Failed attempts are retried once right away, unless the dataset
+// is corrupt, and the result is recorded in the registry.
+func (r *failureRegistry) load(prov api.Provenance, f func() (api.Annotator, error)) (api.Annotator, error) {
+	filename := Filename(prov.Name)
+	if err := r.ready(filename, time.Now()); err != nil {
+		return nil, err
+	}
+	setPending(prov, true)
+	defer setPending(prov, false)
+	ann, err := f()
+	if err != nil && !errors.Is(err, api.ErrCorrupt) {
+		log.Println("Retrying", filename, "after", err)
+		ann, err = f()
+	}
+	r.record(prov, err)
+	return ann, err
+}
+
+// ready returns nil if the dataset may be loaded now, or the error of its
+// last attempt otherwise.
+func (r *failureRegistry) ready(filename Filename, now time.Time) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	s, ok := r.failures[filename]
+	if !ok || (!s.Quarantined && !now.Before(s.nextAttempt())) {
+		return nil
+	}
+	return s.Err
+}
+
+// record records the result of an attempt to load the dataset.
+func (r *failureRegistry) record(prov api.Provenance, err error) {
+	filename := Filename(prov.Name)
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	s, ok := r.failures[filename]
+	if err == nil {
+		if ok {
+			r.remove(filename)
+		}
+		return
+	}
+	if !ok {
+		s = &LoadStatus{Provenance: prov, Source: r.source}
+		r.failures[filename] = s
+		metrics.FailingDatasets.WithLabelValues(r.source).Inc()
+	}
+	metrics.LoadFailures.WithLabelValues(r.source).Inc()
+	s.Err = err
+	s.Attempts++
+	s.Since = time.Now()
+	if errors.Is(err, api.ErrCorrupt) && !s.Quarantined {
+		log.Println("Quarantining corrupt dataset", filename, "after", err)
+		s.Quarantined = true
+		metrics.FailingDatasets.WithLabelValues(r.source).Dec()
+		metrics.QuarantinedDatasets.WithLabelValues(r.source).Inc()
+		return
+	}
+	log.Println("Failed trying to load", filename, "with", err, "attempts:", s.Attempts, "next attempt:", s.nextAttempt())
+}
+
+// retain drops the datasets that are no longer in the source, i.e. not in
+// names, from the registry.
+func (r *failureRegistry) retain(names map[Filename]bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for filename := range r.failures {
+		if !names[filename] {
+			r.remove(filename)
+		}
+	}
+}
+
+// remove drops a dataset from the registry.  r.lock must be held.
+func (r *failureRegistry) remove(filename Filename) {
+	if r.failures[filename].Quarantined {
+		metrics.QuarantinedDatasets.WithLabelValues(r.source).Dec()
+	} else {
+		metrics.FailingDatasets.WithLabelValues(r.source).Dec()
+	}
+	delete(r.failures, filename)
+}
+
+// statuses returns the datasets of the registry.
+func (r *failureRegistry) statuses() []LoadStatus {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	result := make([]LoadStatus, 0, len(r.failures))
+	for _, s := range r.failures {
+		status := *s
+		if !s.Quarantined {
+			status.NextAttempt = s.nextAttempt()
+		}
+		result = append(result, status)
+	}
+	return result
+}
+
+// setPending records the start of the load of prov, or its end.
+func setPending(prov api.Provenance, pending bool) {
+	registriesLock.Lock()
+	defer registriesLock.Unlock()
+	if pending {
+		pendingLoads[Filename(prov.Name)] = LoadStatus{Provenance: prov, Since: time.Now()}
+	} else {
+		delete(pendingLoads, Filename(prov.Name))
+	}
+}
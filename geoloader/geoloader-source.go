@@ -0,0 +1,198 @@
+package geoloader
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/m-lab/annotation-service/api"
+	"github.com/m-lab/annotation-service/loader"
+)
+
+// Source types, for the Type of a SourceConfig.
+const (
+	LegacySource       = "legacy"        // GeoLiteCity .dat files, one per address family
+	GeoLite2Source     = "geolite2"      // GeoLite2-City CSV zips
+	GeoLite2MMDBSource = "geolite2-mmdb" // GeoLite2-City MMDB files
+	RouteViewsSource   = "routeviews"    // RouteViews pfx2as files, one per address family
+	GeoLite2ASNSource  = "geolite2-asn"  // GeoLite2-ASN CSV zips
+)
+
+// Address families, for the Family of a SourceConfig.
+const (
+	IPv4 = "v4"
+	IPv6 = "v6"
+)
+
+// DateFormat is the format of the From and Until dates of a SourceConfig.
+const DateFormat = "2006-01-02"
+
+var (
+	// ErrBadSource is returned for invalid source configurations.
+	ErrBadSource = errors.New("Invalid dataset source")
+)
+
+// SourceConfig declares a set of datasets to load from the dataset source.
+type SourceConfig struct {
+	// Name identifies the source in logs, metrics and the status page, e.g.
+	// "legacy-v4".
+	Name string `json:"name"`
+	// Type is the type of the datasets, e.g. GeoLite2Source.
+	Type string `json:"type"`
+	// Family is the address family of the datasets, IPv4 or IPv6, for the
+	// types that have one file per family.  It must be empty for the others.
+	Family string `json:"family,omitempty"`
+	// Regex matches the object names of the datasets.  It defaults to the
+	// pattern of the type and family, including the -maxmind_dates or
+	// -routeview_dates filter.
+	Regex string `json:"regex,omitempty"`
+	// From and Until restrict the dates of the datasets to [From, Until), in
+	// DateFormat.  They default to the dates the type is used for, e.g. legacy
+	// datasets are not used from the first GeoLite2 date.
+	From  string `json:"from,omitempty"`
+	Until string `json:"until,omitempty"`
+}
+
+// sourceType describes how the datasets of a source type are listed and
+// matched.
+type sourceType struct {
+	kind     string                          // The provenance Type of the datasets
+	prefix   string                          // The prefix of the dataset object names
+	date     func(string) (time.Time, error) // Returns the date of a dataset
+	families bool                            // Whether there is one dataset per address family
+	// regex returns the default pattern of the datasets of a family.
+	regex func(family string) *regexp.Regexp
+	// from and until, if not nil, return the default date range of a family.
+	from, until func(family string) time.Time
+}
+
+// The regular expressions and dates are read when filtering, so that the
+// Update...DatePattern functions apply to loaders that already exist.
+var sourceTypes = map[string]sourceType{
+	LegacySource: {
+		kind: api.LegacyType, prefix: maxmindPrefix, date: maxmindDate, families: true,
+		regex: func(family string) *regexp.Regexp {
+			if family == IPv6 {
+				return geoLegacyv6Regex
+			}
+			return geoLegacyRegex
+		},
+		// We archived but do not use legacy datasets after GeoLite2StartDate.
+		until: func(string) time.Time { return geoLite2StartDate },
+	},
+	GeoLite2Source: {
+		kind: api.GeoLite2Type, prefix: maxmindPrefix, date: maxmindDate,
+		regex: func(string) *regexp.Regexp { return geoLite2Regex },
+	},
+	GeoLite2MMDBSource: {
+		kind: api.GeoLite2Type, prefix: maxmindPrefix, date: maxmindDate,
+		regex: func(string) *regexp.Regexp { return geoLite2MMDBRegex },
+	},
+	RouteViewsSource: {
+		kind: api.RouteViewsType, prefix: routeViewPrefix, date: routeViewDate, families: true,
+		regex: func(family string) *regexp.Regexp {
+			if family == IPv6 {
+				return asnRegexV6
+			}
+			return asnRegexV4
+		},
+		from: func(family string) time.Time {
+			if family == IPv6 {
+				return asnV6StartTime
+			}
+			return asnV4StartTime
+		},
+	},
+	GeoLite2ASNSource: {
+		kind: api.GeoLite2ASNType, prefix: maxmindPrefix, date: maxmindDate,
+		regex: func(string) *regexp.Regexp { return geoLite2ASNRegex },
+	},
+}
+
+// parseDate parses an optional SourceConfig date.
+func parseDate(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(DateFormat, s)
+}
+
+// NewSourceLoader returns a CachingLoader that loads the datasets declared
+// by cfg with the loader, or an error wrapping ErrBadSource if cfg is invalid.
+// The loader is injected, to allow for efficient unit testing.
+func NewSourceLoader(
+	cfg SourceConfig,
+	loader func(loader.Source, *storage.ObjectAttrs) (api.Annotator, error)) (api.CachingLoader, error) {
+	t, ok := sourceTypes[cfg.Type]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s: unknown type %q", ErrBadSource, cfg.Name, cfg.Type)
+	}
+	if cfg.Name == "" {
+		return nil, fmt.Errorf("%w: %s source has no name", ErrBadSource, cfg.Type)
+	}
+	switch {
+	case t.families && cfg.Family != IPv4 && cfg.Family != IPv6:
+		return nil, fmt.Errorf("%w: %s: family must be %q or %q", ErrBadSource, cfg.Name, IPv4, IPv6)
+	case !t.families && cfg.Family != "":
+		return nil, fmt.Errorf("%w: %s: %s datasets have no family", ErrBadSource, cfg.Name, cfg.Type)
+	}
+	var r *regexp.Regexp
+	if cfg.Regex != "" {
+		var err error
+		if r, err = regexp.Compile(cfg.Regex); err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrBadSource, cfg.Name, err)
+		}
+	}
+	from, err := parseDate(cfg.From)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: from: %v", ErrBadSource, cfg.Name, err)
+	}
+	until, err := parseDate(cfg.Until)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: until: %v", ErrBadSource, cfg.Name, err)
+	}
+
+	filter := func(file *storage.ObjectAttrs) error {
+		r, from, until := r, from, until
+		if r == nil {
+			r = t.regex(cfg.Family)
+		}
+		if cfg.From == "" && t.from != nil {
+			from = t.from(cfg.Family)
+		}
+		if cfg.Until == "" && t.until != nil {
+			until = t.until(cfg.Family)
+		}
+		if !from.IsZero() || !until.IsZero() {
+			fileDate, err := t.date(file.Name)
+			if err != nil {
+				return err
+			}
+			if fileDate.Before(from) {
+				return errNeededLoadingDate
+			}
+			if !until.IsZero() && !fileDate.Before(until) {
+				return errAfterLegacyCutoff
+			}
+		}
+		if !r.MatchString(file.Name) {
+			return errNoMatch
+		}
+		return nil
+	}
+	return newCachingLoader(filter, loader, t.date, t.kind, cfg.Name, t.prefix), nil
+}
+
+// mustSourceLoader returns the loader of a built-in source configuration,
+// which is always valid.
+func mustSourceLoader(
+	cfg SourceConfig,
+	loader func(loader.Source, *storage.ObjectAttrs) (api.Annotator, error)) api.CachingLoader {
+	cl, err := NewSourceLoader(cfg, loader)
+	if err != nil {
+		panic(err)
+	}
+	return cl
+}
@@ -12,12 +12,16 @@ import (
 	"log"
 	"regexp"
 	"runtime"
+	"strings"
 	"sync"
 	"time"
 
 	"cloud.google.com/go/storage"
 	"github.com/m-lab/annotation-service/api"
+	"github.com/m-lab/annotation-service/directory"
+	"github.com/m-lab/annotation-service/loader"
 	"github.com/m-lab/annotation-service/metrics"
+	"github.com/m-lab/annotation-service/snapshot"
 	"google.golang.org/api/iterator"
 )
 
@@ -36,6 +40,12 @@ var (
 	// geoLite2Regex is used to filter which geolite2 dataset files we consider acceptable.
 	geoLite2Regex = regexp.MustCompile(`Maxmind/\d{4}/\d{2}/\d{2}/\d{8}T\d{6}Z-GeoLite2-City-CSV\.zip`)
 
+	// geoLite2ASNRegex is used to filter which GeoLite2-ASN dataset files we consider acceptable.
+	geoLite2ASNRegex = regexp.MustCompile(`Maxmind/\d{4}/\d{2}/\d{2}/\d{8}T\d{6}Z-GeoLite2-ASN-CSV\.zip`)
+
+	// geoLite2MMDBRegex is used to filter which geolite2 MMDB dataset files we consider acceptable.
+	geoLite2MMDBRegex = regexp.MustCompile(`Maxmind/\d{4}/\d{2}/\d{2}/\d{8}T\d{6}Z-GeoLite2-City\.mmdb(\.gz)?$`)
+
 	// GeoLegacy??Regex are used to filter which legacy dataset files we consider acceptable.
 	geoLegacyRegex   = regexp.MustCompile(`.*-GeoLiteCity.dat.*`)
 	geoLegacyv6Regex = regexp.MustCompile(`.*-GeoLiteCityv6.dat.*`)
@@ -53,6 +63,8 @@ var (
 // load from GCS. The ymdRegex parameter is a string used as a regex pattern.
 func UpdateGeoliteDatePattern(ymdRegex string) {
 	geoLite2Regex = regexp.MustCompile(fmt.Sprintf(`Maxmind/%s/.*T\d{6}Z-GeoLite2-City-CSV\.zip`, ymdRegex))
+	geoLite2ASNRegex = regexp.MustCompile(fmt.Sprintf(`Maxmind/%s/.*T\d{6}Z-GeoLite2-ASN-CSV\.zip`, ymdRegex))
+	geoLite2MMDBRegex = regexp.MustCompile(fmt.Sprintf(`Maxmind/%s/.*T\d{6}Z-GeoLite2-City\.mmdb(\.gz)?$`, ymdRegex))
 	geoLegacyRegex = regexp.MustCompile(fmt.Sprintf(`Maxmind/%s/.*T.*-GeoLiteCity.dat.*`, ymdRegex))
 	geoLegacyv6Regex = regexp.MustCompile(fmt.Sprintf(`Maxmind/%s/.*T.*-GeoLiteCityv6.dat.*`, ymdRegex))
 	_, file, line, _ := runtime.Caller(1)
@@ -66,6 +78,8 @@ func UseOnlyMarchForTest() {
 		return
 	}
 	geoLite2Regex = regexp.MustCompile(`Maxmind/\d{4}/03/\d{2}/\d{8}T\d{6}Z-GeoLite2-City-CSV\.zip`)
+	geoLite2ASNRegex = regexp.MustCompile(`Maxmind/\d{4}/03/\d{2}/\d{8}T\d{6}Z-GeoLite2-ASN-CSV\.zip`)
+	geoLite2MMDBRegex = regexp.MustCompile(`Maxmind/\d{4}/03/\d{2}/\d{8}T\d{6}Z-GeoLite2-City\.mmdb(\.gz)?$`)
 	geoLegacyRegex = regexp.MustCompile(`Maxmind/\d{4}/03/\d{2}/\d{8}T.*-GeoLiteCity.dat.*`)
 	geoLegacyv6Regex = regexp.MustCompile(`Maxmind/\d{4}/03/\d{2}/\d{8}T.*-GeoLiteCityv6.dat.*`)
 }
@@ -74,30 +88,58 @@ func UseOnlyMarchForTest() {
 *                          LoadAll... functions                              *
 *****************************************************************************/
 
-// Returns the normal iterator for objects in the appropriate GCS bucket.
-func bucketIterator(withPrefix string) (*storage.ObjectIterator, error) {
-	ctx := context.Background()
-	client, err := storage.NewClient(ctx)
-	if err != nil {
-		return nil, err
-	}
-	prospectiveFiles := client.Bucket(api.MaxmindBucketName).Objects(ctx, &storage.Query{Prefix: withPrefix})
-	return prospectiveFiles, nil
+// source is the Source from which all datasets are listed and loaded.
+var source loader.Source = loader.NewGCSSource(api.MaxmindBucketName)
+
+// SetSource sets the Source from which datasets are listed and loaded.  It
+// should be called before the first UpdateCache.
+func SetSource(src loader.Source) {
+	source = src
+	log.Println("Dataset source is set to", src)
+}
+
+// Returns the iterator for objects with the given prefix in the dataset source.
+func bucketIterator(src loader.Source, withPrefix string) (loader.ObjectIterator, error) {
+	return src.Objects(context.Background(), withPrefix)
+}
+
+// lazyCache, if set, holds the datasets loaded on demand through
+// directory.Lazy handles.
+var lazyCache *directory.Cache
+
+// SetLazyCache makes the loaders create directory.Lazy handles in cache,
+// instead of loading all datasets up front.  It should be called before the
+// first UpdateCache.  A nil cache restores eager loading.
+func SetLazyCache(cache *directory.Cache) {
+	lazyCache = cache
 }
 
 // Filename is a typed value for tracking GCS filenames.
 type Filename string
 
-// loadAll loads all datasets from the source that match the filter.
+// maxmindDate returns the date of a Maxmind dataset, from its filename.
+func maxmindDate(name string) (time.Time, error) {
+	return api.ExtractDateFromFilename(name)
+}
+
+// loadAll loads all datasets from the source that match the filter.  With a
+// lazy cache, it creates handles that load the datasets on demand instead,
+// dated with the date function, and with the kind as their provenance Type.
+// Failed loads are recorded in failures, which defers or prevents new
+// attempts.
 func loadAll(
 	cache map[Filename]api.Annotator,
 	filter func(file *storage.ObjectAttrs) error,
-	loader func(*storage.ObjectAttrs) (api.Annotator, error),
-	gcsPrefix string) (map[Filename]api.Annotator, error) {
+	loader func(loader.Source, *storage.ObjectAttrs) (api.Annotator, error),
+	date func(name string) (time.Time, error),
+	kind string,
+	gcsPrefix string,
+	failures *failureRegistry) (map[Filename]api.Annotator, error) {
 	if loader == nil {
 		return nil, ErrNoLoader
 	}
-	source, err := bucketIterator(gcsPrefix)
+	src := source
+	files, err := bucketIterator(src, gcsPrefix)
 	if err != nil {
 		return nil, err
 	}
@@ -106,8 +148,9 @@ func loadAll(
 	result := make(map[Filename]api.Annotator, len(cache)+2)
 	resultLock := sync.Mutex{}
 	wg := sync.WaitGroup{}
+	names := map[Filename]bool{}
 
-	for file, err := source.Next(); err != iterator.Done; file, err = source.Next() {
+	for file, err := files.Next(); err != iterator.Done; file, err = files.Next() {
 		// TODO - should we retry here?
 		if err != nil {
 			return nil, err
@@ -116,33 +159,60 @@ func loadAll(
 			log.Println("file is nil", err)
 			continue
 		}
+		// Snapshots are read by the dataset loaders, along with the raw dataset.
+		if strings.HasSuffix(file.Name, snapshot.Suffix) {
+			continue
+		}
 		if filter != nil && filter(file) != nil {
 			continue
 		}
 		filename := Filename(file.Name)
+		names[filename] = true
 		ann, ok := cache[filename]
 		if ok {
 			result[filename] = ann
 			continue
 		}
+		if lazyCache != nil {
+			d, err := date(file.Name)
+			if err != nil {
+				log.Println("Skipping", filename, "with", err)
+				continue
+			}
+			file := file
+			prov := api.Provenance{Type: kind, Name: file.Name, Date: d}
+			result[filename] = lazyCache.NewLazy(prov, file.Size, func() (api.Annotator, error) {
+				return failures.load(prov, func() (api.Annotator, error) {
+					return loader(src, file)
+				})
+			})
+			continue
+		}
+		if failures.ready(filename, time.Now()) != nil {
+			// Quarantined, or failed too recently.
+			continue
+		}
 		_, _, callerLine, _ := runtime.Caller(1)
 		log.Println("Loading", filename, "from line", callerLine)
 		wg.Add(1)
+		metrics.PendingLoads.Inc()
+		// The date is only informative here, so errors are ignored.
+		d, _ := date(file.Name)
+		prov := api.Provenance{Type: kind, Name: file.Name, Date: d}
 		go func(file *storage.ObjectAttrs) {
 			defer wg.Done()
-			ann, err := loader(file)
+			defer metrics.PendingLoads.Dec()
+			ann, err := failures.load(prov, func() (api.Annotator, error) {
+				return loader(src, file)
+			})
 			if err != nil {
-				log.Println("Retrying", filename, "after", err)
-				ann, err = loader(file)
-				if err != nil {
-					log.Println("Failed trying to load", filename, "with", err)
-					return
-				}
+				return
 			}
 			resultLock.Lock()
 			result[filename] = ann
 			resultLock.Unlock()
 			metrics.DatasetCount.Inc()
+			metrics.LoadCount.Inc()
 
 			m := runtime.MemStats{}
 			runtime.ReadMemStats(&m)
@@ -152,36 +222,20 @@ func loadAll(
 		}(file)
 	}
 	wg.Wait()
+	failures.retain(names)
 	return result, nil
 }
 
-// filter is used to create filter functions for the loaders.
-// The file date is checked against `before` and file name is matched against `r`
-func filter(file *storage.ObjectAttrs, r *regexp.Regexp, before time.Time) error {
-	if !before.Equal(time.Time{}) {
-		fileDate, err := api.ExtractDateFromFilename(file.Name)
-		if err != nil {
-			return err
-		}
-		if !fileDate.Before(before) {
-			return errAfterLegacyCutoff
-		}
-	}
-
-	if !r.MatchString(file.Name) {
-		return errNoMatch
-	}
-
-	return nil
-}
-
 // cachingLoader implements api.CachingLoader for legacy and geolite2 geolocation.
 type cachingLoader struct {
 	lock       sync.Mutex
 	gcsPrefix  string
 	annotators map[Filename]api.Annotator
 	filter     func(*storage.ObjectAttrs) error
-	loader     func(*storage.ObjectAttrs) (api.Annotator, error)
+	loader     func(loader.Source, *storage.ObjectAttrs) (api.Annotator, error)
+	date       func(string) (time.Time, error)
+	kind       string           // The provenance Type of the datasets
+	failures   *failureRegistry // The datasets that failed to load
 }
 
 // UpdateCache causes the loader to load any new annotators and add them to the cached list.
@@ -192,7 +246,10 @@ func (cl *cachingLoader) UpdateCache() error {
 				return cl.filter(file)
 			},
 			cl.loader,
-			cl.gcsPrefix)
+			cl.date,
+			cl.kind,
+			cl.gcsPrefix,
+			cl.failures)
 	if err != nil {
 		return err
 	}
@@ -216,49 +273,46 @@ func (cl *cachingLoader) Fetch() []api.Annotator {
 }
 
 // NewCachingLoader creates a CachingLoader with the provided filter and loader.
+// The date function returns the date of a dataset from its name, and kind is
+// its provenance Type, for lazy loading.  The source labels the load failures
+// of the loader, in metrics and on the status page.
 func newCachingLoader(
 	filter func(*storage.ObjectAttrs) error,
-	loader func(*storage.ObjectAttrs) (api.Annotator, error),
+	loader func(loader.Source, *storage.ObjectAttrs) (api.Annotator, error),
+	date func(string) (time.Time, error),
+	kind string,
+	source string,
 	gcsPrefix string) api.CachingLoader {
-	return &cachingLoader{filter: filter, loader: loader, annotators: make(map[Filename]api.Annotator, 100), gcsPrefix: gcsPrefix}
+	return &cachingLoader{filter: filter, loader: loader, date: date, kind: kind, failures: newFailureRegistry(source), annotators: make(map[Filename]api.Annotator, 100), gcsPrefix: gcsPrefix}
 }
 
 // LegacyV4Loader returns a CachingLoader that loads all v4 legacy datasets.
 // The loader is injected, to allow for efficient unit testing.
 func LegacyV4Loader(
-	loader func(*storage.ObjectAttrs) (api.Annotator, error)) api.CachingLoader {
-	return newCachingLoader(
-		func(file *storage.ObjectAttrs) error {
-			// We archived but do not use legacy datasets after GeoLite2StartDate.
-			return filter(file, geoLegacyRegex, geoLite2StartDate)
-		},
-		loader,
-		maxmindPrefix)
+	loader func(loader.Source, *storage.ObjectAttrs) (api.Annotator, error)) api.CachingLoader {
+	return mustSourceLoader(SourceConfig{Name: "legacy-v4", Type: LegacySource, Family: IPv4}, loader)
 }
 
 // LegacyV6Loader returns a CachingLoader that loads all v6 legacy datasets.
 // The loader is injected, to allow for efficient unit testing.
 func LegacyV6Loader(
-	loader func(*storage.ObjectAttrs) (api.Annotator, error)) api.CachingLoader {
-	return newCachingLoader(
-		func(file *storage.ObjectAttrs) error {
-			// We archived but do not use legacy datasets after GeoLite2StartDate.
-			return filter(file, geoLegacyv6Regex, geoLite2StartDate)
-		},
-		loader,
-		maxmindPrefix)
+	loader func(loader.Source, *storage.ObjectAttrs) (api.Annotator, error)) api.CachingLoader {
+	return mustSourceLoader(SourceConfig{Name: "legacy-v6", Type: LegacySource, Family: IPv6}, loader)
 }
 
 // Geolite2Loader returns a CachingLoader that loads all geolite2 datasets.
 // The loader is injected, to allow for efficient unit testing.
 func Geolite2Loader(
-	loader func(*storage.ObjectAttrs) (api.Annotator, error)) api.CachingLoader {
-	return newCachingLoader(
-		func(file *storage.ObjectAttrs) error {
-			return filter(file, geoLite2Regex, time.Time{})
-		},
-		loader,
-		maxmindPrefix)
+	loader func(loader.Source, *storage.ObjectAttrs) (api.Annotator, error)) api.CachingLoader {
+	return mustSourceLoader(SourceConfig{Name: "geolite2", Type: GeoLite2Source}, loader)
+}
+
+// Geolite2MMDBLoader returns a CachingLoader that loads all geolite2 datasets
+// published in the MaxMind MMDB format.
+// The loader is injected, to allow for efficient unit testing.
+func Geolite2MMDBLoader(
+	loader func(loader.Source, *storage.ObjectAttrs) (api.Annotator, error)) api.CachingLoader {
+	return mustSourceLoader(SourceConfig{Name: "geolite2-mmdb", Type: GeoLite2MMDBSource}, loader)
 }
 
 // IsLegacy checks whether the given date should be handled by the legacy GEO1
@@ -1,12 +1,18 @@
 package geoloader_test
 
 import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
 	"cloud.google.com/go/storage"
 	"github.com/m-lab/annotation-service/api"
+	"github.com/m-lab/annotation-service/directory"
 	"github.com/m-lab/annotation-service/geoloader"
+	"github.com/m-lab/annotation-service/loader"
 )
 
 type fakeAnn struct {
@@ -21,6 +27,10 @@ func (f *fakeAnn) AnnotatorDate() time.Time {
 	return f.startDate
 }
 
+func (f *fakeAnn) Provenance() []api.Provenance {
+	return []api.Provenance{{Type: "fake", Date: f.startDate}}
+}
+
 func (f *fakeAnn) Close() {}
 
 func newFake(date string) *fakeAnn {
@@ -28,7 +38,7 @@ func newFake(date string) *fakeAnn {
 	return &fakeAnn{startDate: d}
 }
 
-func fakeLoader(obj *storage.ObjectAttrs) (api.Annotator, error) {
+func fakeLoader(src loader.Source, obj *storage.ObjectAttrs) (api.Annotator, error) {
 	date, err := api.ExtractDateFromFilename(obj.Name)
 	if err != nil {
 		return nil, err
@@ -75,3 +85,157 @@ func TestLoad(t *testing.T) {
 		t.Error(len(g2))
 	}
 }
+
+func TestLoadFromDirSource(t *testing.T) {
+	dir, err := ioutil.TempDir("", "geoloader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, name := range []string{
+		"Maxmind/2014/03/07/20140307T160000Z-GeoLiteCity.dat.gz",
+		"Maxmind/2014/03/07/20140307T160000Z-GeoLiteCityv6.dat.gz",
+		"Maxmind/2018/03/08/20180308T000000Z-GeoLite2-City-CSV.zip",
+		"Maxmind/2018/04/08/20180408T000000Z-GeoLite2-City-CSV.zip",
+		"Maxmind/2020/01/07/20200107T000000Z-GeoLite2-City.mmdb.gz",
+		"Maxmind/2020/02/04/20200204T000000Z-GeoLite2-City.mmdb",
+	} {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	geoloader.SetSource(loader.NewDirSource(dir))
+	defer geoloader.SetSource(loader.NewGCSSource(api.MaxmindBucketName))
+
+	g2loader := geoloader.Geolite2Loader(fakeLoader)
+	err = g2loader.UpdateCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(g2loader.Fetch()) != 2 {
+		t.Error("Expected 2 geolite2 annotators, got", len(g2loader.Fetch()))
+	}
+
+	v4loader := geoloader.LegacyV4Loader(fakeLoader)
+	err = v4loader.UpdateCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(v4loader.Fetch()) != 1 {
+		t.Error("Expected 1 legacy annotator, got", len(v4loader.Fetch()))
+	}
+
+	mmdbLoader := geoloader.Geolite2MMDBLoader(fakeLoader)
+	err = mmdbLoader.UpdateCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mmdbLoader.Fetch()) != 2 {
+		t.Error("Expected 2 MMDB annotators, got", len(mmdbLoader.Fetch()))
+	}
+
+	// Failed loads are retried with backoff, and reported until the dataset
+	// is loaded.
+	defer func(d time.Duration) { geoloader.MinRetryDelay = d }(geoloader.MinRetryDelay)
+	errLoad := errors.New("load failed")
+	calls := 0
+	loadErr := errLoad
+	v6loader := geoloader.LegacyV6Loader(func(src loader.Source, obj *storage.ObjectAttrs) (api.Annotator, error) {
+		calls++
+		if loadErr != nil {
+			return nil, loadErr
+		}
+		return fakeLoader(src, obj)
+	})
+	if err := v6loader.UpdateCache(); err != nil {
+		t.Fatal(err)
+	}
+	pending, failed := geoloader.Loads()
+	if len(pending) != 0 || len(failed) != 1 || failed[0].Err != errLoad || failed[0].Attempts != 1 || calls != 2 ||
+		failed[0].Name != "Maxmind/2014/03/07/20140307T160000Z-GeoLiteCityv6.dat.gz" || failed[0].Source != "legacy-v6" {
+		t.Errorf("Loads() = %+v, %+v, calls = %d", pending, failed, calls)
+	}
+	// The dataset is not tried again before the backoff delay.
+	if err := v6loader.UpdateCache(); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 2 || len(v6loader.Fetch()) != 0 {
+		t.Error("calls =", calls, "Fetch() =", v6loader.Fetch())
+	}
+	geoloader.MinRetryDelay = 0
+	loadErr = nil
+	if err := v6loader.UpdateCache(); err != nil {
+		t.Fatal(err)
+	}
+	if pending, failed := geoloader.Loads(); len(pending) != 0 || len(failed) != 0 || len(v6loader.Fetch()) != 1 {
+		t.Errorf("Loads() = %+v, %+v", pending, failed)
+	}
+
+	// Corrupt datasets are quarantined, and never tried again.
+	calls = 0
+	corrupt := geoloader.LegacyV4Loader(func(src loader.Source, obj *storage.ObjectAttrs) (api.Annotator, error) {
+		calls++
+		return nil, api.NewError("Corrupted Data", api.ErrCorrupt)
+	})
+	for i := 0; i < 2; i++ {
+		if err := corrupt.UpdateCache(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pending, failed = geoloader.Loads()
+	if calls != 1 || len(pending) != 0 || len(failed) != 1 || !failed[0].Quarantined || failed[0].Source != "legacy-v4" {
+		t.Errorf("Loads() = %+v, %+v, calls = %d", pending, failed, calls)
+	}
+}
+
+func TestLazyLoading(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{
+		"Maxmind/2018/03/08/20180308T000000Z-GeoLite2-City-CSV.zip",
+		"RouteViewIPv4/2019/01/routeviews-rv2-20190101-1200.pfx2as.gz",
+	} {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	geoloader.SetSource(loader.NewDirSource(dir))
+	defer geoloader.SetSource(loader.NewGCSSource(api.MaxmindBucketName))
+	geoloader.SetLazyCache(directory.NewCache(0))
+	defer geoloader.SetLazyCache(nil)
+	geoloader.UpdateASNDatePattern(`\d{4}/\d{2}`)
+
+	loads := 0
+	countingLoader := func(src loader.Source, obj *storage.ObjectAttrs) (api.Annotator, error) {
+		loads++
+		return fakeLoader(src, obj)
+	}
+	for _, tt := range []struct {
+		loader api.CachingLoader
+		want   string
+	}{
+		{loader: geoloader.Geolite2Loader(countingLoader), want: "20180308"},
+		{loader: geoloader.ASNv4Loader(countingLoader), want: "20190101"},
+	} {
+		if err := tt.loader.UpdateCache(); err != nil {
+			t.Fatal(err)
+		}
+		anns := tt.loader.Fetch()
+		if len(anns) != 1 {
+			t.Fatal("Expected 1 annotator, got", len(anns))
+		}
+		if _, ok := anns[0].(*directory.Lazy); !ok || anns[0].AnnotatorDate().Format("20060102") != tt.want {
+			t.Errorf("Fetch() = %T dated %v, want a Lazy handle dated %s", anns[0], anns[0].AnnotatorDate(), tt.want)
+		}
+	}
+	if loads != 0 {
+		t.Error("datasets loaded before use:", loads)
+	}
+}
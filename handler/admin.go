@@ -0,0 +1,75 @@
+package handler
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	v2 "github.com/m-lab/annotation-service/api/v2"
+	"github.com/m-lab/annotation-service/manager"
+)
+
+var errBadAction = errors.New("action must be rollback or resume")
+
+// AdminToken is the token that /admin/diff requests, and POST requests to
+// /admin/directory, must have in an 'Authorization: Bearer <token>' header.
+// They are refused when it is empty.
+var AdminToken = ""
+
+// authorized checks that the request has the AdminToken, or writes an error.
+func authorized(w http.ResponseWriter, r *http.Request) bool {
+	if AdminToken == "" {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return false
+	}
+	auth := []byte(r.Header.Get("Authorization"))
+	if subtle.ConstantTimeCompare(auth, []byte("Bearer "+AdminToken)) != 1 {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// DirectoryAdmin is an admin URL handler for /admin/directory, which returns
+// the state of the directory updates as a v2.DirectoryStatus.  POST requests
+// with action=rollback restore the directory replaced by the last update,
+// and hold the updates until a POST with action=resume.  They need the
+// AdminToken.
+func DirectoryAdmin(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		if !authorized(w, r) {
+			return
+		}
+		switch r.URL.Query().Get("action") {
+		case "rollback":
+			if err := manager.Rollback(); err != nil {
+				http.Error(w, err.Error(), http.StatusConflict)
+				return
+			}
+		case "resume":
+			manager.Resume()
+		default:
+			http.Error(w, errBadAction.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "GET or POST required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	s := manager.Status()
+	resp := v2.DirectoryStatus{
+		Current:  (*v2.DirectoryInfo)(s.Current),
+		Previous: (*v2.DirectoryInfo)(s.Previous),
+		Held:     s.Held,
+	}
+	if s.Rejected != nil {
+		resp.Rejected = s.Rejected.Error()
+		resp.RejectedAt = &s.RejectedAt
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,72 @@
+package handler_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/m-lab/annotation-service/api"
+	v2 "github.com/m-lab/annotation-service/api/v2"
+	"github.com/m-lab/annotation-service/handler"
+	"github.com/m-lab/annotation-service/manager"
+)
+
+func TestDirectoryAdmin(t *testing.T) {
+	march := time.Date(2018, 3, 1, 0, 0, 0, 0, time.UTC)
+	april := time.Date(2018, 4, 1, 0, 0, 0, 0, time.UTC)
+	manager.SetDirectory([]api.Annotator{cityDataset("Boston", march)})
+	manager.SetDirectory([]api.Annotator{cityDataset("Boston", march), cityDataset("Denver", april)})
+	defer manager.Resume()
+	handler.AdminToken = "secret"
+	defer func() { handler.AdminToken = "" }()
+
+	tests := []struct {
+		method   string
+		action   string
+		auth     string
+		code     int
+		current  int
+		previous int
+		held     bool
+	}{
+		{http.MethodGet, "", "", http.StatusOK, 2, 1, false},
+		{http.MethodPost, "rollback", "", http.StatusUnauthorized, 0, 0, false},
+		{http.MethodPost, "rollback", "Bearer wrong", http.StatusUnauthorized, 0, 0, false},
+		{http.MethodPost, "restart", "Bearer secret", http.StatusBadRequest, 0, 0, false},
+		{http.MethodPut, "", "", http.StatusMethodNotAllowed, 0, 0, false},
+		{http.MethodPost, "rollback", "Bearer secret", http.StatusOK, 1, 2, true},
+		{http.MethodPost, "resume", "Bearer secret", http.StatusOK, 1, 2, false},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(tt.method, "/admin/directory?action="+tt.action, nil)
+		r.Header.Set("Authorization", tt.auth)
+		handler.DirectoryAdmin(w, r)
+		if w.Code != tt.code {
+			t.Errorf("%s %s: code = %d, want %d", tt.method, tt.action, w.Code, tt.code)
+			continue
+		}
+		if w.Code != http.StatusOK {
+			continue
+		}
+		var resp v2.DirectoryStatus
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		if resp.Current == nil || resp.Current.Annotators != tt.current || resp.Previous == nil ||
+			resp.Previous.Annotators != tt.previous || resp.Held != tt.held {
+			t.Errorf("%s %s: %s", tt.method, tt.action, w.Body.String())
+		}
+	}
+
+	// Without a token, the directory cannot be changed.
+	handler.AdminToken = ""
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/admin/directory?action=rollback", nil)
+	handler.DirectoryAdmin(w, r)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("no token: code = %d, want %d", w.Code, http.StatusForbidden)
+	}
+}
@@ -0,0 +1,96 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/m-lab/annotation-service/api"
+	v2 "github.com/m-lab/annotation-service/api/v2"
+	"github.com/m-lab/annotation-service/directory"
+	"github.com/m-lab/annotation-service/geoloader"
+	"github.com/m-lab/annotation-service/manager"
+)
+
+// Datasets is a URL handler for /v2/datasets, which returns the inventory of
+// the directory as a v2.DatasetsResponse: each annotator with the dates it is
+// used for and its datasets, and the datasets being loaded or that failed to
+// load.  It never loads lazy datasets.
+func Datasets(w http.ResponseWriter, r *http.Request) {
+	annotators, err := manager.Annotators()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	resp := v2.DatasetsResponse{
+		Annotators: make([]v2.AnnotatorInfo, len(annotators)),
+		Pending:    []v2.DatasetLoad{},
+		Failed:     []v2.DatasetLoad{},
+	}
+	pending, failed := geoloader.Loads()
+	for _, s := range pending {
+		resp.Pending = append(resp.Pending, v2.DatasetLoad{Provenance: s.Provenance, Since: s.Since})
+	}
+	for _, s := range failed {
+		resp.Failed = append(resp.Failed, v2.DatasetLoad{
+			Provenance: s.Provenance, Since: s.Since, Error: s.Err.Error(), Attempts: s.Attempts, Quarantined: s.Quarantined,
+		})
+	}
+	for i, ann := range annotators {
+		info := &resp.Annotators[i]
+		info.Date = ann.AnnotatorDate()
+		if i+1 < len(annotators) {
+			until := annotators[i+1].AnnotatorDate()
+			info.Until = &until
+		}
+		for _, ds := range directory.Datasets(ann) {
+			info.Datasets = append(info.Datasets, datasetInfo(ds, &resp))
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// datasetInfo describes a dataset of the directory.  Lazy datasets that are
+// being loaded, or that failed to load, are also added to the Pending or
+// Failed lists of resp, unless they are already listed, e.g. by the loaders
+// or for another annotator.
+func datasetInfo(ds api.Annotator, resp *v2.DatasetsResponse) v2.DatasetInfo {
+	info := v2.DatasetInfo{Loaded: true}
+	if provs := ds.Provenance(); len(provs) > 0 {
+		info.Provenance = provs[0]
+	}
+	if h, ok := ds.(*directory.Lazy); ok {
+		state := h.State()
+		ds = state.Annotator
+		info.Loaded = ds != nil
+		load := v2.DatasetLoad{Provenance: info.Provenance, Since: state.Since}
+		if state.Pending && !hasLoad(resp.Pending, load.Name) {
+			resp.Pending = append(resp.Pending, load)
+		}
+		if state.Err != nil {
+			info.Error = state.Err.Error()
+			if !hasLoad(resp.Failed, load.Name) {
+				load.Error = info.Error
+				resp.Failed = append(resp.Failed, load)
+			}
+		}
+	}
+	if s, ok := ds.(directory.Sizer); ok {
+		info.Size = s.Size()
+	}
+	if c, ok := ds.(directory.NodeCounter); ok {
+		info.Nodes = c.NodeCount()
+	}
+	return info
+}
+
+// hasLoad returns true if loads has a load of the named dataset.
+func hasLoad(loads []v2.DatasetLoad, name string) bool {
+	for i := range loads {
+		if loads[i].Name == name {
+			return true
+		}
+	}
+	return false
+}
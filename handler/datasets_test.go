@@ -0,0 +1,58 @@
+package handler_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/m-lab/annotation-service/api"
+	v2 "github.com/m-lab/annotation-service/api/v2"
+	"github.com/m-lab/annotation-service/directory"
+	"github.com/m-lab/annotation-service/handler"
+	"github.com/m-lab/annotation-service/manager"
+)
+
+func TestDatasets(t *testing.T) {
+	march := time.Date(2019, 3, 1, 0, 0, 0, 0, time.UTC)
+	april := time.Date(2019, 4, 1, 0, 0, 0, 0, time.UTC)
+	prov := api.Provenance{Type: api.RouteViewsType, Name: "RouteViewIPv4/2019/04/routeviews-rv2-20190401-1200.pfx2as.gz", Date: april}
+	broken := directory.NewCache(0).NewLazy(prov, 1, func() (api.Annotator, error) {
+		return nil, errors.New("load failed")
+	})
+	broken.Load()
+	manager.SetDirectory([]api.Annotator{
+		cityDataset("Boston", march),
+		directory.NewCompositeAnnotator([]api.Annotator{cityDataset("Denver", april), broken}),
+	})
+
+	w := httptest.NewRecorder()
+	handler.Datasets(w, httptest.NewRequest("GET", "/v2/datasets", nil))
+	if w.Code != http.StatusOK {
+		t.Fatal("status =", w.Code, w.Body.String())
+	}
+	resp := v2.DatasetsResponse{}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Annotators) != 2 {
+		t.Fatalf("Annotators = %+v", resp.Annotators)
+	}
+	first, last := resp.Annotators[0], resp.Annotators[1]
+	if !first.Date.Equal(march) || first.Until == nil || !first.Until.Equal(april) || last.Until != nil {
+		t.Errorf("Annotators = %+v", resp.Annotators)
+	}
+	if len(first.Datasets) != 1 || !first.Datasets[0].Loaded || first.Datasets[0].Nodes != 1 ||
+		first.Datasets[0].Size == 0 || first.Datasets[0].Type != api.GeoLite2Type {
+		t.Errorf("Datasets = %+v", first.Datasets)
+	}
+	if len(last.Datasets) != 2 || last.Datasets[1].Loaded || last.Datasets[1].Name != prov.Name ||
+		last.Datasets[1].Error != "load failed" {
+		t.Errorf("Datasets = %+v", last.Datasets)
+	}
+	if len(resp.Pending) != 0 || len(resp.Failed) != 1 || resp.Failed[0].Name != prov.Name {
+		t.Errorf("Pending = %+v, Failed = %+v", resp.Pending, resp.Failed)
+	}
+}
@@ -0,0 +1,71 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/m-lab/annotation-service/diff"
+	"github.com/m-lab/annotation-service/manager"
+)
+
+// defaultMaxChanges is the number of changed ranges returned by Diff, unless
+// the max_changes parameter says otherwise.
+const defaultMaxChanges = 1000
+
+var errNoBefore = errors.New("missing before date")
+
+// Diff is an admin URL handler for /admin/diff?before=&after=, which compares
+// the annotators for two dates, and returns the ranges whose country, city or
+// AS changed between them as a v3.DiffResponse.  The dates have the same
+// format as the v3 date parameter, and after defaults to the current time.
+// Only the first max_changes changes are listed, 1000 by default, or all of
+// them if it is negative, but the summary counts cover all the changes.
+// Requests need the AdminToken, as they walk all the ranges of two datasets.
+func Diff(w http.ResponseWriter, r *http.Request) {
+	tStart := time.Now()
+	if !authorized(w, r) {
+		return
+	}
+	query := r.URL.Query()
+	if query.Get("before") == "" {
+		http.Error(w, errNoBefore.Error(), http.StatusBadRequest)
+		return
+	}
+	before, err := parseDate(query.Get("before"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	after, err := parseDate(query.Get("after"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	maxChanges := defaultMaxChanges
+	if value := query.Get("max_changes"); value != "" {
+		maxChanges, err = strconv.Atoi(value)
+		if err != nil {
+			http.Error(w, "invalid max_changes", http.StatusBadRequest)
+			return
+		}
+	}
+
+	beforeAnn, err := manager.GetAnnotator(before)
+	if checkError(err, w, "", 0, "diff", tStart) {
+		return
+	}
+	afterAnn, err := manager.GetAnnotator(after)
+	if checkError(err, w, "", 0, "diff", tStart) {
+		return
+	}
+	resp, err := diff.Annotators(beforeAnn, afterAnn, maxChanges)
+	if err != nil {
+		http.Error(w, err.Error(), httpStatus(err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,76 @@
+package handler_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/m-lab/annotation-service/api"
+	v3 "github.com/m-lab/annotation-service/api/v3"
+	"github.com/m-lab/annotation-service/handler"
+	"github.com/m-lab/annotation-service/manager"
+)
+
+func TestDiff(t *testing.T) {
+	march := time.Date(2019, 3, 1, 0, 0, 0, 0, time.UTC)
+	april := time.Date(2019, 4, 1, 0, 0, 0, 0, time.UTC)
+	manager.SetDirectory([]api.Annotator{cityDataset("Boston", march), cityDataset("Denver", april)})
+	handler.AdminToken = "secret"
+	defer func() { handler.AdminToken = "" }()
+
+	tests := []struct {
+		query    string
+		wantCode int
+		want     int
+	}{
+		{query: "before=2019-03-02&after=2019-04-02", wantCode: http.StatusOK, want: 1},
+		{query: "before=2019-03-02&after=2019-03-03", wantCode: http.StatusOK, want: 0},
+		{query: "before=2019-03-02&after=2019-04-02&max_changes=0", wantCode: http.StatusOK, want: 0},
+		{query: "after=2019-04-02", wantCode: http.StatusBadRequest},
+		{query: "before=March", wantCode: http.StatusBadRequest},
+		{query: "before=2019-03-02&max_changes=all", wantCode: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/admin/diff?"+tt.query, nil)
+		r.Header.Set("Authorization", "Bearer secret")
+		handler.Diff(w, r)
+		if w.Code != tt.wantCode {
+			t.Errorf("%s: status = %d, want %d: %s", tt.query, w.Code, tt.wantCode, w.Body.String())
+			continue
+		}
+		if tt.wantCode != http.StatusOK {
+			continue
+		}
+		resp := v3.DiffResponse{}
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		if len(resp.Changes) != tt.want || !resp.Before.Equal(march) {
+			t.Errorf("%s: response = %+v", tt.query, resp)
+		}
+	}
+
+	// Errors are reported like those of the other handlers.
+	manager.StrictCoverage = true
+	defer func() { manager.StrictCoverage = false }()
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/admin/diff?before=2019-01-01&after=2019-04-02", nil)
+	r.Header.Set("Authorization", "Bearer secret")
+	handler.Diff(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("outside coverage: status = %d, want %d: %s", w.Code, http.StatusBadRequest, w.Body.String())
+	}
+
+	// Without the token, nothing is compared.
+	for token, want := range map[string]int{"secret": http.StatusUnauthorized, "": http.StatusForbidden} {
+		handler.AdminToken = token
+		w := httptest.NewRecorder()
+		handler.Diff(w, httptest.NewRequest("GET", "/admin/diff?before=2019-03-02", nil))
+		if w.Code != want {
+			t.Errorf("token %q: status = %d, want %d", token, w.Code, want)
+		}
+	}
+}
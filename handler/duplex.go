@@ -0,0 +1,21 @@
+//go:build go1.21
+// +build go1.21
+
+package handler
+
+import (
+	"io"
+	"net/http"
+)
+
+// startStream allows the handler to keep reading the request body after it
+// starts writing the response, and returns the body and the response.
+// HTTP/2 requests always allow it.
+func startStream(w http.ResponseWriter, r *http.Request) (io.Reader, streamResponse, error) {
+	if r.ProtoMajor < 2 {
+		if err := http.NewResponseController(w).EnableFullDuplex(); err != nil {
+			return nil, nil, err
+		}
+	}
+	return r.Body, responseStream{w}, nil
+}
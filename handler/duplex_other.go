@@ -0,0 +1,21 @@
+//go:build !go1.21
+// +build !go1.21
+
+package handler
+
+import (
+	"io"
+	"net/http"
+)
+
+// startStream allows the handler to keep reading the request body after it
+// starts writing the response, and returns the body and the response.
+// HTTP/2 requests always allow it.  Before go1.21, the server discards the
+// unread body of HTTP/1.x requests once the response is flushed, so their
+// connection is hijacked instead.
+func startStream(w http.ResponseWriter, r *http.Request) (io.Reader, streamResponse, error) {
+	if r.ProtoMajor >= 2 {
+		return r.Body, responseStream{w}, nil
+	}
+	return hijackStream(w, r)
+}
@@ -0,0 +1,151 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/m-lab/annotation-service/api"
+	"github.com/m-lab/annotation-service/api/annotatorpb"
+	v2 "github.com/m-lab/annotation-service/api/v2"
+	"github.com/m-lab/annotation-service/directory"
+	"github.com/m-lab/annotation-service/geoloader"
+	"github.com/m-lab/annotation-service/manager"
+	"github.com/m-lab/annotation-service/metrics"
+)
+
+// GRPCServer implements the annotatorpb.Annotator gRPC service.  It annotates
+// with AnnotateV2, so it uses the same annotators and metrics as the v2 JSON
+// batch requests.
+type GRPCServer struct {
+	annotatorpb.UnimplementedAnnotatorServer
+}
+
+// NewGRPCServer returns a grpc.Server with the Annotator service registered.
+func NewGRPCServer(opts ...grpc.ServerOption) *grpc.Server {
+	s := grpc.NewServer(opts...)
+	annotatorpb.RegisterAnnotatorServer(s, &GRPCServer{})
+	return s
+}
+
+// Annotate annotates the request IPs, using the annotator for the request date.
+func (s *GRPCServer) Annotate(ctx context.Context, req *annotatorpb.AnnotateRequest) (*annotatorpb.AnnotateResponse, error) {
+	// Setup timers and counters for prometheus metrics.
+	tStart := time.Now()
+	defer func(t time.Time) {
+		metrics.RequestTimes.Observe(float64(time.Since(t).Nanoseconds()))
+	}(tStart)
+	metrics.ActiveRequests.Inc()
+	metrics.TotalRequests.Inc()
+	defer metrics.ActiveRequests.Dec()
+
+	if err := req.GetDate().CheckValid(); err != nil {
+		metrics.RequestTimeHistogramUsec.WithLabelValues(req.RequestInfo, "grpc", "invalid date").Observe(float64(time.Since(tStart).Nanoseconds()) / 1000)
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	date := req.Date.AsTime()
+	sel, err := selection(req.GeoPolicy, req.AsnPolicy)
+	if err != nil {
+		metrics.RequestTimeHistogramUsec.WithLabelValues(req.RequestInfo, "grpc", "invalid policy").Observe(float64(time.Since(tStart).Nanoseconds()) / 1000)
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	response := v2.Response{}
+	if len(req.Ips) > 0 {
+		response, err = AnnotateV2(date, sel, req.Ips, req.RequestInfo)
+		if err != nil {
+			// Some errors include the date, so they are labeled by their code.
+			code := grpcCode(err)
+			metrics.RequestTimeHistogramUsec.WithLabelValues(req.RequestInfo, "grpc", code.String()).Observe(float64(time.Since(tStart).Nanoseconds()) / 1000)
+			return nil, status.Error(code, err.Error())
+		}
+	}
+
+	result := &annotatorpb.AnnotateResponse{
+		Annotations:  make(map[string]*annotatorpb.GeoData, len(response.Annotations)),
+		Status:       response.Status,
+		Missing:      response.Missing,
+		Stale:        response.Stale,
+		Extrapolated: response.Extrapolated,
+	}
+	for _, p := range response.Provenance {
+		result.Provenance = append(result.Provenance, &annotatorpb.Provenance{
+			Type: p.Type,
+			Name: p.Name,
+			Date: timestamppb.New(p.Date),
+		})
+	}
+	if !response.AnnotatorDate.IsZero() {
+		result.AnnotatorDate = timestamppb.New(response.AnnotatorDate)
+	}
+	for ip, anno := range response.Annotations {
+		trackMissingResponses(anno)
+		result.Annotations[ip] = geoDataProto(anno)
+	}
+
+	if geoloader.IsLegacy(date) {
+		latencyStats(req.RequestInfo, "grpc-legacy", len(req.Ips), tStart)
+	} else {
+		latencyStats(req.RequestInfo, "grpc-geolite2", len(req.Ips), tStart)
+	}
+	return result, nil
+}
+
+// grpcCode returns the status code for an AnnotateV2 error.  Errors caused by
+// datasets that are not loaded yet are Unavailable, so clients may retry.
+// Dates outside the coverage of the datasets, in strict mode, are OutOfRange.
+func grpcCode(err error) codes.Code {
+	if errors.Is(err, manager.ErrOutsideCoverage) {
+		return codes.OutOfRange
+	}
+	switch err {
+	case errNoAnnotator, manager.ErrDirectoryIsNil, directory.ErrEmptyDirectory:
+		return codes.Unavailable
+	default:
+		return codes.Internal
+	}
+}
+
+// geoDataProto converts an annotation to its protocol buffer message.
+func geoDataProto(anno *api.GeoData) *annotatorpb.GeoData {
+	result := &annotatorpb.GeoData{}
+	if g := anno.Geo; g != nil {
+		result.Geo = &annotatorpb.Geolocation{
+			ContinentCode:       g.ContinentCode,
+			CountryCode:         g.CountryCode,
+			CountryCode3:        g.CountryCode3,
+			CountryName:         g.CountryName,
+			Region:              g.Region,
+			Subdivision1IsoCode: g.Subdivision1ISOCode,
+			Subdivision1Name:    g.Subdivision1Name,
+			Subdivision2IsoCode: g.Subdivision2ISOCode,
+			Subdivision2Name:    g.Subdivision2Name,
+			MetroCode:           g.MetroCode,
+			City:                g.City,
+			AreaCode:            g.AreaCode,
+			PostalCode:          g.PostalCode,
+			Latitude:            g.Latitude,
+			Longitude:           g.Longitude,
+			AccuracyRadiusKm:    g.AccuracyRadiusKm,
+			Missing:             g.Missing,
+		}
+	}
+	if n := anno.Network; n != nil {
+		result.Network = &annotatorpb.Network{
+			IpPrefix: n.IPPrefix,
+			Cidr:     n.CIDR,
+			AsNumber: n.ASNumber,
+			AsName:   n.ASName,
+			Missing:  n.Missing,
+		}
+		for i := range n.Systems {
+			result.Network.Systems = append(result.Network.Systems, &annotatorpb.System{Asns: n.Systems[i].ASNs})
+		}
+	}
+	return result
+}
@@ -0,0 +1,193 @@
+package handler_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/m-lab/annotation-service/api"
+	"github.com/m-lab/annotation-service/api/annotatorpb"
+	"github.com/m-lab/annotation-service/directory"
+	"github.com/m-lab/annotation-service/geolite2v2"
+	"github.com/m-lab/annotation-service/handler"
+	"github.com/m-lab/annotation-service/iputils"
+	"github.com/m-lab/annotation-service/manager"
+)
+
+// grpcClient starts a gRPC server on an in-memory listener, and returns a
+// client connected to it.
+func grpcClient(t *testing.T) annotatorpb.AnnotatorClient {
+	lis := bufconn.Listen(1 << 20)
+	s := handler.NewGRPCServer()
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.DialContext(context.Background(), "bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return annotatorpb.NewAnnotatorClient(conn)
+}
+
+func TestGRPCAnnotate(t *testing.T) {
+	ann := geolite2v2.NewGeoDataset(
+		[]geolite2v2.GeoIPNode{
+			{
+				BaseIPNode: iputils.BaseIPNode{
+					IPAddressLow:  net.IPv4(0, 0, 0, 0),
+					IPAddressHigh: net.IPv4(127, 255, 255, 255),
+				},
+				LocationIndex: 0,
+				PostalCode:    "10583",
+				Latitude:      42.1,
+				Longitude:     -73.1,
+			},
+		},
+		nil,
+		[]geolite2v2.LocationNode{
+			{
+				CityName:            "Not A Real City",
+				RegionCode:          "ME",
+				Subdivision1ISOCode: "ME",
+			},
+		},
+	)
+	ann.Start = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	manager.SetDirectory([]api.Annotator{directory.NewCompositeAnnotator([]api.Annotator{ann})})
+
+	client := grpcClient(t)
+	resp, err := client.Annotate(context.Background(), &annotatorpb.AnnotateRequest{
+		Ips:         []string{"1.4.128.0", "227.86.65.1", "2002:0104:8000::"},
+		Date:        timestamppb.New(time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC)),
+		RequestInfo: "test",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resp.AnnotatorDate.AsTime().Equal(ann.Start) {
+		t.Error("AnnotatorDate =", resp.AnnotatorDate.AsTime())
+	}
+	found := &annotatorpb.GeoData{
+		Geo: &annotatorpb.Geolocation{
+			Region:              "ME",
+			Subdivision1IsoCode: "ME",
+			City:                "Not A Real City",
+			PostalCode:          "10583",
+			Latitude:            42.1,
+			Longitude:           -73.1,
+		},
+		Network: &annotatorpb.Network{Missing: true},
+	}
+	want := map[string]*annotatorpb.GeoData{
+		"1.4.128.0": found,
+		"227.86.65.1": {
+			Geo:     &annotatorpb.Geolocation{Missing: true},
+			Network: &annotatorpb.Network{Missing: true},
+		},
+		// 6to4 addresses are annotated with the embedded IPv4 address.
+		"2002:0104:8000::": found,
+	}
+	if len(resp.Annotations) != len(want) {
+		t.Fatal("Annotations =", resp.Annotations)
+	}
+	for ip, w := range want {
+		if got := resp.Annotations[ip]; !proto.Equal(got, w) {
+			t.Errorf("%s: got %v, expected %v", ip, got, w)
+		}
+	}
+	wantStatus := map[string]string{
+		"1.4.128.0":        api.StatusOK,
+		"227.86.65.1":      api.StatusNotFound,
+		"2002:0104:8000::": api.StatusOK,
+	}
+	for ip, w := range wantStatus {
+		if got := resp.Status[ip]; got != w {
+			t.Errorf("%s: status %s, expected %s", ip, got, w)
+		}
+	}
+}
+
+func TestGRPCAnnotateMetadata(t *testing.T) {
+	march := time.Date(2018, 3, 1, 0, 0, 0, 0, time.UTC)
+	manager.SetDirectory(directory.MergeSources(
+		directory.Source{Name: "geo", Annotators: []api.Annotator{cityDataset("Boston", march)}},
+		directory.Source{Name: "asn"}))
+
+	client := grpcClient(t)
+	resp, err := client.Annotate(context.Background(), &annotatorpb.AnnotateRequest{
+		Ips:       []string{"1.2.3.4"},
+		Date:      timestamppb.New(march.AddDate(0, 0, -1)),
+		GeoPolicy: "nearest",
+		AsnPolicy: "last-before",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []*annotatorpb.Provenance{{Type: api.GeoLite2Type, Date: timestamppb.New(march)}}
+	if len(resp.Provenance) != len(want) || !proto.Equal(resp.Provenance[0], want[0]) {
+		t.Error("Provenance =", resp.Provenance)
+	}
+	if len(resp.Missing) != 1 || resp.Missing[0] != "asn" {
+		t.Error("Missing =", resp.Missing)
+	}
+	if len(resp.Extrapolated) != 1 || resp.Extrapolated[0] != api.GeoLite2Type || len(resp.Stale) != 0 {
+		t.Errorf("Extrapolated = %v, Stale = %v", resp.Extrapolated, resp.Stale)
+	}
+	if got := resp.Annotations["1.2.3.4"].GetGeo().GetCity(); got != "Boston" {
+		t.Error("City =", got)
+	}
+}
+
+func TestGRPCAnnotateErrors(t *testing.T) {
+	client := grpcClient(t)
+
+	manager.SetDirectory([]api.Annotator{})
+	_, err := client.Annotate(context.Background(), &annotatorpb.AnnotateRequest{
+		Ips:  []string{"1.4.128.0"},
+		Date: timestamppb.Now(),
+	})
+	if status.Code(err) != codes.Unavailable {
+		t.Error("empty directory: error =", err)
+	}
+
+	_, err = client.Annotate(context.Background(), &annotatorpb.AnnotateRequest{
+		Ips: []string{"1.4.128.0"},
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Error("missing date: error =", err)
+	}
+
+	_, err = client.Annotate(context.Background(), &annotatorpb.AnnotateRequest{
+		Ips:       []string{"1.4.128.0"},
+		Date:      timestamppb.Now(),
+		GeoPolicy: "latest",
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Error("bad policy: error =", err)
+	}
+
+	manager.SetDirectory([]api.Annotator{cityDataset("Boston", time.Date(2018, 3, 1, 0, 0, 0, 0, time.UTC))})
+	manager.StrictCoverage = true
+	defer func() { manager.StrictCoverage = false }()
+	_, err = client.Annotate(context.Background(), &annotatorpb.AnnotateRequest{
+		Ips:  []string{"1.2.3.4"},
+		Date: timestamppb.New(time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)),
+	})
+	if status.Code(err) != codes.OutOfRange {
+		t.Error("outside coverage: error =", err)
+	}
+}
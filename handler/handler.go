@@ -18,6 +18,7 @@ import (
 
 	"github.com/m-lab/annotation-service/api"
 	v2 "github.com/m-lab/annotation-service/api/v2"
+	"github.com/m-lab/annotation-service/directory"
 	"github.com/m-lab/annotation-service/geoloader"
 	"github.com/m-lab/annotation-service/manager"
 	"github.com/m-lab/annotation-service/metrics"
@@ -37,6 +38,13 @@ func InitHandler() {
 	// sets up any handlers that are needed
 	http.HandleFunc("/annotate", Annotate)
 	http.HandleFunc("/batch_annotate", BatchAnnotate)
+	http.HandleFunc("/stream_annotate", StreamAnnotate)
+	http.HandleFunc("/covering_prefixes", CoveringPrefixes)
+	http.HandleFunc("/v3/asn/", ASNPrefixes)
+	http.HandleFunc("/annotate_range", AnnotateRange)
+	http.HandleFunc("/admin/diff", Diff)
+	http.HandleFunc("/v2/datasets", Datasets)
+	http.HandleFunc("/admin/directory", DirectoryAdmin)
 }
 
 // Annotate is a URL handler that looks up IP address and puts
@@ -160,7 +168,7 @@ func AnnotateLegacy(date time.Time, ips []api.RequestData) (map[string]*api.GeoD
 			requestIP = Ip6to4(request.IP)
 		}
 		err := ann.Annotate(requestIP, &data)
-		if err != nil {
+		if err != nil && !isPartial(err) {
 			// TODO need better error handling.
 			continue
 		}
@@ -189,12 +197,27 @@ func Ip6to4(ipv6 string) string {
 	return fmt.Sprintf("%d.%d.%d.%d", ip[2], ip[3], ip[4], ip[5])
 }
 
+// selection returns the directory.Selection of the geo and ASN policies of a
+// v2 request, or an error wrapping directory.ErrBadPolicy.
+func selection(geoPolicy, asnPolicy string) (directory.Selection, error) {
+	geo, err := directory.ParsePolicy(geoPolicy)
+	if err != nil {
+		return nil, err
+	}
+	asn, err := directory.ParsePolicy(asnPolicy)
+	if err != nil {
+		return nil, err
+	}
+	return directory.Selection{manager.GeoRole: geo, manager.ASNRole: asn}, nil
+}
+
 // AnnotateV2 finds an appropriate Annotator based on the requested Date, and creates a
-// response with annotations for all parseable IPs.
-func AnnotateV2(date time.Time, ips []string, reqInfo string) (v2.Response, error) {
+// response with annotations for all parseable IPs.  The datasets are selected with the
+// policies of sel, which may be nil.
+func AnnotateV2(date time.Time, sel directory.Selection, ips []string, reqInfo string) (v2.Response, error) {
 	responseMap := make(map[string]*api.GeoData, len(ips))
 
-	ann, err := manager.GetAnnotator(date)
+	ann, err := manager.SelectAnnotator(date, sel)
 	if err != nil {
 		return v2.Response{}, err
 	}
@@ -203,43 +226,143 @@ func AnnotateV2(date time.Time, ips []string, reqInfo string) (v2.Response, erro
 		return v2.Response{}, errNoAnnotator
 	}
 
+	status := make(map[string]string, len(ips))
 	for i := range ips {
-		metrics.TotalLookups.Inc()
+		annotation, err := annotateIP(ann, ips[i])
+		status[ips[i]] = api.Status(err)
+		if annotation == nil {
+			continue
+		}
+		responseMap[ips[i]] = annotation
+	}
+	coverage := manager.Coverage(ann, date)
+	return v2.Response{AnnotatorDate: ann.AnnotatorDate(), Annotations: responseMap, Provenance: ann.Provenance(),
+		Missing: directory.Missing(ann), Stale: coverage.Stale, Extrapolated: coverage.Extrapolated, Status: status}, nil
+}
+
+// AnnotateMultiDate annotates each IP with the annotator for its own date, selected
+// with the policies of sel, which may be nil.  The IPs are grouped by the annotator
+// selected for their dates, and annotated one group at a time.  The results are in
+// the same order as ips.  When no annotator can be used for a date, e.g. outside
+// the coverage of the datasets in strict mode, the error is reported in the
+// results of its IPs, and the other dates are still annotated.
+func AnnotateMultiDate(ips []v2.StreamRecord, sel directory.Selection, reqInfo string) (v2.MultiDateResponse, error) {
+	type group struct {
+		ann     api.Annotator
+		err     error // Why there is no annotator for the dates of the group
+		indices []int
+	}
+	groups := []*group{}
+	byAnnotator := map[string]*group{} // Keyed by the datasets used, as the annotators are not comparable.
+	byDate := map[int64]*group{}       // Keyed by request date, to avoid repeated lookups.
+	coverage := map[int64]directory.Coverage{}
 
-		annotation := api.GeoData{}
-		// special handling of "2002:" ip address
-		requestIP := ips[i]
-		if strings.HasPrefix(ips[i], "2002:") {
-			requestIP = Ip6to4(ips[i])
+	for i := range ips {
+		g, ok := byDate[ips[i].Date.UnixNano()]
+		if !ok {
+			ann, err := manager.SelectAnnotator(ips[i].Date, sel)
+			if err == manager.ErrDirectoryIsNil || err == directory.ErrEmptyDirectory {
+				// No date can be annotated.
+				return v2.MultiDateResponse{}, err
+			}
+			if err == nil && ann == nil {
+				err = errNoAnnotator
+			}
+			if err != nil {
+				g = &group{err: err}
+				groups = append(groups, g)
+				byDate[ips[i].Date.UnixNano()] = g
+				g.indices = append(g.indices, i)
+				continue
+			}
+			key := fmt.Sprint(ann.Provenance())
+			g, ok = byAnnotator[key]
+			if !ok {
+				g = &group{ann: ann}
+				byAnnotator[key] = g
+				groups = append(groups, g)
+			}
+			byDate[ips[i].Date.UnixNano()] = g
+			coverage[ips[i].Date.UnixNano()] = manager.Coverage(ann, ips[i].Date)
 		}
-		err := ann.Annotate(requestIP, &annotation)
-		if err != nil {
-			switch err.Error {
-			// TODO - enumerate interesting error types here...
-			// Consider testing for an error subtype, rather than enumerating every error.
-			default:
-				// This collapses all other error types into a single error, to avoid excessive
-				// time serices if there are variable error strings.
-				metrics.ErrorTotal.WithLabelValues("Annotate Error").Inc()
-
-				// We are trying to debug error propagation.  So logging errors here to help with that.
-				v2errorLogger.Println(err)
+		g.indices = append(g.indices, i)
+	}
+
+	results := make([]v2.StreamResult, len(ips))
+	for _, g := range groups {
+		if g.err != nil {
+			for _, i := range g.indices {
+				results[i].IP = ips[i].IP
+				results[i].Status = api.Status(g.err)
+				results[i].Error = g.err.Error()
 			}
 			continue
 		}
-		if annotation.Geo == nil {
-			annotation.Geo = &api.GeolocationIP{
-				Missing: true,
+		date := g.ann.AnnotatorDate()
+		missing := directory.Missing(g.ann)
+		for _, i := range g.indices {
+			results[i].IP = ips[i].IP
+			results[i].AnnotatorDate = date
+			results[i].Missing = missing
+			c := coverage[ips[i].Date.UnixNano()]
+			results[i].Stale, results[i].Extrapolated = c.Stale, c.Extrapolated
+			annotation, err := annotateIP(g.ann, ips[i].IP)
+			results[i].Status = api.Status(err)
+			if annotation == nil {
+				results[i].Error = err.Error()
+				continue
 			}
+			results[i].Annotation = annotation
 		}
-		if annotation.Network == nil {
-			annotation.Network = &api.ASData{
-				Missing: true,
-			}
+	}
+	return v2.MultiDateResponse{Results: results}, nil
+}
+
+// annotateIP annotates a single IP with ann, and sets Missing on the empty parts
+// of the annotation.  If some of the annotators in a composite annotator fail,
+// it returns both the annotation and the *api.CompositeError.  For any other
+// error, the annotation is nil.
+func annotateIP(ann api.Annotator, ip string) (*api.GeoData, error) {
+	metrics.TotalLookups.Inc()
+
+	annotation := api.GeoData{}
+	// special handling of "2002:" ip address
+	requestIP := ip
+	if strings.HasPrefix(ip, "2002:") {
+		requestIP = Ip6to4(ip)
+	}
+	err := ann.Annotate(requestIP, &annotation)
+	status := api.Status(err)
+	metrics.AnnotationStatus.WithLabelValues(status).Inc()
+	if status == api.StatusError {
+		// This collapses all other error types into a single error, to avoid excessive
+		// time serices if there are variable error strings.
+		metrics.ErrorTotal.WithLabelValues("Annotate Error").Inc()
+
+		// We are trying to debug error propagation.  So logging errors here to help with that.
+		v2errorLogger.Println(err)
+	}
+	if err != nil && !isPartial(err) {
+		return nil, err
+	}
+	if annotation.Geo == nil {
+		annotation.Geo = &api.GeolocationIP{
+			Missing: true,
 		}
-		responseMap[ips[i]] = &annotation
 	}
-	return v2.Response{AnnotatorDate: ann.AnnotatorDate(), Annotations: responseMap}, nil
+	if annotation.Network == nil {
+		annotation.Network = &api.ASData{
+			Missing: true,
+		}
+	}
+	return &annotation, err
+}
+
+// isPartial returns true if err is from a composite annotator, which still
+// populated the annotations of the annotators that did not fail.
+func isPartial(err error) bool {
+	_, ok := err.(*api.CompositeError)
+	return ok
 }
 
 // BatchAnnotate is a URL handler that expects the body of the request
@@ -285,7 +408,14 @@ func latencyStats(source string, label string, count int, tStart time.Time) {
 // TODO - is this now obsolete?
 func checkError(err error, w http.ResponseWriter, reqInfo string, ipCount int, label string, tStart time.Time) bool {
 	if err != nil {
-		switch err {
+		switch {
+		case errors.Is(err, manager.ErrOutsideCoverage):
+			// The client should not retry with the same date.
+			w.WriteHeader(http.StatusBadRequest)
+			metrics.RequestTimeHistogramUsec.WithLabelValues(reqInfo, label, "outside coverage").Observe(float64(time.Since(tStart).Nanoseconds()) / 1000)
+		case errors.Is(err, directory.ErrBadPolicy):
+			w.WriteHeader(http.StatusBadRequest)
+			metrics.RequestTimeHistogramUsec.WithLabelValues(reqInfo, label, "invalid policy").Observe(float64(time.Since(tStart).Nanoseconds()) / 1000)
 		default:
 			// If it isn't loading, client should probably give up instead of retrying.
 			w.WriteHeader(http.StatusInternalServerError)
@@ -397,7 +527,11 @@ func handleV2(w http.ResponseWriter, tStart time.Time, jsonBuffer []byte) {
 				requestIPs[i] = Ip6to4(request.IPs[i])
 			}
 		}
-		response, err = AnnotateV2(request.Date, requestIPs, request.RequestInfo)
+		var sel directory.Selection
+		sel, err = selection(request.GeoPolicy, request.ASNPolicy)
+		if err == nil {
+			response, err = AnnotateV2(request.Date, sel, requestIPs, request.RequestInfo)
+		}
 		if checkError(err, w, request.RequestInfo, len(request.IPs), "v2", tStart) {
 			return
 		}
@@ -420,6 +554,35 @@ func handleV2(w http.ResponseWriter, tStart time.Time, jsonBuffer []byte) {
 	}
 }
 
+func handleMultiDate(w http.ResponseWriter, tStart time.Time, jsonBuffer []byte) {
+	request := v2.MultiDateRequest{}
+
+	err := json.Unmarshal(jsonBuffer, &request)
+	if checkError(err, w, request.RequestInfo, 0, "v2-multidate", tStart) {
+		return
+	}
+
+	sel, err := selection(request.GeoPolicy, request.ASNPolicy)
+	if checkError(err, w, request.RequestInfo, 0, "v2-multidate", tStart) {
+		return
+	}
+	response, err := AnnotateMultiDate(request.IPs, sel, request.RequestInfo)
+	if checkError(err, w, request.RequestInfo, len(request.IPs), "v2-multidate", tStart) {
+		return
+	}
+	for i := range response.Results {
+		if response.Results[i].Annotation != nil {
+			trackMissingResponses(response.Results[i].Annotation)
+		}
+	}
+	encodedResult, err := json.Marshal(response)
+	if checkError(err, w, request.RequestInfo, len(request.IPs), "v2-multidate", tStart) {
+		return
+	}
+	fmt.Fprint(w, string(encodedResult))
+	latencyStats(request.RequestInfo, "v2-multidate", len(request.IPs), tStart)
+}
+
 func handleNewOrOld(w http.ResponseWriter, tStart time.Time, jsonBuffer []byte) {
 	// Check API version of the request
 	wrapper := api.RequestWrapper{}
@@ -430,6 +593,8 @@ func handleNewOrOld(w http.ResponseWriter, tStart time.Time, jsonBuffer []byte)
 		switch wrapper.RequestType {
 		case v2.RequestTag:
 			handleV2(w, tStart, jsonBuffer)
+		case v2.MultiDateRequestTag:
+			handleMultiDate(w, tStart, jsonBuffer)
 		default:
 			if checkError(errors.New("Unknown Request Type"), w, "newOrOld", 0, "", tStart) {
 				return
@@ -481,5 +646,9 @@ func GetMetadataForSingleIP(request *api.RequestData) (result api.GeoData, err e
 		requestIP = Ip6to4(request.IP)
 	}
 	err = ann.Annotate(requestIP, &result)
+	if isPartial(err) {
+		// Whatever could be annotated is returned.
+		err = nil
+	}
 	return
 }
@@ -2,6 +2,7 @@ package handler_test
 
 import (
 	"bytes"
+	"encoding/json"
 	"errors"
 	"io"
 	"io/ioutil"
@@ -20,6 +21,7 @@ import (
 
 	"github.com/go-test/deep"
 	"github.com/m-lab/annotation-service/api"
+	v2 "github.com/m-lab/annotation-service/api/v2"
 	"github.com/m-lab/annotation-service/handler"
 	"github.com/m-lab/annotation-service/manager"
 )
@@ -71,9 +73,8 @@ func TestAnnotate(t *testing.T) {
 		},
 	}
 	// TODO - make and use an annotator generator
-	ann := &geolite2v2.GeoDataset{
-		Start: time.Now().Truncate(24 * time.Hour),
-		IP4Nodes: []geolite2v2.GeoIPNode{
+	ann := geolite2v2.NewGeoDataset(
+		[]geolite2v2.GeoIPNode{
 			{
 				BaseIPNode: iputils.BaseIPNode{
 					IPAddressLow:  net.IPv4(0, 0, 0, 0),
@@ -85,7 +86,7 @@ func TestAnnotate(t *testing.T) {
 				Longitude:     -73.1,
 			},
 		},
-		IP6Nodes: []geolite2v2.GeoIPNode{
+		[]geolite2v2.GeoIPNode{
 			{
 				BaseIPNode: iputils.BaseIPNode{
 					IPAddressLow:  net.IP{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
@@ -97,14 +98,15 @@ func TestAnnotate(t *testing.T) {
 				Longitude:     -73.1,
 			},
 		},
-		LocationNodes: []geolite2v2.LocationNode{
+		[]geolite2v2.LocationNode{
 			{
 				CityName:            "Not A Real City",
 				RegionCode:          "ME",
 				Subdivision1ISOCode: "ME",
 			},
 		},
-	}
+	)
+	ann.Start = time.Now().Truncate(24 * time.Hour)
 
 	for _, test := range tests {
 		manager.SetDirectory([]api.Annotator{ann})
@@ -257,19 +259,18 @@ func TestBatchAnnotate(t *testing.T) {
 		{
 			// Do not use directory composit annotator to generate an annotation error and return empty result.
 			body: `{"RequestType": "Annotate v2.0", "Date": "2013-10-01T00:00:00Z", "IPs": ["227.86.65.1"]}`,
-			res:  `{"AnnotatorDate":"2020-01-01T00:00:00Z","Annotations":{}}`,
+			res:  `{"AnnotatorDate":"2020-01-01T00:00:00Z","Annotations":{},"Provenance":[{"Type":"geolite2","Name":"Maxmind/2020/01/01/20200101T000000Z-GeoLite2-City-CSV.zip","Date":"2020-01-01T00:00:00Z"}],"Extrapolated":["geolite2"],"Status":{"227.86.65.1":"not-found"}}`,
 		},
 		{
 			// Use directory composit annotator to generate missing annotation values.
 			body:   `{"RequestType": "Annotate v2.0", "Date": "2013-10-01T00:00:00Z", "IPs": ["227.86.65.1"]}`,
-			res:    `{"AnnotatorDate":"2020-01-01T00:00:00Z","Annotations":{"227.86.65.1":{"Geo":{"Missing":true},"Network":{"Missing":true}}}}`,
+			res:    `{"AnnotatorDate":"2020-01-01T00:00:00Z","Annotations":{"227.86.65.1":{"Geo":{"Missing":true},"Network":{"Missing":true}}},"Provenance":[{"Type":"geolite2","Name":"Maxmind/2020/01/01/20200101T000000Z-GeoLite2-City-CSV.zip","Date":"2020-01-01T00:00:00Z"}],"Extrapolated":["geolite2"],"Status":{"227.86.65.1":"not-found"}}`,
 			useDir: true,
 		},
 	}
 	// TODO - make a test utility in geolite2 package.
-	ann := &geolite2v2.GeoDataset{
-		Start: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
-		IP4Nodes: []geolite2v2.GeoIPNode{
+	ann := geolite2v2.NewGeoDataset(
+		[]geolite2v2.GeoIPNode{
 			{
 				BaseIPNode: iputils.BaseIPNode{
 					IPAddressLow:  net.IPv4(0, 0, 0, 0),
@@ -279,7 +280,7 @@ func TestBatchAnnotate(t *testing.T) {
 				PostalCode:    "10583",
 			},
 		},
-		IP6Nodes: []geolite2v2.GeoIPNode{
+		[]geolite2v2.GeoIPNode{
 			{
 				BaseIPNode: iputils.BaseIPNode{
 					IPAddressLow:  net.IP{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
@@ -289,14 +290,16 @@ func TestBatchAnnotate(t *testing.T) {
 				PostalCode:    "10583",
 			},
 		},
-		LocationNodes: []geolite2v2.LocationNode{
+		[]geolite2v2.LocationNode{
 			{
 				CityName:            "Not A Real City",
 				RegionCode:          "ME",
 				Subdivision1ISOCode: "ME",
 			},
 		},
-	}
+	)
+	ann.Start = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	ann.Name = "Maxmind/2020/01/01/20200101T000000Z-GeoLite2-City-CSV.zip"
 	for _, test := range tests {
 		manager.SetDirectory([]api.Annotator{ann})
 		if test.useDir {
@@ -328,9 +331,8 @@ func TestGetMetadataForSingleIP(t *testing.T) {
 				Network: nil},
 		},
 	}
-	ann := &geolite2v2.GeoDataset{
-		Start: time.Now().Truncate(24 * time.Hour),
-		IP4Nodes: []geolite2v2.GeoIPNode{
+	ann := geolite2v2.NewGeoDataset(
+		[]geolite2v2.GeoIPNode{
 			{
 				BaseIPNode: iputils.BaseIPNode{
 					IPAddressLow:  net.IPv4(0, 0, 0, 0),
@@ -340,7 +342,7 @@ func TestGetMetadataForSingleIP(t *testing.T) {
 				PostalCode:    "10583",
 			},
 		},
-		IP6Nodes: []geolite2v2.GeoIPNode{
+		[]geolite2v2.GeoIPNode{
 			{
 				BaseIPNode: iputils.BaseIPNode{
 					IPAddressLow:  net.IP{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
@@ -350,12 +352,13 @@ func TestGetMetadataForSingleIP(t *testing.T) {
 				PostalCode:    "10583",
 			},
 		},
-		LocationNodes: []geolite2v2.LocationNode{
+		[]geolite2v2.LocationNode{
 			{
 				CityName: "Not A Real City",
 			},
 		},
-	}
+	)
+	ann.Start = time.Now().Truncate(24 * time.Hour)
 	manager.SetDirectory([]api.Annotator{ann})
 	for _, test := range tests {
 		res, _ := handler.GetMetadataForSingleIP(test.req)
@@ -364,3 +367,112 @@ func TestGetMetadataForSingleIP(t *testing.T) {
 		}
 	}
 }
+
+// cityDataset returns a dataset that annotates 0.0.0.0/1 with the city.
+func cityDataset(city string, start time.Time) api.Annotator {
+	ann := geolite2v2.NewGeoDataset(
+		[]geolite2v2.GeoIPNode{
+			{
+				BaseIPNode: iputils.BaseIPNode{
+					IPAddressLow:  net.IPv4(0, 0, 0, 0),
+					IPAddressHigh: net.IPv4(127, 255, 255, 255),
+				},
+				LocationIndex: 0,
+			},
+		},
+		nil,
+		[]geolite2v2.LocationNode{{CityName: city}},
+	)
+	ann.Start = start
+	return directory.NewCompositeAnnotator([]api.Annotator{ann})
+}
+
+func TestBatchAnnotateMultiDate(t *testing.T) {
+	manager.SetDirectory([]api.Annotator{
+		cityDataset("Old City", time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)),
+		cityDataset("New City", time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)),
+	})
+	body := `{"RequestType": "Annotate v2.0 multi-date", "IPs": [
+		{"IP": "1.2.3.4", "Date": "2020-03-01T00:00:00Z"},
+		{"IP": "1.2.3.4", "Date": "2019-03-01T00:00:00Z"},
+		{"IP": "227.86.65.1", "Date": "2019-06-01T12:00:00Z"},
+		{"IP": "5.6.7.8", "Date": "2020-06-01T00:00:00Z"}]}`
+	res := `{"Results":[` +
+		`{"IP":"1.2.3.4","AnnotatorDate":"2020-01-01T00:00:00Z","Annotation":{"Geo":{"city":"New City"},"Network":{"Missing":true}},"Status":"ok"},` +
+		`{"IP":"1.2.3.4","AnnotatorDate":"2019-01-01T00:00:00Z","Annotation":{"Geo":{"city":"Old City"},"Network":{"Missing":true}},"Status":"ok"},` +
+		`{"IP":"227.86.65.1","AnnotatorDate":"2019-01-01T00:00:00Z","Annotation":{"Geo":{"Missing":true},"Network":{"Missing":true}},"Status":"not-found"},` +
+		`{"IP":"5.6.7.8","AnnotatorDate":"2020-01-01T00:00:00Z","Annotation":{"Geo":{"city":"New City"},"Network":{"Missing":true}},"Status":"ok"}]}`
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/batch_annotate", strings.NewReader(body))
+	handler.BatchAnnotate(w, r)
+	if w.Body.String() != res {
+		t.Errorf("\nGot\n__%s__\nexpected\n__%s__\n", w.Body.String(), res)
+	}
+
+	manager.SetDirectory([]api.Annotator{})
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest("POST", "/batch_annotate", strings.NewReader(body))
+	handler.BatchAnnotate(w, r)
+	if w.Code != http.StatusInternalServerError {
+		t.Error("empty directory: status =", w.Code)
+	}
+}
+
+func TestAnnotateV2Missing(t *testing.T) {
+	march := time.Date(2018, 3, 1, 0, 0, 0, 0, time.UTC)
+	manager.SetDirectory(directory.MergeSources(
+		directory.Source{Name: "geo", Annotators: []api.Annotator{cityDataset("Boston", march)}},
+		directory.Source{Name: "asn"}))
+	resp, err := handler.AnnotateV2(march.AddDate(0, 1, 0), nil, []string{"1.2.3.4"}, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Missing) != 1 || resp.Missing[0] != "asn" || resp.Annotations["1.2.3.4"].Geo.City != "Boston" {
+		t.Errorf("AnnotateV2() = %+v", resp)
+	}
+}
+
+func TestBatchAnnotateStrictCoverage(t *testing.T) {
+	manager.SetDirectory([]api.Annotator{cityDataset("Boston", time.Date(2018, 3, 1, 0, 0, 0, 0, time.UTC))})
+	manager.StrictCoverage = true
+	defer func() { manager.StrictCoverage = false }()
+	for date, want := range map[string]int{"2018-01-01": http.StatusBadRequest, "2018-04-01": http.StatusOK} {
+		body := `{"RequestType": "Annotate v2.0", "Date": "` + date + `T00:00:00Z", "IPs": ["1.2.3.4"]}`
+		w := httptest.NewRecorder()
+		handler.BatchAnnotate(w, httptest.NewRequest("POST", "/batch_annotate", strings.NewReader(body)))
+		if w.Code != want {
+			t.Errorf("%s: code = %d, want %d: %s", date, w.Code, want, w.Body.String())
+		}
+	}
+	// Multi-date requests report the error for the IPs of that date only.
+	body := `{"RequestType": "Annotate v2.0 multi-date", "IPs": [
+		{"IP": "1.2.3.4", "Date": "2018-01-01T00:00:00Z"},
+		{"IP": "1.2.3.4", "Date": "2018-04-01T00:00:00Z"}]}`
+	w := httptest.NewRecorder()
+	handler.BatchAnnotate(w, httptest.NewRequest("POST", "/batch_annotate", strings.NewReader(body)))
+	resp := v2.MultiDateResponse{}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || w.Code != http.StatusOK || len(resp.Results) != 2 {
+		t.Fatalf("multi-date: code = %d: %s", w.Code, w.Body.String())
+	}
+	if r := resp.Results[0]; r.Annotation != nil || r.Status != api.StatusError || !strings.Contains(r.Error, "outside the validity") {
+		t.Errorf("multi-date: outside coverage result = %+v", r)
+	}
+	if r := resp.Results[1]; r.Annotation == nil || r.Status != api.StatusOK {
+		t.Errorf("multi-date: covered result = %+v", r)
+	}
+}
+
+func TestBatchAnnotateBadPolicy(t *testing.T) {
+	manager.SetDirectory([]api.Annotator{cityDataset("Boston", time.Date(2018, 3, 1, 0, 0, 0, 0, time.UTC))})
+	for _, body := range []string{
+		`{"RequestType": "Annotate v2.0", "Date": "2018-04-01T00:00:00Z", "IPs": ["1.2.3.4"], "GeoPolicy": "latest"}`,
+		`{"RequestType": "Annotate v2.0 multi-date", "IPs": [{"IP": "1.2.3.4", "Date": "2018-04-01T00:00:00Z"}], "ASNPolicy": "closest"}`,
+	} {
+		w := httptest.NewRecorder()
+		handler.BatchAnnotate(w, httptest.NewRequest("POST", "/batch_annotate", strings.NewReader(body)))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("code = %d, want %d: %s", w.Code, http.StatusBadRequest, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package handler
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"strings"
+	"time"
+)
+
+var errNoHijack = errors.New("Streaming requests need HTTP/2 or a connection that can be hijacked")
+
+// hijackStream takes over the connection of an HTTP/1.x request, so that the
+// request body can be read while the response is written, without the server
+// getting in the way.  It sends the 200 status line and the headers of w, and
+// returns the request body and the response body, which is chunked for
+// HTTP/1.1.  Closing the response body closes the connection.
+func hijackStream(w http.ResponseWriter, r *http.Request) (io.Reader, streamResponse, error) {
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		return nil, nil, errNoHijack
+	}
+	conn, buf, err := hj.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	// The server deadlines no longer apply.
+	conn.SetDeadline(time.Time{})
+
+	// The server has not read the body yet, so it is all in buf, or still
+	// to come on conn.
+	var body io.Reader = io.LimitReader(buf.Reader, r.ContentLength)
+	if len(r.TransferEncoding) > 0 && r.TransferEncoding[0] == "chunked" {
+		body = httputil.NewChunkedReader(buf.Reader)
+	}
+	if r.ProtoAtLeast(1, 1) && strings.EqualFold(r.Header.Get("Expect"), "100-continue") {
+		buf.WriteString("HTTP/1.1 100 Continue\r\n\r\n")
+	}
+
+	resp := &hijackedResponse{conn: conn, buf: buf.Writer, body: nopCloser{buf.Writer}}
+	header := w.Header().Clone()
+	header.Set("Connection", "close")
+	if r.ProtoAtLeast(1, 1) {
+		header.Set("Transfer-Encoding", "chunked")
+		resp.body = httputil.NewChunkedWriter(buf.Writer)
+	}
+	fmt.Fprintf(buf, "HTTP/%d.%d 200 OK\r\n", r.ProtoMajor, r.ProtoMinor)
+	header.Write(buf)
+	buf.WriteString("\r\n")
+	// Write errors are reported by the response body, as for a client that
+	// goes away later.
+	buf.Flush()
+	return body, resp, nil
+}
+
+// hijackedResponse is the streamResponse of a hijacked connection.
+type hijackedResponse struct {
+	conn net.Conn
+	buf  *bufio.Writer
+	body io.WriteCloser
+}
+
+func (h *hijackedResponse) Write(p []byte) (int, error) {
+	return h.body.Write(p)
+}
+
+func (h *hijackedResponse) Flush() {
+	h.buf.Flush()
+}
+
+// Close ends the response body, and closes the connection.
+func (h *hijackedResponse) Close() error {
+	h.body.Close()
+	if _, ok := h.body.(nopCloser); !ok {
+		// The empty trailer of the chunked body.
+		h.buf.WriteString("\r\n")
+	}
+	h.buf.Flush()
+	return h.conn.Close()
+}
+
+// nopCloser is an io.WriteCloser whose Close does nothing.
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
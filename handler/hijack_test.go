@@ -0,0 +1,86 @@
+package handler
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// echo streams back the lines of the request body, in upper case.
+func echo(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain")
+	body, out, err := hijackStream(w, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer out.Close()
+	lines := bufio.NewScanner(body)
+	for lines.Scan() {
+		fmt.Fprintln(out, strings.ToUpper(lines.Text()))
+		out.Flush()
+	}
+}
+
+func TestHijackStream(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(echo))
+	defer srv.Close()
+
+	// A chunked body, with each line echoed before the next one is sent.
+	body, bodyWriter := io.Pipe()
+	respc := make(chan *http.Response)
+	go func() {
+		resp, err := http.Post(srv.URL, "text/plain", body)
+		if err != nil {
+			t.Error(err)
+			close(respc)
+			return
+		}
+		respc <- resp
+	}()
+	fmt.Fprintln(bodyWriter, "first")
+	resp, ok := <-respc
+	if !ok {
+		t.FailNow()
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/plain" {
+		t.Errorf("response = %d %v", resp.StatusCode, resp.Header)
+	}
+	lines := bufio.NewScanner(resp.Body)
+	for _, s := range []string{"first", "second", "third"} {
+		if s != "first" {
+			fmt.Fprintln(bodyWriter, s)
+		}
+		if !lines.Scan() || lines.Text() != strings.ToUpper(s) {
+			t.Fatalf("got %q for %q: %v", lines.Text(), s, lines.Err())
+		}
+	}
+	bodyWriter.Close()
+	if lines.Scan() {
+		t.Error("unexpected line", lines.Text())
+	}
+
+	// A body with a Content-Length, after a 100 Continue.
+	client := &http.Client{Transport: &http.Transport{ExpectContinueTimeout: time.Minute}}
+	req, err := http.NewRequest("POST", srv.URL, strings.NewReader("a\nb\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Expect", "100-continue")
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil || string(b) != "A\nB\n" {
+		t.Errorf("got %q, %v", b, err)
+	}
+}
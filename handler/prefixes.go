@@ -0,0 +1,164 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/m-lab/annotation-service/api"
+	v2 "github.com/m-lab/annotation-service/api/v2"
+	v3 "github.com/m-lab/annotation-service/api/v3"
+	"github.com/m-lab/annotation-service/manager"
+	"github.com/m-lab/annotation-service/metrics"
+)
+
+// errNoPrefixes is returned when the annotator for a date does not keep
+// routing prefixes.
+var errNoPrefixes = api.NewError("no routing prefixes for this date", api.ErrNotApplicable)
+
+// httpStatus returns the HTTP status code for an annotator error.
+func httpStatus(err error) int {
+	switch {
+	case errors.Is(err, api.ErrInvalidIP), errors.Is(err, manager.ErrOutsideCoverage):
+		return http.StatusBadRequest
+	case errors.Is(err, api.ErrNotFound), errors.Is(err, api.ErrNotApplicable):
+		return http.StatusNotFound
+	case errors.Is(err, api.ErrNotLoaded):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// CoveringPrefixes is a URL handler that returns all the RouteViews prefixes
+// covering an IP address, with their origin AS sets, as a v2.PrefixesResponse.
+// It takes the same since_epoch and ip_addr parameters as Annotate.
+func CoveringPrefixes(w http.ResponseWriter, r *http.Request) {
+	tStart := time.Now()
+	defer func(t time.Time) {
+		metrics.RequestTimes.Observe(float64(time.Since(t).Nanoseconds()))
+	}(tStart)
+	metrics.ActiveRequests.Inc()
+	metrics.TotalRequests.Inc()
+	defer metrics.ActiveRequests.Dec()
+
+	data, err := ValidateAndParse(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ann, err := manager.GetAnnotator(data.Timestamp)
+	if checkError(err, w, "", 1, "prefixes", tStart) {
+		return
+	}
+	pa, ok := ann.(api.PrefixAnnotator)
+	if !ok {
+		http.Error(w, errNoPrefixes.Error(), httpStatus(errNoPrefixes))
+		return
+	}
+	prefixes, err := pa.CoveringPrefixes(data.IP)
+	if err != nil {
+		http.Error(w, err.Error(), httpStatus(err))
+		metrics.RequestTimeHistogramUsec.WithLabelValues("unknown", "prefixes", api.Status(err)).Observe(float64(time.Since(tStart).Nanoseconds()) / 1000)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(v2.PrefixesResponse{AnnotatorDate: ann.AnnotatorDate(), IP: data.IP, Prefixes: prefixes})
+	if err != nil {
+		return
+	}
+	metrics.RequestTimeHistogramUsec.WithLabelValues("unknown", "prefixes", "success").Observe(float64(time.Since(tStart).Nanoseconds()) / 1000)
+}
+
+// parseASNPath returns the AS number of a /v3/asn/{asn}/prefixes path.  The
+// number may have an "AS" prefix.
+func parseASNPath(path string) (uint32, error) {
+	parts := strings.Split(strings.TrimPrefix(path, "/v3/asn/"), "/")
+	if len(parts) != 2 || parts[1] != "prefixes" {
+		return 0, errors.New("path should be /v3/asn/{asn}/prefixes")
+	}
+	number, err := strconv.ParseUint(strings.TrimPrefix(strings.ToUpper(parts[0]), "AS"), 10, 32)
+	if err != nil {
+		return 0, errors.New("invalid AS number")
+	}
+	return uint32(number), nil
+}
+
+// parseDate parses the date parameter of v3 requests, as a day, e.g.
+// 2019-03-01, or an RFC3339 time.  The current time is used if it is empty.
+func parseDate(value string) (time.Time, error) {
+	if value == "" {
+		return time.Now(), nil
+	}
+	if date, err := time.Parse("2006-01-02", value); err == nil {
+		return date, nil
+	}
+	date, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, errors.New("invalid date")
+	}
+	return date, nil
+}
+
+// ASNPrefixes is a URL handler for /v3/asn/{asn}/prefixes?date=, which returns
+// all the RouteViews prefixes originated by the AS, as a
+// v3.ASNPrefixesResponse, using the annotator for the date.
+func ASNPrefixes(w http.ResponseWriter, r *http.Request) {
+	tStart := time.Now()
+	defer func(t time.Time) {
+		metrics.RequestTimes.Observe(float64(time.Since(t).Nanoseconds()))
+	}(tStart)
+	metrics.ActiveRequests.Inc()
+	metrics.TotalRequests.Inc()
+	defer metrics.ActiveRequests.Dec()
+
+	number, err := parseASNPath(r.URL.Path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	date, err := parseDate(r.URL.Query().Get("date"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ann, err := manager.GetAnnotator(date)
+	if checkError(err, w, "", 0, "asn-prefixes", tStart) {
+		return
+	}
+	pa, ok := ann.(api.PrefixAnnotator)
+	if !ok {
+		http.Error(w, errNoPrefixes.Error(), httpStatus(errNoPrefixes))
+		return
+	}
+	prefixes, err := pa.OriginPrefixes(number)
+	if err != nil {
+		http.Error(w, err.Error(), httpStatus(err))
+		metrics.RequestTimeHistogramUsec.WithLabelValues("unknown", "asn-prefixes", api.Status(err)).Observe(float64(time.Since(tStart).Nanoseconds()) / 1000)
+		return
+	}
+
+	resp := v3.ASNPrefixesResponse{ASN: number, AnnotatorDate: ann.AnnotatorDate(), Prefixes: make([]v3.OriginPrefix, len(prefixes))}
+	for _, p := range ann.Provenance() {
+		if p.Type == api.RouteViewsType {
+			resp.Provenance = append(resp.Provenance, p)
+		}
+	}
+	for i := range prefixes {
+		resp.Prefixes[i] = v3.OriginPrefix{
+			CIDR:    prefixes[i].CIDR,
+			Systems: prefixes[i].Systems,
+			MOAS:    len(prefixes[i].Systems) > 1,
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(resp)
+	if err != nil {
+		return
+	}
+	metrics.RequestTimeHistogramUsec.WithLabelValues("unknown", "asn-prefixes", "success").Observe(float64(time.Since(tStart).Nanoseconds()) / 1000)
+}
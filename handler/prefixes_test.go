@@ -0,0 +1,159 @@
+package handler_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+
+	"github.com/m-lab/annotation-service/api"
+	v2 "github.com/m-lab/annotation-service/api/v2"
+	v3 "github.com/m-lab/annotation-service/api/v3"
+	"github.com/m-lab/annotation-service/asn"
+	"github.com/m-lab/annotation-service/directory"
+	"github.com/m-lab/annotation-service/handler"
+	"github.com/m-lab/annotation-service/manager"
+)
+
+func TestCoveringPrefixes(t *testing.T) {
+	ann, err := asn.LoadASNDatasetFromReader(strings.NewReader(
+		"1.0.0.0\t8\t100\n1.0.4.0\t22\t200_300\n1.0.5.0\t24\t400\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rv := ann.(*asn.ASNDataset)
+	rv.Start = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	g2asn := asn.NewASNDataset(nil)
+	g2asn.Start = rv.Start
+
+	tests := []struct {
+		name       string
+		annotators []api.Annotator
+		ip         string
+		wantCode   int
+		want       []string
+	}{
+		{
+			name:       "success",
+			annotators: []api.Annotator{directory.NewCompositeAnnotator([]api.Annotator{g2asn, rv})},
+			ip:         "1.0.5.1",
+			wantCode:   http.StatusOK,
+			want:       []string{"1.0.0.0/8", "1.0.4.0/22", "1.0.5.0/24"},
+		},
+		{
+			name:       "not-found",
+			annotators: []api.Annotator{rv},
+			ip:         "2.0.0.1",
+			wantCode:   http.StatusNotFound,
+		},
+		{
+			name:       "no-prefixes",
+			annotators: []api.Annotator{g2asn},
+			ip:         "1.0.5.1",
+			wantCode:   http.StatusNotFound,
+		},
+		{
+			name:       "invalid-ip",
+			annotators: []api.Annotator{rv},
+			ip:         "not an IP",
+			wantCode:   http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manager.SetDirectory(tt.annotators)
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("GET", "/covering_prefixes?since_epoch=1600000000&ip_addr="+url.QueryEscape(tt.ip), nil)
+			handler.CoveringPrefixes(w, r)
+			if w.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantCode, w.Body.String())
+			}
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+			resp := v2.PrefixesResponse{}
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if !resp.AnnotatorDate.Equal(rv.Start) || resp.IP != tt.ip {
+				t.Errorf("response = %+v", resp)
+			}
+			if len(resp.Prefixes) != len(tt.want) {
+				t.Fatalf("Prefixes = %+v, want %v", resp.Prefixes, tt.want)
+			}
+			for i := range tt.want {
+				if resp.Prefixes[i].CIDR != tt.want[i] {
+					t.Errorf("Prefixes[%d] = %+v, want %s", i, resp.Prefixes[i], tt.want[i])
+				}
+			}
+			if resp.Prefixes[1].ASNumber != 200 || len(resp.Prefixes[1].Systems) != 2 {
+				t.Errorf("Prefixes[1] = %+v", resp.Prefixes[1])
+			}
+		})
+	}
+}
+
+func TestASNPrefixes(t *testing.T) {
+	ann, err := asn.LoadASNDatasetFromReader(strings.NewReader(
+		"1.0.0.0\t8\t100\n1.0.4.0\t22\t200_100\n1.0.5.0\t24\t400\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rv := ann.(*asn.ASNDataset)
+	rv.Start = time.Date(2019, 3, 1, 0, 0, 0, 0, time.UTC)
+	rv.Type = api.RouteViewsType
+	rv.Name = "routeviews-rv2-20190301-1200.pfx2as.gz"
+	g2asn := asn.NewASNDataset(nil)
+	g2asn.Start = rv.Start
+	manager.SetDirectory([]api.Annotator{directory.NewCompositeAnnotator([]api.Annotator{rv, g2asn})})
+
+	tests := []struct {
+		path     string
+		wantCode int
+		want     []v3.OriginPrefix
+	}{
+		{
+			path:     "/v3/asn/100/prefixes?date=2019-03-15",
+			wantCode: http.StatusOK,
+			want: []v3.OriginPrefix{
+				{CIDR: "1.0.0.0/8", Systems: []api.System{{ASNs: []uint32{100}}}},
+				{CIDR: "1.0.4.0/22", Systems: []api.System{{ASNs: []uint32{200}}, {ASNs: []uint32{100}}}, MOAS: true},
+			},
+		},
+		{
+			path:     "/v3/asn/AS400/prefixes?date=2019-03-15T12:00:00Z",
+			wantCode: http.StatusOK,
+			want:     []v3.OriginPrefix{{CIDR: "1.0.5.0/24", Systems: []api.System{{ASNs: []uint32{400}}}}},
+		},
+		{path: "/v3/asn/500/prefixes?date=2019-03-15", wantCode: http.StatusNotFound},
+		{path: "/v3/asn/100/prefixes?date=March", wantCode: http.StatusBadRequest},
+		{path: "/v3/asn/x/prefixes", wantCode: http.StatusNotFound},
+		{path: "/v3/asn/100", wantCode: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		handler.ASNPrefixes(w, httptest.NewRequest("GET", tt.path, nil))
+		if w.Code != tt.wantCode {
+			t.Errorf("%s: status = %d, want %d: %s", tt.path, w.Code, tt.wantCode, w.Body.String())
+			continue
+		}
+		if tt.wantCode != http.StatusOK {
+			continue
+		}
+		resp := v3.ASNPrefixesResponse{}
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		if !resp.AnnotatorDate.Equal(rv.Start) || len(resp.Provenance) != 1 || resp.Provenance[0].Name != rv.Name {
+			t.Errorf("%s: response = %+v", tt.path, resp)
+		}
+		if diff := deep.Equal(resp.Prefixes, tt.want); diff != nil {
+			t.Errorf("%s: %v", tt.path, diff)
+		}
+	}
+}
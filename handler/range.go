@@ -0,0 +1,99 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/m-lab/annotation-service/api"
+	v2 "github.com/m-lab/annotation-service/api/v2"
+	"github.com/m-lab/annotation-service/directory"
+	"github.com/m-lab/annotation-service/manager"
+	"github.com/m-lab/annotation-service/metrics"
+)
+
+// The shortest prefixes accepted by AnnotateRange, which limit the size of
+// the responses.
+const (
+	minRangeBits4 = 8
+	minRangeBits6 = 32
+)
+
+var (
+	errNoRanges     = api.NewError("range queries are not supported for this date", api.ErrNotApplicable)
+	errRangeTooWide = errors.New("prefix is too wide for a range query")
+)
+
+// parseRangePrefix parses the cidr parameter of a range query, and checks that
+// it is not too wide.
+func parseRangePrefix(cidr string) (*net.IPNet, error) {
+	_, prefix, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, errors.New("invalid CIDR")
+	}
+	ones, bits := prefix.Mask.Size()
+	if (bits == 32 && ones < minRangeBits4) || (bits == 128 && ones < minRangeBits6) {
+		return nil, errRangeTooWide
+	}
+	return prefix, nil
+}
+
+// AnnotateRange is a URL handler that annotates all the addresses of a prefix
+// at once.  It takes the since_epoch and cidr parameters, and returns a
+// v2.RangeResponse with the ranges of the prefix that have different
+// annotations, clipped to the prefix.  Legacy and MMDB datasets don't
+// support range queries, so their annotations are missing.
+func AnnotateRange(w http.ResponseWriter, r *http.Request) {
+	tStart := time.Now()
+	defer func(t time.Time) {
+		metrics.RequestTimes.Observe(float64(time.Since(t).Nanoseconds()))
+	}(tStart)
+	metrics.ActiveRequests.Inc()
+	metrics.TotalRequests.Inc()
+	defer metrics.ActiveRequests.Dec()
+
+	query := r.URL.Query()
+	seconds, err := strconv.ParseInt(query.Get("since_epoch"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid time", http.StatusBadRequest)
+		return
+	}
+	prefix, err := parseRangePrefix(query.Get("cidr"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	date := time.Unix(seconds, 0)
+	ann, err := manager.GetAnnotator(date)
+	if checkError(err, w, "", 0, "range", tStart) {
+		return
+	}
+	ra, ok := ann.(api.RangeAnnotator)
+	if !ok {
+		http.Error(w, errNoRanges.Error(), httpStatus(errNoRanges))
+		return
+	}
+	ranges, err := ra.AnnotateRange(prefix)
+	if err != nil {
+		http.Error(w, err.Error(), httpStatus(err))
+		metrics.RequestTimeHistogramUsec.WithLabelValues("unknown", "range", api.Status(err)).Observe(float64(time.Since(tStart).Nanoseconds()) / 1000)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	resp := v2.RangeResponse{AnnotatorDate: ann.AnnotatorDate(), CIDR: prefix.String(), Ranges: ranges, Provenance: ann.Provenance(),
+		Missing: directory.Missing(ann)}
+	coverage := manager.Coverage(ann, date)
+	resp.Stale, resp.Extrapolated = coverage.Stale, coverage.Extrapolated
+	if resp.Ranges == nil {
+		resp.Ranges = []api.RangeAnnotations{}
+	}
+	err = json.NewEncoder(w).Encode(resp)
+	if err != nil {
+		return
+	}
+	metrics.RequestTimeHistogramUsec.WithLabelValues("unknown", "range", "success").Observe(float64(time.Since(tStart).Nanoseconds()) / 1000)
+}
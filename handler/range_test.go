@@ -0,0 +1,99 @@
+package handler_test
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/m-lab/annotation-service/api"
+	v2 "github.com/m-lab/annotation-service/api/v2"
+	"github.com/m-lab/annotation-service/asn"
+	"github.com/m-lab/annotation-service/directory"
+	"github.com/m-lab/annotation-service/geolite2v2"
+	"github.com/m-lab/annotation-service/handler"
+	"github.com/m-lab/annotation-service/iputils"
+	"github.com/m-lab/annotation-service/manager"
+)
+
+func TestAnnotateRange(t *testing.T) {
+	geo := geolite2v2.NewGeoDataset(
+		[]geolite2v2.GeoIPNode{
+			{
+				BaseIPNode:    iputils.BaseIPNode{IPAddressLow: net.IPv4(1, 0, 0, 0), IPAddressHigh: net.IPv4(1, 0, 0, 255)},
+				LocationIndex: 0,
+			},
+			{
+				BaseIPNode:    iputils.BaseIPNode{IPAddressLow: net.IPv4(1, 0, 1, 0), IPAddressHigh: net.IPv4(1, 0, 1, 255)},
+				LocationIndex: 1,
+			},
+		},
+		nil,
+		[]geolite2v2.LocationNode{{CityName: "First City"}, {CityName: "Second City"}},
+	)
+	geo.Start = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	ann, err := asn.LoadASNDatasetFromReader(strings.NewReader("1.0.0.128\t25\t100\n1.0.1.0\t24\t200\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rv := ann.(*asn.ASNDataset)
+	rv.Start = geo.Start
+	manager.SetDirectory([]api.Annotator{directory.NewCompositeAnnotator([]api.Annotator{geo, rv})})
+
+	tests := []struct {
+		cidr     string
+		wantCode int
+		want     []string
+	}{
+		{
+			cidr:     "1.0.0.0/23",
+			wantCode: http.StatusOK,
+			want: []string{
+				"1.0.0.0-1.0.0.127 First City -",
+				"1.0.0.128-1.0.0.255 First City 1.0.0.128/25",
+				"1.0.1.0-1.0.1.255 Second City 1.0.1.0/24",
+			},
+		},
+		{
+			cidr:     "1.0.1.16/28",
+			wantCode: http.StatusOK,
+			want:     []string{"1.0.1.16-1.0.1.31 Second City 1.0.1.0/24"},
+		},
+		{cidr: "2.0.0.0/24", wantCode: http.StatusOK, want: []string{}},
+		{cidr: "1.0.0.0/7", wantCode: http.StatusBadRequest},
+		{cidr: "1.0.0.0", wantCode: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/annotate_range?since_epoch=1600000000&cidr="+url.QueryEscape(tt.cidr), nil)
+		handler.AnnotateRange(w, r)
+		if w.Code != tt.wantCode {
+			t.Errorf("%s: status = %d, want %d: %s", tt.cidr, w.Code, tt.wantCode, w.Body.String())
+			continue
+		}
+		if tt.wantCode != http.StatusOK {
+			continue
+		}
+		resp := v2.RangeResponse{}
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		if !resp.AnnotatorDate.Equal(geo.Start) || len(resp.Ranges) != len(tt.want) {
+			t.Errorf("%s: response = %+v", tt.cidr, resp)
+			continue
+		}
+		for i, r := range resp.Ranges {
+			cidr := "-"
+			if r.Network != nil {
+				cidr = r.Network.CIDR
+			}
+			if got := r.First.String() + "-" + r.Last.String() + " " + r.Geo.City + " " + cidr; got != tt.want[i] {
+				t.Errorf("%s: Ranges[%d] = %s, want %s", tt.cidr, i, got, tt.want[i])
+			}
+		}
+	}
+}
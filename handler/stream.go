@@ -0,0 +1,140 @@
+package handler
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/m-lab/annotation-service/api"
+	v2 "github.com/m-lab/annotation-service/api/v2"
+	"github.com/m-lab/annotation-service/directory"
+	"github.com/m-lab/annotation-service/manager"
+	"github.com/m-lab/annotation-service/metrics"
+)
+
+// maxStreamRecord is the maximum size of a single /stream_annotate record.
+const maxStreamRecord = 64 * 1024
+
+// streamResponse is the body of a streaming response.  Close ends it.
+type streamResponse interface {
+	io.WriteCloser
+	http.Flusher
+}
+
+// responseStream is the streamResponse of a ResponseWriter that allows
+// reading the request body after the response is flushed.
+type responseStream struct {
+	http.ResponseWriter
+}
+
+func (s responseStream) Flush() {
+	if f, ok := s.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (s responseStream) Close() error {
+	return nil
+}
+
+// StreamAnnotate is a URL handler for very large batches.  The request body
+// holds newline delimited JSON v2.StreamRecords, and the response has one
+// JSON v2.StreamResult line per record, written as the records are read.  The
+// request and response are never held in memory as a whole.  The annotator is
+// only looked up again when the date changes, so records should be sorted by
+// date when possible.
+func StreamAnnotate(w http.ResponseWriter, r *http.Request) {
+	// Setup timers and counters for prometheus metrics.
+	tStart := time.Now()
+	defer func(t time.Time) {
+		metrics.RequestTimes.Observe(float64(time.Since(t).Nanoseconds()))
+	}(tStart)
+	metrics.ActiveRequests.Inc()
+	metrics.TotalRequests.Inc()
+	defer metrics.ActiveRequests.Dec()
+
+	// The response is written while the body is still being read.
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	body, out, err := startStream(w, r)
+	if err != nil {
+		w.Header().Del("Content-Type")
+		checkError(err, w, "stream", 0, "stream", tStart)
+		return
+	}
+	defer out.Close()
+
+	in := bufio.NewReaderSize(body, maxStreamRecord)
+	enc := json.NewEncoder(out)
+
+	var (
+		ann     api.Annotator
+		annErr  error
+		annDate time.Time          // The request date ann was selected for
+		annCov  directory.Coverage // The coverage of ann for annDate
+		looked  bool               // Whether an annotator was looked up yet
+		count   int
+	)
+	for {
+		if in.Buffered() == 0 {
+			// Send the results so far before waiting for more records.
+			out.Flush()
+		}
+		line, err := in.ReadSlice('\n')
+		if err == io.EOF && len(line) > 0 {
+			err = nil // The last record has no newline.
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// The rest of the body can't be read, e.g. because a record was too long.
+			enc.Encode(&v2.StreamResult{Error: err.Error()})
+			break
+		}
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		count++
+		record := v2.StreamRecord{}
+		result := v2.StreamResult{}
+		err = json.Unmarshal(line, &record)
+		if err == nil && (!looked || !record.Date.Equal(annDate)) {
+			annDate = record.Date
+			looked = true
+			ann, annErr = manager.GetAnnotator(annDate)
+			if annErr == nil && ann == nil {
+				annErr = errNoAnnotator
+			}
+			if annErr == nil {
+				annCov = manager.Coverage(ann, annDate)
+			}
+		}
+		if err == nil {
+			err = annErr
+		}
+		result.IP = record.IP
+		if err == nil {
+			result.AnnotatorDate = ann.AnnotatorDate()
+			result.Missing = directory.Missing(ann)
+			result.Stale, result.Extrapolated = annCov.Stale, annCov.Extrapolated
+			result.Annotation, err = annotateIP(ann, record.IP)
+			result.Status = api.Status(err)
+		}
+		if result.Annotation == nil {
+			result.Error = err.Error()
+		} else {
+			trackMissingResponses(result.Annotation)
+		}
+		if err := enc.Encode(&result); err != nil {
+			// The client went away.
+			log.Println("Stream aborted after", count, "records:", err)
+			return
+		}
+	}
+	latencyStats("stream", "stream", count, tStart)
+}
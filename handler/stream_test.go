@@ -0,0 +1,136 @@
+package handler_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/m-lab/annotation-service/api"
+	v2 "github.com/m-lab/annotation-service/api/v2"
+	"github.com/m-lab/annotation-service/directory"
+	"github.com/m-lab/annotation-service/geolite2v2"
+	"github.com/m-lab/annotation-service/handler"
+	"github.com/m-lab/annotation-service/iputils"
+	"github.com/m-lab/annotation-service/manager"
+)
+
+func TestStreamAnnotate(t *testing.T) {
+	ann := geolite2v2.NewGeoDataset(
+		[]geolite2v2.GeoIPNode{
+			{
+				BaseIPNode: iputils.BaseIPNode{
+					IPAddressLow:  net.IPv4(0, 0, 0, 0),
+					IPAddressHigh: net.IPv4(127, 255, 255, 255),
+				},
+				LocationIndex: 0,
+				PostalCode:    "10583",
+			},
+		},
+		nil,
+		[]geolite2v2.LocationNode{{CityName: "Not A Real City"}},
+	)
+	ann.Start = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	manager.SetDirectory([]api.Annotator{directory.NewCompositeAnnotator([]api.Annotator{ann})})
+
+	srv := httptest.NewServer(http.HandlerFunc(handler.StreamAnnotate))
+	defer srv.Close()
+
+	// Feed the body through a pipe, to check that each result is returned
+	// before the next record is sent.
+	body, bodyWriter := io.Pipe()
+	respc := make(chan *http.Response)
+	go func() {
+		resp, err := http.Post(srv.URL, "application/x-ndjson", body)
+		if err != nil {
+			t.Error(err)
+			close(respc)
+			return
+		}
+		respc <- resp
+	}()
+
+	records := []string{
+		`{"ip": "1.4.128.0", "date": "2020-03-01T00:00:00Z"}`,
+		`{"ip": "227.86.65.1", "date": "2020-03-01T00:00:00Z"}`,
+		`not json`,
+		``,
+		`{"ip": "1.4.128.0", "date": "2019-03-01T00:00:00Z"}`,
+	}
+	want := []v2.StreamResult{
+		{IP: "1.4.128.0", AnnotatorDate: ann.Start, Annotation: &api.GeoData{
+			Geo:     &api.GeolocationIP{City: "Not A Real City", PostalCode: "10583"},
+			Network: &api.ASData{Missing: true},
+		}, Status: api.StatusOK},
+		{IP: "227.86.65.1", AnnotatorDate: ann.Start, Annotation: &api.GeoData{
+			Geo:     &api.GeolocationIP{Missing: true},
+			Network: &api.ASData{Missing: true},
+		}, Status: api.StatusNotFound},
+		{Error: "invalid character 'o' in literal null (expecting 'u')"},
+		// The only annotator is used for earlier dates too, and flagged.
+		{IP: "1.4.128.0", AnnotatorDate: ann.Start, Annotation: &api.GeoData{
+			Geo:     &api.GeolocationIP{City: "Not A Real City", PostalCode: "10583"},
+			Network: &api.ASData{Missing: true},
+		}, Extrapolated: []string{"geolite2"}, Status: api.StatusOK},
+	}
+
+	var lines *bufio.Scanner
+	next := 0
+	for _, record := range records {
+		fmt.Fprintln(bodyWriter, record)
+		if record == "" {
+			continue
+		}
+		if lines == nil {
+			resp, ok := <-respc
+			if !ok {
+				t.FailNow()
+			}
+			defer resp.Body.Close()
+			lines = bufio.NewScanner(resp.Body)
+		}
+		if !lines.Scan() {
+			t.Fatal("missing result for", record, lines.Err())
+		}
+		got := v2.StreamResult{}
+		if err := json.Unmarshal(lines.Bytes(), &got); err != nil {
+			t.Fatal(err)
+		}
+		w, _ := json.Marshal(want[next])
+		g, _ := json.Marshal(got)
+		if string(g) != string(w) {
+			t.Errorf("Got\n%s\nexpected\n%s", g, w)
+		}
+		next++
+	}
+	bodyWriter.Close()
+	if lines.Scan() {
+		t.Error("unexpected result", lines.Text())
+	}
+}
+
+func TestStreamAnnotateLongRecord(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(handler.StreamAnnotate))
+	defer srv.Close()
+
+	body := strings.Repeat(" ", 100000) + `{"ip": "1.4.128.0"}` + "\n"
+	resp, err := http.Post(srv.URL, "application/x-ndjson", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != `{"IP":"","AnnotatorDate":"0001-01-01T00:00:00Z","Error":"bufio: buffer full"}`+"\n" {
+		t.Error("Got", string(b))
+	}
+}
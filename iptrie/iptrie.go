@@ -0,0 +1,430 @@
+// Package iptrie stores IP prefixes in a path-compressed binary trie.  Unlike
+// a rangetable.Table, it keeps the original, possibly nested, prefixes of a
+// dataset, and a search returns the longest matching prefix.
+//
+// IPv4 prefixes are stored inside ::ffff:0:0/96, so a single trie holds both
+// families, and IPv4 addresses are also matched by IPv6 prefixes covering
+// ::ffff:0:0/96.  Rows are numbered in the order the prefixes were added, and
+// datasets store the data for each prefix in columns indexed by row.
+package iptrie
+
+import (
+	"encoding/binary"
+	"errors"
+	"math/bits"
+	"net"
+	"unsafe"
+
+	"github.com/m-lab/annotation-service/iputils"
+	"github.com/m-lab/annotation-service/snapshot"
+)
+
+// ErrNotPrefix is returned by Builder.Add for ranges that are not CIDR prefixes.
+var ErrNotPrefix = errors.New("IP range is not a CIDR prefix")
+
+// node is a trie node.  Its key holds the first bits of the prefix, and the
+// rest of the key is zero.
+type node struct {
+	hi, lo uint64   // The 128 bit key, high word first
+	child  [2]int32 // Indexes of the children, for the next bit 0 or 1, or -1
+	row    int32    // The row of the prefix, or -1 for nodes that only branch
+	bits   uint8    // The prefix length, 0 to 128
+}
+
+// Trie is a path-compressed binary trie of IP prefixes.  The zero value is
+// an empty trie.
+type Trie struct {
+	nodes []node  // nodes[0] is the root, ::/0
+	rows  []int32 // The node of each row
+}
+
+// Len returns the number of prefixes in the trie.
+func (t *Trie) Len() int {
+	return len(t.rows)
+}
+
+// Size returns the approximate memory used by the trie, in bytes.
+func (t *Trie) Size() int64 {
+	return int64(len(t.nodes))*int64(unsafe.Sizeof(node{})) + 4*int64(len(t.rows))
+}
+
+// key returns ip as a 128 bit key, or false if it is not a valid IP.
+func key(ip net.IP) (uint64, uint64, bool) {
+	ip16 := ip.To16()
+	if ip16 == nil {
+		return 0, 0, false
+	}
+	return binary.BigEndian.Uint64(ip16), binary.BigEndian.Uint64(ip16[8:]), true
+}
+
+// bit returns bit i of the key, counting from the most significant one.
+func bit(hi, lo uint64, i uint8) int {
+	if i < 64 {
+		return int(hi>>(63-i)) & 1
+	}
+	return int(lo>>(127-i)) & 1
+}
+
+// mask clears all but the first n bits of the key.
+func mask(hi, lo uint64, n uint8) (uint64, uint64) {
+	if n <= 64 {
+		return hi &^ (1<<(64-n) - 1), 0
+	}
+	return hi, lo &^ (1<<(128-n) - 1)
+}
+
+// common returns the number of leading bits the keys have in common.
+func common(hi1, lo1, hi2, lo2 uint64) uint8 {
+	if x := hi1 ^ hi2; x != 0 {
+		return uint8(bits.LeadingZeros64(x))
+	}
+	return uint8(64 + bits.LeadingZeros64(lo1^lo2))
+}
+
+// Families returns whether the trie has prefixes with IPv4 addresses, which
+// are inside ::ffff:0:0/96 or cover it, and prefixes with IPv6 ones.
+func (t *Trie) Families() (ipv4, ipv6 bool) {
+	for _, r := range t.rows {
+		n := &t.nodes[r]
+		inside := n.bits >= 96 && n.hi == 0 && n.lo>>32 == 0xffff
+		hi, lo := mask(0, 0xffff00000000, n.bits)
+		if inside || (hi == n.hi && lo == n.lo) {
+			ipv4 = true
+		}
+		if !inside {
+			ipv6 = true
+		}
+	}
+	return ipv4, ipv6
+}
+
+// Search returns the row of the longest prefix containing ip, or
+// iputils.ErrNodeNotFound if there is none.
+func (t *Trie) Search(ip net.IP) (int, error) {
+	hi, lo, ok := key(ip)
+	if !ok || len(t.nodes) == 0 {
+		return -1, iputils.ErrNodeNotFound
+	}
+	best := int32(-1)
+	n := &t.nodes[0]
+	for {
+		if n.row >= 0 {
+			best = n.row
+		}
+		if n.bits == 128 {
+			break
+		}
+		c := n.child[bit(hi, lo, n.bits)]
+		if c < 0 {
+			break
+		}
+		n = &t.nodes[c]
+		if common(hi, lo, n.hi, n.lo) < n.bits {
+			break
+		}
+	}
+	if best < 0 {
+		return -1, iputils.ErrNodeNotFound
+	}
+	return int(best), nil
+}
+
+// Covering returns the rows of all the prefixes containing ip, from the least
+// to the most specific.  The last one is the row returned by Search.
+func (t *Trie) Covering(ip net.IP) []int {
+	hi, lo, ok := key(ip)
+	if !ok || len(t.nodes) == 0 {
+		return nil
+	}
+	var rows []int
+	n := &t.nodes[0]
+	for {
+		if n.row >= 0 {
+			rows = append(rows, int(n.row))
+		}
+		if n.bits == 128 {
+			break
+		}
+		c := n.child[bit(hi, lo, n.bits)]
+		if c < 0 {
+			break
+		}
+		n = &t.nodes[c]
+		if common(hi, lo, n.hi, n.lo) < n.bits {
+			break
+		}
+	}
+	return rows
+}
+
+// SearchRange returns the ranges of [first, last] covered by the prefixes, in
+// address order, each with the row of the most specific prefix covering it,
+// as Search would return for its addresses.  Addresses covered by no prefix
+// are omitted.
+func (t *Trie) SearchRange(first, last net.IP) []iputils.RowRange {
+	fhi, flo, ok := key(first)
+	lhi, llo, ok2 := key(last)
+	if !ok || !ok2 || len(t.nodes) == 0 || cmp(fhi, flo, lhi, llo) > 0 {
+		return nil
+	}
+	w := rangeWalk{t: t, fhi: fhi, flo: flo, lhi: lhi, llo: llo}
+	w.walk(0, -1)
+	return w.result
+}
+
+// rangeWalk holds the state of SearchRange.
+type rangeWalk struct {
+	t                  *Trie
+	fhi, flo, lhi, llo uint64 // The query bounds
+	result             []iputils.RowRange
+}
+
+// walk adds the ranges of the subtree of node n that overlap the query.  row
+// is the row of the closest ancestor with a prefix, or -1.
+func (w *rangeWalk) walk(n int32, row int32) {
+	nd := &w.t.nodes[n]
+	if nd.row >= 0 {
+		row = nd.row
+	}
+	mhi, mlo := mask(^uint64(0), ^uint64(0), nd.bits)
+	// The part of the node prefix inside the query.
+	shi, slo := nd.hi, nd.lo
+	if cmp(shi, slo, w.fhi, w.flo) < 0 {
+		shi, slo = w.fhi, w.flo
+	}
+	ehi, elo := nd.hi|^mhi, nd.lo|^mlo
+	if cmp(ehi, elo, w.lhi, w.llo) > 0 {
+		ehi, elo = w.lhi, w.llo
+	}
+	if cmp(shi, slo, ehi, elo) > 0 {
+		return
+	}
+	// The addresses from the cursor to the next child have no more specific
+	// prefix than row.
+	chi, clo := shi, slo
+	for _, c := range nd.child {
+		if c < 0 {
+			continue
+		}
+		child := &w.t.nodes[c]
+		cmhi, cmlo := mask(^uint64(0), ^uint64(0), child.bits)
+		if cmp(child.hi|^cmhi, child.lo|^cmlo, chi, clo) < 0 || cmp(child.hi, child.lo, ehi, elo) > 0 {
+			continue
+		}
+		if cmp(child.hi, child.lo, chi, clo) > 0 {
+			phi, plo := dec(child.hi, child.lo)
+			w.add(chi, clo, phi, plo, row)
+		}
+		w.walk(c, row)
+		if cmp(child.hi|^cmhi, child.lo|^cmlo, ehi, elo) >= 0 {
+			return
+		}
+		chi, clo = inc(child.hi|^cmhi, child.lo|^cmlo)
+	}
+	w.add(chi, clo, ehi, elo, row)
+}
+
+// add appends the range to the result, or extends the last range if it is
+// adjacent and has the same row.
+func (w *rangeWalk) add(fhi, flo, lhi, llo uint64, row int32) {
+	if row < 0 {
+		return
+	}
+	first, last := make(net.IP, net.IPv6len), make(net.IP, net.IPv6len)
+	binary.BigEndian.PutUint64(first, fhi)
+	binary.BigEndian.PutUint64(first[8:], flo)
+	binary.BigEndian.PutUint64(last, lhi)
+	binary.BigEndian.PutUint64(last[8:], llo)
+	if n := len(w.result); n > 0 && w.result[n-1].Row == int(row) {
+		phi, plo := dec(fhi, flo)
+		prev := w.result[n-1].Last
+		if binary.BigEndian.Uint64(prev) == phi && binary.BigEndian.Uint64(prev[8:]) == plo {
+			w.result[n-1].Last = last
+			return
+		}
+	}
+	w.result = append(w.result, iputils.RowRange{First: first, Last: last, Row: int(row)})
+}
+
+// cmp compares two 128 bit keys, and returns -1, 0 or 1.
+func cmp(hi1, lo1, hi2, lo2 uint64) int {
+	switch {
+	case hi1 < hi2 || (hi1 == hi2 && lo1 < lo2):
+		return -1
+	case hi1 == hi2 && lo1 == lo2:
+		return 0
+	}
+	return 1
+}
+
+// inc returns the key plus one.  It must not be the last address.
+func inc(hi, lo uint64) (uint64, uint64) {
+	lo, carry := bits.Add64(lo, 1, 0)
+	return hi + carry, lo
+}
+
+// dec returns the key minus one.  It must not be the first address.
+func dec(hi, lo uint64) (uint64, uint64) {
+	lo, borrow := bits.Sub64(lo, 1, 0)
+	return hi - borrow, lo
+}
+
+// Bounds returns the first and last addresses of the prefix in the row, in
+// the 16 byte form.
+func (t *Trie) Bounds(row int) (net.IP, net.IP) {
+	n := &t.nodes[t.rows[row]]
+	low, high := make(net.IP, net.IPv6len), make(net.IP, net.IPv6len)
+	binary.BigEndian.PutUint64(low, n.hi)
+	binary.BigEndian.PutUint64(low[8:], n.lo)
+	mhi, mlo := mask(^uint64(0), ^uint64(0), n.bits)
+	binary.BigEndian.PutUint64(high, n.hi|^mhi)
+	binary.BigEndian.PutUint64(high[8:], n.lo|^mlo)
+	return low, high
+}
+
+// Prefix returns the prefix in the row.  IPv4 prefixes are returned with
+// 4 byte addresses and masks.
+func (t *Trie) Prefix(row int) *net.IPNet {
+	n := &t.nodes[t.rows[row]]
+	low, _ := t.Bounds(row)
+	if ip4 := low.To4(); ip4 != nil && n.bits >= 96 {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(int(n.bits)-96, 32)}
+	}
+	return &net.IPNet{IP: low, Mask: net.CIDRMask(int(n.bits), 128)}
+}
+
+// Less returns true if the prefix in row i sorts before the one in row j, by
+// first address, and then from the least to the most specific.
+func (t *Trie) Less(i, j int) bool {
+	a, b := &t.nodes[t.rows[i]], &t.nodes[t.rows[j]]
+	if a.hi != b.hi {
+		return a.hi < b.hi
+	}
+	if a.lo != b.lo {
+		return a.lo < b.lo
+	}
+	return a.bits < b.bits
+}
+
+// Builder builds a Trie from a list of prefixes.
+type Builder struct {
+	t Trie
+}
+
+// Add adds the prefix with the bounds [low, high] to the trie, in the next
+// row.  Prefixes may be added in any order, and may be nested.  If the same
+// prefix is added again, its row refers to the same node, and the last row
+// added is returned by searches.
+func (b *Builder) Add(low, high net.IP) error {
+	hi, lo, ok := key(low)
+	hhi, hlo, ok2 := key(high)
+	if !ok || !ok2 {
+		return ErrNotPrefix
+	}
+	n := common(hi, lo, hhi, hlo)
+	mhi, mlo := mask(^uint64(0), ^uint64(0), n)
+	if hi&^mhi != 0 || lo&^mlo != 0 || hhi|mhi != ^uint64(0) || hlo|mlo != ^uint64(0) {
+		return ErrNotPrefix
+	}
+	b.insert(hi, lo, n, int32(len(b.t.rows)))
+	return nil
+}
+
+// Encode writes the prefixes of the trie to a snapshot, in row order.
+func (t *Trie) Encode(e *snapshot.Encoder) {
+	hi := make([]uint64, len(t.rows))
+	lo := make([]uint64, len(t.rows))
+	n := make([]uint32, len(t.rows))
+	for row, i := range t.rows {
+		hi[row], lo[row], n[row] = t.nodes[i].hi, t.nodes[i].lo, uint32(t.nodes[i].bits)
+	}
+	e.Uint64s(hi)
+	e.Uint64s(lo)
+	e.Uint32s(n)
+}
+
+// DecodeTrie reads a trie written by Encode.  Unlike the range tables, the
+// trie is rebuilt from the prefixes, so it does not use the snapshot content
+// in place.
+func DecodeTrie(d *snapshot.Decoder) *Trie {
+	hi, lo, n := d.Uint64s(), d.Uint64s(), d.Uint32s()
+	if len(hi) != len(lo) || len(hi) != len(n) {
+		d.Fail(snapshot.ErrCorrupt)
+		return &Trie{}
+	}
+	b := Builder{}
+	for row := range hi {
+		if n[row] > 128 {
+			d.Fail(snapshot.ErrCorrupt)
+			return &Trie{}
+		}
+		if mhi, mlo := mask(hi[row], lo[row], uint8(n[row])); mhi != hi[row] || mlo != lo[row] {
+			d.Fail(snapshot.ErrCorrupt)
+			return &Trie{}
+		}
+		b.insert(hi[row], lo[row], uint8(n[row]), int32(row))
+	}
+	return b.Build()
+}
+
+// newNode appends a node to the trie, and returns its index.
+func (b *Builder) newNode(hi, lo uint64, n uint8, row int32) int32 {
+	b.t.nodes = append(b.t.nodes, node{hi: hi, lo: lo, bits: n, row: row, child: [2]int32{-1, -1}})
+	return int32(len(b.t.nodes) - 1)
+}
+
+// insert adds the prefix of n bits of the key, with the row.
+func (b *Builder) insert(hi, lo uint64, n uint8, row int32) {
+	if len(b.t.nodes) == 0 {
+		b.newNode(0, 0, 0, -1)
+	}
+	cur := int32(0)
+	for {
+		if b.t.nodes[cur].bits == n {
+			b.t.nodes[cur].row = row
+			b.t.rows = append(b.t.rows, cur)
+			return
+		}
+		dir := bit(hi, lo, b.t.nodes[cur].bits)
+		c := b.t.nodes[cur].child[dir]
+		if c < 0 {
+			leaf := b.newNode(hi, lo, n, row)
+			b.t.nodes[cur].child[dir] = leaf
+			b.t.rows = append(b.t.rows, leaf)
+			return
+		}
+		child := b.t.nodes[c]
+		split := common(hi, lo, child.hi, child.lo)
+		if split >= child.bits && child.bits <= n {
+			// The child is a prefix of the new prefix.
+			cur = c
+			continue
+		}
+		if split > n {
+			split = n
+		}
+		if split == n {
+			// The new prefix goes between cur and the child.
+			mid := b.newNode(hi, lo, n, row)
+			b.t.nodes[mid].child[bit(child.hi, child.lo, n)] = c
+			b.t.nodes[cur].child[dir] = mid
+			b.t.rows = append(b.t.rows, mid)
+			return
+		}
+		// The new prefix and the child branch at split.
+		mhi, mlo := mask(hi, lo, split)
+		mid := b.newNode(mhi, mlo, split, -1)
+		leaf := b.newNode(hi, lo, n, row)
+		b.t.nodes[mid].child[bit(hi, lo, split)] = leaf
+		b.t.nodes[mid].child[bit(child.hi, child.lo, split)] = c
+		b.t.nodes[cur].child[dir] = mid
+		b.t.rows = append(b.t.rows, leaf)
+		return
+	}
+}
+
+// Build returns the trie.
+func (b *Builder) Build() *Trie {
+	t := b.t
+	return &t
+}
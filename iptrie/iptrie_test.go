@@ -0,0 +1,391 @@
+package iptrie_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/rand"
+	"net"
+	"sort"
+	"testing"
+
+	"github.com/m-lab/annotation-service/iptrie"
+	"github.com/m-lab/annotation-service/iputils"
+	"github.com/m-lab/annotation-service/rangetable"
+	"github.com/m-lab/annotation-service/snapshot"
+)
+
+// bounds returns the first and last addresses of the CIDR prefix.
+func bounds(cidr string) (net.IP, net.IP) {
+	_, ipnet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		panic(err)
+	}
+	low := ipnet.IP.To16()
+	high := make(net.IP, net.IPv6len)
+	mask := ipnet.Mask
+	if len(mask) == net.IPv4len {
+		mask = append(net.CIDRMask(96, 128)[:12], mask...)
+	}
+	for i := range high {
+		high[i] = low[i] | ^mask[i]
+	}
+	return low, high
+}
+
+func newTrie(t *testing.T, prefixes ...string) *iptrie.Trie {
+	b := iptrie.Builder{}
+	for _, p := range prefixes {
+		if err := b.Add(bounds(p)); err != nil {
+			t.Fatal(p, err)
+		}
+	}
+	trie := b.Build()
+	for row, p := range prefixes {
+		low, high := bounds(p)
+		gotLow, gotHigh := trie.Bounds(row)
+		if !gotLow.Equal(low) || !gotHigh.Equal(high) {
+			t.Errorf("Bounds(%d) = %s-%s, want %s", row, gotLow, gotHigh, p)
+		}
+		if got := trie.Prefix(row).String(); got != p {
+			t.Errorf("Prefix(%d) = %s, want %s", row, got, p)
+		}
+	}
+	return trie
+}
+
+func TestSearch(t *testing.T) {
+	trie := newTrie(t,
+		"1.0.0.0/16",
+		"1.0.4.0/22",
+		"1.0.0.0/24", // Same first address as the /16
+		"1.0.5.0/24",
+		"2001:db8::/32",
+		"2001:db8:1::/48",
+		"::/96", // Covers no IPv4 addresses
+		"10.1.2.3/32",
+	)
+	if trie.Len() != 8 {
+		t.Fatal("Len() =", trie.Len())
+	}
+	if v4, v6 := trie.Families(); !v4 || !v6 {
+		t.Errorf("Families() = %v, %v", v4, v6)
+	}
+	for _, tt := range []struct {
+		prefixes []string
+		v4, v6   bool
+	}{
+		{[]string{"1.0.0.0/16", "10.1.2.3/32"}, true, false},
+		{[]string{"2001:db8::/32", "::/96"}, false, true},
+		{[]string{"::/0"}, true, true},
+	} {
+		if v4, v6 := newTrie(t, tt.prefixes...).Families(); v4 != tt.v4 || v6 != tt.v6 {
+			t.Errorf("%v: Families() = %v, %v", tt.prefixes, v4, v6)
+		}
+	}
+	tests := []struct {
+		ip      string
+		want    string // The prefix found.
+		wantErr error
+	}{
+		{ip: "1.0.0.1", want: "1.0.0.0/24"},
+		{ip: "1.0.1.0", want: "1.0.0.0/16"},
+		{ip: "1.0.4.1", want: "1.0.4.0/22"},
+		{ip: "1.0.5.1", want: "1.0.5.0/24"},
+		{ip: "1.0.7.255", want: "1.0.4.0/22"},
+		{ip: "1.0.255.255", want: "1.0.0.0/16"},
+		{ip: "1.1.0.0", wantErr: iputils.ErrNodeNotFound},
+		{ip: "10.1.2.3", want: "10.1.2.3/32"},
+		{ip: "10.1.2.4", wantErr: iputils.ErrNodeNotFound},
+		{ip: "::1", want: "::/96"},
+		{ip: "2001:db8::1", want: "2001:db8::/32"},
+		{ip: "2001:db8:1::1", want: "2001:db8:1::/48"},
+		{ip: "2001:db9::", wantErr: iputils.ErrNodeNotFound},
+	}
+	for _, tt := range tests {
+		row, err := trie.Search(net.ParseIP(tt.ip))
+		if err != tt.wantErr {
+			t.Errorf("Search(%s) error = %v, want %v", tt.ip, err, tt.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if got := trie.Prefix(row).String(); got != tt.want {
+			t.Errorf("Search(%s) found %s, want %s", tt.ip, got, tt.want)
+		}
+	}
+}
+
+func TestCovering(t *testing.T) {
+	trie := newTrie(t,
+		"1.0.4.0/22",
+		"1.0.0.0/16",
+		"1.0.5.0/24",
+		"1.0.0.0/8",
+		"1.0.5.0/24", // Duplicate, replaces row 2
+		"2001:db8::/32",
+	)
+	tests := []struct {
+		ip   string
+		want []string
+	}{
+		{ip: "1.0.5.1", want: []string{"1.0.0.0/8", "1.0.0.0/16", "1.0.4.0/22", "1.0.5.0/24"}},
+		{ip: "1.0.6.1", want: []string{"1.0.0.0/8", "1.0.0.0/16", "1.0.4.0/22"}},
+		{ip: "1.2.0.0", want: []string{"1.0.0.0/8"}},
+		{ip: "2.0.0.0"},
+		{ip: "2001:db8::1", want: []string{"2001:db8::/32"}},
+	}
+	for _, tt := range tests {
+		rows := trie.Covering(net.ParseIP(tt.ip))
+		got := []string{}
+		for _, row := range rows {
+			got = append(got, trie.Prefix(row).String())
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("Covering(%s) = %v, want %v", tt.ip, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("Covering(%s) = %v, want %v", tt.ip, got, tt.want)
+				break
+			}
+		}
+	}
+	if rows := trie.Covering(net.ParseIP("1.0.5.1")); rows[len(rows)-1] != 4 {
+		t.Error("Covering() returned the replaced row", rows)
+	}
+	if rows := (&iptrie.Trie{}).Covering(net.ParseIP("1.0.0.1")); rows != nil {
+		t.Error("Covering() on empty trie =", rows)
+	}
+}
+
+func TestEncodeDecode(t *testing.T) {
+	want := newTrie(t, "1.0.0.0/16", "1.0.4.0/22", "1.0.4.0/22", "2001:db8::/32", "::/0")
+	buf := bytes.Buffer{}
+	e := snapshot.NewEncoder(&buf, snapshot.KindASN)
+	want.Encode(e)
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+	d, err := snapshot.NewDecoder(buf.Bytes(), snapshot.KindASN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := iptrie.DecodeTrie(d)
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got.Len() != want.Len() {
+		t.Fatal("Len() =", got.Len())
+	}
+	for row := 0; row < want.Len(); row++ {
+		if got.Prefix(row).String() != want.Prefix(row).String() {
+			t.Errorf("Prefix(%d) = %s, want %s", row, got.Prefix(row), want.Prefix(row))
+		}
+	}
+	for _, ip := range []string{"1.0.4.1", "1.0.0.1", "2001:db8::1", "2.0.0.0"} {
+		gotRow, _ := got.Search(net.ParseIP(ip))
+		wantRow, _ := want.Search(net.ParseIP(ip))
+		if gotRow != wantRow {
+			t.Errorf("Search(%s) = %d, want %d", ip, gotRow, wantRow)
+		}
+	}
+
+	// A prefix with bits set after its length.
+	buf.Reset()
+	e = snapshot.NewEncoder(&buf, snapshot.KindASN)
+	e.Uint64s([]uint64{1})
+	e.Uint64s([]uint64{1})
+	e.Uint32s([]uint32{64})
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+	d, err = snapshot.NewDecoder(buf.Bytes(), snapshot.KindASN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iptrie.DecodeTrie(d)
+	if err := d.Close(); err != snapshot.ErrCorrupt {
+		t.Error("Close() error =", err)
+	}
+}
+
+func TestSearchEmpty(t *testing.T) {
+	trie := iptrie.Trie{}
+	if _, err := trie.Search(net.ParseIP("1.2.3.4")); err != iputils.ErrNodeNotFound {
+		t.Error("Search() error =", err)
+	}
+	trie = *newTrie(t, "::/0")
+	for _, ip := range []string{"1.2.3.4", "2001:db8::1"} {
+		if row, err := trie.Search(net.ParseIP(ip)); err != nil || row != 0 {
+			t.Errorf("Search(%s) = %d, %v", ip, row, err)
+		}
+	}
+}
+
+func TestAddNotPrefix(t *testing.T) {
+	b := iptrie.Builder{}
+	for _, r := range [][2]string{
+		{"1.0.0.0", "1.0.0.254"},
+		{"1.0.0.1", "1.0.0.255"},
+		{"1.0.0.255", "1.0.0.0"},
+		{"2001:db8::", "2001:db8::2"},
+	} {
+		if err := b.Add(net.ParseIP(r[0]), net.ParseIP(r[1])); err != iptrie.ErrNotPrefix {
+			t.Errorf("Add(%s, %s) error = %v", r[0], r[1], err)
+		}
+	}
+	if b.Build().Len() != 0 {
+		t.Error("Len() =", b.Build().Len())
+	}
+}
+
+// randomPrefixes returns n random, possibly nested, IPv4 prefixes.
+func randomPrefixes(r *rand.Rand, n int) []*net.IPNet {
+	prefixes := make([]*net.IPNet, n)
+	for i := range prefixes {
+		ip := make(net.IP, net.IPv4len)
+		binary.BigEndian.PutUint32(ip, r.Uint32()&0x0fffffff)
+		mask := net.CIDRMask(8+r.Intn(25), 32)
+		prefixes[i] = &net.IPNet{IP: ip.Mask(mask), Mask: mask}
+	}
+	return prefixes
+}
+
+func TestSearchRandom(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	prefixes := randomPrefixes(r, 1000)
+	b := iptrie.Builder{}
+	for _, p := range prefixes {
+		if err := b.Add(bounds(p.String())); err != nil {
+			t.Fatal(err)
+		}
+	}
+	trie := b.Build()
+	for i := 0; i < 10000; i++ {
+		ip := make(net.IP, net.IPv4len)
+		binary.BigEndian.PutUint32(ip, r.Uint32()&0x0fffffff)
+		// The longest prefix containing ip, or the last one added if several.
+		want := -1
+		for j, p := range prefixes {
+			if p.Contains(ip) && (want < 0 || bytes.Compare(p.Mask, prefixes[want].Mask) >= 0) {
+				want = j
+			}
+		}
+		got, err := trie.Search(ip)
+		if want < 0 {
+			if err != iputils.ErrNodeNotFound {
+				t.Fatalf("Search(%s) = %d, %v, want not found", ip, got, err)
+			}
+			continue
+		}
+		if err != nil || trie.Prefix(got).String() != prefixes[want].String() {
+			t.Fatalf("Search(%s) = %d, %v, want %s", ip, got, err, prefixes[want])
+		}
+	}
+}
+
+func TestSearchRange(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	b := iptrie.Builder{}
+	for i := 0; i < 200; i++ {
+		ip := net.IPv4(10, 0, byte(r.Intn(256)), byte(r.Intn(256))).To4()
+		mask := net.CIDRMask(17+r.Intn(16), 32)
+		if err := b.Add(bounds((&net.IPNet{IP: ip.Mask(mask), Mask: mask}).String())); err != nil {
+			t.Fatal(err)
+		}
+	}
+	trie := b.Build()
+	for _, query := range []string{"10.0.0.0/16", "10.0.64.0/20", "10.0.128.0/18", "10.0.3.7/32", "9.255.255.0/23"} {
+		first, last := bounds(query)
+		ranges := trie.SearchRange(first, last)
+		// Each address of the query is in the range for the row Search returns.
+		i := 0
+		for x := binary.BigEndian.Uint32(first[12:]); x <= binary.BigEndian.Uint32(last[12:]); x++ {
+			ip := make(net.IP, net.IPv4len)
+			binary.BigEndian.PutUint32(ip, x)
+			row, err := trie.Search(ip)
+			for i < len(ranges) && bytes.Compare(ranges[i].Last, ip.To16()) < 0 {
+				i++
+			}
+			inRange := i < len(ranges) && bytes.Compare(ranges[i].First, ip.To16()) <= 0
+			if err != nil && inRange {
+				t.Fatalf("%s: %s is in range %v, but not found", query, ip, ranges[i])
+			}
+			if err == nil && (!inRange || ranges[i].Row != row) {
+				t.Fatalf("%s: %s has row %d, not in ranges", query, ip, row)
+			}
+		}
+		// Adjacent ranges have different rows.
+		for i := 1; i < len(ranges); i++ {
+			if ranges[i].Row == ranges[i-1].Row && ranges[i].First.Equal(nextIP(ranges[i-1].Last)) {
+				t.Errorf("%s: ranges %d and %d should be merged", query, i-1, i)
+			}
+		}
+	}
+	if ranges := trie.SearchRange(net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.0")); ranges != nil {
+		t.Error("SearchRange() with reversed bounds =", ranges)
+	}
+	if ranges := newTrie(t, "::/0").SearchRange(net.ParseIP("::"), net.ParseIP("ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff")); len(ranges) != 1 {
+		t.Error("SearchRange() of all addresses =", ranges)
+	}
+}
+
+// nextIP returns the 16 byte address after ip.
+func nextIP(ip net.IP) net.IP {
+	next := append(net.IP(nil), ip.To16()...)
+	for i := len(next) - 1; i >= 0; i-- {
+		if next[i]++; next[i] != 0 {
+			break
+		}
+	}
+	return next
+}
+
+// benchmarkPrefixes returns n non-overlapping random /24 prefixes, sorted.
+func benchmarkPrefixes(n int) []*net.IPNet {
+	r := rand.New(rand.NewSource(1))
+	seen := map[string]bool{}
+	prefixes := []*net.IPNet{}
+	for len(prefixes) < n {
+		p := randomPrefixes(r, 1)[0]
+		p.Mask = net.CIDRMask(24, 32)
+		p.IP = p.IP.Mask(p.Mask)
+		if !seen[p.String()] {
+			seen[p.String()] = true
+			prefixes = append(prefixes, p)
+		}
+	}
+	sort.Slice(prefixes, func(i, j int) bool { return bytes.Compare(prefixes[i].IP, prefixes[j].IP) < 0 })
+	return prefixes
+}
+
+// The Search benchmarks compare the trie with the range list, for the same
+// prefixes.  See also BenchmarkGeoLite2ipv4 and BenchmarkGeoLite2ipv4Trie,
+// which use a real dataset.
+func BenchmarkSearchTrie(b *testing.B) {
+	prefixes := benchmarkPrefixes(100000)
+	builder := iptrie.Builder{}
+	for _, p := range prefixes {
+		builder.Add(bounds(p.String()))
+	}
+	trie := builder.Build()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		trie.Search(prefixes[n%len(prefixes)].IP)
+	}
+}
+
+func BenchmarkSearchRangeList(b *testing.B) {
+	prefixes := benchmarkPrefixes(100000)
+	builder := rangetable.Builder{}
+	for _, p := range prefixes {
+		builder.Add(bounds(p.String()))
+	}
+	table, _ := builder.Build()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		table.Search(prefixes[n%len(prefixes)].IP)
+	}
+}
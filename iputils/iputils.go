@@ -8,6 +8,7 @@ import (
 	"io"
 	"net"
 
+	"github.com/m-lab/annotation-service/api"
 	"github.com/m-lab/annotation-service/loader"
 	"github.com/m-lab/annotation-service/metrics"
 )
@@ -17,17 +18,50 @@ var (
 	maxWrongRecordsPerFile = 50
 
 	// ErrorTooManyErrors raised when the maximum number of errors during the import of a single file is > then maxWrongRecordsPerFile
-	ErrorTooManyErrors = errors.New("Too many errors during loading the dataset IP list")
+	ErrorTooManyErrors = api.NewError("Too many errors during loading the dataset IP list", api.ErrCorrupt)
 
 	// ErrNodeNotFound raised when a node is not found during SearchBinary
-	ErrNodeNotFound = errors.New("node not found")
+	ErrNodeNotFound = api.NewError("node not found", api.ErrNotFound)
 
 	// ErrEmptyIP is returned for IP address strings that are empty.
-	ErrEmptyIP = errors.New("Empty IP address")
+	ErrEmptyIP = api.NewError("Empty IP address", api.ErrInvalidIP)
 	// ErrInvalidIP is returned for non-empty IP address strings that cannot be parsed.
-	ErrInvalidIP = errors.New("Invalid IP address")
+	ErrInvalidIP = api.NewError("Invalid IP address", api.ErrInvalidIP)
 )
 
+// Engine names a lookup structure that the dataset loaders can build.
+type Engine string
+
+// The lookup engines.
+const (
+	// RangeList flattens the nested prefixes into a sorted list of
+	// non-overlapping ranges, searched with a binary search.
+	RangeList Engine = "ranges"
+	// PrefixTrie keeps the original prefixes in a path-compressed binary trie,
+	// and searches return the longest matching prefix.
+	PrefixTrie Engine = "trie"
+)
+
+// RowRange is a range of IP addresses, with the row of the dataset table that
+// holds its data.  Range searches return them clipped to the query.
+type RowRange struct {
+	First, Last net.IP // In the 16 byte form
+	Row         int
+}
+
+// PrefixBounds returns the first and last addresses of the prefix, in the 16
+// byte form.
+func PrefixBounds(prefix *net.IPNet) (net.IP, net.IP) {
+	first := prefix.IP.Mask(prefix.Mask).To16()
+	last := make(net.IP, net.IPv6len)
+	copy(last, first)
+	mask := prefix.Mask
+	for i := range mask {
+		last[net.IPv6len-len(mask)+i] |= ^mask[i]
+	}
+	return first, last
+}
+
 // BaseIPNode is a basic type for nodes to handle. This struct should be embedded in all the IP range related
 // struct.
 type BaseIPNode struct {
@@ -179,7 +213,7 @@ func (c *ipNodeCSVConsumer) Consume(record []string) error {
 }
 
 func canBeMergedByIP(prev, next IPNode) bool {
-	nextLowIP := minusOne(next.GetLowIP())
+	nextLowIP := MinusOne(next.GetLowIP())
 	return bytes.Compare(prev.GetHighIP(), nextLowIP) >= 0 // equals or the next low is lower than the prev high
 }
 
@@ -196,14 +230,48 @@ func BuildIPNodeList(reader io.Reader, parser IPNodeParser) error {
 	return nil
 }
 
+// BuildPrefixList reads the same input as BuildIPNodeList, but appends one node
+// per record, with the bounds of its prefix, in input order.  Nested prefixes
+// are kept as they are, for the lookup structures that handle them, like
+// iptrie.Trie.
+func BuildPrefixList(reader io.Reader, parser IPNodeParser) error {
+	csvReader := loader.NewCSVReader(reader, &prefixCSVConsumer{parser})
+	return csvReader.ReadAll()
+}
+
+// prefixCSVConsumer is the consumer for loader.CSVReader used by BuildPrefixList.
+type prefixCSVConsumer struct {
+	IPNodeParser
+}
+
+// Consume appends a node for the record.
+func (c *prefixCSVConsumer) Consume(record []string) error {
+	lowIP, highIP, err := rangeCIDR(c.ExtractIP(record))
+	if err != nil {
+		return err
+	}
+	newNode := c.CreateNode()
+	newNode.SetIPBounds(lowIP, highIP)
+	err = c.PopulateRecordData(record, newNode)
+	if err != nil {
+		return err
+	}
+	c.AppendNode(newNode)
+	return nil
+}
+
 // finalizeStackAndList processes the remaining elements on the stack and closes the list
 // if it's necessary (if a parent range should have a subrange after the last embedded range)
 func finalizeStackAndList(stack []IPNode, parser IPNodeParser) []IPNode {
+	if len(stack) == 0 {
+		// There were no records.
+		return stack
+	}
 	var pop IPNode
 	pop, stack = stack[len(stack)-1], stack[:len(stack)-1]
 	for ; len(stack) > 0; pop, stack = stack[len(stack)-1], stack[:len(stack)-1] {
 		peek := stack[len(stack)-1]
-		peek.SetLowIP(plusOne(pop.GetHighIP()))
+		peek.SetLowIP(PlusOne(pop.GetHighIP()))
 		// KZ: there was a bug here (and is in the original implementation as well): when 2 ranges has only intersection
 		// and the first one does not contain entirely the next one, a wrong item got appended to the end of the list
 		// from the end of the latter range to the end of the prior range. This resulted in IPLow > IPHigh for this item.
@@ -240,19 +308,19 @@ func handleStack(stack []IPNode, parser IPNodeParser, newNode IPNode) []IPNode {
 				if lessThan(newNode.GetLowIP(), peek.GetHighIP()) {
 					// if there's a gap in between adjacent nested IP's,
 					// complete the gap
-					peekCpy.SetLowIP(plusOne(pop.GetHighIP()))
-					peekCpy.SetHighIP(minusOne(newNode.GetLowIP()))
+					peekCpy.SetLowIP(PlusOne(pop.GetHighIP()))
+					peekCpy.SetHighIP(MinusOne(newNode.GetLowIP()))
 					parser.AppendNode(peekCpy)
 					break
 				}
-				peekCpy.SetLowIP(plusOne(pop.GetHighIP()))
+				peekCpy.SetLowIP(PlusOne(pop.GetHighIP()))
 				parser.AppendNode(peekCpy)
 			}
 		} else {
 			// if we're nesting IP's
 			// create begnning bounds
 			lastListNode := parser.LastNode()
-			lastListNode.SetHighIP(minusOne(newNode.GetLowIP()))
+			lastListNode.SetHighIP(MinusOne(newNode.GetLowIP()))
 
 		}
 	}
@@ -314,26 +382,29 @@ func CIDRRange(lowIP, highIP net.IP) string {
 	return fmt.Sprintf("%s/%d", lowIP, mask)
 }
 
-// plusOne adds one to a net.IP.
-func plusOne(a net.IP) net.IP {
-	a = append([]byte(nil), a...)
-	var i int
-	for i = 15; a[i] == 255; i-- {
-		a[i] = 0
+// PlusOne returns the address after a, in the 16 byte form, or nil for the
+// last IPv6 address.
+func PlusOne(a net.IP) net.IP {
+	a = append(net.IP(nil), a.To16()...)
+	for i := len(a) - 1; i >= 0; i-- {
+		a[i]++
+		if a[i] != 0 {
+			return a
+		}
 	}
-	a[i]++
-	return a
+	return nil
 }
 
-// minusOne subtracts one from a net.IP.
-func minusOne(a net.IP) net.IP {
-	a = append([]byte(nil), a...)
-	var i int
-	for i = 15; a[i] == 0; i-- {
-		a[i] = 255
+// MinusOne returns the address before a, in the 16 byte form, or nil for ::.
+func MinusOne(a net.IP) net.IP {
+	a = append(net.IP(nil), a.To16()...)
+	for i := len(a) - 1; i >= 0; i-- {
+		a[i]--
+		if a[i] != 0xff {
+			return a
+		}
 	}
-	a[i]--
-	return a
+	return nil
 }
 
 // lessThan returns true if the net.IP in the first argument is smaller than the net.IP in
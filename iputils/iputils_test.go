@@ -231,7 +231,7 @@ func TestHandleStackIntersection(t *testing.T) {
 	}, parser.list)
 }
 
-// TestPlusOneMinusOne tests the plusOne and minusOne functions
+// TestPlusOneMinusOne tests the PlusOne and MinusOne functions
 func TestPlusOneMinusOne(t *testing.T) {
 	sourceIps := []string{
 		"192.0.0.1",
@@ -249,11 +249,14 @@ func TestPlusOneMinusOne(t *testing.T) {
 		"0.255.255.255",
 	}
 	for idx, source := range sourceIps {
-		gotPlus, gotMinus := plusOne(net.ParseIP(source)), minusOne(net.ParseIP(source))
+		gotPlus, gotMinus := PlusOne(net.ParseIP(source)), MinusOne(net.ParseIP(source))
 		expPlus, expMinus := net.ParseIP(expectedPlusResult[idx]), net.ParseIP(expectedMinusResult[idx])
 		assert.True(t, expPlus.Equal(gotPlus), "%s + 1 should be %s, but got %s", source, expPlus.String(), gotPlus.String())
 		assert.True(t, expMinus.Equal(gotMinus), "%s - 1 should be %s, but got %s", source, expMinus.String(), gotMinus.String())
 	}
+	assert.Nil(t, PlusOne(net.ParseIP("ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff")))
+	assert.Nil(t, MinusOne(net.ParseIP("::")))
+	assert.True(t, net.ParseIP("1.0.0.1").Equal(PlusOne(net.ParseIP("1.0.0.0").To4())))
 }
 
 // TestLessThan tests the lessThan method
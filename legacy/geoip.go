@@ -1,61 +1,78 @@
 // Package legacy supports legacy MaxMind data lookups.
 // TODO - should probably rename this legacy?
-/* Go (cgo) interface to libgeoip
-   originally forked from github.com/abh/geoip
+/* Pure Go reader for the legacy MaxMind GeoIP binary (.dat) format.
+   The API was originally forked from github.com/abh/geoip, and the lookup
+   logic follows libgeoip 1.6.
 */
 package legacy
 
-/*
-#cgo pkg-config: geoip
-#include <stdio.h>
-#include <errno.h>
-#include <GeoIP.h>
-#include <GeoIPCity.h>
-//typedef GeoIP* GeoIP_pnt
-*/
-import "C"
-
 import (
+	"bytes"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
+	"net"
 	"os"
 	"regexp"
 	"sync"
-	"unsafe"
 
+	"github.com/m-lab/annotation-service/api"
 	"github.com/m-lab/go/rtx"
 )
 
 // This is the regex used to filter for which files we want to consider acceptable for using with legacy dataset
 var geoLegacyv6Regex = regexp.MustCompile(`.*-GeoLiteCityv6.dat.*`)
 
-// GeoIPOptions enum in GeoIP.h
+// Database types, from the GeoIPDBTypes enum in GeoIP.h.
 const (
-	Standard    = 0
-	MemoryCache = 1
-	CheckCache  = 2
-	IndexCache  = 4
-	MMapCache   = 8
+	CountryEdition       = 1
+	CityEditionRev1      = 2
+	ISPEdition           = 4
+	OrgEdition           = 5
+	CityEditionRev0      = 6
+	ASNumEdition         = 9
+	DomainEdition        = 11
+	CountryEditionV6     = 12
+	ASNumEditionV6       = 21
+	ISPEditionV6         = 22
+	OrgEditionV6         = 23
+	DomainEditionV6      = 24
+	CityEditionRev1V6    = 30
+	CityEditionRev0V6    = 31
+	countryBegin         = 16776960
+	structureInfoMaxSize = 20
+	segmentRecordLength  = 3
+	standardRecordLength = 3
+	orgRecordLength      = 4
+	fullRecordLength     = 50
+	maxOrgRecordLength   = 300
+
+	// charsetUTF8 is GEOIP_CHARSET_UTF8.  City names are always returned as UTF-8.
+	charsetUTF8 = 1
 )
 
 var (
+	// ErrInvalidDatabase is returned when the data is too short to hold a GeoIP database.
+	ErrInvalidDatabase = api.NewError("Invalid GeoIP database", api.ErrCorrupt)
+
 	// once is used to make sure loading the fips2iso map only happens once.
 	once sync.Once
 )
 
 // GeoIP contains a single v4 or v6 dataset for a particular day.
+// The whole database is held in memory, and lookups are safe for
+// concurrent use.
 type GeoIP struct {
-	db *C.GeoIP
+	data []byte
+
+	databaseType int
+	segment      uint32 // databaseSegments[0] in libgeoip.
+	recordLength int
 
 	isIPv4 bool
 	name   string
 
-	// We don't use GeoIP's thread-safe API calls, which means there is a
-	// single global netmask variable that gets clobbered in the main
-	// lookup routine.  Any calls which have _GeoIP_seek_record_gl need to
-	// be wrapped in this mutex.
-	mu sync.Mutex
-
 	// Counter that how many times Free() was called.
 	freeCalled uint32
 
@@ -63,63 +80,58 @@ type GeoIP struct {
 	fips2ISOMap map[string]subdivision
 }
 
-// Free the memory hold by GeoIP dataset. Mutex should be held for this operation.
+// Free releases the memory held by the GeoIP dataset.
 func (gi *GeoIP) Free() {
 	if gi == nil {
 		log.Println("Attempt to free from nil GeoIP pointer")
 		return
 	}
-	if gi.db == nil || gi.freeCalled >= 1 {
+	if gi.data == nil || gi.freeCalled >= 1 {
 		log.Println("GeoIP db already nil")
 		return
 	}
 	log.Println("free memory for legacy dataset: " + gi.name)
-	C.GeoIP_delete(gi.db)
+	gi.data = nil
 	gi.freeCalled++
 	return
 }
 
-// GetFreeCached returns how many times Free() was called.
+// GetFreeCalled returns how many times Free() was called.
 func (gi *GeoIP) GetFreeCalled() uint32 {
 	return gi.freeCalled
 }
 
-// Open opens a DB. It is a default convenience wrapper around OpenDB.
-func Open(filename string, datasetName string) (*GeoIP, error) {
-	return OpenDB(filename, MemoryCache, datasetName)
+// DatabaseType returns the GeoIP database type, e.g. CityEditionRev1.
+func (gi *GeoIP) DatabaseType() int {
+	return gi.databaseType
 }
 
-// OpenDB opens a GeoIP database by filename with specified GeoIPOptions flag.
-// All formats supported by libgeoip are supported though there are only
-// functions to access some of the databases in this API.
-func OpenDB(file string, flag int, datasetName string) (*GeoIP, error) {
-	g := &GeoIP{}
-	// Original code has "runtime.SetFinalizer(g, (*GeoIP).Free)" here
-	// But it caused the loaded DB kicked out from memory immediately and
-	// has to be loaded again. So we remove it for now.
-
-	var err error
-
-	if _, err := os.Stat(file); err != nil {
-		return nil, fmt.Errorf("Error get Fileinfo of GeoIP database (%s): %s", file, err)
+// Open reads the GeoIP database in filename into memory.
+func Open(filename string, datasetName string) (*GeoIP, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("Error opening GeoIP database (%s): %s", filename, err)
 	}
+	defer f.Close()
+	return Read(f, datasetName)
+}
 
-	cbase := C.CString(file)
-	defer C.free(unsafe.Pointer(cbase))
-
-	g.db, err = C.GeoIP_open(cbase, C.int(flag))
-
+// Read reads a complete GeoIP database from r into memory.
+func Read(r io.Reader, datasetName string) (*GeoIP, error) {
+	data, err := ioutil.ReadAll(r)
 	if err != nil {
-		return nil, fmt.Errorf("Error opening GeoIP database (%s): %s", file, err)
+		return nil, fmt.Errorf("Error reading GeoIP database (%s): %s", datasetName, err)
 	}
+	return FromBytes(data, datasetName)
+}
 
-	if g.db == nil {
-		return nil, fmt.Errorf("Didn't open GeoIP database (%s)", file)
+// FromBytes creates a GeoIP from the content of a GeoIP database.  The data
+// slice is used directly, and must not be modified afterwards.
+func FromBytes(data []byte, datasetName string) (*GeoIP, error) {
+	g := &GeoIP{data: data, name: datasetName}
+	if err := g.setupSegments(); err != nil {
+		return nil, fmt.Errorf("Didn't open GeoIP database (%s): %s", datasetName, err)
 	}
-
-	C.GeoIP_set_charset(g.db, C.GEOIP_CHARSET_UTF8)
-	g.name = datasetName
-	g.freeCalled = 0
 	g.isIPv4 = !geoLegacyv6Regex.MatchString(datasetName)
 
 	once.Do(func() {
@@ -133,75 +145,96 @@ func OpenDB(file string, flag int, datasetName string) (*GeoIP, error) {
 	return g, nil
 }
 
-// SetCustomDirectory sets the default location for the GeoIP .dat files used when
-// calling OpenType()
-func SetCustomDirectory(dir string) {
-	cdir := C.CString(dir)
-	// GeoIP doesn't copy the string, so don't free it when we're done here.
-	// defer C.free(unsafe.Pointer(cdir))
-	C.GeoIP_setup_custom_directory(cdir)
-}
-
-// OpenTypeFlag opens a specified GeoIP database type in the default location with the
-// specified GeoIPOptions flag. Constants are defined for each database type
-// (for example GEOIP_COUNTRY_EDITION).
-func OpenTypeFlag(dbType int, flag int) (*GeoIP, error) {
-	g := &GeoIP{}
-	// Original code has "runtime.SetFinalizer(g, (*GeoIP).Free)" here
-	// removed for the same reason above.
-
-	var err error
-
-	g.db, err = C.GeoIP_open_type(C.int(dbType), C.int(flag))
-	if err != nil {
-		return nil, fmt.Errorf("Error opening GeoIP database (%d): %s", dbType, err)
+// setupSegments reads the database info that follows the search tree, as
+// _setup_segments does in libgeoip.  The info is marked by three 0xFF bytes
+// somewhere in the last structureInfoMaxSize+3 bytes of the database.
+func (gi *GeoIP) setupSegments() error {
+	if len(gi.data) < 2*standardRecordLength {
+		return ErrInvalidDatabase
 	}
-
-	if g.db == nil {
-		return nil, fmt.Errorf("Didn't open GeoIP database (%d)", dbType)
+	gi.databaseType = CountryEdition
+	gi.recordLength = standardRecordLength
+	gi.segment = countryBegin
+
+	for i := 0; i < structureInfoMaxSize; i++ {
+		pos := len(gi.data) - 3 - i
+		if pos < 0 {
+			break
+		}
+		if !bytes.Equal(gi.data[pos:pos+3], []byte{0xff, 0xff, 0xff}) {
+			continue
+		}
+		if pos+3 >= len(gi.data) {
+			break
+		}
+		gi.databaseType = int(gi.data[pos+3])
+		if gi.databaseType >= 106 {
+			// Backwards compatibility with databases from April 2003 and earlier.
+			gi.databaseType -= 105
+		}
+		switch gi.databaseType {
+		case CityEditionRev0, CityEditionRev1, CityEditionRev0V6, CityEditionRev1V6,
+			OrgEdition, OrgEditionV6, DomainEdition, DomainEditionV6,
+			ISPEdition, ISPEditionV6, ASNumEdition, ASNumEditionV6:
+			start := pos + 4
+			if start+segmentRecordLength > len(gi.data) {
+				return ErrInvalidDatabase
+			}
+			gi.segment = uint32(readLE(gi.data[start : start+segmentRecordLength]))
+			switch gi.databaseType {
+			case OrgEdition, OrgEditionV6, DomainEdition, DomainEditionV6, ISPEdition, ISPEditionV6:
+				gi.recordLength = orgRecordLength
+			}
+		}
+		break
 	}
-
-	C.GeoIP_set_charset(g.db, C.GEOIP_CHARSET_UTF8)
-	g.freeCalled = 0
-	return g, nil
+	return nil
 }
 
-// OpenType opens a specified GeoIP database type in the default location
-// and the 'memory cache' flag. Use OpenTypeFlag() to specify flag.
-func OpenType(dbType int) (*GeoIP, error) {
-	return OpenTypeFlag(dbType, MemoryCache)
+// readLE decodes a little endian unsigned integer of up to 4 bytes.
+func readLE(b []byte) uint32 {
+	var x uint32
+	for j := range b {
+		x |= uint32(b[j]) << (uint(j) * 8)
+	}
+	return x
 }
 
-// GetOrg takes an IPv4 address string and returns the organization name for that IP.
-// Requires the GeoIP organization database.
-// TODO remove this code.
-func (gi *GeoIP) GetOrg(ip string) string {
-	name, _ := gi.GetName(ip)
-	return name
+// seekRecord walks the search tree for ip, which must be 4 or 16 bytes long,
+// and returns the record offset and the netmask of the matching block.
+func (gi *GeoIP) seekRecord(ip net.IP) (uint32, int) {
+	bits := len(ip) * 8
+	offset := uint32(0)
+	for depth := bits - 1; depth >= 0; depth-- {
+		p := int(offset) * 2 * gi.recordLength
+		if p+2*gi.recordLength > len(gi.data) {
+			// Corrupt tree.  libgeoip treats this as not found.
+			return gi.segment, 0
+		}
+		bit := bits - 1 - depth
+		if ip[bit/8]&(0x80>>(uint(bit)%8)) != 0 {
+			p += gi.recordLength
+		}
+		x := readLE(gi.data[p : p+gi.recordLength])
+		if x >= gi.segment {
+			return x, bits - depth
+		}
+		offset = x
+	}
+	return gi.segment, 0
 }
 
-// GetName works on the ASN, Netspeed, Organization and probably other
-// databases, takes an IP string and returns a "name" and the netmask.
-// TODO remove this code.
-func (gi *GeoIP) GetName(ip string) (name string, netmask int) {
-	if gi.db == nil {
-		return
+// normalize converts ip to the form expected by the database.  It returns nil
+// if the IP is invalid or of the wrong type.
+func (gi *GeoIP) normalize(ip string, isIP4 bool) net.IP {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return nil
 	}
-
-	gi.mu.Lock()
-	defer gi.mu.Unlock()
-
-	cip := C.CString(ip)
-	defer C.free(unsafe.Pointer(cip))
-	cname := C.GeoIP_name_by_addr(gi.db, cip)
-
-	if cname != nil {
-		name = C.GoString(cname)
-		defer C.free(unsafe.Pointer(cname))
-		netmask = int(C.GeoIP_last_netmask(gi.db))
-		return
+	if isIP4 {
+		return parsed.To4()
 	}
-	return
+	return parsed.To16()
 }
 
 // GeoIPRecord contains a single record for a particular IP block.
@@ -225,165 +258,193 @@ type GeoIPRecord struct {
 // Returns nil if IP is invalid, wrong type (v4/v6), or record is not found.
 // TODO - consider returning different error codes.
 func (gi *GeoIP) GetRecord(ip string, isIP4 bool) *GeoIPRecord {
-	if gi.db == nil {
+	if gi.data == nil {
 		return nil
 	}
 
 	if len(ip) == 0 || isIP4 != gi.isIPv4 {
 		return nil
 	}
-
-	cip := C.CString(ip)
-	defer C.free(unsafe.Pointer(cip))
-
-	var record *C.GeoIPRecord
-	// We are using a non-thread-safe API, so we grab the lock.
-	// TODO - can we use the thread-safe API insteaad?
-	gi.mu.Lock()
-	if gi.isIPv4 {
-		record = C.GeoIP_record_by_addr(gi.db, cip)
-	} else {
-		record = C.GeoIP_record_by_addr_v6(gi.db, cip)
+	switch gi.databaseType {
+	case CityEditionRev0, CityEditionRev1:
+		if !isIP4 {
+			return nil
+		}
+	case CityEditionRev0V6, CityEditionRev1V6:
+		if isIP4 {
+			return nil
+		}
+	default:
+		return nil
 	}
-	gi.mu.Unlock()
+	parsed := gi.normalize(ip, isIP4)
+	if parsed == nil {
+		return nil
+	}
+	seek, _ := gi.seekRecord(parsed)
+	return gi.extractRecord(seek)
+}
 
-	if record == nil {
+// extractRecord decodes the city record at seek, as _extract_record does in libgeoip.
+func (gi *GeoIP) extractRecord(seek uint32) *GeoIPRecord {
+	if seek == gi.segment {
 		return nil
 	}
-	// defer C.free(unsafe.Pointer(record))
-	defer C.GeoIPRecord_delete(record)
-	rec := new(GeoIPRecord)
-	rec.CountryCode = C.GoString(record.country_code)
-	rec.CountryCode3 = C.GoString(record.country_code3)
-	rec.CountryName = C.GoString(record.country_name)
-	rec.Region = C.GoString(record.region)
-	rec.City = C.GoString(record.city)
-	rec.PostalCode = C.GoString(record.postal_code)
-	rec.Latitude = float32(record.latitude)
-	rec.Longitude = float32(record.longitude)
-	rec.CharSet = int(record.charset)
-	rec.ContinentCode = C.GoString(record.continent_code)
-
-	if gi.db.databaseType != C.GEOIP_CITY_EDITION_REV0 {
-		/* DIRTY HACK BELOW:
-		   The GeoIPRecord struct in GeoIPCity.h contains an int32 union of metro_code and dma_code.
-		   The union is unnamed, so cgo names it anon0 and assumes it's a 4-byte array.
-		*/
-		unionInt := (*int32)(unsafe.Pointer(&record.anon0))
-		rec.MetroCode = int(*unionInt)
-		rec.AreaCode = int(record.area_code)
+	pointer := int(seek) + (2*gi.recordLength-1)*int(gi.segment)
+	if pointer >= len(gi.data) {
+		return nil
+	}
+	end := pointer + fullRecordLength
+	if end > len(gi.data) {
+		end = len(gi.data)
+	}
+	buf := gi.data[pointer:end]
+
+	rec := &GeoIPRecord{CharSet: charsetUTF8}
+	c := countries[buf[0]]
+	rec.CountryCode = c.Code
+	rec.CountryCode3 = c.Code3
+	rec.CountryName = c.Name
+	rec.ContinentCode = c.Continent
+	buf = buf[1:]
+
+	var ok bool
+	if rec.Region, buf, ok = nextString(buf); !ok {
+		return nil
+	}
+	var city string
+	if city, buf, ok = nextString(buf); !ok {
+		return nil
+	}
+	rec.City = latin1ToUTF8(city)
+	if rec.PostalCode, buf, ok = nextString(buf); !ok {
+		return nil
 	}
 
+	if len(buf) < 6 {
+		return nil
+	}
+	rec.Latitude = float32(float64(readLE(buf[0:3]))/10000 - 180)
+	rec.Longitude = float32(float64(readLE(buf[3:6]))/10000 - 180)
+	buf = buf[6:]
+
+	// Area and metro codes are only present for US locations in rev1 databases.
+	if (gi.databaseType == CityEditionRev1 || gi.databaseType == CityEditionRev1V6) && rec.CountryCode == "US" {
+		if len(buf) < 3 {
+			return nil
+		}
+		combo := int(readLE(buf[0:3]))
+		rec.MetroCode = combo / 1000
+		rec.AreaCode = combo % 1000
+	}
 	return rec
 }
 
-// GetRegion returns the country code and region code for an IP address. Requires
-// the GeoIP Region database.
-func (gi *GeoIP) GetRegion(ip string) (string, string) {
-	if gi.db == nil {
-		return "", ""
+// nextString returns the null terminated string at the start of buf, and the
+// remainder of buf following the terminator.
+func nextString(buf []byte) (string, []byte, bool) {
+	i := bytes.IndexByte(buf, 0)
+	if i < 0 {
+		return "", nil, false
 	}
+	return string(buf[:i]), buf[i+1:], true
+}
 
-	cip := C.CString(ip)
-	defer C.free(unsafe.Pointer(cip))
-
-	gi.mu.Lock()
-	region := C.GeoIP_region_by_addr(gi.db, cip)
-	gi.mu.Unlock()
-
-	if region == nil {
-		return "", ""
+// latin1ToUTF8 converts an ISO-8859-1 string to UTF-8.
+func latin1ToUTF8(s string) string {
+	runes := make([]rune, len(s))
+	for i := 0; i < len(s); i++ {
+		runes[i] = rune(s[i])
 	}
-
-	countryCode := C.GoString(&region.country_code[0])
-	regionCode := C.GoString(&region.region[0])
-	defer C.free(unsafe.Pointer(region))
-
-	return countryCode, regionCode
+	return string(runes)
 }
 
-// GetRegionName returns the region name given a country code and region code
-func GetRegionName(countryCode, regionCode string) string {
-
-	cc := C.CString(countryCode)
-	defer C.free(unsafe.Pointer(cc))
-
-	rc := C.CString(regionCode)
-	defer C.free(unsafe.Pointer(rc))
+// GetOrg takes an IPv4 address string and returns the organization name for that IP.
+// Requires the GeoIP organization database.
+// TODO remove this code.
+func (gi *GeoIP) GetOrg(ip string) string {
+	name, _ := gi.GetName(ip)
+	return name
+}
 
-	region := C.GeoIP_region_name_by_code(cc, rc)
-	if region == nil {
-		return ""
+// GetName works on the ASN, Organization and probably other
+// databases, takes an IP string and returns a "name" and the netmask.
+// TODO remove this code.
+func (gi *GeoIP) GetName(ip string) (name string, netmask int) {
+	switch gi.databaseType {
+	case OrgEdition, ISPEdition, DomainEdition, ASNumEdition:
+		return gi.getName(ip, true)
 	}
-
-	// it's a static string constant, don't free this
-	regionName := C.GoString(region)
-
-	return regionName
+	return
 }
 
 // GetNameV6 is same as GetName() but for IPv6 addresses.
 // TODO remove this code.
 func (gi *GeoIP) GetNameV6(ip string) (name string, netmask int) {
-	if gi.db == nil {
-		return
+	switch gi.databaseType {
+	case OrgEditionV6, ISPEditionV6, DomainEditionV6, ASNumEditionV6:
+		return gi.getName(ip, false)
 	}
+	return
+}
 
-	gi.mu.Lock()
-	defer gi.mu.Unlock()
-
-	cip := C.CString(ip)
-	defer C.free(unsafe.Pointer(cip))
-	cname := C.GeoIP_name_by_addr_v6(gi.db, cip)
-
-	if cname != nil {
-		name = C.GoString(cname)
-		defer C.free(unsafe.Pointer(cname))
-		netmask = int(C.GeoIP_last_netmask(gi.db))
+func (gi *GeoIP) getName(ip string, isIP4 bool) (name string, netmask int) {
+	if gi.data == nil {
 		return
 	}
-	return
+	parsed := gi.normalize(ip, isIP4)
+	if parsed == nil {
+		return
+	}
+	seek, mask := gi.seekRecord(parsed)
+	if seek == gi.segment {
+		return
+	}
+	pointer := int(seek) + (2*gi.recordLength-1)*int(gi.segment)
+	if pointer >= len(gi.data) {
+		return
+	}
+	end := pointer + maxOrgRecordLength
+	if end > len(gi.data) {
+		end = len(gi.data)
+	}
+	name, _, ok := nextString(gi.data[pointer:end])
+	if !ok {
+		return "", 0
+	}
+	return name, mask
 }
 
 // GetCountry takes an IPv4 address string and returns the country code for that IP
 // and the netmask for that IP range.
 func (gi *GeoIP) GetCountry(ip string) (cc string, netmask int) {
-	if gi.db == nil {
+	if gi.databaseType != CountryEdition {
 		return
 	}
-
-	gi.mu.Lock()
-	defer gi.mu.Unlock()
-
-	cip := C.CString(ip)
-	defer C.free(unsafe.Pointer(cip))
-	ccountry := C.GeoIP_country_code_by_addr(gi.db, cip)
-
-	if ccountry != nil {
-		cc = C.GoString(ccountry)
-		netmask = int(C.GeoIP_last_netmask(gi.db))
-		return
-	}
-	return
+	return gi.getCountry(ip, true)
 }
 
 // GetCountryV6 works the same as GetCountry except for IPv6 addresses, be sure to
 // load a database with IPv6 data to get any results.
 func (gi *GeoIP) GetCountryV6(ip string) (cc string, netmask int) {
-	if gi.db == nil {
+	if gi.databaseType != CountryEditionV6 {
 		return
 	}
+	return gi.getCountry(ip, false)
+}
 
-	gi.mu.Lock()
-	defer gi.mu.Unlock()
-
-	cip := C.CString(ip)
-	defer C.free(unsafe.Pointer(cip))
-	ccountry := C.GeoIP_country_code_by_addr_v6(gi.db, cip)
-	if ccountry != nil {
-		cc = C.GoString(ccountry)
-		netmask = int(C.GeoIP_last_netmask(gi.db))
+func (gi *GeoIP) getCountry(ip string, isIP4 bool) (cc string, netmask int) {
+	if gi.data == nil {
 		return
 	}
-	return
+	parsed := gi.normalize(ip, isIP4)
+	if parsed == nil {
+		return
+	}
+	seek, mask := gi.seekRecord(parsed)
+	id := int(seek) - countryBegin
+	if id <= 0 || id >= len(countries) {
+		return
+	}
+	return countries[id].Code, mask
 }
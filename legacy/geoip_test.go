@@ -3,6 +3,8 @@ package legacy_test
 import (
 	"testing"
 
+	"github.com/go-test/deep"
+
 	"github.com/m-lab/annotation-service/legacy"
 )
 
@@ -25,3 +27,103 @@ func TestOpenAndFree(t *testing.T) {
 		t.Fatal("Space not freed properly")
 	}
 }
+
+func TestGetRecord(t *testing.T) {
+	legacy.Fips2ISOMapFile = "testdata/fips-iso-map-test.csv"
+	v4, err := legacy.Open("testdata/GeoLiteCity.dat", "20140307T160000Z-GeoLiteCity.dat.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	v6, err := legacy.Open("testdata/GeoLiteCityv6.dat", "20140307T160000Z-GeoLiteCityv6.dat.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v4.DatabaseType() != legacy.CityEditionRev1 || v6.DatabaseType() != legacy.CityEditionRev1V6 {
+		t.Fatal("wrong database types", v4.DatabaseType(), v6.DatabaseType())
+	}
+
+	tests := []struct {
+		name  string
+		gi    *legacy.GeoIP
+		ip    string
+		isIP4 bool
+		want  *legacy.GeoIPRecord
+	}{
+		{
+			name: "us-rev1-metro-and-area", gi: v4, ip: "207.171.7.51", isIP4: true,
+			want: &legacy.GeoIPRecord{
+				CountryCode: "US", CountryCode3: "USA", CountryName: "United States",
+				Region: "CA", City: "El Segundo", PostalCode: "90245",
+				Latitude: 33.9164, Longitude: -118.4041, AreaCode: 310, MetroCode: 803,
+				CharSet: 1, ContinentCode: "NA",
+			},
+		},
+		{
+			name: "non-us", gi: v4, ip: "1.4.128.0", isIP4: true,
+			want: &legacy.GeoIPRecord{
+				CountryCode: "TH", CountryCode3: "THA", CountryName: "Thailand",
+				Region: "40", City: "Bangkok", Latitude: 13.754, Longitude: 100.501,
+				CharSet: 1, ContinentCode: "AS",
+			},
+		},
+		{
+			name: "latin1-city-name", gi: v4, ip: "194.95.1.1", isIP4: true,
+			want: &legacy.GeoIPRecord{
+				CountryCode: "DE", CountryCode3: "DEU", CountryName: "Germany",
+				Region: "02", City: "München", PostalCode: "80331", Latitude: 48.15, Longitude: 11.5833,
+				CharSet: 1, ContinentCode: "EU",
+			},
+		},
+		{
+			name: "v6", gi: v6, ip: "2620:0:1003:415:fa1e:73f3:ec68:7709", isIP4: false,
+			want: &legacy.GeoIPRecord{
+				CountryCode: "US", CountryCode3: "USA", CountryName: "United States",
+				Latitude: 38, Longitude: -97, CharSet: 1, ContinentCode: "NA",
+			},
+		},
+		{
+			name: "v6-city", gi: v6, ip: "2001:5a0:4300::1", isIP4: false,
+			want: &legacy.GeoIPRecord{
+				CountryCode: "US", CountryCode3: "USA", CountryName: "United States",
+				Region: "NY", City: "New York", PostalCode: "10001", Latitude: 40.7143, Longitude: -74.006,
+				AreaCode: 212, MetroCode: 501, CharSet: 1, ContinentCode: "NA",
+			},
+		},
+		{name: "not-found", gi: v4, ip: "207.171.8.1", isIP4: true},
+		{name: "not-found-v6", gi: v6, ip: "2001:5a0:4400::1", isIP4: false},
+		{name: "wrong-type", gi: v4, ip: "2620:0:1003::1", isIP4: false},
+		{name: "invalid-ip", gi: v4, ip: "207.171.7", isIP4: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.gi.GetRecord(tt.ip, tt.isIP4)
+			if diff := deep.Equal(got, tt.want); diff != nil {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+func TestGetCountryV6(t *testing.T) {
+	legacy.Fips2ISOMapFile = "testdata/fips-iso-map-test.csv"
+	gi, err := legacy.Open("testdata/GeoIPv6.dat", "GeoIPv6.dat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gi.DatabaseType() != legacy.CountryEditionV6 {
+		t.Fatal("wrong database type", gi.DatabaseType())
+	}
+	cc, netmask := gi.GetCountryV6("2607:f238:2::5")
+	if cc != "US" || netmask == 0 {
+		t.Error("GetCountryV6() =", cc, netmask)
+	}
+	if cc, _ := gi.GetCountryV6("2a00:1450::1"); cc != "IE" {
+		t.Error("GetCountryV6(2a00:1450::1) =", cc)
+	}
+	if cc, _ := gi.GetCountryV6("fc00::1"); cc != "" {
+		t.Error("GetCountryV6(fc00::1) =", cc)
+	}
+	if cc, _ := gi.GetCountry("1.2.3.4"); cc != "" {
+		t.Error("GetCountry() on v6 database =", cc)
+	}
+}
@@ -84,12 +84,14 @@ gs://downloader-mlab-oti/Maxmind/2018/02/08/20180208T013555Z-GeoLite2-City-CSV.z
 
 */
 import (
+	"compress/gzip"
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"log"
-	"os"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -110,10 +112,13 @@ var (
 	ErrInvalidDatasetFilename = errors.New("Invalid input dataset name")
 
 	// ErrNoRecord is returned when there is no record for requested IP in the dataset.
-	ErrNoRecord = errors.New("No record in dataset")
+	ErrNoRecord = api.NewError("No record in dataset", api.ErrNotFound)
 
 	// ErrDatasetNotLoaded is returned when the IPv4 or IPv6 dataset was not loaded properly.
-	ErrDatasetNotLoaded = errors.New("Dataset was not loaded properly")
+	ErrDatasetNotLoaded = api.NewError("Dataset was not loaded properly", api.ErrNotLoaded)
+
+	// ErrWrongIPFamily is returned when an IPv4 address is requested from an IPv6 dataset, or vice versa.
+	ErrWrongIPFamily = api.NewError("IP family does not match dataset", api.ErrNotApplicable)
 )
 
 // Annotator contains pointer to the dataset used to hold and lookup IP data.
@@ -123,6 +128,7 @@ type Annotator struct {
 	dataset *GeoIP
 
 	startDate time.Time // This is static after construction.  Lock not required.
+	name      string    // The object the dataset was loaded from.  Also static.
 }
 
 // Annotate adds GeoLocation annotations.
@@ -138,6 +144,9 @@ func (gi *Annotator) Annotate(IP string, data *api.GeoData) error {
 	}
 	var record *GeoIPRecord
 	isIPv4 := ip.To4() != nil
+	if isIPv4 != gi.dataset.isIPv4 {
+		return ErrWrongIPFamily
+	}
 	record = gi.dataset.GetRecord(IP, isIPv4)
 
 	// It is very possible that the record missed some fields in legacy dataset.
@@ -170,33 +179,56 @@ func (gi *Annotator) AnnotatorDate() time.Time {
 	return gi.startDate
 }
 
+// Provenance returns the name and date of the dataset.
+func (gi *Annotator) Provenance() []api.Provenance {
+	return []api.Provenance{{Type: api.LegacyType, Name: gi.name, Date: gi.startDate}}
+}
+
+// Size returns the approximate memory used by the dataset, in bytes.
+func (gi *Annotator) Size() int64 {
+	gi.lock.RLock()
+	defer gi.lock.RUnlock()
+	if gi.dataset == nil {
+		return 0
+	}
+	return int64(len(gi.dataset.data))
+}
+
 // LoadLegacyDataset loads the requested dataset into memory.
-func LoadLegacyDataset(filename string, bucketname string) (*Annotator, error) {
+func LoadLegacyDataset(src loader.Source, filename string) (*Annotator, error) {
 	date, err := api.ExtractDateFromFilename(filename)
 	if err != nil {
 		log.Println("Error extracting date:", filename)
 		return nil, ErrDateExtractionFailed
 	}
-	ann, err := LoadGeoliteDataset(filename, bucketname)
+	ann, err := LoadGeoliteDataset(src, filename)
 	if err != nil {
 		return nil, ErrLoadLegacyFailed
 	}
-	return &Annotator{dataset: ann, startDate: date}, nil
+	return &Annotator{dataset: ann, startDate: date, name: filename}, nil
 }
 
-// LoadGeoliteDataset will check GCS for the matching dataset, download
+// LoadGeoliteDataset will check the dataset source for the matching dataset, download
 // it, process it, and load it into memory so that it can be easily
 // searched, then it will return a pointer to that GeoDataset or an error.
-func LoadGeoliteDataset(filename string, bucketname string) (*GeoIP, error) {
-	// load the legacy binary dataset
-	dataFileName := loader.GetGzBase(filename)
-	err := loader.UncompressGzFile(context.Background(), bucketname, filename, dataFileName)
+// Gzipped datasets are decompressed while reading, without using local disk.
+func LoadGeoliteDataset(src loader.Source, filename string) (*GeoIP, error) {
+	rdr, err := src.NewReader(context.Background(), filename)
 	if err != nil {
 		return nil, err
 	}
-	defer os.Remove(dataFileName)
-
-	return Open(dataFileName, filename)
+	defer rdr.Close()
+
+	var data io.Reader = rdr
+	if strings.HasSuffix(filename, ".gz") {
+		gzr, err := gzip.NewReader(rdr)
+		if err != nil {
+			return nil, err
+		}
+		defer gzr.Close()
+		data = gzr
+	}
+	return Read(data, filename)
 }
 
 // TODO - remove this and use Math.Round()
@@ -208,9 +240,9 @@ func round(x float32) float64 {
 	return i
 }
 
-// LoadAnnotator loads a legacy Annotator from a GCS object.
-func LoadAnnotator(file *storage.ObjectAttrs) (api.Annotator, error) {
-	dataset, err := LoadGeoliteDataset(file.Name, file.Bucket)
+// LoadAnnotator loads a legacy Annotator from an object in the dataset source.
+func LoadAnnotator(src loader.Source, file *storage.ObjectAttrs) (api.Annotator, error) {
+	dataset, err := LoadGeoliteDataset(src, file.Name)
 	if err != nil {
 		return nil, err
 	}
@@ -218,5 +250,5 @@ func LoadAnnotator(file *storage.ObjectAttrs) (api.Annotator, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &Annotator{startDate: date, dataset: dataset}, nil
+	return &Annotator{startDate: date, dataset: dataset, name: file.Name}, nil
 }
@@ -1,20 +1,30 @@
 package legacy_test
 
 import (
+	"compress/gzip"
+	"io/ioutil"
 	"log"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
 
 	"github.com/go-test/deep"
 	"github.com/m-lab/annotation-service/api"
 	"github.com/m-lab/annotation-service/legacy"
+	"github.com/m-lab/annotation-service/loader"
 )
 
+var testSource = loader.NewGCSSource("downloader-mlab-testing")
+
 func TestLoadLegacyDataset(t *testing.T) {
 	if testing.Short() {
 		t.Skip("Skipping test that accesses GCS")
 	}
 	// Note this is slow - 3 to 5 seconds.
-	gi, err := legacy.LoadLegacyDataset("Maxmind/2017/04/08/20170408T080000Z-GeoLiteCityv6.dat.gz", "downloader-mlab-testing")
+	gi, err := legacy.LoadLegacyDataset(testSource, "Maxmind/2017/04/08/20170408T080000Z-GeoLiteCityv6.dat.gz")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -48,7 +58,7 @@ func TestLoadGeoliteDataset(t *testing.T) {
 	if testing.Short() {
 		t.Skip("Skipping test that accesses GCS")
 	}
-	gi, err := legacy.LoadGeoliteDataset("Maxmind/2014/03/07/20140307T160000Z-GeoLiteCity.dat.gz", "downloader-mlab-testing")
+	gi, err := legacy.LoadGeoliteDataset(testSource, "Maxmind/2014/03/07/20140307T160000Z-GeoLiteCity.dat.gz")
 	if err != nil {
 		log.Printf("Did not load legacy dataset correctly %v", err)
 	}
@@ -78,7 +88,7 @@ func TestLoadGeoliteDataset(t *testing.T) {
 }
 
 func TestLoadLegacyGeoliteV6Dataset(t *testing.T) {
-	gi, err := legacy.LoadGeoliteDataset("Maxmind/2014/03/07/20140307T160000Z-GeoLiteCityv6.dat.gz", "downloader-mlab-testing")
+	gi, err := legacy.LoadGeoliteDataset(testSource, "Maxmind/2014/03/07/20140307T160000Z-GeoLiteCityv6.dat.gz")
 	if err != nil {
 		log.Printf("Did not load legacy dataset correctly %v", err)
 	}
@@ -107,3 +117,57 @@ func TestLoadLegacyGeoliteV6Dataset(t *testing.T) {
 		}
 	}
 }
+
+func TestLoadAnnotatorFromDirSource(t *testing.T) {
+	legacy.Fips2ISOMapFile = "testdata/fips-iso-map-test.csv"
+	raw, err := ioutil.ReadFile("testdata/GeoLiteCity.dat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	name := "Maxmind/2014/03/07/20140307T160000Z-GeoLiteCity.dat.gz"
+	path := filepath.Join(dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gzw := gzip.NewWriter(f)
+	gzw.Write(raw)
+	gzw.Close()
+	f.Close()
+
+	ann, err := legacy.LoadAnnotator(loader.NewDirSource(dir), &storage.ObjectAttrs{Name: name})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := deep.Equal(ann.Provenance(), []api.Provenance{
+		{Type: api.LegacyType, Name: name, Date: time.Date(2014, 3, 7, 0, 0, 0, 0, time.UTC)}}); diff != nil {
+		t.Error(diff)
+	}
+	record := api.GeoData{}
+	if err := ann.Annotate("207.171.7.51", &record); err != nil {
+		t.Fatal(err)
+	}
+	if diff := deep.Equal(*record.Geo,
+		api.GeolocationIP{
+			ContinentCode: "NA",
+			CountryCode:   "US",
+			CountryCode3:  "USA",
+			CountryName:   "United States",
+			Region:        "CA",
+			MetroCode:     803,
+			City:          "El Segundo",
+			AreaCode:      310,
+			PostalCode:    "90245",
+			Latitude:      33.916,
+			Longitude:     -118.404,
+		}); diff != nil {
+		t.Error(diff)
+	}
+	if err := ann.Annotate("8.8.8.8", &api.GeoData{}); err != legacy.ErrNoRecord {
+		t.Error("Annotate() error =", err, "want", legacy.ErrNoRecord)
+	}
+}
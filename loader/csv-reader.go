@@ -2,16 +2,17 @@ package loader
 
 import (
 	"encoding/csv"
-	"errors"
 	"io"
 	"log"
+
+	"github.com/m-lab/annotation-service/api"
 )
 
 // Loader errors
 var (
-	ErrBadRecord     = errors.New("Corrupted Data: wrong number of columns")
-	ErrTooFewColumns = errors.New("Header has too few columns")
-	ErrTooManyErrors = errors.New("Too many errors during loading the dataset IP list")
+	ErrBadRecord     = api.NewError("Corrupted Data: wrong number of columns", api.ErrCorrupt)
+	ErrTooFewColumns = api.NewError("Header has too few columns", api.ErrCorrupt)
+	ErrTooManyErrors = api.NewError("Too many errors during loading the dataset IP list", api.ErrCorrupt)
 )
 
 var (
@@ -1,11 +1,10 @@
-// Package loader has tools for finding, reading, and uncompressing gzip files.
-// The UncompressGzFile is required for legacy MaxMind data used by the external MaxMind library.
+// Package loader has tools for finding and reading dataset files, such as zip archives.
+// Files are read through a Source, which may be a GCS bucket or a local directory.
 package loader
 
 import (
 	"archive/zip"
 	"bytes"
-	"compress/gzip"
 	"errors"
 	"io"
 	"io/ioutil"
@@ -13,8 +12,9 @@ import (
 	"path/filepath"
 	"strings"
 
-	"cloud.google.com/go/storage"
 	"golang.org/x/net/context"
+
+	"github.com/m-lab/annotation-service/api"
 )
 
 // GetGzBase extracts basename, such as "20140307T160000Z-GeoLiteCity.dat"
@@ -25,17 +25,10 @@ func GetGzBase(filename string) string {
 	return base[0 : len(base)-3]
 }
 
-// CreateZipReader reads a file from GCS and wraps it in a zip.Reader.
-func CreateZipReader(ctx context.Context, bucket string, bucketObj string) (*zip.Reader, error) {
-	client, err := storage.NewClient(ctx)
-	if err != nil {
-		log.Println(err)
-		return nil, errors.New("Failed creating new client")
-	}
-	obj := client.Bucket(bucket).Object(bucketObj)
-
+// CreateZipReader reads an object from the Source and wraps it in a zip.Reader.
+func CreateZipReader(ctx context.Context, src Source, name string) (*zip.Reader, error) {
 	// Takes context returns *Reader
-	reader, err := obj.NewReader(ctx)
+	reader, err := src.NewReader(ctx, name)
 	if err != nil {
 		log.Println(err)
 		return nil, errors.New("Failed creating new reader")
@@ -54,7 +47,7 @@ func CreateZipReader(ctx context.Context, bucket string, bucketObj string) (*zip
 	zipReader, err := zip.NewReader(r, int64(len(bytesSlice)))
 	if err != nil {
 		log.Println(err)
-		return nil, errors.New("Failed to create zip.Reader")
+		return nil, api.NewError("Failed to create zip.Reader", api.ErrCorrupt)
 	}
 	return zipReader, nil
 }
@@ -71,40 +64,6 @@ func FindFile(fn string, zrdr *zip.Reader) (io.ReadCloser, error) {
 		}
 	}
 	log.Println("File not found")
-	return nil, errors.New("File not found")
-}
-
-// UncompressGzFile reads a .gz file from GCS and write it to a local file.
-// Consumer should delete the file when finished.
-func UncompressGzFile(ctx context.Context, bucketName string, fileName string, outputFile string) error {
-	ctx = context.Background()
-	client, err := storage.NewClient(ctx)
-	if err != nil {
-		log.Println(err)
-		return errors.New("Failed creating new client")
-	}
-
-	// Creates a Bucket instance.
-	bucket := client.Bucket(bucketName)
-	obj := bucket.Object(fileName).ReadCompressed(true)
-
-	rdr, err := obj.NewReader(ctx)
-	if err != nil {
-		log.Println(err)
-		return err
-	}
-
-	gzr, err := gzip.NewReader(rdr)
-	if err != nil {
-		log.Println(err)
-		return err
-	}
-	// TODO - this could be done incrementally, to use less memory.
-	data, err := ioutil.ReadAll(gzr)
-	if err != nil {
-		return err
-	}
-
-	err = ioutil.WriteFile(outputFile, data, 0644)
-	return err
+	// The zip file is missing a member of the dataset.
+	return nil, api.NewError("File not found", api.ErrCorrupt)
 }
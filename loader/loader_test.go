@@ -14,7 +14,7 @@ func TestCreateZipReader(t *testing.T) {
 		log.Println("skipping test")
 		return
 	}
-	zipReader, err := loader.CreateZipReader(context.Background(), "test-annotator-sandbox", "MaxMind/2017/08/15/GeoLite2City.zip")
+	zipReader, err := loader.CreateZipReader(context.Background(), loader.NewGCSSource("test-annotator-sandbox"), "MaxMind/2017/08/15/GeoLite2City.zip")
 	if err != nil {
 		log.Println(err)
 		t.Errorf("Failed to create zipReader")
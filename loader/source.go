@@ -0,0 +1,192 @@
+package loader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+)
+
+var (
+	// ErrUnsupportedScheme is returned by NewSource for URLs other than gs:// or file://.
+	ErrUnsupportedScheme = errors.New("Unsupported dataset source scheme")
+)
+
+// ObjectIterator iterates over the objects in a Source.  Next returns
+// iterator.Done when there are no more objects.  *storage.ObjectIterator
+// satisfies this interface.
+type ObjectIterator interface {
+	Next() (*storage.ObjectAttrs, error)
+}
+
+// Source provides access to a tree of dataset objects, such as a GCS bucket
+// or a local directory, laid out as Maxmind/YYYY/MM/DD/... and
+// RouteViewIPv4/YYYY/MM/...
+type Source interface {
+	// Objects returns an iterator over all objects whose name begins with prefix.
+	Objects(ctx context.Context, prefix string) (ObjectIterator, error)
+	// NewReader opens the named object.  The object content is returned as
+	// stored, so .gz objects are NOT transparently decompressed.
+	NewReader(ctx context.Context, name string) (io.ReadCloser, error)
+	// String returns the URL of the source, e.g. gs://bucket or file:///path.
+	String() string
+}
+
+// LocalSource is a Source whose objects are files on the local filesystem,
+// so that they can be memory mapped.
+type LocalSource interface {
+	Source
+	// LocalPath returns the path of the file holding the named object.
+	LocalPath(name string) string
+}
+
+// NewSource returns a Source for the provided URL.  gs://bucket and
+// file:///path/to/dir schemes are supported.
+func NewSource(rawurl string) (Source, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "gs":
+		return NewGCSSource(u.Host), nil
+	case "file":
+		return NewDirSource(u.Opaque + u.Path), nil
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedScheme, rawurl)
+	}
+}
+
+// gcsSource reads dataset objects from a GCS bucket.
+type gcsSource struct {
+	bucket string
+
+	mutex  sync.Mutex
+	client *storage.Client // Created on first use, and shared by all requests
+}
+
+// NewGCSSource returns a Source that reads from the named GCS bucket.
+func NewGCSSource(bucket string) Source {
+	return &gcsSource{bucket: bucket}
+}
+
+// bucketHandle returns the bucket, through the client of the source.  The
+// client is created by the first call, so that sources can be created before
+// the credentials are available, e.g. in tests.
+func (s *gcsSource) bucketHandle() (*storage.BucketHandle, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.client == nil {
+		// The client outlives the requests, so it does not use their context.
+		client, err := storage.NewClient(context.Background())
+		if err != nil {
+			return nil, err
+		}
+		s.client = client
+	}
+	return s.client.Bucket(s.bucket), nil
+}
+
+func (s *gcsSource) Objects(ctx context.Context, prefix string) (ObjectIterator, error) {
+	bucket, err := s.bucketHandle()
+	if err != nil {
+		return nil, err
+	}
+	return bucket.Objects(ctx, &storage.Query{Prefix: prefix}), nil
+}
+
+func (s *gcsSource) NewReader(ctx context.Context, name string) (io.ReadCloser, error) {
+	bucket, err := s.bucketHandle()
+	if err != nil {
+		return nil, err
+	}
+	// ReadCompressed prevents GCS from decompressing objects stored with
+	// Content-Encoding: gzip, so that all sources return the raw object.
+	return bucket.Object(name).ReadCompressed(true).NewReader(ctx)
+}
+
+func (s *gcsSource) String() string {
+	return "gs://" + s.bucket
+}
+
+// dirSource reads dataset objects from a local directory.  Object names are
+// slash separated paths relative to the directory.
+type dirSource struct {
+	dir string
+}
+
+// NewDirSource returns a Source that reads from the local directory dir.
+func NewDirSource(dir string) Source {
+	return &dirSource{dir: dir}
+}
+
+func (s *dirSource) Objects(ctx context.Context, prefix string) (ObjectIterator, error) {
+	objects := []*storage.ObjectAttrs{}
+	err := filepath.Walk(s.dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if info.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(s.dir, path)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		if !strings.HasPrefix(name, prefix) {
+			return nil
+		}
+		objects = append(objects, &storage.ObjectAttrs{
+			Bucket:  s.dir,
+			Name:    name,
+			Size:    info.Size(),
+			Updated: info.ModTime(),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	// GCS lists objects in lexical order, so do the same here.
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Name < objects[j].Name })
+	return &sliceIterator{objects: objects}, nil
+}
+
+func (s *dirSource) NewReader(ctx context.Context, name string) (io.ReadCloser, error) {
+	return os.Open(s.LocalPath(name))
+}
+
+func (s *dirSource) LocalPath(name string) string {
+	return filepath.Join(s.dir, filepath.FromSlash(name))
+}
+
+func (s *dirSource) String() string {
+	return "file://" + s.dir
+}
+
+// sliceIterator implements ObjectIterator over a precomputed list.
+type sliceIterator struct {
+	objects []*storage.ObjectAttrs
+}
+
+func (it *sliceIterator) Next() (*storage.ObjectAttrs, error) {
+	if len(it.objects) == 0 {
+		return nil, iterator.Done
+	}
+	next := it.objects[0]
+	it.objects = it.objects[1:]
+	return next, nil
+}
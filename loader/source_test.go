@@ -0,0 +1,92 @@
+package loader_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/m-lab/annotation-service/loader"
+	"google.golang.org/api/iterator"
+)
+
+func writeFile(t *testing.T, dir, name string, data []byte) {
+	path := filepath.Join(dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNewSource(t *testing.T) {
+	tests := []struct {
+		url     string
+		want    string
+		wantErr bool
+	}{
+		{url: "gs://downloader-mlab-testing", want: "gs://downloader-mlab-testing"},
+		{url: "file:///tmp/data", want: "file:///tmp/data"},
+		{url: "http://example.com/data", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			src, err := loader.NewSource(tt.url)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewSource() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && src.String() != tt.want {
+				t.Errorf("NewSource() = %v, want %v", src, tt.want)
+			}
+		})
+	}
+}
+
+func TestDirSource(t *testing.T) {
+	dir, err := ioutil.TempDir("", "source")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeFile(t, dir, "RouteViewIPv4/2019/01/routeviews-rv2-20190101-1200.pfx2as.gz", []byte("a"))
+	writeFile(t, dir, "Maxmind/2018/03/08/20180308T000000Z-GeoLite2-City-CSV.zip", []byte("b"))
+	writeFile(t, dir, "Maxmind/2014/03/07/20140307T160000Z-GeoLiteCity.dat.gz", []byte("c"))
+
+	src := loader.NewDirSource(dir)
+	it, err := src.Objects(context.Background(), "Maxmind/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for file, err := it.Next(); err != iterator.Done; file, err = it.Next() {
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, file.Name)
+	}
+	want := []string{
+		"Maxmind/2014/03/07/20140307T160000Z-GeoLiteCity.dat.gz",
+		"Maxmind/2018/03/08/20180308T000000Z-GeoLite2-City-CSV.zip",
+	}
+	if len(names) != len(want) {
+		t.Fatalf("Objects() = %v, want %v", names, want)
+	}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Errorf("Objects()[%d] = %v, want %v", i, names[i], want[i])
+		}
+	}
+
+	rdr, err := src.NewReader(context.Background(), want[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rdr.Close()
+	data, err := ioutil.ReadAll(rdr)
+	if err != nil || string(data) != "b" {
+		t.Errorf("NewReader() = %q, %v", data, err)
+	}
+}
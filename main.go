@@ -4,19 +4,30 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"html"
 	"log"
+	"net"
 	"net/http"
 	_ "net/http/pprof"
 	"os"
 	"runtime"
 	"time"
 
+	"github.com/m-lab/annotation-service/api"
+	"github.com/m-lab/annotation-service/asn"
+	"github.com/m-lab/annotation-service/directory"
+	"github.com/m-lab/annotation-service/geolite2v2"
 	"github.com/m-lab/annotation-service/geoloader"
+	"github.com/m-lab/annotation-service/iputils"
+	"github.com/m-lab/annotation-service/loader"
+	"github.com/m-lab/annotation-service/notify"
+	"github.com/m-lab/annotation-service/snapshot"
 
 	"github.com/m-lab/annotation-service/handler"
 	"github.com/m-lab/annotation-service/manager"
 	"github.com/m-lab/go/memoryless"
 	"github.com/m-lab/go/prometheusx"
+	"github.com/m-lab/go/rtx"
 )
 
 var (
@@ -26,6 +37,21 @@ var (
 
 	maxmindDates   = flag.String("maxmind_dates", `\d{4}/\d{2}/\d{2}`, "Regex used to match Maxmind file dates.")
 	routeViewDates = flag.String("routeview_dates", `\d{4}/\d{2}`, "Regex used to match RouteView file dates")
+	datasetSource  = flag.String("datasets", "gs://"+api.MaxmindBucketName, "URL of the dataset tree. gs:// and file:// schemes accepted.")
+	snapshotCache  = flag.String("snapshot_cache", "", "Local directory where remote dataset snapshots are copied, so they can be memory mapped.")
+	lazyLoad       = flag.Bool("lazy", false, "Load datasets on demand, on first use, instead of loading all of them at startup.")
+	memoryBudgetMB = flag.Int64("memory_budget_mb", 0, "With -lazy, evict the least recently used datasets when the loaded ones exceed this size. 0 means no limit.")
+	grpcAddr       = flag.String("grpc_addr", ":9091", "Address for the gRPC annotation API. Empty disables it.")
+	lookupEngine   = flag.String("lookup_engine", string(iputils.RangeList), "Lookup structure for GeoLite2 and ASN datasets: ranges or trie.")
+	watchDatasets  = flag.Bool("watch", false, "With a file:// -datasets URL, update the directory when dataset files are added.")
+	webhook        = flag.Bool("webhook", false, "Update the directory when dataset changes are POSTed to /notify.")
+	webhookToken   = flag.String("webhook_token", "", "If set, /notify requests must have an 'Authorization: Bearer <token>' header.")
+	adminToken     = flag.String("admin_token", "", "/admin/diff and POST /admin/directory requests must have an 'Authorization: Bearer <token>' header.  Empty disables them.")
+	pubsubTopic    = flag.String("pubsub_topic", "", "Pub/Sub topic of dataset changes, e.g. downloader-new-files, in the GCLOUD_PROJECT project. Empty disables it.")
+	notifyDelay    = flag.Duration("notify_delay", time.Minute, "Wait this long after the last dataset change notification before updating the directory.")
+	notifyMaxDelay = flag.Duration("notify_max_delay", 10*time.Minute, "Update the directory at most this long after the first pending dataset change notification.")
+	pipelineConfig = flag.String("pipeline", "", "JSON file declaring the dataset sources and how they are merged. Empty uses the built-in sources.")
+	strictCoverage = flag.Bool("strict_coverage", false, "Reject the requests for dates when some datasets are stale or extrapolated, instead of flagging them in the response.")
 	// Create a single unified context and a cancellationMethod for said context.
 	ctx, cancelCtx = context.WithCancel(context.Background())
 )
@@ -45,6 +71,20 @@ func Status(w http.ResponseWriter, r *http.Request) {
 	}
 
 	//	fmt.Fprintf(w, "<p>Workers: %d / %d</p>\n", atomic.LoadInt32(&inFlight), maxInFlight)
+	if _, failed := geoloader.Loads(); len(failed) > 0 {
+		fmt.Fprintf(w, "<p>Failed datasets:</p>\n<table>\n")
+		fmt.Fprintf(w, "<tr><th>Source</th><th>Dataset</th><th>Attempts</th><th>Last attempt</th><th>Next attempt</th><th>Error</th></tr>\n")
+		for _, s := range failed {
+			next := "quarantined"
+			if !s.Quarantined {
+				next = s.NextAttempt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "<tr><td>%s</td><td>%s</td><td>%d</td><td>%s</td><td>%s</td><td>%s</td></tr>\n",
+				s.Source, html.EscapeString(s.Name), s.Attempts, s.Since.Format(time.RFC3339), next,
+				html.EscapeString(s.Err.Error()))
+		}
+		fmt.Fprintf(w, "</table>\n")
+	}
 	env := os.Environ()
 	for i := range env {
 		fmt.Fprintf(w, "%s</br>\n", env[i])
@@ -53,7 +93,10 @@ func Status(w http.ResponseWriter, r *http.Request) {
 }
 
 func updateMaxmindDatasets(w http.ResponseWriter, r *http.Request) {
-	manager.MustUpdateDirectory()
+	if err := manager.UpdateDirectory(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("OK"))
 }
@@ -86,6 +129,28 @@ func main() {
 	geoloader.UpdateASNDatePattern(*routeViewDates)
 	geoloader.UpdateGeoliteDatePattern(*maxmindDates)
 
+	src, err := loader.NewSource(*datasetSource)
+	rtx.Must(err, "Invalid dataset source URL %q", *datasetSource)
+	geoloader.SetSource(src)
+	snapshot.CacheDir = *snapshotCache
+	switch engine := iputils.Engine(*lookupEngine); engine {
+	case iputils.RangeList, iputils.PrefixTrie:
+		geolite2v2.LookupEngine = engine
+		asn.LookupEngine = engine
+	default:
+		log.Fatal("Invalid -lookup_engine ", *lookupEngine)
+	}
+	if *lazyLoad {
+		geoloader.SetLazyCache(directory.NewCache(*memoryBudgetMB << 20))
+	}
+	if *pipelineConfig != "" {
+		cfg, err := manager.LoadConfig(*pipelineConfig)
+		rtx.Must(err, "Could not read pipeline config %q", *pipelineConfig)
+		rtx.Must(manager.SetConfig(cfg), "Invalid pipeline config %q", *pipelineConfig)
+	}
+	manager.StrictCoverage = *strictCoverage
+	handler.AdminToken = *adminToken
+
 	runtime.SetBlockProfileRate(1000000) // 1 sample/msec
 	runtime.SetMutexProfileFraction(1000)
 
@@ -95,12 +160,44 @@ func main() {
 	go memoryless.Run(ctx, manager.MustUpdateDirectory,
 		memoryless.Config{Expected: *updateInterval, Min: *minInterval, Max: *maxInterval})
 
+	// Dataset change notifications trigger updates between the periodic ones.
+	var sources []notify.Source
+	if *watchDatasets {
+		local, ok := src.(loader.LocalSource)
+		if !ok {
+			log.Fatal("-watch requires a file:// -datasets URL")
+		}
+		sources = append(sources, &notify.Dir{Path: local.LocalPath("")})
+	}
+	if *webhook {
+		hook := notify.NewWebhook(*webhookToken)
+		http.Handle("/notify", hook)
+		sources = append(sources, hook)
+	}
+	if *pubsubTopic != "" {
+		sources = append(sources, &notify.PubSub{Project: os.Getenv("GCLOUD_PROJECT"), Topic: *pubsubTopic})
+	}
+	if len(sources) > 0 {
+		updater := &notify.Updater{Update: manager.UpdateDirectory, Delay: *notifyDelay, MaxDelay: *notifyMaxDelay}
+		go updater.Run(ctx, sources...)
+	}
+
 	http.HandleFunc("/status", Status)
 	http.HandleFunc("/updateDatasets", updateMaxmindDatasets)
 	http.HandleFunc("/ready", ready)
 	http.HandleFunc("/live", live)
 
 	handler.InitHandler()
+
+	if *grpcAddr != "" {
+		lis, err := net.Listen("tcp", *grpcAddr)
+		rtx.Must(err, "Could not listen on %q", *grpcAddr)
+		log.Print("gRPC listening on ", lis.Addr())
+		go func() {
+			log.Fatal(handler.NewGRPCServer().Serve(lis))
+		}()
+	}
+
 	log.Print("Listening on port 8080")
 	log.Fatal(http.ListenAndServe(":8080", nil))
 }
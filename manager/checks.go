@@ -0,0 +1,100 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/m-lab/annotation-service/api"
+	"github.com/m-lab/annotation-service/directory"
+)
+
+var (
+	// ErrRejected is returned when a new directory fails the Checks, and the
+	// current one is kept.
+	ErrRejected = errors.New("Directory rejected")
+)
+
+// Checks are the sanity checks that a new directory must pass to replace
+// the current one.  A new directory must also have annotators, and its latest
+// annotator must not be older than the latest one of the current directory.
+type Checks struct {
+	// MinAnnotators is the minimum number of annotators.
+	MinAnnotators int `json:"min_annotators,omitempty"`
+	// MaxAge, if set, is the maximum age of the latest annotator, e.g.
+	// "720h".
+	MaxAge string `json:"max_age,omitempty"`
+	// Canaries are lookups that the latest annotator must answer.
+	Canaries []Canary `json:"canaries,omitempty"`
+}
+
+// Canary is a lookup that must succeed, and return the expected annotations.
+type Canary struct {
+	IP      string `json:"ip"`
+	Country string `json:"country,omitempty"` // The expected country code, if set
+	ASN     uint32 `json:"asn,omitempty"`     // The expected AS number, if set
+}
+
+// validate checks that the checks are well formed.
+func (c *Checks) validate() error {
+	if c.MaxAge != "" {
+		if _, err := time.ParseDuration(c.MaxAge); err != nil {
+			return fmt.Errorf("%w: max_age: %v", ErrBadConfig, err)
+		}
+	}
+	for _, canary := range c.Canaries {
+		if net.ParseIP(canary.IP) == nil {
+			return fmt.Errorf("%w: invalid canary IP %q", ErrBadConfig, canary.IP)
+		}
+	}
+	return nil
+}
+
+// check returns an error wrapping ErrRejected if the candidate directory
+// fails the checks.  current is the directory it would replace, or nil.
+// The canary lookups load the latest datasets, if they are lazy.
+func (c *Checks) check(candidate, current *directory.Directory, now time.Time) error {
+	annotators := candidate.Annotators()
+	min := c.MinAnnotators
+	if min < 1 {
+		min = 1
+	}
+	if len(annotators) < min {
+		return fmt.Errorf("%w: %d annotators, want at least %d", ErrRejected, len(annotators), min)
+	}
+	latest := annotators[len(annotators)-1].AnnotatorDate()
+	if c.MaxAge != "" {
+		// The duration was checked by validate.
+		maxAge, _ := time.ParseDuration(c.MaxAge)
+		if now.Sub(latest) > maxAge {
+			return fmt.Errorf("%w: latest annotator %s is older than %s", ErrRejected, latest.Format("20060102"), c.MaxAge)
+		}
+	}
+	if current != nil {
+		if cur := current.Annotators(); len(cur) > 0 && latest.Before(cur[len(cur)-1].AnnotatorDate()) {
+			return fmt.Errorf("%w: latest annotator %s is older than the current one %s", ErrRejected,
+				latest.Format("20060102"), cur[len(cur)-1].AnnotatorDate().Format("20060102"))
+		}
+	}
+	if len(c.Canaries) == 0 {
+		return nil
+	}
+	ann, err := candidate.GetAnnotator(now)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrRejected, err)
+	}
+	for _, canary := range c.Canaries {
+		result := api.Annotations{}
+		if err := ann.Annotate(canary.IP, &result); err != nil {
+			return fmt.Errorf("%w: canary %s: %v", ErrRejected, canary.IP, err)
+		}
+		if canary.Country != "" && (result.Geo == nil || result.Geo.CountryCode != canary.Country) {
+			return fmt.Errorf("%w: canary %s: country is not %s", ErrRejected, canary.IP, canary.Country)
+		}
+		if canary.ASN != 0 && (result.Network == nil || result.Network.ASNumber != canary.ASN) {
+			return fmt.Errorf("%w: canary %s: AS is not %d", ErrRejected, canary.IP, canary.ASN)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,214 @@
+package manager
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/m-lab/annotation-service/api"
+	"github.com/m-lab/annotation-service/directory"
+)
+
+// countryAnn annotates all IPs with a country, or fails if it has none.
+type countryAnn struct {
+	fakeAnn
+	country string
+}
+
+func (c *countryAnn) Annotate(ip string, ann *api.Annotations) error {
+	if c.country == "" {
+		return errors.New("not found")
+	}
+	ann.Geo = &api.GeolocationIP{CountryCode: c.country}
+	return nil
+}
+
+func newDir(country string, dates ...time.Time) *directory.Directory {
+	annotators := make([]api.Annotator, len(dates))
+	for i, d := range dates {
+		annotators[i] = &countryAnn{fakeAnn: fakeAnn{prov: api.Provenance{Date: d}}, country: country}
+	}
+	return directory.Build(annotators)
+}
+
+func TestChecks(t *testing.T) {
+	now := time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC)
+	jan := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	feb := time.Date(2020, 2, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name      string
+		checks    Checks
+		candidate *directory.Directory
+		current   *directory.Directory
+		ok        bool
+	}{
+		{"empty", Checks{}, newDir("US"), nil, false},
+		{"first", Checks{}, newDir("US", jan), nil, true},
+		{"min", Checks{MinAnnotators: 2}, newDir("US", feb), nil, false},
+		{"newer", Checks{MinAnnotators: 2}, newDir("US", jan, feb), newDir("US", jan), true},
+		{"older", Checks{}, newDir("US", jan), newDir("US", jan, feb), false},
+		{"max-age", Checks{MaxAge: "720h"}, newDir("US", jan), nil, false},
+		{"recent", Checks{MaxAge: "720h"}, newDir("US", feb), nil, true},
+		{"canary", Checks{Canaries: []Canary{{IP: "8.8.8.8", Country: "US"}}}, newDir("US", feb), nil, true},
+		{"canary-country", Checks{Canaries: []Canary{{IP: "8.8.8.8", Country: "FR"}}}, newDir("US", feb), nil, false},
+		{"canary-asn", Checks{Canaries: []Canary{{IP: "8.8.8.8", ASN: 15169}}}, newDir("US", feb), nil, false},
+		{"canary-error", Checks{Canaries: []Canary{{IP: "8.8.8.8"}}}, newDir("", feb), nil, false},
+	}
+	for _, tt := range tests {
+		err := tt.checks.check(tt.candidate, tt.current, now)
+		if (err == nil) != tt.ok || (err != nil && !errors.Is(err, ErrRejected)) {
+			t.Errorf("%s: check() = %v", tt.name, err)
+		}
+	}
+
+	bad := []Checks{{MaxAge: "month"}, {Canaries: []Canary{{IP: "8.8.8"}}}}
+	for _, c := range bad {
+		if err := c.validate(); !errors.Is(err, ErrBadConfig) {
+			t.Errorf("validate(%+v) = %v", c, err)
+		}
+	}
+}
+
+func TestInstallAndRollback(t *testing.T) {
+	defer func() {
+		annotatorDirectory, previousDirectory, held, rejected = nil, nil, false, nil
+	}()
+	jan := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	feb := time.Date(2020, 2, 1, 0, 0, 0, 0, time.UTC)
+	checks := &Checks{}
+
+	if err := Rollback(); err != ErrNoPrevious {
+		t.Error("Rollback() =", err)
+	}
+	if err := install(newDir("US", jan), checks); err != nil {
+		t.Fatal(err)
+	}
+	if err := install(newDir("US", jan, feb), checks); err != nil {
+		t.Fatal(err)
+	}
+	// A failing update keeps the current directory.
+	if err := install(newDir("US"), checks); !errors.Is(err, ErrRejected) {
+		t.Error("install() =", err)
+	}
+	s := Status()
+	if s.Current == nil || s.Current.Annotators != 2 || !s.Current.Latest.Equal(feb) ||
+		s.Previous == nil || s.Previous.Annotators != 1 || s.Held || !errors.Is(s.Rejected, ErrRejected) {
+		t.Errorf("Status() = %+v", s)
+	}
+
+	if err := Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	if s := Status(); s.Current.Annotators != 1 || s.Previous.Annotators != 2 || !s.Held {
+		t.Errorf("Status() after Rollback = %+v", s)
+	}
+	// Updates are held, until Resume.
+	if err := install(newDir("US", jan, feb), checks); err != ErrHeld {
+		t.Error("install() =", err)
+	}
+	Resume()
+	if err := install(newDir("US", jan, feb), checks); err != nil {
+		t.Error("install() =", err)
+	}
+	if s := Status(); s.Current.Annotators != 2 || s.Held || s.Rejected != nil {
+		t.Errorf("Status() after Resume = %+v", s)
+	}
+}
+
+func TestStrictCoverage(t *testing.T) {
+	defer func() {
+		annotatorDirectory, previousDirectory, StrictCoverage = nil, nil, false
+	}()
+	jan := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	dir := directory.Build([]api.Annotator{&fakeAnn{prov: api.Provenance{Name: "jan", Type: "fake", Date: jan}}})
+	dir.SetWindows(map[string]directory.Window{"jan": {Source: "monthly", ValidFor: 31 * 24 * time.Hour}})
+	swap(dir)
+
+	tests := []struct {
+		date  time.Time
+		stale bool
+		extra bool
+	}{
+		{jan.AddDate(0, 0, 10), false, false},
+		{jan.AddDate(0, 2, 0), true, false},
+		{jan.AddDate(-1, 0, 0), false, true},
+	}
+	for _, tt := range tests {
+		StrictCoverage = false
+		ann, err := GetAnnotator(tt.date)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := Coverage(ann, tt.date)
+		if (len(c.Stale) > 0) != tt.stale || (len(c.Extrapolated) > 0) != tt.extra {
+			t.Errorf("Coverage(%s) = %+v", tt.date, c)
+		}
+		if tt.stale && c.Stale[0] != "monthly" {
+			t.Errorf("Coverage(%s) = %+v", tt.date, c)
+		}
+		StrictCoverage = true
+		_, err = GetAnnotator(tt.date)
+		if c.Covered() != (err == nil) || (err != nil && !errors.Is(err, ErrOutsideCoverage)) {
+			t.Errorf("strict GetAnnotator(%s) = %v", tt.date, err)
+		}
+	}
+}
+
+func TestSelectAnnotator(t *testing.T) {
+	defer func() {
+		annotatorDirectory, previousDirectory = nil, nil
+	}()
+	jan := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	feb := time.Date(2020, 2, 1, 0, 0, 0, 0, time.UTC)
+	swap(directory.BuildSources(
+		directory.Source{Name: GeoRole, Annotators: []api.Annotator{&fakeAnn{prov: api.Provenance{Date: jan}}, &fakeAnn{prov: api.Provenance{Date: feb}}}},
+		directory.Source{Name: ASNRole, Annotators: []api.Annotator{&fakeAnn{prov: api.Provenance{Date: jan}}, &fakeAnn{prov: api.Provenance{Date: feb}}}}))
+
+	date := jan.AddDate(0, 0, 10)
+	ann, err := SelectAnnotator(date, directory.Selection{ASNRole: directory.FirstAfter})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := ann.Provenance(); len(p) != 2 || !p[0].Date.Equal(jan) || !p[1].Date.Equal(feb) {
+		t.Error("SelectAnnotator() =", p)
+	}
+	if ann, _ := GetAnnotator(date); !ann.AnnotatorDate().Equal(jan) {
+		t.Error("GetAnnotator() =", ann.Provenance())
+	}
+}
+
+func TestGetAnnotatorLoadsWithoutLock(t *testing.T) {
+	defer func() {
+		annotatorDirectory, previousDirectory = nil, nil
+	}()
+	jan := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	started, release := make(chan bool), make(chan bool)
+	h := directory.NewCache(0).NewLazy(api.Provenance{Date: jan}, 1, func() (api.Annotator, error) {
+		started <- true
+		<-release
+		return &fakeAnn{prov: api.Provenance{Date: jan}}, nil
+	})
+	SetDirectory([]api.Annotator{directory.NewCompositeAnnotator([]api.Annotator{h})})
+
+	done := make(chan error)
+	go func() {
+		_, err := GetAnnotator(jan)
+		done <- err
+	}()
+	<-started
+	// The directory can be swapped while the dataset is loading.
+	swapped := make(chan bool)
+	go func() {
+		SetDirectory([]api.Annotator{newDir("US", jan).Annotators()[0]})
+		swapped <- true
+	}()
+	select {
+	case <-swapped:
+	case <-time.After(time.Second):
+		t.Error("SetDirectory() blocked by a dataset load")
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Error("GetAnnotator() =", err)
+	}
+}
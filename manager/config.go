@@ -0,0 +1,159 @@
+package manager
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/m-lab/annotation-service/api"
+	"github.com/m-lab/annotation-service/asn"
+	"github.com/m-lab/annotation-service/geolite2v2"
+	"github.com/m-lab/annotation-service/geoloader"
+	"github.com/m-lab/annotation-service/legacy"
+	"github.com/m-lab/annotation-service/loader"
+)
+
+// Merge roles, for the Role of a SourceConfig.  The annotators of each role
+// are merged into one list, and the lists into CompositeAnnotators.
+const (
+	GeoRole = "geo" // Geolocation annotators
+	ASNRole = "asn" // Network (AS) annotators
+)
+
+// Merge modes, for the Merge of a SourceConfig.
+const (
+	// UnionMerge adds the annotators of a source to the earlier ones of its
+	// role, except on the dates they already cover.
+	UnionMerge = "union"
+	// FallbackMerge merges the annotators of a source with the earlier ones
+	// of its role, so that it annotates what they do not.
+	FallbackMerge = "fallback"
+)
+
+var (
+	// ErrBadConfig is returned for invalid pipeline configurations.
+	ErrBadConfig = errors.New("Invalid pipeline config")
+
+	// Loaders holds the functions that load the datasets of each source type.
+	// They may be replaced, to allow for efficient unit testing.
+	Loaders = map[string]func(loader.Source, *storage.ObjectAttrs) (api.Annotator, error){
+		geoloader.LegacySource:       legacy.LoadAnnotator,
+		geoloader.GeoLite2Source:     geolite2v2.LoadG2,
+		geoloader.GeoLite2MMDBSource: geolite2v2.LoadMMDB,
+		geoloader.RouteViewsSource:   asn.LoadASNDataset,
+		geoloader.GeoLite2ASNSource:  asn.LoadGeoLite2ASN,
+	}
+)
+
+// Config declares the dataset sources of the directory, and how their
+// annotators are merged.
+type Config struct {
+	Sources []SourceConfig `json:"sources"`
+	// Checks are the sanity checks of the directories built from the sources.
+	Checks Checks `json:"checks,omitempty"`
+}
+
+// SourceConfig declares a dataset source, and its place in the directory.
+type SourceConfig struct {
+	geoloader.SourceConfig
+	// Role is GeoRole or ASNRole.
+	Role string `json:"role"`
+	// Group pairs the IPv4 and IPv6 sources of a type, whose annotators are
+	// merged so that IPv6 lookups fall back to the IPv6 datasets.
+	Group string `json:"group,omitempty"`
+	// Merge is how the source is merged with the earlier sources of its role,
+	// UnionMerge (the default) or FallbackMerge.
+	Merge string `json:"merge,omitempty"`
+	// ValidFor, if set, is how long each dataset of the source stays valid
+	// after its date, e.g. "336h".  Annotations for later dates are flagged as
+	// stale.
+	ValidFor string `json:"valid_for,omitempty"`
+	// Disabled sources are not loaded.
+	Disabled bool `json:"disabled,omitempty"`
+}
+
+// DefaultConfig returns the configuration used when none is set: legacy
+// geolocation until GeoLite2, GeoLite2 CSV or else MMDB geolocation after,
+// and RouteViews AS numbers, completed by GeoLite2-ASN.  The GeoLite2
+// datasets, published weekly, are valid for 14 days, and the monthly
+// RouteViews ones for 45 days.  The legacy datasets were discontinued, so
+// they are never stale.
+func DefaultConfig() *Config {
+	source := func(name, typ, family, role, group, merge, validFor string) SourceConfig {
+		return SourceConfig{
+			SourceConfig: geoloader.SourceConfig{Name: name, Type: typ, Family: family},
+			Role:         role, Group: group, Merge: merge, ValidFor: validFor}
+	}
+	return &Config{Sources: []SourceConfig{
+		source("legacy-v4", geoloader.LegacySource, geoloader.IPv4, GeoRole, "legacy", "", ""),
+		source("legacy-v6", geoloader.LegacySource, geoloader.IPv6, GeoRole, "legacy", "", ""),
+		source("geolite2", geoloader.GeoLite2Source, "", GeoRole, "", "", "336h"),
+		source("geolite2-mmdb", geoloader.GeoLite2MMDBSource, "", GeoRole, "", "", "336h"),
+		source("routeviews-v4", geoloader.RouteViewsSource, geoloader.IPv4, ASNRole, "routeviews", "", "1080h"),
+		source("routeviews-v6", geoloader.RouteViewsSource, geoloader.IPv6, ASNRole, "routeviews", "", "1080h"),
+		source("geolite2-asn", geoloader.GeoLite2ASNSource, "", ASNRole, "", FallbackMerge, "336h"),
+	}}
+}
+
+// LoadConfig reads a Config from a JSON file.
+func LoadConfig(path string) (*Config, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &Config{}
+	if err := json.Unmarshal(b, cfg); err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrBadConfig, path, err)
+	}
+	return cfg, nil
+}
+
+// validate checks the roles, groups and merge modes of the enabled sources.
+// The sources themselves are checked by geoloader.NewSourceLoader.
+func (cfg *Config) validate() error {
+	names := map[string]bool{}
+	groups := map[string][]SourceConfig{}
+	enabled := 0
+	for _, s := range cfg.Sources {
+		if s.Disabled {
+			continue
+		}
+		enabled++
+		if names[s.Name] {
+			return fmt.Errorf("%w: duplicate source %q", ErrBadConfig, s.Name)
+		}
+		names[s.Name] = true
+		if s.Role != GeoRole && s.Role != ASNRole {
+			return fmt.Errorf("%w: %s: role must be %q or %q", ErrBadConfig, s.Name, GeoRole, ASNRole)
+		}
+		if s.Merge != "" && s.Merge != UnionMerge && s.Merge != FallbackMerge {
+			return fmt.Errorf("%w: %s: merge must be %q or %q", ErrBadConfig, s.Name, UnionMerge, FallbackMerge)
+		}
+		if s.ValidFor != "" {
+			if d, err := time.ParseDuration(s.ValidFor); err != nil || d < 0 {
+				return fmt.Errorf("%w: %s: invalid valid_for %q", ErrBadConfig, s.Name, s.ValidFor)
+			}
+		}
+		if s.Group != "" {
+			groups[s.Group] = append(groups[s.Group], s)
+		}
+	}
+	if enabled == 0 {
+		return fmt.Errorf("%w: no enabled source", ErrBadConfig)
+	}
+	if err := cfg.Checks.validate(); err != nil {
+		return err
+	}
+	for name, g := range groups {
+		if len(g) != 2 || g[0].Family == g[1].Family || g[0].Family == "" || g[1].Family == "" {
+			return fmt.Errorf("%w: group %q must have one IPv4 and one IPv6 source", ErrBadConfig, name)
+		}
+		if g[0].Role != g[1].Role || g[0].Merge != g[1].Merge {
+			return fmt.Errorf("%w: sources of group %q have different roles or merges", ErrBadConfig, name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,227 @@
+package manager
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/m-lab/annotation-service/api"
+	"github.com/m-lab/annotation-service/directory"
+	"github.com/m-lab/annotation-service/geoloader"
+	"github.com/m-lab/annotation-service/loader"
+)
+
+type fakeAnn struct {
+	prov api.Provenance
+}
+
+func (f *fakeAnn) Annotate(ip string, ann *api.Annotations) error { return nil }
+func (f *fakeAnn) AnnotatorDate() time.Time                       { return f.prov.Date }
+func (f *fakeAnn) Provenance() []api.Provenance                   { return []api.Provenance{f.prov} }
+
+// routeViewsDate matches the date of RouteViews file names, e.g.
+// routeviews-rv2-20180301-1200.pfx2as.gz.
+var routeViewsDate = regexp.MustCompile(`-([0-9]{8})-[0-9]{4}\.pfx2as`)
+
+func fakeLoad(src loader.Source, obj *storage.ObjectAttrs) (api.Annotator, error) {
+	d, err := api.ExtractDateFromFilename(obj.Name)
+	if m := routeViewsDate.FindStringSubmatch(obj.Name); err != nil && m != nil {
+		d, err = time.Parse("20060102", m[1])
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &fakeAnn{prov: api.Provenance{Name: obj.Name, Date: d}}, nil
+}
+
+func TestLoadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pipeline.json")
+	js := `{"sources": [
+		{"name": "legacy-v4", "type": "legacy", "family": "v4", "role": "geo", "group": "legacy", "until": "2017-08-15"},
+		{"name": "geolite2-asn", "type": "geolite2-asn", "role": "asn", "merge": "fallback", "disabled": true}
+	]}`
+	if err := ioutil.WriteFile(path, []byte(js), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Sources) != 2 || cfg.Sources[0].Family != geoloader.IPv4 || cfg.Sources[0].Until != "2017-08-15" ||
+		cfg.Sources[0].Group != "legacy" || cfg.Sources[1].Merge != FallbackMerge || !cfg.Sources[1].Disabled {
+		t.Errorf("LoadConfig() = %+v", cfg)
+	}
+	if err := ioutil.WriteFile(path, []byte(`{"sources": {}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadConfig(path); !errors.Is(err, ErrBadConfig) {
+		t.Error("LoadConfig() error =", err)
+	}
+}
+
+func TestNewListBuilderErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(cfg *Config)
+		want   error
+	}{
+		{"role", func(cfg *Config) { cfg.Sources[2].Role = "city" }, ErrBadConfig},
+		{"merge", func(cfg *Config) { cfg.Sources[2].Merge = "replace" }, ErrBadConfig},
+		{"duplicate", func(cfg *Config) { cfg.Sources[3].Name = cfg.Sources[2].Name }, ErrBadConfig},
+		{"group", func(cfg *Config) { cfg.Sources[1].Disabled = true }, ErrBadConfig},
+		{"valid_for", func(cfg *Config) { cfg.Sources[2].ValidFor = "14d" }, ErrBadConfig},
+		{"none", func(cfg *Config) {
+			for i := range cfg.Sources {
+				cfg.Sources[i].Disabled = true
+			}
+		}, ErrBadConfig},
+		{"type", func(cfg *Config) { cfg.Sources[2].Type = "ip2location" }, geoloader.ErrBadSource},
+		{"family", func(cfg *Config) { cfg.Sources[2].Family = geoloader.IPv4 }, geoloader.ErrBadSource},
+		{"regex", func(cfg *Config) { cfg.Sources[2].Regex = "(" }, geoloader.ErrBadSource},
+		{"date", func(cfg *Config) { cfg.Sources[2].From = "2018/03/08" }, geoloader.ErrBadSource},
+	}
+	for _, tt := range tests {
+		cfg := DefaultConfig()
+		tt.change(cfg)
+		if _, err := newListBuilder(cfg); !errors.Is(err, tt.want) {
+			t.Errorf("%s: newListBuilder() error = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestListBuilder(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{
+		"Maxmind/2014/03/07/20140307T160000Z-GeoLiteCity.dat.gz",
+		"Maxmind/2014/03/07/20140307T160000Z-GeoLiteCityv6.dat.gz",
+		"Maxmind/2018/03/08/20180308T000000Z-GeoLiteCity.dat.gz", // After the legacy cutoff
+		"Maxmind/2018/03/08/20180308T000000Z-GeoLite2-City-CSV.zip",
+		"Maxmind/2018/03/08/20180308T000000Z-GeoLite2-City.mmdb", // Same date as the CSV
+		"Maxmind/2019/01/01/20190101T000000Z-GeoLite2-City.mmdb",
+		"Maxmind/2018/03/08/20180308T000000Z-GeoLite2-ASN-CSV.zip",
+		"RouteViewIPv4/2018/03/routeviews-rv2-20180301-1200.pfx2as.gz",
+	} {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	geoloader.SetSource(loader.NewDirSource(dir))
+	defer geoloader.SetSource(loader.NewGCSSource(api.MaxmindBucketName))
+	defer func(l map[string]func(loader.Source, *storage.ObjectAttrs) (api.Annotator, error)) { Loaders = l }(Loaders)
+	Loaders = map[string]func(loader.Source, *storage.ObjectAttrs) (api.Annotator, error){}
+	for _, s := range DefaultConfig().Sources {
+		Loaders[s.Type] = fakeLoad
+	}
+
+	// The RouteViews datasets are disabled, so GeoLite2-ASN is used alone.
+	cfg := DefaultConfig()
+	for i := range cfg.Sources {
+		if cfg.Sources[i].Type == geoloader.RouteViewsSource {
+			cfg.Sources[i].Disabled = true
+		}
+	}
+	bldr, err := newListBuilder(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bldr.update(); err != nil {
+		t.Fatal(err)
+	}
+	geo, ok := bldr.role(GeoRole)
+	if !ok || len(geo) != 3 {
+		t.Fatal("role(GeoRole) =", geo, ok)
+	}
+	for i, want := range []string{
+		"Maxmind/2014/03/07/20140307T160000Z-GeoLiteCity.dat.gz",
+		"Maxmind/2018/03/08/20180308T000000Z-GeoLite2-City-CSV.zip",
+		"Maxmind/2019/01/01/20190101T000000Z-GeoLite2-City.mmdb",
+	} {
+		if got := geo[i].Provenance()[0].Name; got != want {
+			t.Errorf("geo[%d] = %s, want %s", i, got, want)
+		}
+	}
+	if len(geo[0].Provenance()) != 2 {
+		t.Error("Legacy v4 and v6 were not merged:", geo[0].Provenance())
+	}
+	asn, ok := bldr.role(ASNRole)
+	if !ok || len(asn) != 1 || asn[0].Provenance()[0].Name != "Maxmind/2018/03/08/20180308T000000Z-GeoLite2-ASN-CSV.zip" {
+		t.Error("role(ASNRole) =", asn, ok)
+	}
+	if combo := bldr.build().Annotators(); len(combo) != 3 {
+		t.Error("build() =", combo)
+	}
+
+	// Without ASN sources, the directory only has geo annotators.
+	cfg = &Config{Sources: cfg.Sources[:4]}
+	if bldr, err = newListBuilder(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if err := bldr.update(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := bldr.role(ASNRole); ok {
+		t.Error("role(ASNRole) found without ASN sources")
+	}
+	if combo := bldr.build().Annotators(); len(combo) != 3 || len(combo[2].Provenance()) != 1 {
+		t.Error("build() =", combo)
+	}
+
+	// There is no RouteViews IPv6 dataset, so the IPv4 one is used alone.
+	cfg = DefaultConfig()
+	if bldr, err = newListBuilder(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if err := bldr.update(); err != nil {
+		t.Fatal(err)
+	}
+	combo := bldr.build().Annotators()
+	if len(combo) != 4 {
+		t.Fatal("build() =", combo)
+	}
+	for i, want := range [][]string{{"asn"}, {"geolite2-asn", "routeviews-v6"}, {"routeviews-v6"}, {"routeviews-v6"}} {
+		if got := directory.Missing(combo[i]); !reflect.DeepEqual(got, want) {
+			t.Errorf("Missing(combo[%d]) = %v, want %v", i, got, want)
+		}
+	}
+	windows := bldr.windows()
+	if w := windows["Maxmind/2018/03/08/20180308T000000Z-GeoLite2-City-CSV.zip"]; w.Source != "geolite2" || w.ValidFor != 336*time.Hour {
+		t.Error("windows() =", windows)
+	}
+	if w := windows["RouteViewIPv4/2018/03/routeviews-rv2-20180301-1200.pfx2as.gz"]; w.Source != "routeviews-v4" || w.ValidFor != 1080*time.Hour {
+		t.Error("windows() =", windows)
+	}
+	if w := windows["Maxmind/2014/03/07/20140307T160000Z-GeoLiteCity.dat.gz"]; w.Source != "legacy-v4" || w.ValidFor != 0 {
+		t.Error("windows() =", windows)
+	}
+
+	// With the default windows, the GeoLite2 datasets are stale after two
+	// weeks, and the RouteViews one after 45 days.
+	d := bldr.build()
+	d.SetWindows(windows)
+	for _, tt := range []struct {
+		date  time.Time
+		stale []string
+	}{
+		{time.Date(2018, 3, 20, 0, 0, 0, 0, time.UTC), nil},
+		{time.Date(2018, 4, 1, 0, 0, 0, 0, time.UTC), []string{"geolite2", "geolite2-asn"}},
+		{time.Date(2018, 5, 1, 0, 0, 0, 0, time.UTC), []string{"geolite2", "routeviews-v4", "geolite2-asn"}},
+	} {
+		ann, err := d.Find(tt.date, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c := d.Coverage(ann, tt.date); !reflect.DeepEqual(c.Stale, tt.stale) {
+			t.Errorf("Coverage(%s).Stale = %v, want %v", tt.date.Format("2006-01-02"), c.Stale, tt.stale)
+		}
+	}
+}
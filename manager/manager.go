@@ -10,50 +10,189 @@ import (
 	"sync"
 	"time"
 
-	"github.com/m-lab/annotation-service/asn"
-	"github.com/m-lab/annotation-service/geolite2v2"
-
 	"github.com/m-lab/annotation-service/geoloader"
-	"github.com/m-lab/annotation-service/legacy"
 
 	"github.com/m-lab/annotation-service/api"
 	"github.com/m-lab/annotation-service/directory"
+	"github.com/m-lab/annotation-service/metrics"
 )
 
 var (
 	// ErrDirectoryIsNil is returned before the annotatorDirectory is initialized.
 	ErrDirectoryIsNil = errors.New("annotatorDirectory has not been initialized")
+	// ErrConfigTooLate is returned by SetConfig after the directory is loaded.
+	ErrConfigTooLate = errors.New("Directory is already loaded")
+	// ErrHeld is returned by UpdateDirectory after a Rollback, until Resume.
+	ErrHeld = errors.New("Directory updates are held after a rollback")
+	// ErrNoPrevious is returned by Rollback when there is no previous directory.
+	ErrNoPrevious = errors.New("No previous directory")
+	// ErrOutsideCoverage is returned by GetAnnotator in StrictCoverage mode
+	// when the datasets are not valid for the date.
+	ErrOutsideCoverage = errors.New("Date is outside the validity of the datasets")
+
+	// StrictCoverage makes GetAnnotator reject the dates for which some
+	// datasets are stale or extrapolated, instead of using them.
+	StrictCoverage = false
 
-	// dirLock must be held when accessing or replacing annotatorDirectory.
+	// dirLock must be held when accessing or replacing annotatorDirectory,
+	// and the other swap state.
 	dirLock sync.RWMutex
 	// annotatorDirectory points to a Directory containing CompositeAnnotators.
 	annotatorDirectory *directory.Directory
+	// previousDirectory is the directory replaced by the last swap, for Rollback.
+	previousDirectory *directory.Directory
+	// currentSince and previousSince are when the directories were swapped in.
+	currentSince, previousSince time.Time
+	// held suspends the directory updates, after a Rollback.
+	held bool
+	// rejected is the error of the last rejected update, since the last swap.
+	rejected   error
+	rejectedAt time.Time
 
 	once    sync.Once // This is used to construct the builder the first time it is used.
 	builder *listBuilder
+
+	// updateLock serializes the directory updates, so that an older list
+	// never replaces a newer one.
+	updateLock sync.Mutex
 )
 
+// swap replaces the directory with dir, and keeps the current one for
+// Rollback.  dirLock must be held.
+func swap(dir *directory.Directory) {
+	previousDirectory, previousSince = annotatorDirectory, currentSince
+	annotatorDirectory, currentSince = dir, time.Now()
+	rejected, rejectedAt = nil, time.Time{}
+}
+
 // SetDirectory wraps the list of annotators in a Directory, and safely replaces the global
-// annotatorDirectory.
+// annotatorDirectory, without any checks.
 func SetDirectory(annotators []api.Annotator) {
 	dirLock.Lock()
 	defer dirLock.Unlock()
 	log.Println("Directory has", len(annotators), "entries")
-	annotatorDirectory = directory.Build(annotators)
-	if annotatorDirectory == nil {
-		log.Println("ERROR LOADING DIRECTORY")
+	swap(directory.Build(annotators))
+}
+
+// Rollback restores the directory replaced by the last swap, and holds the
+// directory updates until Resume, so that it is not replaced again.  Another
+// Rollback restores the directory that was rolled back.
+func Rollback() error {
+	dirLock.Lock()
+	defer dirLock.Unlock()
+	if previousDirectory == nil {
+		return ErrNoPrevious
+	}
+	annotatorDirectory, previousDirectory = previousDirectory, annotatorDirectory
+	currentSince, previousSince = time.Now(), currentSince
+	held = true
+	metrics.DirectoryUpdates.WithLabelValues("rollback").Inc()
+	log.Println("Directory rolled back, updates are held")
+	return nil
+}
+
+// Resume resumes the directory updates held by Rollback.
+func Resume() {
+	dirLock.Lock()
+	defer dirLock.Unlock()
+	held = false
+	log.Println("Directory updates resumed")
+}
+
+// DirectoryInfo summarizes a directory.
+type DirectoryInfo struct {
+	Annotators int       // The number of annotators
+	Latest     time.Time // The date of the latest annotator
+	Since      time.Time // When the directory was swapped in
+}
+
+// UpdateStatus describes the current and previous directories, and the
+// updates.
+type UpdateStatus struct {
+	Current    *DirectoryInfo // The current directory, or nil
+	Previous   *DirectoryInfo // The directory replaced by the last swap, or nil
+	Held       bool           // Whether the updates are held after a Rollback
+	Rejected   error          // The error of the last rejected update, since the last swap
+	RejectedAt time.Time      // When the update was rejected
+}
+
+// info summarizes dir, or returns nil if dir is nil.
+func info(dir *directory.Directory, since time.Time) *DirectoryInfo {
+	if dir == nil {
+		return nil
+	}
+	annotators := dir.Annotators()
+	result := &DirectoryInfo{Annotators: len(annotators), Since: since}
+	if len(annotators) > 0 {
+		result.Latest = annotators[len(annotators)-1].AnnotatorDate()
+	}
+	return result
+}
+
+// Status returns the state of the directory updates.
+func Status() UpdateStatus {
+	dirLock.RLock()
+	defer dirLock.RUnlock()
+	return UpdateStatus{
+		Current:    info(annotatorDirectory, currentSince),
+		Previous:   info(previousDirectory, previousSince),
+		Held:       held,
+		Rejected:   rejected,
+		RejectedAt: rejectedAt,
 	}
 }
 
 // GetAnnotator returns the correct annotator to use for a given timestamp.
 func GetAnnotator(date time.Time) (api.Annotator, error) {
+	return SelectAnnotator(date, nil)
+}
+
+// SelectAnnotator is like GetAnnotator, but selects the datasets of each role,
+// GeoRole or ASNRole, with its policy in sel.  See directory.Select.
+func SelectAnnotator(date time.Time, sel directory.Selection) (api.Annotator, error) {
+	dirLock.RLock()
+	dir := annotatorDirectory
+	dirLock.RUnlock()
+	if dir == nil {
+		log.Print("annotatorDirectory is nil!")
+		return nil, ErrDirectoryIsNil
+	}
+	// Loading lazy datasets may take minutes, so it is done without dirLock,
+	// which would otherwise block the directory swaps, and all the requests
+	// after them.  The directories are not modified once installed.
+	ann, err := dir.Find(date, sel)
+	if err != nil {
+		return nil, err
+	}
+	if StrictCoverage {
+		if c := dir.Coverage(ann, date); !c.Covered() {
+			return nil, fmt.Errorf("%w: %s: stale %v, extrapolated %v", ErrOutsideCoverage,
+				date.Format("2006-01-02"), c.Stale, c.Extrapolated)
+		}
+	}
+	directory.Load(ann)
+	return ann, nil
+}
+
+// Coverage tells which datasets of ann, returned by GetAnnotator, are not
+// valid for the date, according to the validity windows of their sources.
+func Coverage(ann api.Annotator, date time.Time) directory.Coverage {
+	dirLock.RLock()
+	defer dirLock.RUnlock()
+	if annotatorDirectory == nil {
+		return directory.Coverage{}
+	}
+	return annotatorDirectory.Coverage(ann, date)
+}
+
+// Annotators returns the annotators of the directory, in date order.
+func Annotators() ([]api.Annotator, error) {
 	dirLock.RLock()
 	defer dirLock.RUnlock()
 	if annotatorDirectory == nil {
-		log.Print("annotatorDirectory is nil!")
 		return nil, ErrDirectoryIsNil
 	}
-	return annotatorDirectory.GetAnnotator(date)
+	return annotatorDirectory.Annotators(), nil
 }
 
 // Writes list of annotator dates to log, preceded by header string.
@@ -67,21 +206,40 @@ func logAnnotatorDates(header string, an []api.Annotator) {
 	log.Println(b.String())
 }
 
-// MustUpdateDirectory loads ALL datasets into memory.
-// NOTE: This may log.Fatal if there is a problem constructing the Directory.
-// TODO rename Directory and this function
-func MustUpdateDirectory() {
+// SetConfig sets the dataset sources of the directory, instead of
+// DefaultConfig.  It must be called before the first MustUpdateDirectory.
+func SetConfig(cfg *Config) error {
+	b, err := newListBuilder(cfg)
+	if err != nil {
+		return err
+	}
+	set := false
 	once.Do(func() {
-		v4loader := geoloader.LegacyV4Loader(legacy.LoadAnnotator)
-		v6loader := geoloader.LegacyV6Loader(legacy.LoadAnnotator)
-		g2loader := geoloader.Geolite2Loader(geolite2v2.LoadG2)
-		asnv4Loader := geoloader.ASNv4Loader(asn.LoadASNDataset)
-		asnv6Loader := geoloader.ASNv6Loader(asn.LoadASNDataset)
-
-		builder = newListBuilder(v4loader, v6loader, g2loader, asnv4Loader, asnv6Loader)
-		if builder == nil {
-			// This only happens if one of the loaders is nil.
-			log.Fatal("Nil list builder")
+		builder = b
+		set = true
+	})
+	if !set {
+		return ErrConfigTooLate
+	}
+	log.Println("Pipeline has", len(b.sources), "dataset sources")
+	return nil
+}
+
+// UpdateDirectory loads the new datasets, and replaces the directory.  Only
+// the datasets that are not loaded yet are loaded, so it may be called
+// whenever datasets are added.  The new directory replaces the current one
+// only if it passes the Checks of the Config.  Otherwise, UpdateDirectory
+// returns an error wrapping ErrRejected, and the current directory is kept.
+// While the updates are held by a Rollback, it returns ErrHeld.
+func UpdateDirectory() error {
+	updateLock.Lock()
+	defer updateLock.Unlock()
+	once.Do(func() {
+		var err error
+		builder, err = newListBuilder(DefaultConfig())
+		if err != nil {
+			// This only happens if the default config is broken.
+			log.Fatal(err)
 		}
 	})
 	err := builder.update()
@@ -89,40 +247,91 @@ func MustUpdateDirectory() {
 		// TODO - add a metric?
 		log.Println(err)
 	}
-	combo := builder.build()
+	dir := builder.build()
+	dir.SetWindows(builder.windows())
+	return install(dir, &builder.checks)
+}
 
-	// Sort them just in case there are some out of order.
-	combo = directory.SortSlice(combo)
+// install replaces the directory with candidate, if it passes the checks.
+// updateLock must be held.
+func install(candidate *directory.Directory, checks *Checks) error {
+	dirLock.RLock()
+	current := annotatorDirectory
+	dirLock.RUnlock()
+	// The checks may load lazy datasets, so they run without the lock.
+	// updateLock prevents other updates meanwhile.
+	err := checks.check(candidate, current, time.Now())
 
-	if len(combo) < 1 {
-		log.Fatal("No annotators.  Terminating!!")
+	dirLock.Lock()
+	defer dirLock.Unlock()
+	if held {
+		return ErrHeld
+	}
+	if err != nil {
+		rejected, rejectedAt = err, time.Now()
+		metrics.DirectoryUpdates.WithLabelValues("rejected").Inc()
+		return err
 	}
+	log.Println("Directory has", len(candidate.Annotators()), "entries")
+	swap(candidate)
+	metrics.DirectoryUpdates.WithLabelValues("swapped").Inc()
+	return nil
+}
 
-	SetDirectory(combo)
+// MustUpdateDirectory loads ALL datasets into memory.
+// NOTE: This may log.Fatal if there is a problem constructing the first
+// Directory.  Later failures keep the current directory.
+// TODO rename Directory and this function
+func MustUpdateDirectory() {
+	err := UpdateDirectory()
+	if err == nil {
+		return
+	}
+	dirLock.RLock()
+	loaded := annotatorDirectory != nil
+	dirLock.RUnlock()
+	if !loaded {
+		log.Fatal(err, ".  Terminating!!")
+	}
+	log.Println("Keeping the current directory:", err)
 }
 
 /*************************************************************************
 *                    CompositeAnnotator List Builder                     *
 *************************************************************************/
 
-// listBuilder wraps a set of CachingLoaders, and creates a set of merged Annotators on request.
-// TODO - unit tests?
+// sourceLoader is the CachingLoader of an enabled source of the Config.
+type sourceLoader struct {
+	SourceConfig
+	loader api.CachingLoader
+}
+
+// listBuilder wraps the CachingLoaders of a Config, and creates a set of merged Annotators on request.
 type listBuilder struct {
-	mutex    sync.Mutex        // Prevents concurrent update and/or build
-	legacyV4 api.CachingLoader // loader for legacy v4 annotators
-	legacyV6 api.CachingLoader // loader for legacy v6 annotators
-	geolite2 api.CachingLoader // loader for geolite2 annotators
-	asnV4    api.CachingLoader // loader for asn v4 annotators
-	asnV6    api.CachingLoader // loader for asn v6 annotators
-}
-
-// newListBuilder initializes a listBuilder object, and preloads the CachingLoaders.
-// The arguments must all be non-nil, or the return value will be nil.
-func newListBuilder(v4, v6, g2, asnV4, asnV6 api.CachingLoader) *listBuilder {
-	if v4 == nil || v6 == nil || g2 == nil || asnV4 == nil || asnV6 == nil {
-		return nil
+	mutex   sync.Mutex     // Prevents concurrent update and/or build
+	sources []sourceLoader // The enabled sources, in config order
+	checks  Checks         // The checks of the directories built
+}
+
+// newListBuilder creates the CachingLoaders of the enabled sources of cfg,
+// or returns an error if cfg is invalid.
+func newListBuilder(cfg *Config) (*listBuilder, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	bldr := &listBuilder{checks: cfg.Checks}
+	for _, s := range cfg.Sources {
+		if s.Disabled {
+			log.Println("Dataset source", s.Name, "is disabled")
+			continue
+		}
+		cl, err := geoloader.NewSourceLoader(s.SourceConfig, Loaders[s.Type])
+		if err != nil {
+			return nil, err
+		}
+		bldr.sources = append(bldr.sources, sourceLoader{SourceConfig: s, loader: cl})
 	}
-	return &listBuilder{legacyV4: v4, legacyV6: v6, geolite2: g2, asnV4: asnV4, asnV6: asnV6}
+	return bldr, nil
 }
 
 // Update updates the (dynamic) CachingLoaders
@@ -130,97 +339,170 @@ func (bldr *listBuilder) update() error {
 	bldr.mutex.Lock()
 	defer bldr.mutex.Unlock()
 
-	var errV4, errV6, errG2, errAsnV4, errAsnV6 error
-
 	log.Println("Updating dataset directory")
+	errs := make([]error, len(bldr.sources))
 	wg := sync.WaitGroup{}
-	wg.Add(5)
-	go func() {
-		errV4 = bldr.legacyV4.UpdateCache()
-		log.Println("Legacy V4 loading done.")
-		wg.Done()
-	}()
-	go func() {
-		errV6 = bldr.legacyV6.UpdateCache()
-		log.Println("Legacy V6 loading done.")
-		wg.Done()
-	}()
-	go func() {
-		errG2 = bldr.geolite2.UpdateCache()
-		log.Println("Geolite2 loading done.")
-		wg.Done()
-	}()
-	go func() {
-		errAsnV4 = bldr.asnV4.UpdateCache()
-		log.Println("ASN V4 loading done.")
-		wg.Done()
-	}()
-	go func() {
-		errAsnV6 = bldr.asnV6.UpdateCache()
-		log.Println("ASN V6 loading done.")
-		wg.Done()
-	}()
+	wg.Add(len(bldr.sources))
+	for i := range bldr.sources {
+		go func(i int) {
+			errs[i] = bldr.sources[i].loader.UpdateCache()
+			log.Println(bldr.sources[i].Name, "loading done.")
+			wg.Done()
+		}(i)
+	}
 	wg.Wait()
 
 	log.Println("Dataset update complete.")
 
-	if errV4 != nil {
-		return errV4
-	}
-	if errV6 != nil {
-		return errV6
-	}
-	if errG2 != nil {
-		return errG2
-	}
-	if errAsnV4 != nil {
-		return errAsnV4
-	}
-	if errAsnV6 != nil {
-		return errAsnV6
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
-// build creates a complete list of CompositeAnnotators from the cached annotators
+// windows returns the validity windows of the cached annotators, keyed by
+// their Provenance names.
+func (bldr *listBuilder) windows() map[string]directory.Window {
+	bldr.mutex.Lock()
+	defer bldr.mutex.Unlock()
+
+	result := map[string]directory.Window{}
+	for _, s := range bldr.sources {
+		// Config.validate checks the durations.
+		validFor, _ := time.ParseDuration(s.ValidFor)
+		for _, ann := range s.loader.Fetch() {
+			for _, p := range ann.Provenance() {
+				result[p.Name] = directory.Window{Source: s.Name, ValidFor: validFor}
+			}
+		}
+	}
+	return result
+}
+
+// build creates a Directory of CompositeAnnotators from the cached annotators
 // from the CachingLoaders.
-func (bldr *listBuilder) build() []api.Annotator {
+func (bldr *listBuilder) build() *directory.Directory {
 	bldr.mutex.Lock()
 	defer bldr.mutex.Unlock()
 
-	// merge the legacy V4 & V6 annotators
-	legacy := mergeV4V6(bldr.legacyV4.Fetch(), bldr.legacyV6.Fetch(), "legacy")
-	// Now append the Geolite2 annotators
-	g2 := directory.SortSlice(bldr.geolite2.Fetch())
-	geo := make([]api.Annotator, 0, len(g2)+len(legacy))
-	geo = append(geo, legacy...)
-	geo = append(geo, g2...)
-	// here we have all the geo annotators in the ordered list.
-	// now merge the ASN V4 & V6 annotators
-	asn := mergeV4V6(bldr.asnV4.Fetch(), bldr.asnV6.Fetch(), "ASN")
-	// and now we need to create the composite annotators. First list is the
-	// geo annotators, the second is the ASN
-	combo := directory.MergeAnnotators(geo, asn)
-
-	if len(combo) < 1 {
+	// The first source is the geo annotators, the second is the ASN, unless
+	// no source has the role.  The composite annotators of the dates before
+	// a role has annotators list it as missing.
+	sources := make([]directory.Source, 0, 2)
+	for _, role := range []string{GeoRole, ASNRole} {
+		if list, ok := bldr.role(role); ok {
+			sources = append(sources, directory.Source{Name: role, Annotators: list})
+		}
+	}
+	// and now we need to create the composite annotators.  The roles are
+	// kept, so that their datasets can be selected independently.
+	dir := directory.BuildSources(sources...)
+
+	if len(dir.Annotators()) < 1 {
 		log.Println("No annotators available")
-		return nil
 	}
 
-	return combo
+	return dir
 }
 
-// mergeV4V6 holds common logic to merge legacy location and ASN v4 and v6 annotators into composite annotators.
-// The purpose of the merge is to fallback to IPv6 lookup if IPv4 lookup was unsuccessful.
-func mergeV4V6(v4Annotators, v6Annotators []api.Annotator, discriminator string) []api.Annotator {
-	v4 := directory.SortSlice(v4Annotators)
-	v6 := directory.SortSlice(v6Annotators)
+// role merges the annotators of the sources of a role, in config order.  It
+// returns false if no source has the role.
+func (bldr *listBuilder) role(role string) ([]api.Annotator, bool) {
 	var merged []api.Annotator
-	if len(v4)*len(v6) < 1 {
-		log.Printf("empty v4 or v6 annotator list for %s data, skipping", discriminator)
-		merged = make([]api.Annotator, 0)
-	} else {
-		merged = directory.MergeAnnotators(v4, v6)
+	var names []string // The names of the sources merged so far.
+	groups := map[string]bool{}
+	for i, s := range bldr.sources {
+		if s.Role != role || groups[s.Group] {
+			continue
+		}
+		var annotators []api.Annotator
+		name := s.Name
+		if s.Group == "" {
+			annotators = directory.SortSlice(s.loader.Fetch())
+		} else {
+			// Merge the v4 & v6 annotators of the group.
+			groups[s.Group] = true
+			name = s.Group
+			v4, v6 := s, bldr.partner(i)
+			if s.Family == geoloader.IPv6 {
+				v4, v6 = v6, v4
+			}
+			annotators = mergeV4V6(
+				directory.Source{Name: v4.Name, Annotators: v4.loader.Fetch()},
+				directory.Source{Name: v6.Name, Annotators: v6.loader.Fetch()})
+		}
+		switch {
+		case names == nil:
+			merged = annotators
+		case s.Merge == FallbackMerge:
+			merged = mergeFallback(
+				directory.Source{Name: strings.Join(names, "+"), Annotators: merged},
+				directory.Source{Name: name, Annotators: annotators})
+		default:
+			merged = mergeUnion(merged, annotators)
+		}
+		names = append(names, name)
+	}
+	return merged, names != nil
+}
+
+// partner returns the other source of the group of source i.
+func (bldr *listBuilder) partner(i int) sourceLoader {
+	for j, s := range bldr.sources {
+		if j != i && s.Group == bldr.sources[i].Group {
+			return s
+		}
+	}
+	// Config.validate ensures that every group has two sources.
+	panic("no partner for source " + bldr.sources[i].Name)
+}
+
+// mergeV4V6 holds common logic to merge legacy location and ASN v4 and v6 annotators into composite annotators.
+// The purpose of the merge is to fallback to IPv6 lookup if IPv4 lookup was unsuccessful.  When
+// one of the lists is empty, or starts later, the composite annotators list it as missing, and
+// only use the other.
+func mergeV4V6(v4, v6 directory.Source) []api.Annotator {
+	v4.Annotators = directory.SortSlice(v4.Annotators)
+	v6.Annotators = directory.SortSlice(v6.Annotators)
+	if len(v4.Annotators) == 0 || len(v6.Annotators) == 0 {
+		log.Printf("empty %s or %s annotator list, using partial data", v4.Name, v6.Name)
+	}
+	return directory.MergeSources(v4, v6)
+}
+
+// mergeUnion combines two lists of annotators into a single sorted list.
+// When both lists have an annotator for the same date, the earlier one is used,
+// e.g. the Geolite2 CSV annotator rather than the MMDB one.
+func mergeUnion(earlier, later []api.Annotator) []api.Annotator {
+	dates := make(map[time.Time]bool, len(earlier))
+	for i := range earlier {
+		dates[earlier[i].AnnotatorDate()] = true
+	}
+	merged := make([]api.Annotator, 0, len(earlier)+len(later))
+	merged = append(merged, earlier...)
+	for i := range later {
+		if !dates[later[i].AnnotatorDate()] {
+			merged = append(merged, later[i])
+		}
+	}
+	return directory.SortSlice(merged)
+}
+
+// mergeFallback combines two lists of annotators into composite annotators.
+// The primary annotations take precedence, and the fallback ones are used for
+// IPs missing from the primary ones, e.g. Geolite2 ASN for prefixes missing
+// from RouteViews.  If the fallback list is empty, the primary one is returned
+// unchanged.  The composite annotators of the dates without primary
+// annotators list the primary as missing.
+func mergeFallback(primary, fallback directory.Source) []api.Annotator {
+	fallback.Annotators = directory.SortSlice(fallback.Annotators)
+	if len(fallback.Annotators) == 0 {
+		return primary.Annotators
+	}
+	if len(primary.Annotators) == 0 {
+		log.Printf("empty %s annotator list, using only %s data", primary.Name, fallback.Name)
 	}
-	return merged
+	return directory.MergeSources(primary, fallback)
 }
@@ -47,6 +47,12 @@ var (
 		Name: "annotator_Annotation_Lookups_total",
 		Help: "The total number of ip lookups.",
 	})
+	// AnnotationStatus counts the annotated IPs by their api.Status, e.g.
+	// "ok", "partial" or "not-found".
+	AnnotationStatus = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "annotator_Annotation_Status_total",
+		Help: "The total number of ip lookups, by annotation status.",
+	}, []string{"status"})
 	BadIPTotal = promauto.NewCounterVec(prometheus.CounterOpts{
 		Name: "annotator_Bad_IP_Addresses_total",
 		Help: "The total number of ip parse failures.",
@@ -81,6 +87,37 @@ var (
 		Help: "The total number of datasets loaded.",
 	})
 
+	// LoadFailures counts the failed attempts to load datasets, by loader,
+	// e.g. "geolite2" or "routeviews-v4".
+	LoadFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "annotator_load_failures_total",
+		Help: "The total number of failed attempts to load a dataset.",
+	}, []string{"source"})
+	// FailingDatasets counts the datasets that failed to load, and will be
+	// tried again, by loader.
+	FailingDatasets = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "annotator_failing_datasets",
+		Help: "Number of datasets that failed to load, and are waiting to be retried.",
+	}, []string{"source"})
+	// QuarantinedDatasets counts the corrupt datasets, which are not loaded
+	// again, by loader.
+	QuarantinedDatasets = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "annotator_quarantined_datasets",
+		Help: "Number of corrupt datasets that are not loaded again.",
+	}, []string{"source"})
+	// DatasetEvents counts the dataset change notifications, by event source,
+	// e.g. "dir", "webhook" or "pubsub".
+	DatasetEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "annotator_dataset_events_total",
+		Help: "The total number of dataset change notifications.",
+	}, []string{"source"})
+	// DirectoryUpdates counts the directory updates, by result: "swapped",
+	// "rejected" by the sanity checks, or "rollback".
+	DirectoryUpdates = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "annotator_directory_updates_total",
+		Help: "The total number of directory updates, by result.",
+	}, []string{"result"})
+
 	RejectionCount = promauto.NewCounterVec(prometheus.CounterOpts{
 		Name: "annotator_rejections_total",
 		Help: "The total number of rejected requests.",
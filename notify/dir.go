@@ -0,0 +1,9 @@
+package notify
+
+// Dir is a Source whose events are the files written or moved into a local
+// dataset directory, or any of its subdirectories.  The event names are the
+// slash separated paths of the files relative to the directory, as the object
+// names of a loader.NewDirSource.
+type Dir struct {
+	Path string
+}
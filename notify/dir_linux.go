@@ -0,0 +1,109 @@
+package notify
+
+import (
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// watchMask selects the inotify events that report new files and directories.
+const watchMask = syscall.IN_CLOSE_WRITE | syscall.IN_MOVED_TO | syscall.IN_CREATE
+
+// dirWatcher holds the inotify watches of a directory tree.
+type dirWatcher struct {
+	fd   int
+	root string
+	dirs map[int32]string // The watched directories, by watch descriptor
+}
+
+// add watches the directory path and its subdirectories.
+func (w *dirWatcher) add(path string) error {
+	return filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+		if err != nil || !info.IsDir() {
+			return err
+		}
+		wd, err := syscall.InotifyAddWatch(w.fd, p, watchMask)
+		if err != nil {
+			return os.NewSyscallError("inotify_add_watch", err)
+		}
+		w.dirs[int32(wd)] = p
+		return nil
+	})
+}
+
+// event returns the Event for path.
+func (w *dirWatcher) event(path string) Event {
+	name, err := filepath.Rel(w.root, path)
+	if err != nil {
+		name = path
+	}
+	return Event{Source: "dir", Name: filepath.ToSlash(name)}
+}
+
+// Watch sends an event for each file written or moved into the directory
+// tree, and for each new subdirectory, whose files may predate its watch.
+func (d *Dir) Watch(ctx context.Context, events chan<- Event) error {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return os.NewSyscallError("inotify_init1", err)
+	}
+	// The non-blocking descriptor uses the runtime poller, so Close
+	// interrupts a pending Read.
+	f := os.NewFile(uintptr(fd), "inotify")
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-stop:
+		}
+		f.Close()
+	}()
+
+	w := &dirWatcher{fd: fd, root: filepath.Clean(d.Path), dirs: map[int32]string{}}
+	if err := w.add(w.root); err != nil {
+		return err
+	}
+	log.Println("Watching", w.root, "for new datasets")
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		n, err := f.Read(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		for off := 0; off+syscall.SizeofInotifyEvent <= n; {
+			raw := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[off]))
+			nameStart := off + syscall.SizeofInotifyEvent
+			off = nameStart + int(raw.Len)
+			name := strings.TrimRight(string(buf[nameStart:off]), "\x00")
+
+			dir, ok := w.dirs[raw.Wd]
+			switch {
+			case raw.Mask&syscall.IN_Q_OVERFLOW != 0:
+				// Events were lost, so the whole tree may have changed.
+				err = send(ctx, events, Event{Source: "dir"})
+			case raw.Mask&syscall.IN_IGNORED != 0:
+				delete(w.dirs, raw.Wd)
+			case !ok:
+			case raw.Mask&syscall.IN_ISDIR != 0:
+				path := filepath.Join(dir, name)
+				if err := w.add(path); err != nil {
+					log.Println("Could not watch", path, err)
+				}
+				err = send(ctx, events, w.event(path))
+			case raw.Mask&(syscall.IN_CLOSE_WRITE|syscall.IN_MOVED_TO) != 0:
+				err = send(ctx, events, w.event(filepath.Join(dir, name)))
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+// +build !linux
+
+package notify
+
+import "context"
+
+// Watch returns ErrNotSupported, as only Linux directories are watched.
+func (d *Dir) Watch(ctx context.Context, events chan<- Event) error {
+	return ErrNotSupported
+}
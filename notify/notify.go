@@ -0,0 +1,126 @@
+// Package notify triggers dataset directory updates from change events, such
+// as new files in a local dataset directory, webhook calls or Pub/Sub
+// messages, instead of waiting for the next periodic update.
+package notify
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/m-lab/annotation-service/metrics"
+)
+
+var (
+	// ErrNotSupported is returned by sources that are not available on the
+	// platform.
+	ErrNotSupported = errors.New("Event source not supported")
+)
+
+// Event reports that datasets may have changed.
+type Event struct {
+	Source string // The kind of source of the event, e.g. "dir" or "webhook"
+	Name   string // The name of the changed object, if known
+}
+
+// Source is a source of dataset change events.
+type Source interface {
+	// Watch sends the events of the source to events, until ctx is canceled
+	// or the source fails.
+	Watch(ctx context.Context, events chan<- Event) error
+}
+
+// Updater runs Update when sources report events.  Events are debounced, so
+// that the files of a new dataset are picked up by a single update: Update
+// runs once no event arrived for Delay, and at most MaxDelay after the first
+// pending event.  Events that arrive during an update are coalesced into one
+// more update.
+type Updater struct {
+	Update   func() error
+	Delay    time.Duration
+	MaxDelay time.Duration
+}
+
+// Run watches the sources until ctx is canceled.  Sources that fail are
+// logged, and the other ones are still watched.
+func (u *Updater) Run(ctx context.Context, sources ...Source) error {
+	events := make(chan Event, 100)
+	wg := sync.WaitGroup{}
+	defer wg.Wait()
+	for _, src := range sources {
+		wg.Add(1)
+		go func(src Source) {
+			defer wg.Done()
+			if err := src.Watch(ctx, events); err != nil && ctx.Err() == nil {
+				log.Printf("Dataset event source %T failed: %v", src, err)
+			}
+		}(src)
+	}
+
+	// The timer runs while events are pending.
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
+	defer timer.Stop()
+	done := make(chan error)
+	pending := 0        // The events since the last update started
+	var first time.Time // The arrival of the first pending event
+	running := false    // Whether an update is running
+	due := false        // Whether the pending events are due for an update
+
+	for {
+		select {
+		case <-ctx.Done():
+			if running {
+				<-done
+			}
+			return ctx.Err()
+		case e := <-events:
+			metrics.DatasetEvents.WithLabelValues(e.Source).Inc()
+			now := time.Now()
+			if pending == 0 {
+				first = now
+			}
+			pending++
+			if due {
+				// An update is running, and the next one starts after it.
+				continue
+			}
+			deadline := now.Add(u.Delay)
+			if max := first.Add(u.MaxDelay); deadline.After(max) {
+				deadline = max
+			}
+			if !timer.Stop() {
+				// Drain the timer, in case it fired at the same time.
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(deadline.Sub(now))
+		case <-timer.C:
+			due = true
+		case err := <-done:
+			running = false
+			if err != nil {
+				log.Println("Dataset update failed:", err)
+			}
+		}
+		if due && !running {
+			log.Println("Updating datasets after", pending, "events")
+			due, running, pending = false, true, 0
+			go func() { done <- u.Update() }()
+		}
+	}
+}
+
+// send sends an event, unless ctx is canceled first.
+func send(ctx context.Context, events chan<- Event, e Event) error {
+	select {
+	case events <- e:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,197 @@
+package notify_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/m-lab/annotation-service/notify"
+)
+
+// chanSource is a Source whose events are sent by the test.
+type chanSource chan notify.Event
+
+func (c chanSource) Watch(ctx context.Context, events chan<- notify.Event) error {
+	for {
+		select {
+		case e := <-c:
+			events <- e
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// waitFor waits up to a second for cond to be true.
+func waitFor(cond func() bool) bool {
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if cond() {
+			return true
+		}
+	}
+	return cond()
+}
+
+func TestUpdater(t *testing.T) {
+	var updates int32
+	release := make(chan bool)
+	u := &notify.Updater{
+		Update: func() error {
+			atomic.AddInt32(&updates, 1)
+			<-release
+			return nil
+		},
+		Delay:    20 * time.Millisecond,
+		MaxDelay: time.Second,
+	}
+	src := make(chanSource)
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error)
+	go func() { result <- u.Run(ctx, src) }()
+
+	// A burst of events triggers a single update.
+	for i := 0; i < 5; i++ {
+		src <- notify.Event{Source: "test"}
+	}
+	if !waitFor(func() bool { return atomic.LoadInt32(&updates) == 1 }) {
+		t.Fatal("updates =", atomic.LoadInt32(&updates))
+	}
+	// Events during an update are coalesced into one more update, after it.
+	for i := 0; i < 5; i++ {
+		src <- notify.Event{Source: "test"}
+	}
+	time.Sleep(50 * time.Millisecond)
+	if got := atomic.LoadInt32(&updates); got != 1 {
+		t.Error("updates during an update =", got)
+	}
+	release <- true
+	if !waitFor(func() bool { return atomic.LoadInt32(&updates) == 2 }) {
+		t.Fatal("updates =", atomic.LoadInt32(&updates))
+	}
+	release <- true
+	time.Sleep(50 * time.Millisecond)
+	if got := atomic.LoadInt32(&updates); got != 2 {
+		t.Error("updates =", got)
+	}
+
+	cancel()
+	if err := <-result; err != context.Canceled {
+		t.Error("Run() =", err)
+	}
+}
+
+func TestUpdaterMaxDelay(t *testing.T) {
+	var updates int32
+	u := &notify.Updater{
+		Update: func() error {
+			atomic.AddInt32(&updates, 1)
+			return errors.New("update failed")
+		},
+		Delay:    time.Hour,
+		MaxDelay: 20 * time.Millisecond,
+	}
+	src := make(chanSource)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go u.Run(ctx, src)
+	src <- notify.Event{Source: "test"}
+	if !waitFor(func() bool { return atomic.LoadInt32(&updates) == 1 }) {
+		t.Error("updates =", atomic.LoadInt32(&updates))
+	}
+}
+
+func TestWebhook(t *testing.T) {
+	hook := notify.NewWebhook("secret")
+	tests := []struct {
+		method string
+		auth   string
+		body   string
+		want   int
+	}{
+		{http.MethodGet, "Bearer secret", "", http.StatusMethodNotAllowed},
+		{http.MethodPost, "", "", http.StatusUnauthorized},
+		{http.MethodPost, "Bearer wrong", "", http.StatusUnauthorized},
+		{http.MethodPost, "Bearer secret", "{", http.StatusBadRequest},
+		{http.MethodPost, "Bearer secret", `{"name": "Maxmind/2020/02/04/20200204T000000Z-GeoLite2-City.mmdb"}`, http.StatusAccepted},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, "/notify", strings.NewReader(tt.body))
+		if tt.auth != "" {
+			r.Header.Set("Authorization", tt.auth)
+		}
+		w := httptest.NewRecorder()
+		hook.ServeHTTP(w, r)
+		if w.Code != tt.want {
+			t.Errorf("%s %q: code = %d, want %d", tt.method, tt.auth, w.Code, tt.want)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := make(chan notify.Event)
+	go hook.Watch(ctx, events)
+	select {
+	case e := <-events:
+		if e.Source != "webhook" || e.Name != "Maxmind/2020/02/04/20200204T000000Z-GeoLite2-City.mmdb" {
+			t.Error("event =", e)
+		}
+	case <-time.After(time.Second):
+		t.Error("No webhook event")
+	}
+}
+
+func TestDir(t *testing.T) {
+	dir := t.TempDir()
+	ctx, cancel := context.WithCancel(context.Background())
+	events := make(chan notify.Event, 10)
+	result := make(chan error)
+	go func() { result <- (&notify.Dir{Path: dir}).Watch(ctx, events) }()
+
+	next := func() notify.Event {
+		select {
+		case e := <-events:
+			return e
+		case err := <-result:
+			if err == notify.ErrNotSupported {
+				t.Skip(err)
+			}
+			t.Fatal(err)
+		case <-time.After(time.Second):
+			t.Fatal("No dir event")
+		}
+		return notify.Event{}
+	}
+	// Give the watcher time to start.
+	time.Sleep(50 * time.Millisecond)
+	if err := os.MkdirAll(filepath.Join(dir, "Maxmind", "2020"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if e := next(); e.Source != "dir" || e.Name != "Maxmind" {
+		t.Error("event =", e)
+	}
+	// Files in new directories are reported too.
+	time.Sleep(50 * time.Millisecond)
+	name := "Maxmind/2020/20200204T000000Z-GeoLite2-City.mmdb"
+	if err := ioutil.WriteFile(filepath.Join(dir, filepath.FromSlash(name)), []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// The events of the subdirectory may come first.
+	for e := next(); e.Name != name; e = next() {
+		if e.Name != "Maxmind/2020" {
+			t.Error("event =", e)
+		}
+	}
+
+	cancel()
+	if err := <-result; err != context.Canceled {
+		t.Error("Watch() =", err)
+	}
+}
@@ -0,0 +1,45 @@
+package notify
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+)
+
+// PubSub is a Source whose events are the messages of a Pub/Sub topic, such
+// as the "downloader-new-files" topic of the dataset downloader.  Each
+// instance creates a fresh subscription, and deletes it when it stops.
+type PubSub struct {
+	Project string // The GCP project of the topic
+	Topic   string // The topic name
+}
+
+// Watch sends an event for each message of the topic.
+func (p *PubSub) Watch(ctx context.Context, events chan<- Event) error {
+	// Get a client to connect to the pubsub service
+	client, err := pubsub.NewClient(ctx, p.Project)
+	if err != nil {
+		return err
+	}
+	// Create a fresh subscriptions just for this instance
+	sub, err := client.CreateSubscription(ctx,
+		"annotator-"+strconv.FormatInt(time.Now().UnixNano(), 10),
+		pubsub.SubscriptionConfig{
+			Topic:       client.Topic(p.Topic),
+			AckDeadline: 30 * time.Second,
+		})
+	if err != nil {
+		return err
+	}
+	defer sub.Delete(context.Background())
+	return sub.Receive(ctx, func(ctx context.Context, m *pubsub.Message) {
+		// GCS notifications name the object in the objectId attribute.
+		if send(ctx, events, Event{Source: "pubsub", Name: m.Attributes["objectId"]}) != nil {
+			m.Nack()
+			return
+		}
+		m.Ack()
+	})
+}
@@ -0,0 +1,76 @@
+package notify
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+)
+
+// Webhook is a Source whose events are HTTP POST requests, e.g. from the job
+// that downloads the datasets.  The request body may be a JSON object with
+// the name of the new object, as in {"name": "Maxmind/2020/..."}.
+type Webhook struct {
+	token  string
+	events chan Event
+}
+
+// NewWebhook returns a Webhook.  If token is not empty, requests must have an
+// "Authorization: Bearer <token>" header.
+func NewWebhook(token string) *Webhook {
+	// Events are coalesced anyway, so a few of them are enough to trigger an
+	// update while the Updater is busy.
+	return &Webhook{token: token, events: make(chan Event, 10)}
+}
+
+// Watch sends the events of the requests to events.
+func (w *Webhook) Watch(ctx context.Context, events chan<- Event) error {
+	for {
+		select {
+		case e := <-w.events:
+			if err := send(ctx, events, e); err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// ServeHTTP accepts a notification.
+func (w *Webhook) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(rw, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+	if w.token != "" {
+		auth := []byte(r.Header.Get("Authorization"))
+		if subtle.ConstantTimeCompare(auth, []byte("Bearer "+w.token)) != 1 {
+			http.Error(rw, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+	}
+	e := Event{Source: "webhook"}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(rw, r.Body, 4096))
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(body) > 0 {
+		var notification struct {
+			Name string `json:"name"`
+		}
+		if err := json.Unmarshal(body, &notification); err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+		e.Name = notification.Name
+	}
+	select {
+	case w.events <- e:
+	default:
+		// An update is already pending.
+	}
+	rw.WriteHeader(http.StatusAccepted)
+}
@@ -0,0 +1,33 @@
+#!/bin/sh
+
+# Pins the dependencies downloaded by `go get -d` into GOPATH, which are the
+# latest commits of their default branches, to versions that build with the
+# Go 1.15 toolchain of the Dockerfile and .travis.yml.
+#
+# All the dependencies are checked out at their last commit before PIN_DATE,
+# and gRPC and protobuf at the releases that api/annotatorpb was generated
+# for: protoc-gen-go v1.28.1, and protoc-gen-go-grpc v1.2.0, which needs
+# gRPC-Go v1.32.0 or later.
+
+set -e
+
+PIN_DATE=${PIN_DATE:-2021-08-01}
+GRPC_VERSION=v1.40.0
+PROTOBUF_VERSION=v1.28.1
+
+src="$(go env GOPATH)/src"
+self="${src}/github.com/m-lab/annotation-service"
+
+find "${src}" -name .git -prune | while read -r git; do
+  dir=$(dirname "${git}")
+  if [ "${dir}" = "${self}" ]; then
+    continue
+  fi
+  rev=$(git -C "${dir}" rev-list -n 1 --first-parent --before="${PIN_DATE}" HEAD)
+  if [ -n "${rev}" ]; then
+    git -C "${dir}" checkout -q "${rev}"
+  fi
+done
+
+git -C "${src}/google.golang.org/grpc" checkout -q "${GRPC_VERSION}"
+git -C "${src}/google.golang.org/protobuf" checkout -q "${PROTOBUF_VERSION}"
@@ -0,0 +1,195 @@
+// Package rangetable stores sorted lists of non-overlapping IP ranges as
+// fixed width columns, instead of lists of nodes holding net.IP slices.  The
+// columns can be written to, and used in place from, dataset snapshots.
+//
+// Ranges inside ::ffff:0:0/96 are stored with uint32 bounds, and all other
+// ranges with 128 bit bounds.  Rows are numbered with the IPv4 ranges first,
+// and datasets store the data for each range in columns indexed by row.
+package rangetable
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"sort"
+
+	"github.com/m-lab/annotation-service/iputils"
+	"github.com/m-lab/annotation-service/snapshot"
+)
+
+// Table is a sorted list of non-overlapping IP ranges.  The zero value is an
+// empty table.
+type Table struct {
+	low4, high4 []uint32
+	// The 128 bit bounds are stored as pairs of uint64, high word first.
+	low6, high6 []uint64
+}
+
+// Len returns the number of ranges in the table.
+func (t *Table) Len() int {
+	return len(t.low4) + len(t.low6)/2
+}
+
+// Size returns the approximate memory used by the table, in bytes.
+func (t *Table) Size() int64 {
+	return int64(4*(len(t.low4)+len(t.high4)) + 8*(len(t.low6)+len(t.high6)))
+}
+
+// Families returns whether the table has ranges with IPv4 addresses, and
+// ranges with IPv6 ones.
+func (t *Table) Families() (ipv4, ipv6 bool) {
+	// The first 128 bit range ending in ::ffff:0:0/96 or after it may
+	// overlap it.
+	n := len(t.high6) / 2
+	i := sort.Search(n, func(i int) bool { return !less(t.high6, i, 0, 0xffff00000000) })
+	ipv4 = len(t.low4) > 0 || (i < n && !greater(t.low6, i, 0, 0xffffffffffff))
+	return ipv4, n > 0
+}
+
+// Search returns the row of the range containing ip, or
+// iputils.ErrNodeNotFound if there is none.
+func (t *Table) Search(ip net.IP) (int, error) {
+	if ip4 := ip.To4(); ip4 != nil {
+		x := binary.BigEndian.Uint32(ip4)
+		i := sort.Search(len(t.high4), func(i int) bool { return t.high4[i] >= x })
+		if i < len(t.high4) && t.low4[i] <= x {
+			return i, nil
+		}
+		// IPv4 addresses may still be inside an IPv6 range that spans
+		// ::ffff:0:0/96.
+	}
+	ip16 := ip.To16()
+	if ip16 == nil {
+		return -1, iputils.ErrNodeNotFound
+	}
+	hi, lo := binary.BigEndian.Uint64(ip16), binary.BigEndian.Uint64(ip16[8:])
+	n := len(t.high6) / 2
+	i := sort.Search(n, func(i int) bool { return !less(t.high6, i, hi, lo) })
+	if i < n && !greater(t.low6, i, hi, lo) {
+		return len(t.low4) + i, nil
+	}
+	return -1, iputils.ErrNodeNotFound
+}
+
+// SearchRange returns the ranges that overlap [first, last], in address
+// order, clipped to [first, last].
+func (t *Table) SearchRange(first, last net.IP) []iputils.RowRange {
+	first, last = first.To16(), last.To16()
+	if first == nil || last == nil || bytes.Compare(first, last) > 0 {
+		return nil
+	}
+	var result []iputils.RowRange
+	// The IPv4 ranges, for the part of the query inside ::ffff:0:0/96.
+	if len(t.low4) > 0 && bytes.Compare(first, v4Last) <= 0 && bytes.Compare(last, v4First) >= 0 {
+		x, y := uint32(0), ^uint32(0)
+		if bytes.Compare(first, v4First) > 0 {
+			x = binary.BigEndian.Uint32(first[12:])
+		}
+		if bytes.Compare(last, v4Last) < 0 {
+			y = binary.BigEndian.Uint32(last[12:])
+		}
+		for i := sort.Search(len(t.high4), func(i int) bool { return t.high4[i] >= x }); i < len(t.low4) && t.low4[i] <= y; i++ {
+			result = append(result, t.clip(i, first, last))
+		}
+	}
+	hi, lo := binary.BigEndian.Uint64(first), binary.BigEndian.Uint64(first[8:])
+	lastHi, lastLo := binary.BigEndian.Uint64(last), binary.BigEndian.Uint64(last[8:])
+	n := len(t.high6) / 2
+	for i := sort.Search(n, func(i int) bool { return !less(t.high6, i, hi, lo) }); i < n && !greater(t.low6, i, lastHi, lastLo); i++ {
+		result = append(result, t.clip(len(t.low4)+i, first, last))
+	}
+	// IPv6 ranges may be before or after the IPv4 ones.
+	sort.Slice(result, func(i, j int) bool { return bytes.Compare(result[i].First, result[j].First) < 0 })
+	return result
+}
+
+var (
+	v4First = net.IPv4(0, 0, 0, 0)
+	v4Last  = net.IPv4(255, 255, 255, 255)
+)
+
+// clip returns the range in the row, clipped to [first, last].
+func (t *Table) clip(row int, first, last net.IP) iputils.RowRange {
+	low, high := t.Bounds(row)
+	if bytes.Compare(low, first) < 0 {
+		low = first
+	}
+	if bytes.Compare(high, last) > 0 {
+		high = last
+	}
+	return iputils.RowRange{First: low, Last: high, Row: row}
+}
+
+// less returns true if the 128 bit value at index i in v is less than hi:lo.
+func less(v []uint64, i int, hi, lo uint64) bool {
+	return v[2*i] < hi || (v[2*i] == hi && v[2*i+1] < lo)
+}
+
+// greater returns true if the 128 bit value at index i in v is greater than hi:lo.
+func greater(v []uint64, i int, hi, lo uint64) bool {
+	return v[2*i] > hi || (v[2*i] == hi && v[2*i+1] > lo)
+}
+
+// Bounds returns the first and last addresses of the range in the row, in
+// the 16 byte form.
+func (t *Table) Bounds(row int) (net.IP, net.IP) {
+	if row < len(t.low4) {
+		return net.IPv4(byte(t.low4[row]>>24), byte(t.low4[row]>>16), byte(t.low4[row]>>8), byte(t.low4[row])),
+			net.IPv4(byte(t.high4[row]>>24), byte(t.high4[row]>>16), byte(t.high4[row]>>8), byte(t.high4[row]))
+	}
+	i := row - len(t.low4)
+	low, high := make(net.IP, net.IPv6len), make(net.IP, net.IPv6len)
+	binary.BigEndian.PutUint64(low, t.low6[2*i])
+	binary.BigEndian.PutUint64(low[8:], t.low6[2*i+1])
+	binary.BigEndian.PutUint64(high, t.high6[2*i])
+	binary.BigEndian.PutUint64(high[8:], t.high6[2*i+1])
+	return low, high
+}
+
+// Encode writes the table to a snapshot.
+func (t *Table) Encode(e *snapshot.Encoder) {
+	e.Uint32s(t.low4)
+	e.Uint32s(t.high4)
+	e.Uint64s(t.low6)
+	e.Uint64s(t.high6)
+}
+
+// DecodeTable reads a table written by Encode.  The table uses the snapshot
+// content in place when possible.
+func DecodeTable(d *snapshot.Decoder) Table {
+	t := Table{low4: d.Uint32s(), high4: d.Uint32s(), low6: d.Uint64s(), high6: d.Uint64s()}
+	if len(t.low4) != len(t.high4) || len(t.low6) != len(t.high6) || len(t.low6)%2 != 0 {
+		d.Fail(snapshot.ErrCorrupt)
+		return Table{}
+	}
+	return t
+}
+
+// Builder builds a Table from a list of ranges.
+type Builder struct {
+	t            Table
+	rows4, rows6 []int
+}
+
+// Add appends the range [low, high] to the table.  Ranges must be added in
+// increasing order, and must not overlap.
+func (b *Builder) Add(low, high net.IP) {
+	index := len(b.rows4) + len(b.rows6)
+	low4, high4 := low.To4(), high.To4()
+	if low4 != nil && high4 != nil {
+		b.t.low4 = append(b.t.low4, binary.BigEndian.Uint32(low4))
+		b.t.high4 = append(b.t.high4, binary.BigEndian.Uint32(high4))
+		b.rows4 = append(b.rows4, index)
+		return
+	}
+	low, high = low.To16(), high.To16()
+	b.t.low6 = append(b.t.low6, binary.BigEndian.Uint64(low), binary.BigEndian.Uint64(low[8:]))
+	b.t.high6 = append(b.t.high6, binary.BigEndian.Uint64(high), binary.BigEndian.Uint64(high[8:]))
+	b.rows6 = append(b.rows6, index)
+}
+
+// Build returns the table, and for each of its rows, the index of the Add
+// call that added the range.  Datasets use it to lay out their columns.
+func (b *Builder) Build() (Table, []int) {
+	return b.t, append(b.rows4, b.rows6...)
+}
@@ -0,0 +1,162 @@
+package rangetable_test
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/m-lab/annotation-service/iputils"
+	"github.com/m-lab/annotation-service/rangetable"
+	"github.com/m-lab/annotation-service/snapshot"
+)
+
+func newTable(t *testing.T, ranges ...string) rangetable.Table {
+	b := rangetable.Builder{}
+	for i := 0; i < len(ranges); i += 2 {
+		b.Add(net.ParseIP(ranges[i]), net.ParseIP(ranges[i+1]))
+	}
+	table, order := b.Build()
+	for row, i := range order {
+		low, high := table.Bounds(row)
+		if !low.Equal(net.ParseIP(ranges[2*i])) || !high.Equal(net.ParseIP(ranges[2*i+1])) {
+			t.Fatalf("Bounds(%d) = %s-%s, want range %d", row, low, high, i)
+		}
+	}
+	return table
+}
+
+func TestSearch(t *testing.T) {
+	table := newTable(t,
+		"::", "::ffff", // IPv6 range before ::ffff:0:0/96
+		"1.0.0.0", "1.0.0.255",
+		"1.0.4.0", "1.0.7.255",
+		"2001:db8::", "2001:db8::ffff",
+		"2001:db8:1::", "2001:db9::",
+	)
+	if table.Len() != 5 {
+		t.Fatal("Len() =", table.Len())
+	}
+	for _, tt := range []struct {
+		ranges []string
+		v4, v6 bool
+	}{
+		{[]string{"1.0.0.0", "1.0.0.255"}, true, false},
+		{[]string{"::", "::ffff", "2001:db8::", "2001:db8::ffff"}, false, true},
+		{[]string{"::", "ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff"}, true, true},
+	} {
+		table := newTable(t, tt.ranges...)
+		if v4, v6 := table.Families(); v4 != tt.v4 || v6 != tt.v6 {
+			t.Errorf("%v: Families() = %v, %v", tt.ranges, v4, v6)
+		}
+	}
+	tests := []struct {
+		ip      string
+		want    string // The first address of the range found.
+		wantErr error
+	}{
+		{ip: "1.0.0.0", want: "1.0.0.0"},
+		{ip: "1.0.0.255", want: "1.0.0.0"},
+		{ip: "1.0.5.1", want: "1.0.4.0"},
+		{ip: "1.0.1.0", wantErr: iputils.ErrNodeNotFound},
+		{ip: "0.0.0.1", wantErr: iputils.ErrNodeNotFound},
+		{ip: "::1", want: "::"},
+		{ip: "2001:db8::1", want: "2001:db8::"},
+		{ip: "2001:db8:2::", want: "2001:db8:1::"},
+		{ip: "2001:db9::", want: "2001:db8:1::"},
+		{ip: "2001:db9::1", wantErr: iputils.ErrNodeNotFound},
+	}
+	for _, tt := range tests {
+		row, err := table.Search(net.ParseIP(tt.ip))
+		if err != tt.wantErr {
+			t.Errorf("Search(%s) error = %v, want %v", tt.ip, err, tt.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if low, _ := table.Bounds(row); !low.Equal(net.ParseIP(tt.want)) {
+			t.Errorf("Search(%s) found %s, want %s", tt.ip, low, tt.want)
+		}
+	}
+}
+
+func TestSearchIPv4InIPv6Range(t *testing.T) {
+	// A range spanning ::ffff:0:0/96 is stored with 128 bit bounds, but must
+	// still be found for IPv4 addresses.
+	table := newTable(t, "::", "ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff")
+	if _, err := table.Search(net.ParseIP("127.0.0.1")); err != nil {
+		t.Error("Search() error =", err)
+	}
+	if _, err := table.Search(net.ParseIP("127.0.0.1").To4()); err != nil {
+		t.Error("Search() error =", err)
+	}
+}
+
+func TestEncodeDecode(t *testing.T) {
+	want := newTable(t, "1.0.0.0", "1.0.0.255", "2001:db8::", "2001:db8::ffff")
+	postal := rangetable.NewStrings([]string{"10583", "10583"})
+
+	buf := bytes.Buffer{}
+	e := snapshot.NewEncoder(&buf, snapshot.KindASN)
+	want.Encode(e)
+	postal.Encode(e)
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+	d, err := snapshot.NewDecoder(buf.Bytes(), snapshot.KindASN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := rangetable.DecodeTable(d)
+	gotPostal := rangetable.DecodeStrings(d)
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got.Len() != 2 || gotPostal.Len() != 2 || gotPostal.Get(1) != "10583" {
+		t.Fatal("decoded", got.Len(), "ranges and", gotPostal.Len(), "strings")
+	}
+	for _, ip := range []string{"1.0.0.1", "2001:db8::1"} {
+		if _, err := got.Search(net.ParseIP(ip)); err != nil {
+			t.Errorf("Search(%s) error = %v", ip, err)
+		}
+	}
+}
+
+func TestSearchRange(t *testing.T) {
+	table := newTable(t,
+		"1.0.0.0", "1.0.0.255",
+		"1.0.2.0", "1.0.3.255",
+		"::1", "::ffff:0.255.255.255", // Spans the first IPv4 addresses
+		"2001:db8::", "2001:db8::ffff",
+	)
+	tests := []struct {
+		first, last string
+		want        []string
+	}{
+		{"1.0.0.128", "1.0.2.5", []string{"1.0.0.128-1.0.0.255", "1.0.2.0-1.0.2.5"}},
+		{"1.0.1.0", "1.0.1.255", nil},
+		{"::", "1.0.0.0", []string{"::1-0.255.255.255", "1.0.0.0-1.0.0.0"}},
+		{"2001:db8::8", "2001:db9::", []string{"2001:db8::8-2001:db8::ffff"}},
+		{"1.0.0.1", "1.0.0.0", nil},
+	}
+	for _, tt := range tests {
+		got := []string{}
+		for _, r := range table.SearchRange(net.ParseIP(tt.first), net.ParseIP(tt.last)) {
+			low, high := table.Bounds(r.Row)
+			if bytes.Compare(r.First, low) < 0 || bytes.Compare(r.Last, high) > 0 {
+				t.Errorf("range %s-%s is not in row %d", r.First, r.Last, r.Row)
+			}
+			got = append(got, r.First.String()+"-"+r.Last.String())
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("SearchRange(%s, %s) = %v, want %v", tt.first, tt.last, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("SearchRange(%s, %s) = %v, want %v", tt.first, tt.last, got, tt.want)
+				break
+			}
+		}
+	}
+}
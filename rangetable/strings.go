@@ -0,0 +1,75 @@
+package rangetable
+
+import (
+	"unsafe"
+
+	"github.com/m-lab/annotation-service/snapshot"
+)
+
+// Strings is a column of interned strings.  Each row holds an index into the
+// list of distinct values, so repeated values, like postal codes, are stored
+// once.
+type Strings struct {
+	index  []int32
+	values []string
+}
+
+// NewStrings returns a column holding the values.
+func NewStrings(values []string) Strings {
+	s := Strings{index: make([]int32, len(values))}
+	ids := map[string]int32{}
+	for i, v := range values {
+		id, ok := ids[v]
+		if !ok {
+			id = int32(len(s.values))
+			ids[v] = id
+			s.values = append(s.values, v)
+		}
+		s.index[i] = id
+	}
+	return s
+}
+
+// Len returns the number of rows.
+func (s *Strings) Len() int {
+	return len(s.index)
+}
+
+// Get returns the value in the row.
+func (s *Strings) Get(row int) string {
+	return s.values[s.index[row]]
+}
+
+// Size returns the approximate memory used by the column, in bytes.
+func (s *Strings) Size() int64 {
+	size := int64(4 * len(s.index))
+	for _, v := range s.values {
+		size += int64(unsafe.Sizeof(v)) + int64(len(v))
+	}
+	return size
+}
+
+// Encode writes the column to a snapshot.
+func (s *Strings) Encode(e *snapshot.Encoder) {
+	e.Uvarint(uint64(len(s.values)))
+	for _, v := range s.values {
+		e.Text(v)
+	}
+	e.Int32s(s.index)
+}
+
+// DecodeStrings reads a column written by Encode.
+func DecodeStrings(d *snapshot.Decoder) Strings {
+	s := Strings{values: make([]string, d.Count())}
+	for i := range s.values {
+		s.values[i] = d.Text()
+	}
+	s.index = d.Int32s()
+	for _, id := range s.index {
+		if id < 0 || int(id) >= len(s.values) {
+			d.Fail(snapshot.ErrCorrupt)
+			return Strings{}
+		}
+	}
+	return s
+}
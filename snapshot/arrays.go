@@ -0,0 +1,76 @@
+package snapshot
+
+import (
+	"encoding/binary"
+	"math"
+	"unsafe"
+)
+
+// littleEndian is true if the host byte order matches the snapshot byte
+// order, so that arrays can be used in place.
+var littleEndian = func() bool {
+	x := uint16(1)
+	return *(*byte)(unsafe.Pointer(&x)) == 1
+}()
+
+// inPlace returns true if b can be used in place as an array of values with
+// the given alignment.
+func inPlace(b []byte, align uintptr) bool {
+	return littleEndian && uintptr(unsafe.Pointer(&b[0]))%align == 0
+}
+
+func uint32s(n int, b []byte) []uint32 {
+	if n == 0 {
+		return nil
+	}
+	if inPlace(b, unsafe.Alignof(uint32(0))) {
+		return (*[maxCount]uint32)(unsafe.Pointer(&b[0]))[:n:n]
+	}
+	v := make([]uint32, n)
+	for i := range v {
+		v[i] = binary.LittleEndian.Uint32(b[4*i:])
+	}
+	return v
+}
+
+func int32s(n int, b []byte) []int32 {
+	if n == 0 {
+		return nil
+	}
+	if inPlace(b, unsafe.Alignof(int32(0))) {
+		return (*[maxCount]int32)(unsafe.Pointer(&b[0]))[:n:n]
+	}
+	v := make([]int32, n)
+	for i := range v {
+		v[i] = int32(binary.LittleEndian.Uint32(b[4*i:]))
+	}
+	return v
+}
+
+func uint64s(n int, b []byte) []uint64 {
+	if n == 0 {
+		return nil
+	}
+	if inPlace(b, unsafe.Alignof(uint64(0))) {
+		return (*[maxCount]uint64)(unsafe.Pointer(&b[0]))[:n:n]
+	}
+	v := make([]uint64, n)
+	for i := range v {
+		v[i] = binary.LittleEndian.Uint64(b[8*i:])
+	}
+	return v
+}
+
+func float64s(n int, b []byte) []float64 {
+	if n == 0 {
+		return nil
+	}
+	if inPlace(b, unsafe.Alignof(float64(0))) {
+		return (*[maxCount]float64)(unsafe.Pointer(&b[0]))[:n:n]
+	}
+	v := make([]float64, n)
+	for i := range v {
+		v[i] = math.Float64frombits(binary.LittleEndian.Uint64(b[8*i:]))
+	}
+	return v
+}
@@ -0,0 +1,15 @@
+//go:build !darwin && !freebsd && !linux
+// +build !darwin,!freebsd,!linux
+
+package snapshot
+
+import "io/ioutil"
+
+// mapFile reads the file at path onto the heap, on platforms without mmap support.
+func mapFile(path string) (*Mapping, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Mapping{data: data}, nil
+}
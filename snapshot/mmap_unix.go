@@ -0,0 +1,36 @@
+//go:build darwin || freebsd || linux
+// +build darwin freebsd linux
+
+package snapshot
+
+import (
+	"os"
+	"runtime"
+	"syscall"
+)
+
+// mapFile memory maps the file at path read only.
+func mapFile(path string) (*Mapping, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := fi.Size()
+	if size == 0 || int64(int(size)) != size {
+		return nil, ErrCorrupt
+	}
+	data, err := syscall.Mmap(int(f.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, err
+	}
+	m := &Mapping{data: data}
+	runtime.SetFinalizer(m, func(m *Mapping) {
+		syscall.Munmap(m.data)
+	})
+	return m, nil
+}
@@ -0,0 +1,410 @@
+// Package snapshot provides a compact binary encoding for preprocessed
+// datasets, so that annotators can be restored without re-parsing the raw
+// CSV and RouteViews sources.
+//
+// A snapshot is a header (magic, format version and dataset kind), followed
+// by dataset specific content written with the Encoder primitives, followed by
+// a trailer.  The dataset packages (geolite2v2, asn) define their content.
+// Snapshots are stored next to the raw dataset, with the Suffix appended to
+// the raw object name.
+//
+// Arrays are written as fixed width little endian values, aligned to 8 bytes
+// from the start of the snapshot.  When a snapshot is memory mapped, the
+// Decoder returns them in place, so the page cache holds the only copy of the
+// dataset and is shared by all the processes using it.
+package snapshot
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+
+	"github.com/m-lab/annotation-service/loader"
+)
+
+// Kind identifies the type of dataset stored in a snapshot.
+type Kind uint8
+
+const (
+	// KindGeoLite2 is a geolite2v2.GeoDataset.
+	KindGeoLite2 Kind = 1
+	// KindASN is an asn.ASNDataset.
+	KindASN Kind = 2
+
+	// Suffix is appended to the name of a raw dataset object to name its snapshot.
+	Suffix = ".snap"
+
+	// Version is the current snapshot format version.
+	Version = 3
+
+	magic   = "MLABSNAP"
+	trailer = "ENDSNAP!"
+
+	// maxCount limits the size of slices and strings allocated while decoding,
+	// so that a corrupt snapshot can't cause huge allocations.
+	maxCount = 1 << 26
+
+	// arrayAlign is the alignment of arrays, from the start of the snapshot.
+	arrayAlign = 8
+)
+
+var (
+	// ErrBadMagic is returned when the input is not a snapshot.
+	ErrBadMagic = errors.New("Not a dataset snapshot")
+	// ErrUnsupportedVersion is returned for snapshots written with an unknown format version.
+	ErrUnsupportedVersion = errors.New("Unsupported snapshot version")
+	// ErrWrongKind is returned when the snapshot contains a different kind of dataset.
+	ErrWrongKind = errors.New("Wrong snapshot kind")
+	// ErrCorrupt is returned when the snapshot content is invalid or truncated.
+	ErrCorrupt = errors.New("Corrupt snapshot")
+
+	// CacheDir is a local directory where snapshots from remote sources are
+	// copied, so that they can be memory mapped.  If empty, remote snapshots
+	// are read onto the heap.
+	CacheDir = ""
+)
+
+// Name returns the snapshot object name for the raw dataset object name.
+func Name(datasetName string) string {
+	return datasetName + Suffix
+}
+
+// Mapping holds the content of a snapshot.  Memory mapped content is unmapped
+// when the Mapping becomes unreachable, so datasets decoded from it must keep
+// a reference to it.
+type Mapping struct {
+	data []byte
+}
+
+// Bytes returns the snapshot content.  It must not be modified.
+func (m *Mapping) Bytes() []byte {
+	return m.data
+}
+
+// Load returns the snapshot for the raw dataset object name in src.  Snapshots
+// in local sources are memory mapped in place.  Snapshots in remote sources
+// are copied to CacheDir and mapped from there, or read onto the heap if
+// CacheDir is not set.  It returns an error if there is no snapshot.
+func Load(ctx context.Context, src loader.Source, datasetName string) (*Mapping, error) {
+	name := Name(datasetName)
+	if local, ok := src.(loader.LocalSource); ok {
+		return mapFile(local.LocalPath(name))
+	}
+	if CacheDir != "" {
+		path, err := cache(ctx, src, name)
+		if err != nil {
+			return nil, err
+		}
+		return mapFile(path)
+	}
+	rdr, err := src.NewReader(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	defer rdr.Close()
+	data, err := ioutil.ReadAll(rdr)
+	if err != nil {
+		return nil, err
+	}
+	return &Mapping{data: data}, nil
+}
+
+// cache copies the named snapshot from src to CacheDir, unless it is already
+// there, and returns the path of the copy.
+func cache(ctx context.Context, src loader.Source, name string) (string, error) {
+	path := filepath.Join(CacheDir, filepath.FromSlash(name))
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	}
+	rdr, err := src.NewReader(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	defer rdr.Close()
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return "", err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(f, rdr)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return path, nil
+}
+
+// Encoder writes snapshot content.  Errors are sticky, and returned by Close.
+type Encoder struct {
+	w   *bufio.Writer
+	buf [binary.MaxVarintLen64]byte
+	off int64 // The number of bytes written so far.
+	err error
+}
+
+// NewEncoder writes the snapshot header for kind to w, and returns an Encoder
+// for the content.
+func NewEncoder(w io.Writer, kind Kind) *Encoder {
+	e := &Encoder{w: bufio.NewWriter(w)}
+	e.write([]byte(magic))
+	e.Uvarint(Version)
+	e.Uvarint(uint64(kind))
+	return e
+}
+
+func (e *Encoder) write(b []byte) {
+	if e.err != nil {
+		return
+	}
+	var n int
+	n, e.err = e.w.Write(b)
+	e.off += int64(n)
+}
+
+// align pads the output to a multiple of arrayAlign bytes.
+func (e *Encoder) align() {
+	var pad [arrayAlign]byte
+	if rem := int(e.off % arrayAlign); rem != 0 {
+		e.write(pad[:arrayAlign-rem])
+	}
+}
+
+// Uvarint writes an unsigned integer.
+func (e *Encoder) Uvarint(x uint64) {
+	n := binary.PutUvarint(e.buf[:], x)
+	e.write(e.buf[:n])
+}
+
+// Varint writes a signed integer.
+func (e *Encoder) Varint(x int64) {
+	n := binary.PutVarint(e.buf[:], x)
+	e.write(e.buf[:n])
+}
+
+// Float64 writes a float64.
+func (e *Encoder) Float64(f float64) {
+	binary.LittleEndian.PutUint64(e.buf[:8], math.Float64bits(f))
+	e.write(e.buf[:8])
+}
+
+// Text writes a length prefixed string.
+func (e *Encoder) Text(s string) {
+	e.Uvarint(uint64(len(s)))
+	e.write([]byte(s))
+}
+
+// Uint32s writes a uint32 array.
+func (e *Encoder) Uint32s(v []uint32) {
+	e.Uvarint(uint64(len(v)))
+	e.align()
+	for _, x := range v {
+		binary.LittleEndian.PutUint32(e.buf[:4], x)
+		e.write(e.buf[:4])
+	}
+}
+
+// Int32s writes an int32 array.
+func (e *Encoder) Int32s(v []int32) {
+	e.Uvarint(uint64(len(v)))
+	e.align()
+	for _, x := range v {
+		binary.LittleEndian.PutUint32(e.buf[:4], uint32(x))
+		e.write(e.buf[:4])
+	}
+}
+
+// Uint64s writes a uint64 array.
+func (e *Encoder) Uint64s(v []uint64) {
+	e.Uvarint(uint64(len(v)))
+	e.align()
+	for _, x := range v {
+		binary.LittleEndian.PutUint64(e.buf[:8], x)
+		e.write(e.buf[:8])
+	}
+}
+
+// Float64s writes a float64 array.
+func (e *Encoder) Float64s(v []float64) {
+	e.Uvarint(uint64(len(v)))
+	e.align()
+	for _, f := range v {
+		binary.LittleEndian.PutUint64(e.buf[:8], math.Float64bits(f))
+		e.write(e.buf[:8])
+	}
+}
+
+// Close writes the trailer and flushes the output.  It returns the first
+// error encountered while encoding.
+func (e *Encoder) Close() error {
+	e.write([]byte(trailer))
+	if e.err != nil {
+		return e.err
+	}
+	return e.w.Flush()
+}
+
+// Decoder reads snapshot content.  Errors are sticky, and returned by Close.
+// Arrays are returned in place when the host byte order and the alignment of
+// the content allow it, so the content must outlive the decoded values.
+type Decoder struct {
+	data []byte
+	off  int
+	err  error
+}
+
+// NewDecoder checks the snapshot header in data, and returns a Decoder for
+// the content.
+func NewDecoder(data []byte, kind Kind) (*Decoder, error) {
+	d := &Decoder{data: data}
+	if string(d.bytes(len(magic))) != magic {
+		return nil, ErrBadMagic
+	}
+	if v := d.Uvarint(); v != Version {
+		if d.err != nil {
+			return nil, d.err
+		}
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedVersion, v)
+	}
+	if k := Kind(d.Uvarint()); k != kind {
+		if d.err != nil {
+			return nil, d.err
+		}
+		return nil, fmt.Errorf("%w: got %d, want %d", ErrWrongKind, k, kind)
+	}
+	return d, nil
+}
+
+// Fail records a decoding error, such as ErrCorrupt for content that fails
+// validation.  Only the first error is kept.
+func (d *Decoder) Fail(err error) {
+	if d.err == nil {
+		d.err = err
+	}
+}
+
+func (d *Decoder) bytes(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(d.data)-d.off {
+		d.Fail(ErrCorrupt)
+		return nil
+	}
+	b := d.data[d.off : d.off+n]
+	d.off += n
+	return b
+}
+
+func (d *Decoder) align() {
+	if rem := d.off % arrayAlign; rem != 0 {
+		d.bytes(arrayAlign - rem)
+	}
+}
+
+// array reads the length and the aligned content of an array of size byte
+// values.
+func (d *Decoder) array(size int) (int, []byte) {
+	n := d.Count()
+	d.align()
+	b := d.bytes(n * size)
+	if b == nil {
+		return 0, nil
+	}
+	return n, b
+}
+
+// Uvarint reads an unsigned integer.
+func (d *Decoder) Uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	x, n := binary.Uvarint(d.data[d.off:])
+	if n <= 0 {
+		d.Fail(ErrCorrupt)
+		return 0
+	}
+	d.off += n
+	return x
+}
+
+// Varint reads a signed integer.
+func (d *Decoder) Varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	x, n := binary.Varint(d.data[d.off:])
+	if n <= 0 {
+		d.Fail(ErrCorrupt)
+		return 0
+	}
+	d.off += n
+	return x
+}
+
+// Float64 reads a float64.
+func (d *Decoder) Float64() float64 {
+	b := d.bytes(8)
+	if b == nil {
+		return 0
+	}
+	return math.Float64frombits(binary.LittleEndian.Uint64(b))
+}
+
+// Count reads a slice length, and checks that it is not unreasonably large.
+func (d *Decoder) Count() int {
+	n := d.Uvarint()
+	if n > maxCount {
+		d.Fail(ErrCorrupt)
+		return 0
+	}
+	return int(n)
+}
+
+// Text reads a length prefixed string.
+func (d *Decoder) Text() string {
+	return string(d.bytes(d.Count()))
+}
+
+// Uint32s reads a uint32 array.
+func (d *Decoder) Uint32s() []uint32 {
+	return uint32s(d.array(4))
+}
+
+// Int32s reads an int32 array.
+func (d *Decoder) Int32s() []int32 {
+	return int32s(d.array(4))
+}
+
+// Uint64s reads a uint64 array.
+func (d *Decoder) Uint64s() []uint64 {
+	return uint64s(d.array(8))
+}
+
+// Float64s reads a float64 array.
+func (d *Decoder) Float64s() []float64 {
+	return float64s(d.array(8))
+}
+
+// Close checks the trailer, and returns the first error encountered while decoding.
+func (d *Decoder) Close() error {
+	if string(d.bytes(len(trailer))) != trailer {
+		d.Fail(ErrCorrupt)
+	}
+	return d.err
+}
@@ -0,0 +1,145 @@
+package snapshot_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-test/deep"
+
+	"github.com/m-lab/annotation-service/loader"
+	"github.com/m-lab/annotation-service/snapshot"
+)
+
+func TestRoundTrip(t *testing.T) {
+	buf := bytes.Buffer{}
+	e := snapshot.NewEncoder(&buf, snapshot.KindASN)
+	e.Uvarint(300)
+	e.Varint(-5)
+	e.Float64(-97.822)
+	e.Text("München")
+	e.Uint32s([]uint32{1, 0xffffffff})
+	e.Int32s([]int32{-1, 7})
+	e.Uint64s(nil)
+	e.Float64s([]float64{42.1, -73.1})
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := snapshot.NewDecoder(buf.Bytes(), snapshot.KindASN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := d.Uvarint(); got != 300 {
+		t.Error("Uvarint() =", got)
+	}
+	if got := d.Varint(); got != -5 {
+		t.Error("Varint() =", got)
+	}
+	if got := d.Float64(); got != -97.822 {
+		t.Error("Float64() =", got)
+	}
+	if got := d.Text(); got != "München" {
+		t.Error("Text() =", got)
+	}
+	if diff := deep.Equal(d.Uint32s(), []uint32{1, 0xffffffff}); diff != nil {
+		t.Error("Uint32s()", diff)
+	}
+	if diff := deep.Equal(d.Int32s(), []int32{-1, 7}); diff != nil {
+		t.Error("Int32s()", diff)
+	}
+	if got := d.Uint64s(); len(got) != 0 {
+		t.Error("Uint64s() =", got)
+	}
+	if diff := deep.Equal(d.Float64s(), []float64{42.1, -73.1}); diff != nil {
+		t.Error("Float64s()", diff)
+	}
+	if err := d.Close(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDecoderErrors(t *testing.T) {
+	buf := bytes.Buffer{}
+	e := snapshot.NewEncoder(&buf, snapshot.KindGeoLite2)
+	e.Text("some content")
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+	good := buf.Bytes()
+
+	tests := []struct {
+		name    string
+		data    []byte
+		kind    snapshot.Kind
+		wantErr error
+	}{
+		{name: "not-a-snapshot", data: []byte("PK\x03\x04 a zip file"), kind: snapshot.KindGeoLite2, wantErr: snapshot.ErrBadMagic},
+		{name: "empty", data: []byte{}, kind: snapshot.KindGeoLite2, wantErr: snapshot.ErrBadMagic},
+		{name: "wrong-kind", data: good, kind: snapshot.KindASN, wantErr: snapshot.ErrWrongKind},
+		{name: "truncated", data: good[:len(good)-3], kind: snapshot.KindGeoLite2, wantErr: snapshot.ErrCorrupt},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := snapshot.NewDecoder(tt.data, tt.kind)
+			if err == nil {
+				d.Text()
+				err = d.Close()
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	name := "RouteViewIPv4/2019/01/routeviews-rv2-20190101-1200.pfx2as.gz"
+	path := filepath.Join(dir, filepath.FromSlash(snapshot.Name(name)))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte("snapshot content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	src := loader.NewDirSource(dir)
+
+	// A local snapshot is used in place.
+	m, err := snapshot.Load(context.Background(), src, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(m.Bytes()) != "snapshot content" {
+		t.Errorf("Load() = %q", m.Bytes())
+	}
+	if _, err := snapshot.Load(context.Background(), src, "missing"); err == nil {
+		t.Error("Load() of a missing snapshot succeeded")
+	}
+
+	// A remote snapshot is copied to the cache directory.
+	snapshot.CacheDir = t.TempDir()
+	defer func() { snapshot.CacheDir = "" }()
+	m, err = snapshot.Load(context.Background(), remoteSource{src}, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(m.Bytes()) != "snapshot content" {
+		t.Errorf("Load() = %q", m.Bytes())
+	}
+	if _, err := os.Stat(filepath.Join(snapshot.CacheDir, filepath.FromSlash(snapshot.Name(name)))); err != nil {
+		t.Error("snapshot not cached:", err)
+	}
+	if _, err := snapshot.Load(context.Background(), remoteSource{src}, "missing"); err == nil {
+		t.Error("Load() of a missing snapshot succeeded")
+	}
+}
+
+// remoteSource hides the LocalSource methods of a Source.
+type remoteSource struct {
+	loader.Source
+}